@@ -17,33 +17,66 @@
 package main
 
 import (
+	`context`
+	`encoding/hex`
+	`encoding/json`
+	`errors`
 	`flag`
 	`fmt`
+	`io`
 	`lightChain/core`
 	`lightChain/network`
-	`lightChain/utils`
 	`log`
 	`os`
+	`path/filepath`
+	`runtime`
 	`strconv`
+	`strings`
+	`time`
 )
 
 // CLI is the command line interface for lightChain.
-type CLI struct{}
+type CLI struct {
+	JSON bool // print the results of the query commands in JSON (set by the global flag -json)
+}
 
 // the "addr" below means wallet address! We dont' really care about the IP address of nodes in the p2p overlay network.
 
-const usage = `Usage:
-  createchain -addr ADDR                        --- Create lightChain and send coinbase reward of genesis block to ADDR
-  createwallet                                  --- Generate a new wallet (public-private key pair) and save it into file
+const usage = `Usage: lightChain [-json] COMMAND
+  -json is a global flag which prints the results of the query commands (e.g., getbalance, getchaininfo) in JSON
+  createchain -addr ADDR -msg MSG -decay FACTOR --- Create lightChain and send coinbase reward of genesis block to ADDR. MSG is embedded in the genesis block if set. The coinbase reward is multiplied by FACTOR (0.5 in default) periodically. Set -pow to select the PoW scheme of the chain: sha256 (in default) or memhard
+  createwallet -bech32 -compressed              --- Generate a new wallet (public-private key pair) and save it into file. The address is in bech32 if -bech32 is set, otherwise in base58check. The public key is compressed if -compressed is set
+  createwallets -count N                        --- Generate N new wallets at once and save them into file with a single write, then print all the addresses
+  deletewallet -addr ADDR -force                --- Delete the wallet of ADDR from the wallet file. Set -force if ADDR still holds coins, which are unrecoverable after deleting
   listaddr                                      --- List all addresses saved in local wallet file
-  printchain                                    --- Print all the blocks in local lightChain
-  printtx -b BLOCK_IDX -tx TX_IDX               --- Print the the TX_IDX-th transaction of the BLOCK_IDX-th block of local lightChain
+  validateaddr -addr ADDR                       --- Check whether ADDR is a valid address, and print its version byte and pubKeyHash if so
+  printchain -validate                          --- Print all the blocks in local lightChain, validate their PoW if -validate is set
+  printtx -b BLOCK_IDX -tx TX_IDX               --- Print the TX_IDX-th transaction of the BLOCK_IDX-th block of local lightChain. Both start from 0, and block 0 is the newest one (as labeled by printalltxs)
   printalltxs                                   --- Print all transactions in every block of local lightChain
   getblocknum                                   --- Print the number of blocks in local lightChain
-  send -src ADDR1 -dst ADDR2 -amount AMT -mine  --- Send AMT of coins from ADDR1 to ADDR2, mine on the same node if -mine is set
-  getbalance -addr ADDR                         --- Get the balance of ADDR
+  getchaininfo                                  --- Print the number of blocks and transactions, the tip height, the target block interval, and the UTXO commitment of local lightChain
+  difficultyhistory                             --- Print the height, timestamp, and difficulty of every block in local lightChain, from the oldest
+  tipinfo                                       --- Print the tip of local lightChain with its height and accumulated work, and the tips of the competing branches stored after forks
+  listcoinbase                                  --- Print the height, reward, and recipient pubkey hash of every coinbase transaction in local lightChain, from the newest
+  getblock -hash HASH -json                     --- Print the block whose hash is or starts with HASH (at least 4 bytes), in JSON if -json is set
+  getrawtx -id TXID -json                       --- Print the transaction whose Id is TXID, in JSON if -json is set
+  send -src ADDR1 -dst ADDR2 -amount AMT -mine  --- Send AMT of coins from ADDR1 to ADDR2, mine on the same node if -mine is set. Set -dsthash HASH instead of -dst to send to the pubkey hash HASH (20 bytes in hex) directly. The fee is capped at -maxfee coins (0.1 in default) unless -allowhighfee is set. Sending to ADDR1 itself is rejected unless -consolidate is set, which merges all the coins of ADDR1 into a single output instead. Set -replaceable to allow replacing the transaction with a conflicting one paying a higher fee before it is mined
+  splitcoins -addr ADDR -into N -mine           --- Send all the coins of ADDR back to itself in N equal outputs (minus the fee), to spend them in parallel later. Mine on the same node if -mine is set. The fee is capped like send
+  getbalance -addr ADDR                         --- Get the balance of ADDR, and the spendable part of it (excluding the immature coinbases, which is advisory since send does not exclude them yet)
+  listutxo -addr ADDR                           --- List the unspent outputs of ADDR with their heights, confirmations, and coin ages (value × confirmations), from the oldest
+  richlist -n N                                 --- Print the top N addresses (10 in default, all of them if N is 0) by balance in the UTXO set, from the richest
   rebuildutxo                                   --- Rebuild the UTXO
-  startnode -miner ADDR                         --- Add a new node to lightChain network with Node Id specified in NODE_ID environment variable. Enable mining if -miner set`
+  rebuildtxindex                                --- Rebuild the txid index (TxId to the block packing it) of local lightChain from scratch
+  compactdb                                     --- Compact the db file of local lightChain to return the freed pages to the disk. The node must be stopped first
+  supply                                        --- Print the total coin supply of local lightChain
+  verifychain -workers N                        --- Verify the signatures of all transactions in local lightChain with N workers in parallel (the number of CPUs in default)
+  estimatefee -in N -out M -rate RATE           --- Estimate the fee of a transaction with N inputs and M outputs at RATE coins per byte (1e-05 in default)
+  benchmine -seconds N                          --- Run the PoW loop of local lightChain on a synthetic block for N seconds and report the hashrate
+  comparenodes -a ADDR1 -b ADDR2                --- Check whether the nodes at ADDR1 and ADDR2 (e.g., localhost:3000) have the same lightChain copy
+  protocolinfo                                  --- Print every command of the p2p protocol and the fields of its payload
+  pausemining -rpcsocket P                      --- Pause the mining of the running node serving local queries on the unix domain socket P. The received transactions are still pooled
+  resumemining -rpcsocket P                     --- Resume the mining of the running node serving local queries on the unix domain socket P
+  startnode -miner ADDR -seeds S -rpcsocket P   --- Add a new node to lightChain network with Node Id specified in NODE_ID environment variable. Enable mining if -miner set. The seed nodes S (comma separated, localhost:23333 in default) are tried in turn to bootstrap from. Serve local queries on the unix domain socket P if -rpcsocket is set. Log every network message if -trace is set. Reject the transactions paying less than R coins per byte if -minrelayfee R is set`
 
 // printUsage prints the usage of the cli.
 func (cli *CLI) printUsage() {
@@ -58,22 +91,54 @@ func (cli *CLI) validateArgs() {
 	}
 }
 
-// listAddrs prints the wallets' address created by node with nodeId.
+// listAddrs prints the wallets' address created by node with nodeId. Local lightChain is not opened.
 func (cli *CLI) listAddrs(nodeId string) {
 	wallets, err := core.NewWallets(nodeId)
 	if err != nil {
 		log.Panic(err)
 	}
-	addrs := wallets.GetAddrs()
-	for addrIdx, addr := range addrs {
-		fmt.Printf("#%d: %s\n", addrIdx, addr)
+	cli.output(os.Stdout, addrListResult{Addresses: wallets.GetAddrs()})
+}
+
+// validateAddr prints whether addr is a valid address. No wallet is needed.
+func (cli *CLI) validateAddr(addr string) {
+	cli.output(os.Stdout, addrInfoOf(addr))
+	if !cli.JSON {
+		fmt.Println()
 	}
-	fmt.Println()
 }
 
-// printChain prints all blocks of local lightChain of nodeId from the newest to the oldest.
-func (cli *CLI) printChain(nodeId string) {
-	chain := core.NewBlockChain(nodeId)
+// printAddrInfo prints to w whether addr is a valid address, and its version byte and pubKeyHash if so. Malformed
+// input is reported as invalid with the reason.
+func printAddrInfo(w io.Writer, addr string) {
+	addrInfoOf(addr).printText(w)
+}
+
+// openChain opens local lightChain of nodeId. It exits if local lightChain cannot be opened.
+func openChain(nodeId string) *core.BlockChain {
+	chain, err := core.NewBlockChain(nodeId)
+	if err != nil {
+		fmt.Printf("Cannot open local lightChain: %v\n", err)
+		os.Exit(1)
+	}
+	return chain
+}
+
+// openChainReadOnly opens local lightChain of nodeId read-only for the query-only commands, thus they can run at the
+// same time. It exits if local lightChain cannot be opened, e.g., it is held by a running node.
+func openChainReadOnly(nodeId string) *core.BlockChain {
+	chain, err := core.NewBlockChainReadOnly(nodeId)
+	if err != nil {
+		fmt.Printf("Cannot open local lightChain: %v\n", err)
+		os.Exit(1)
+	}
+	return chain
+}
+
+// printChain prints all blocks of local lightChain of nodeId from the newest to the oldest. The PoW of each block is
+// validated only if validate is set, because it re-hashes every block.
+func (cli *CLI) printChain(nodeId string, validate bool) {
+	chain := openChainReadOnly(nodeId)
 	if !chain.ValidBlockChain() {
 		fmt.Println("Local lightChain is illegal (height + 1 ≠ blocks num)!")
 		os.Exit(1)
@@ -85,6 +150,11 @@ func (cli *CLI) printChain(nodeId string) {
 		}
 	}()
 
+	printBlocks(chain, validate)
+}
+
+// printBlocks prints all blocks of chain from the newest to the oldest, see printChain.
+func printBlocks(chain *core.BlockChain, validate bool) {
 	iter := chain.Iterator()
 	numBlocks := 0
 	for {
@@ -95,9 +165,12 @@ func (cli *CLI) printChain(nodeId string) {
 		fmt.Printf("Hash: %x\n", block.Hash)
 		fmt.Printf("Nonce: %d\n", block.Nonce)
 		fmt.Printf("Height: %d\n", block.Height)
-		// new a validator with the mined block to examine the nonce
-		pow := core.NewPoW(block)
-		fmt.Printf("Proof: PoW, Validated: %s\n\n", strconv.FormatBool(pow.Validate()))
+		fmt.Printf("Difficulty: %d\n", block.Difficulty())
+		if validate {
+			// examine the nonce with the validator of chain
+			fmt.Printf("Proof: PoW, Validated: %s\n", strconv.FormatBool(chain.PoW.Validate(block)))
+		}
+		fmt.Println()
 
 		numBlocks++
 
@@ -107,9 +180,10 @@ func (cli *CLI) printChain(nodeId string) {
 	}
 }
 
-// printTx prints the required Transaction's details. Note blockIdx is relative to the newest block (from the newest to the oldest).
+// printTx prints the required Transaction's details. Note blockIdx is relative to the newest block (from the newest to the
+// oldest), i.e. block 0 is the newest block, which is consistent with the block labels of printAllTxs.
 func (cli *CLI) printTx(nodeId string, blockIdx, txIdx int) {
-	chain := core.NewBlockChain(nodeId)
+	chain := openChain(nodeId)
 	if !chain.ValidBlockChain() {
 		fmt.Println("Local lightChain is illegal (height + 1 ≠ blocks num)!")
 		os.Exit(1)
@@ -120,17 +194,25 @@ func (cli *CLI) printTx(nodeId string, blockIdx, txIdx int) {
 			log.Panic(err)
 		}
 	}()
-	tx, err := chain.GetTx(blockIdx+1, txIdx)
-	if err != nil {
+	if err := cli.printTxAt(os.Stdout, chain, blockIdx, txIdx); err != nil {
 		log.Panic(err)
 	}
-	fmt.Println(tx)
+}
+
+// printTxAt prints the txIdx-th transaction of the blockIdx-th block of chain to w, see printTx.
+func (cli *CLI) printTxAt(w io.Writer, chain *core.BlockChain, blockIdx, txIdx int) error {
+	tx, err := chain.GetTx(blockIdx, txIdx)
+	if err != nil {
+		return err
+	}
+	cli.output(w, txResult{tx})
+	return nil
 }
 
 // printAllTxs prints all Transaction's details for all blocks in current lightChain. The print is form the most
-// recent block to the genesis block.
+// recent block (labeled as block #0) to the genesis block. The labels are the block indices accepted by printTx.
 func (cli *CLI) printAllTxs(nodeId string) {
-	chain := core.NewBlockChain(nodeId)
+	chain := openChain(nodeId)
 	if !chain.ValidBlockChain() {
 		fmt.Println("Local lightChain is illegal (height + 1 ≠ blocks num)!")
 		os.Exit(1)
@@ -142,18 +224,19 @@ func (cli *CLI) printAllTxs(nodeId string) {
 		}
 	}()
 
+	printTxs(os.Stdout, chain)
+}
+
+// printTxs prints all transactions of chain to w, see printAllTxs. Each block is read from the db once and its
+// transactions are printed directly, rather than looked up by index which walks the chain again.
+func printTxs(w io.Writer, chain *core.BlockChain) {
 	blockIdx := 0
 	iter := chain.Iterator()
 	for {
-		fmt.Printf("== Block #%d ==\n", blockIdx)
+		_, _ = fmt.Fprintf(w, "== Block #%d ==\n", blockIdx)
 		block := iter.Next()
-		for txIdx := range block.Transactions {
-			tx, err := chain.GetTx(blockIdx+1, txIdx)
-			if err != nil {
-				log.Panic(err)
-			}
-			fmt.Println(tx)
-			fmt.Println()
+		for _, tx := range block.Transactions {
+			_, _ = fmt.Fprintf(w, "%s\n\n", tx)
 		}
 		blockIdx++
 
@@ -165,7 +248,7 @@ func (cli *CLI) printAllTxs(nodeId string) {
 
 // getBlockNum returns #blocks in lightChain.
 func (cli *CLI) getBlockNum(nodeId string) {
-	chain := core.NewBlockChain(nodeId)
+	chain := openChainReadOnly(nodeId)
 	if !chain.ValidBlockChain() {
 		fmt.Println("Local lightChain is illegal (height + 1 ≠ blocks num)!")
 		os.Exit(1)
@@ -176,16 +259,190 @@ func (cli *CLI) getBlockNum(nodeId string) {
 			log.Panic(err)
 		}
 	}()
-	fmt.Printf("%d\n\n", chain.GetBlocksNum())
+	cli.output(os.Stdout, blockNumResult{Blocks: chain.GetBlocksNum()})
+}
+
+// getChainInfo prints the number of blocks, the number of transactions, the tip, the target block interval, and the
+// commitment of the UTXO set of local lightChain.
+func (cli *CLI) getChainInfo(nodeId string) {
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	cli.output(os.Stdout, chainInfoOf(chain))
+}
+
+// tipInfo prints the tip of local lightChain and the tips of the competing branches.
+func (cli *CLI) tipInfo(nodeId string) {
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	cli.output(os.Stdout, tipInfoOf(chain))
+}
+
+// difficultyHistory prints the height, timestamp, and difficulty of every block in local lightChain as a table.
+func (cli *CLI) difficultyHistory(nodeId string) {
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	fmt.Printf("%-8s %-26s %s\n", "Height", "Time", "Difficulty")
+	for _, point := range chain.DifficultyHistory() {
+		timeStamp := time.Unix(point.TimeStamp, 0).Format(time.RFC3339)
+		fmt.Printf("%-8d %-26s %d\n", point.Height, timeStamp, point.Difficulty)
+	}
+	fmt.Println()
+}
+
+// listCoinbase prints the height, reward, and recipient pubkey hash of every coinbase transaction in local lightChain.
+func (cli *CLI) listCoinbase(nodeId string) {
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	printCoinbases(os.Stdout, chain)
+	fmt.Println()
+}
+
+// printCoinbases prints the coinbase transactions of chain to w as a table, see listCoinbase. The reward is the sum of
+// the outputs, and the pubkey hashes of all the outputs are listed as the recipients.
+func printCoinbases(w io.Writer, chain *core.BlockChain) {
+	_, _ = fmt.Fprintf(w, "%-8s %-14s %s\n", "Height", "Reward", "Recipient")
+	chain.EachCoinbase(func(tx *core.Transaction, height int) {
+		reward := 0.0
+		var recipients []string
+		for _, txOutput := range tx.Vout {
+			reward += txOutput.Value
+			recipients = append(recipients, hex.EncodeToString(txOutput.PubKeyHash))
+		}
+		_, _ = fmt.Fprintf(w, "%-8d %-14f %s\n", height, reward, strings.Join(recipients, ","))
+	})
+}
+
+// getBlock prints the block whose hash is the hex string blockHash, which can also be a unique prefix of the hash (see
+// BlockChain.GetBlockByPrefix). If inJSON or cli.JSON is true, the block is printed in JSON.
+func (cli *CLI) getBlock(nodeId, blockHash string, inJSON bool) {
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	block, err := chain.GetBlockByPrefix(hash)
+	if errors.Is(err, core.ErrBlockNotFound) || errors.Is(err, core.ErrAmbiguousPrefix) {
+		fmt.Printf("Cannot get the block: %v\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	if inJSON || cli.JSON {
+		printJSON(block)
+		return
+	}
+	fmt.Printf("Timestamp: %d\n", block.TimeStamp)
+	fmt.Printf("Previous block's hash: %x\n", block.PrevBlockHash)
+	fmt.Printf("Hash: %x\n", block.Hash)
+	fmt.Printf("Nonce: %d\n", block.Nonce)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Difficulty: %d\n", block.Difficulty())
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+	fmt.Println()
+}
+
+// getRawTx prints the transaction whose Id is the hex string txId. If inJSON or cli.JSON is true, the tx is printed in
+// JSON.
+func (cli *CLI) getRawTx(nodeId, txId string, inJSON bool) {
+	id, err := hex.DecodeString(txId)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	tx, err := chain.FindTx(id)
+	if err != nil {
+		log.Panic(err)
+	}
+	if inJSON || cli.JSON {
+		printJSON(tx)
+		return
+	}
+	fmt.Printf("%s\n\n", tx)
+}
+
+// printJSON prints v in indented JSON.
+func printJSON(v interface{}) {
+	writeJSON(os.Stdout, v)
+}
+
+// writeJSON writes v in indented JSON to w.
+func writeJSON(w io.Writer, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Panic(err)
+	}
+	_, _ = fmt.Fprintln(w, string(data))
 }
 
 // createBlockChain creates lightChain on the whole network. The node with nodeId is the creator.
-// addr is the wallet address to receive the coinbase reward.
-func (cli *CLI) createBlockChain(addr, nodeId string) {
+// addr is the wallet address to receive the coinbase reward. genesisMsg is the coinbase data of the genesis block.
+// decayFactor is the factor multiplied to the coinbase reward periodically, and pow is the name of the PoW scheme.
+func (cli *CLI) createBlockChain(addr, nodeId, genesisMsg string, decayFactor float64, pow string) {
 	if !core.ValidateAddr(addr) {
 		log.Panic("Error: address is not valid")
 	}
-	chain := core.CreateBlockChain(addr, nodeId)
+	if decayFactor <= 0 || decayFactor > 1 {
+		log.Panic("Error: the decay factor should be in (0, 1]")
+	}
+	config := core.DefaultGenesisConfig()
+	config.GenesisMsg = genesisMsg
+	config.RewardDecayFactor = decayFactor
+	config.PoW = pow
+	chain, err := core.CreateBlockChain(addr, nodeId, config)
+	if err == core.ErrChainExists {
+		fmt.Println("lightChain is found in the whole network. You should not create it again.")
+		os.Exit(1)
+	}
+	if errors.Is(err, core.ErrUnknownPoW) {
+		fmt.Printf("Cannot create lightChain: %v\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
 	defer func() {
 		err := chain.Db.Close()
 		if err != nil {
@@ -198,15 +455,45 @@ func (cli *CLI) createBlockChain(addr, nodeId string) {
 	fmt.Printf("Done!\n\n")
 }
 
-// createWallet creates a new wallet and prints this wallet address. The node with nodeId is the creator.
-func (cli *CLI) createWallet(nodeId string) {
+// createWallet creates a new wallet and prints this wallet address (in format). The node with nodeId is the creator.
+// The public key of the wallet is in the compressed format if compressed is true. Local lightChain is not opened, thus
+// a wallet-only node works without any chain db.
+func (cli *CLI) createWallet(nodeId string, format core.AddrFormat, compressed bool) {
 	wallets, _ := core.NewWallets(nodeId)
-	addr := wallets.CreateWallet()
+	wallet := core.NewWallet()
+	if compressed {
+		wallet = core.NewCompressedWallet()
+	}
+	addr := wallets.AddWallet(wallet, format)
 	wallets.Save2File(nodeId)
 	fmt.Printf("The newly created address: %s\n\n", addr)
+	recordAddrs(addr)
+}
+
+// createWallets creates count new wallets, and prints their addresses. The wallet file of nodeId is saved only once
+// after all of them are created.
+func (cli *CLI) createWallets(nodeId string, count int) {
+	wallets, _ := core.NewWallets(nodeId)
+	addrs := wallets.CreateWallets(count)
+	wallets.Save2File(nodeId)
+	fmt.Printf("The %d newly created addresses:\n", len(addrs))
+	for _, addr := range addrs {
+		fmt.Println(addr)
+	}
+	fmt.Println()
+	recordAddrs(addrs...)
+}
 
-	// save addr to local file temporarily (this is for clear.sh)
-	f, err := os.OpenFile("./tmp/addresses.dat", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// addrRecordFile is the local file where the addresses of the created wallets are recorded temporarily.
+var addrRecordFile = "./tmp/addresses.dat"
+
+// recordAddrs appends addrs to addrRecordFile (this is for clear.sh). The directory of it is created if not exists.
+func recordAddrs(addrs ...string) {
+	err := os.MkdirAll(filepath.Dir(addrRecordFile), 0755)
+	if err != nil {
+		log.Panic(err)
+	}
+	f, err := os.OpenFile(addrRecordFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -216,22 +503,28 @@ func (cli *CLI) createWallet(nodeId string) {
 			log.Panic(err)
 		}
 	}()
-	if _, err := f.WriteString(addr + "\n"); err != nil {
-		log.Panic(err)
+	for _, addr := range addrs {
+		if _, err := f.WriteString(addr + "\n"); err != nil {
+			log.Panic(err)
+		}
 	}
 }
 
-// send invoke a transfer transaction from srcAddr to dstAddr with certain amount. If mineNow is true, the sender node
-// will mine this block directly. Otherwise, the tx will be broadcasted to all known nodes.
-func (cli *CLI) send(srcAddr, dstAddr string, amount float64, nodeId string, mineNow bool) {
+// send invoke a transfer transaction from srcAddr to dstAddr with certain amount. If dstPubKeyHash (the pubkey hash of
+// the receiver) is set, the coins are sent to it instead of dstAddr. If mineNow is true, the sender node will mine
+// this block directly. Otherwise, the tx will be announced to the central node, which pulls and broadcasts it to all
+// known nodes. Sending to srcAddr itself is rejected unless consolidate is set, in which case all the coins of srcAddr
+// are consolidated into a single output instead (amount is ignored).
+func (cli *CLI) send(srcAddr, dstAddr string, dstPubKeyHash []byte, amount float64, nodeId string, mineNow,
+	consolidate bool) {
 	if !core.ValidateAddr(srcAddr) {
 		log.Panic("Error: srcAddr is not valid")
 	}
-	if !core.ValidateAddr(dstAddr) {
+	if len(dstPubKeyHash) == 0 && !core.ValidateAddr(dstAddr) {
 		log.Panic("Error: dstAddr is not valid")
 	}
 
-	chain := core.NewBlockChain(nodeId)
+	chain := openChain(nodeId)
 	if !chain.ValidBlockChain() {
 		fmt.Println("Local lightChain is illegal (height + 1 ≠ blocks num)!")
 		os.Exit(1)
@@ -253,28 +546,73 @@ func (cli *CLI) send(srcAddr, dstAddr string, amount float64, nodeId string, min
 	if err != nil {
 		log.Panic(err)
 	}
-	tx := core.NewUTXOTx(&senderWallet, dstAddr, amount, &utxoSet)
+	var opts []core.TxOption
+	if len(dstPubKeyHash) != 0 {
+		opts = append(opts, core.WithDstHash(dstPubKeyHash))
+	}
+	tx, err := core.NewUTXOTx(&senderWallet, dstAddr, amount, &utxoSet, opts...)
+	if errors.Is(err, core.ErrSelfTransfer) {
+		if !consolidate {
+			fmt.Printf("%v. Set -consolidate to merge the coins of %s into a single output.\n", err, srcAddr)
+			os.Exit(1)
+		}
+		tx, err = core.NewConsolidationTx(&senderWallet, core.DefaultFeePerByte, &utxoSet)
+	}
+	if errors.Is(err, core.ErrFeeTooHigh) {
+		fmt.Printf("%v. Set -allowhighfee if it is intended.\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	submitTx(tx, srcAddr, &utxoSet, mineNow)
+}
 
+// submitTx mines tx on the local chain of utxoSet at once (the reward goes to minerAddr) if mineNow is set, otherwise
+// announces it to the central node, and exits if the central node rejects it.
+func submitTx(tx *core.Transaction, minerAddr string, utxoSet *core.UTXOSet, mineNow bool) {
 	if mineNow {
-		coinbaseTx := core.NewCoinbaseTx(srcAddr, "", chain.CoinbaseReward)
+		chain := utxoSet.BlockChain
+		height := chain.GetChainHeight() + 1
+		coinbaseTx := core.NewCoinbaseTx(minerAddr, "", chain.CurrentReward(height), height)
 		txs := []*core.Transaction{coinbaseTx, tx}
 
 		newBlock := chain.MineBlock(txs)
 		utxoSet.Update(newBlock)
 	} else {
-		network.SendTx(network.CentralNode, tx)
+		pulled, err := network.AnnounceTx(context.Background(), network.CentralNode, tx)
+		if errors.Is(err, network.ErrTxRejected) {
+			fmt.Printf("The transaction is not accepted: %v\n", err)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("Failed to announce the transaction: %v\n", err)
+			os.Exit(1)
+		}
+		if !pulled {
+			fmt.Println("The transaction is already known by the central node.")
+		}
 	}
 
 	fmt.Printf("Success!\n\n")
 }
 
-// getBalance prints the balance of the wallet whose address is addr. This function is called by node whose Id is nodeId.
-func (cli *CLI) getBalance(addr, nodeId string) {
+// maxTxFee returns the core.MaxTxFee set by the flags -maxfee (maxFee) and -allowhighfee (allowHighFee).
+func maxTxFee(maxFee float64, allowHighFee bool) float64 {
+	if allowHighFee {
+		return -1
+	}
+	return maxFee
+}
+
+// splitCoins sends all the coins of addr back to itself in into equal outputs (minus the fee), see core.NewSplitTx.
+// The transaction is mined on the same node if mineNow is set. This function is called by node whose Id is nodeId.
+func (cli *CLI) splitCoins(addr string, into int, nodeId string, mineNow bool) {
 	if !core.ValidateAddr(addr) {
 		log.Panic("Error: address is not valid")
 	}
 
-	chain := core.NewBlockChain(nodeId)
+	chain := openChain(nodeId)
 	if !chain.ValidBlockChain() {
 		fmt.Println("Local lightChain is illegal (height + 1 ≠ blocks num)!")
 		os.Exit(1)
@@ -287,21 +625,127 @@ func (cli *CLI) getBalance(addr, nodeId string) {
 		}
 	}()
 
-	balance := 0.0
-	pubKeyHash := utils.Base58Decoding([]byte(addr))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	utxo := utxoSet.FindUTXO(pubKeyHash)
+	wallets, err := core.NewWallets(nodeId)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, err := wallets.GetWallet(addr)
+	if err != nil {
+		log.Panic(err)
+	}
+	tx, err := core.NewSplitTx(&wallet, into, core.DefaultFeePerByte, &utxoSet)
+	if err != nil {
+		fmt.Printf("Cannot split the coins: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Split into %d outputs of %f coins each.\n", into, tx.Vout[0].Value)
+	submitTx(tx, addr, &utxoSet, mineNow)
+}
+
+// getBalance prints the balance of the wallet whose address is addr, and the spendable part of it. This function is
+// called by node whose Id is nodeId.
+func (cli *CLI) getBalance(addr, nodeId string) {
+	if !core.ValidateAddr(addr) {
+		log.Panic("Error: address is not valid")
+	}
+
+	chain := openChainReadOnly(nodeId)
+	if !chain.ValidBlockChain() {
+		fmt.Println("Local lightChain is illegal (height + 1 ≠ blocks num)!")
+		os.Exit(1)
+	}
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	balance, err := balanceOf(chain, addr)
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.output(os.Stdout, balance)
+}
+
+// listUTXO prints the unspent outputs of addr with their coin ages. This function is called by node whose Id is nodeId.
+func (cli *CLI) listUTXO(addr, nodeId string) {
+	if !core.ValidateAddr(addr) {
+		log.Panic("Error: address is not valid")
+	}
+
+	chain := openChainReadOnly(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	utxoList, err := utxoListOf(chain, addr)
+	if err != nil {
+		fmt.Printf("Cannot list the unspent outputs: %v\n", err)
+		os.Exit(1)
+	}
+	cli.output(os.Stdout, utxoList)
+}
+
+// richList prints the top n addresses by balance in the UTXO set of local lightChain to nodeId.
+func (cli *CLI) richList(n int, nodeId string) {
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	cli.output(os.Stdout, richListOf(chain, n))
+}
+
+// deleteWallet removes the wallet of addr from the wallet file of nodeId. If addr still holds coins, the wallet is
+// removed only if force is set, since the coins are unrecoverable without the private key.
+func (cli *CLI) deleteWallet(addr, nodeId string, force bool) {
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+	wallets, err := core.NewWallets(nodeId)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if err := removeWallet(wallets, addr, &core.UTXOSet{BlockChain: chain}, force); err != nil {
+		fmt.Printf("Cannot delete the wallet: %v\n", err)
+		os.Exit(1)
+	}
+	wallets.Save2File(nodeId)
+	fmt.Printf("The wallet of '%s' is deleted.\n\n", addr)
+}
 
-	for _, output := range utxo {
-		balance += output.Value
+// removeWallet removes the wallet of addr from wallets. An error is returned if addr holds coins in utxoSet and force
+// is not set.
+func removeWallet(wallets *core.Wallets, addr string, utxoSet *core.UTXOSet, force bool) error {
+	wallet, err := wallets.GetWallet(addr)
+	if err != nil {
+		return err
+	}
+	if balance := utxoSet.Balance(core.HashingPubKey(wallet.PubKey)); balance > 0 {
+		if !force {
+			return fmt.Errorf("'%s' still holds %f coins, which are unrecoverable after deleting. Set -force to delete anyway", addr, balance)
+		}
+		fmt.Printf("Warning: %f coins of '%s' are unrecoverable!\n", balance, addr)
 	}
-	fmt.Printf("The balance of '%s': %f\n\n", addr, balance)
+	return wallets.RemoveWallet(addr)
 }
 
 // rebuildUTXO rebuilds the UTXO incrementally when local lightChain to nodeId changes. Note that the utxoBucket in db
 // is shared by all nodes in the lightChain network.
 func (cli *CLI) rebuildUTXO(nodeId string) {
-	chain := core.NewBlockChain(nodeId)
+	chain := openChain(nodeId)
 	if !chain.ValidBlockChain() {
 		fmt.Println("Local lightChain is illegal (height + 1 ≠ blocks num)!")
 		os.Exit(1)
@@ -309,12 +753,148 @@ func (cli *CLI) rebuildUTXO(nodeId string) {
 	utxoSet := core.UTXOSet{BlockChain: chain}
 	utxoSet.Rebuild()
 
-	fmt.Printf("Done! %d transactions found in UTXO set.\n\n", utxoSet.CountTxs())
+	numTxs, numOutputs, totalValue := utxoSet.Stats()
+	fmt.Printf("Done! %d transactions (%d outputs, %f coins in total) found in UTXO set.\n\n", numTxs, numOutputs, totalValue)
+}
+
+// rebuildTxIndex rebuilds the txid index of local lightChain to nodeId from scratch.
+func (cli *CLI) rebuildTxIndex(nodeId string) {
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	numTxs, err := chain.RebuildTxIndex()
+	if err != nil {
+		fmt.Printf("Cannot rebuild the txid index: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Done! %d transactions are indexed.\n\n", numTxs)
+}
+
+// compactDb compacts the db file of local lightChain to nodeId.
+func (cli *CLI) compactDb(nodeId string) {
+	before, after, err := core.CompactDb(nodeId)
+	if err == core.ErrStoreLocked {
+		fmt.Println("The db file is in use. Stop the node first.")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Done! The db file is compacted from %d bytes to %d bytes.\n\n", before, after)
+}
+
+// verifyChain verifies the signatures of all transactions in local lightChain with workers in parallel.
+func (cli *CLI) verifyChain(nodeId string, workers int) {
+	chain := openChain(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	core.VerifyWorkers = workers
+	start := time.Now()
+	if err := chain.VerifyAll(); err != nil {
+		fmt.Printf("Local lightChain is invalid: %v\n\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("All signatures are valid (%d workers, %v).\n\n", workers, time.Since(start))
+}
+
+// printSupply prints the total coin supply (sum of UTXO values) and the issued coins (sum of coinbase outputs) of local
+// lightChain. The two should always agree. Otherwise, some transaction has created coins out of nothing.
+func (cli *CLI) printSupply(nodeId string) {
+	chain := openChain(nodeId)
+	if !chain.ValidBlockChain() {
+		fmt.Println("Local lightChain is illegal (height + 1 ≠ blocks num)!")
+		os.Exit(1)
+	}
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	cli.output(os.Stdout, supplyResult{TotalSupply: chain.TotalSupply(), IssuedSupply: chain.IssuedSupply()})
+}
+
+// estimateFee prints the estimated size and fee of a transaction with numInputs inputs and numOutputs outputs.
+func (cli *CLI) estimateFee(numInputs, numOutputs int, feePerByte float64) {
+	cli.output(os.Stdout, feeResult{
+		Size: core.EstimateTxSize(numInputs, numOutputs),
+		Fee:  core.EstimateFee(numInputs, numOutputs, feePerByte),
+	})
+}
+
+// benchMine runs the PoW loop of local lightChain of nodeId (through its PoW scheme) on a synthetic block for the given
+// seconds and prints the hashrate. No block is added to the chain.
+func (cli *CLI) benchMine(nodeId string, seconds int) {
+	chain := openChainReadOnly(nodeId)
+	defer func() {
+		err := chain.Db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	fmt.Printf("Benchmarking mining for %d seconds...\n", seconds)
+	hashRate := chain.BenchmarkMining(time.Duration(seconds) * time.Second)
+	fmt.Printf("Hashrate: %.2f attempts per second\n\n", hashRate)
+}
+
+// protocolInfo prints every command of the p2p protocol and the fields of its payload.
+func (cli *CLI) protocolInfo() {
+	printProtocolInfo(os.Stdout)
+}
+
+// printProtocolInfo prints to w each command listed by network.ProtocolInfo, followed by the fields of its payload.
+func printProtocolInfo(w io.Writer) {
+	for _, spec := range network.ProtocolInfo() {
+		_, _ = fmt.Fprintf(w, "%s: %s\n", spec.Name, spec.Description)
+		for _, field := range spec.Payload {
+			_, _ = fmt.Fprintf(w, "    %s\n", field)
+		}
+	}
+}
+
+// compareNodes checks whether the nodes at addrA and addrB are in sync, i.e., have the same tip.
+func (cli *CLI) compareNodes(addrA, addrB string) {
+	inSync, heightDiff, err := network.CompareChains(addrA, addrB)
+	if err != nil {
+		log.Panic(err)
+	}
+	if inSync {
+		fmt.Printf("%s and %s are in sync.\n", addrA, addrB)
+	} else {
+		fmt.Printf("%s and %s are not in sync. Height difference (%s - %s): %d\n", addrA, addrB, addrA, addrB, heightDiff)
+	}
+}
+
+// controlMining pauses (if pause is set) or resumes the mining of the running node serving local queries on the unix
+// domain socket rpcSocket.
+func (cli *CLI) controlMining(rpcSocket string, pause bool) {
+	paused, err := network.ControlMining("unix", rpcSocket, pause)
+	if err != nil {
+		log.Panic(err)
+	}
+	if paused {
+		fmt.Printf("Mining is paused.\n\n")
+	} else {
+		fmt.Printf("Mining is resumed.\n\n")
+	}
 }
 
 // startNode starts a new node (a new node whose IP is "localhost:nodeId" joins the lightChain network). If nodeMinerAddr
-// is not "", this node is a miner node and the address to receive mining reward is nodeMinerAddr.
-func (cli *CLI) startNode(nodeId, nodeMinerAddr string) {
+// is not "", this node is a miner node and the address to receive mining reward is nodeMinerAddr. The node bootstraps
+// from seedNodes. The local queries are served on the unix domain socket rpcSocket if it is not "".
+func (cli *CLI) startNode(nodeId, nodeMinerAddr string, seedNodes []string, rpcSocket string) {
 	fmt.Printf("Starting node %s...\n", nodeId)
 	if len(nodeMinerAddr) > 0 {
 		if core.ValidateAddr(nodeMinerAddr) {
@@ -323,10 +903,21 @@ func (cli *CLI) startNode(nodeId, nodeMinerAddr string) {
 			log.Panic("Miner address is illegal!")
 		}
 	}
+	network.SeedNodes = seedNodes
+	network.RPCSocket = rpcSocket
 	network.StartNode(nodeId, nodeMinerAddr)
 }
 
 func (cli *CLI) Run() {
+	// the global flags precede the command
+	globalFlags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	globalFlags.BoolVar(&cli.JSON, "json", false, "Print the results of the query commands in JSON")
+	if len(os.Args) > 1 {
+		if err := globalFlags.Parse(os.Args[1:]); err != nil {
+			log.Panic(err)
+		}
+		os.Args = append(os.Args[:1], globalFlags.Args()...)
+	}
 	cli.validateArgs()
 
 	nodeId := os.Getenv("NODE_ID")
@@ -338,14 +929,46 @@ func (cli *CLI) Run() {
 	// define flag set
 	createChainSubCmd := flag.NewFlagSet("createchain", flag.ExitOnError)
 	addr2GetReward := createChainSubCmd.String("addr", "", "The wallet address to get the coinbase reward of the genesis block")
+	genesisMsg := createChainSubCmd.String("msg", "", "The message embedded in the coinbase of the genesis block")
+	decayFactor := createChainSubCmd.Float64("decay", 0.5, "The factor multiplied to the coinbase reward periodically")
+	chainPoW := createChainSubCmd.String("pow", core.Sha256PoWName, "The PoW scheme of the chain (sha256 or memhard)")
 
 	createWalletSubCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	walletBech32 := createWalletSubCmd.Bool("bech32", false, "Generate the address in bech32")
+	walletCompressed := createWalletSubCmd.Bool("compressed", false, "Use the compressed public key format")
+
+	createWalletsSubCmd := flag.NewFlagSet("createwallets", flag.ExitOnError)
+	walletCount := createWalletsSubCmd.Int("count", 0, "The number of wallets to generate")
+
+	deleteWalletSubCmd := flag.NewFlagSet("deletewallet", flag.ExitOnError)
+	addr2Delete := deleteWalletSubCmd.String("addr", "", "The address of the wallet to delete")
+	forceDelete := deleteWalletSubCmd.Bool("force", false, "Delete the wallet even if it still holds coins")
 
 	listAddrSubCmd := flag.NewFlagSet("listaddr", flag.ExitOnError)
 
+	validateAddrSubCmd := flag.NewFlagSet("validateaddr", flag.ExitOnError)
+	addr2Validate := validateAddrSubCmd.String("addr", "", "The address to validate")
+
 	getBlockNumSubCmd := flag.NewFlagSet("getblocknum", flag.ExitOnError)
 
+	getChainInfoSubCmd := flag.NewFlagSet("getchaininfo", flag.ExitOnError)
+
+	difficultyHistorySubCmd := flag.NewFlagSet("difficultyhistory", flag.ExitOnError)
+
+	tipInfoSubCmd := flag.NewFlagSet("tipinfo", flag.ExitOnError)
+
+	listCoinbaseSubCmd := flag.NewFlagSet("listcoinbase", flag.ExitOnError)
+
 	printChainSubCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	validatePoW := printChainSubCmd.Bool("validate", false, "Validate the PoW of each block")
+
+	getBlockSubCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	blockHash := getBlockSubCmd.String("hash", "", "The hash of the block or its prefix (in hex)")
+	blockInJSON := getBlockSubCmd.Bool("json", false, "Print the block in JSON")
+
+	getRawTxSubCmd := flag.NewFlagSet("getrawtx", flag.ExitOnError)
+	rawTxId := getRawTxSubCmd.String("id", "", "The Id of the transaction (in hex)")
+	rawTxInJSON := getRawTxSubCmd.Bool("json", false, "Print the transaction in JSON")
 
 	printTxSubCmd := flag.NewFlagSet("printtx", flag.ExitOnError)
 	blockIdx := printTxSubCmd.Int("b", 0, "The block index since the newest block (starts from 0)")
@@ -356,16 +979,67 @@ func (cli *CLI) Run() {
 	sendSubCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	sendFrom := sendSubCmd.String("src", "", "Source wallet address")
 	sendTo := sendSubCmd.String("dst", "", "Destination wallet address")
+	sendToHash := sendSubCmd.String("dsthash", "", "Destination pubkey hash in hex, instead of the wallet address")
 	sendAmt := sendSubCmd.Float64("amount", 0.0, "Amount of coins to send")
 	sendMine := sendSubCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendMaxFee := sendSubCmd.Float64("maxfee", core.DefaultMaxTxFee, "The cap of the fee (coins)")
+	sendAllowHighFee := sendSubCmd.Bool("allowhighfee", false, "Lift the cap of the fee")
+	sendConsolidate := sendSubCmd.Bool("consolidate", false, "Consolidate the coins if sent to itself")
+	sendReplaceable := sendSubCmd.Bool("replaceable", false, "Allow replacing the transaction with a higher fee")
+
+	splitCoinsSubCmd := flag.NewFlagSet("splitcoins", flag.ExitOnError)
+	addr2Split := splitCoinsSubCmd.String("addr", "", "The address whose coins are split")
+	splitInto := splitCoinsSubCmd.Int("into", 0, "The number of outputs to split into")
+	splitMine := splitCoinsSubCmd.Bool("mine", false, "Mine immediately on the same node")
+	splitMaxFee := splitCoinsSubCmd.Float64("maxfee", core.DefaultMaxTxFee, "The cap of the fee (coins)")
+	splitAllowHighFee := splitCoinsSubCmd.Bool("allowhighfee", false, "Lift the cap of the fee")
 
 	getBalanceSubCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	addr2QueryBalance := getBalanceSubCmd.String("addr", "", "The address to query balance")
 
+	listUTXOSubCmd := flag.NewFlagSet("listutxo", flag.ExitOnError)
+	addr2ListUTXO := listUTXOSubCmd.String("addr", "", "The address whose unspent outputs are listed")
+
+	richListSubCmd := flag.NewFlagSet("richlist", flag.ExitOnError)
+	richListN := richListSubCmd.Int("n", 10, "The number of addresses to print (0 for all)")
+
 	rebuildUTXOSubCmd := flag.NewFlagSet("rebuildutxo", flag.ExitOnError)
 
+	rebuildTxIndexSubCmd := flag.NewFlagSet("rebuildtxindex", flag.ExitOnError)
+
+	compactDbSubCmd := flag.NewFlagSet("compactdb", flag.ExitOnError)
+
+	supplySubCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+
+	verifyChainSubCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	verifyWorkers := verifyChainSubCmd.Int("workers", runtime.NumCPU(), "The number of workers verifying in parallel")
+
+	estimateFeeSubCmd := flag.NewFlagSet("estimatefee", flag.ExitOnError)
+	feeNumInputs := estimateFeeSubCmd.Int("in", 1, "The number of inputs")
+	feeNumOutputs := estimateFeeSubCmd.Int("out", 2, "The number of outputs")
+	feePerByte := estimateFeeSubCmd.Float64("rate", core.DefaultFeePerByte, "The fee rate (coins per byte)")
+
+	benchMineSubCmd := flag.NewFlagSet("benchmine", flag.ExitOnError)
+	benchSeconds := benchMineSubCmd.Int("seconds", 10, "The seconds to run the benchmark")
+
+	pauseMiningSubCmd := flag.NewFlagSet("pausemining", flag.ExitOnError)
+	pauseSocket := pauseMiningSubCmd.String("rpcsocket", "", "The unix domain socket the node serves local queries on")
+
+	resumeMiningSubCmd := flag.NewFlagSet("resumemining", flag.ExitOnError)
+	resumeSocket := resumeMiningSubCmd.String("rpcsocket", "", "The unix domain socket the node serves local queries on")
+
+	compareNodesSubCmd := flag.NewFlagSet("comparenodes", flag.ExitOnError)
+	nodeAddrA := compareNodesSubCmd.String("a", "", "The address of one node")
+	nodeAddrB := compareNodesSubCmd.String("b", "", "The address of another node")
+
+	protocolInfoSubCmd := flag.NewFlagSet("protocolinfo", flag.ExitOnError)
+
 	startNodeSubCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
 	nodeMinerAddr := startNodeSubCmd.String("miner", "", "Enable mining and send reward to ADDR")
+	seedNodes := startNodeSubCmd.String("seeds", network.CentralNode, "The seed nodes (comma separated) to bootstrap from")
+	rpcSocket := startNodeSubCmd.String("rpcsocket", "", "The unix domain socket to serve local queries on")
+	traceMessages := startNodeSubCmd.Bool("trace", false, "Log every network message for debugging")
+	minRelayFeeRate := startNodeSubCmd.Float64("minrelayfee", network.MinRelayFeeRate, "The lowest fee rate (coins per byte) of the transactions to accept")
 
 	// parse flag set
 	switch os.Args[1] {
@@ -379,21 +1053,66 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "createwallets":
+		err := createWalletsSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "deletewallet":
+		err := deleteWalletSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "listaddr":
 		err := listAddrSubCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "validateaddr":
+		err := validateAddrSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "getblocknum":
 		err := getBlockNumSubCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "getchaininfo":
+		err := getChainInfoSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "difficultyhistory":
+		err := difficultyHistorySubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "tipinfo":
+		err := tipInfoSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listcoinbase":
+		err := listCoinbaseSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "printchain":
 		err := printChainSubCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "getblock":
+		err := getBlockSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getrawtx":
+		err := getRawTxSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "printtx":
 		err := printTxSubCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -409,16 +1128,81 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "splitcoins":
+		err := splitCoinsSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "getbalance":
 		err := getBalanceSubCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "listutxo":
+		err := listUTXOSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "richlist":
+		err := richListSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "rebuildutxo":
 		err := rebuildUTXOSubCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "rebuildtxindex":
+		err := rebuildTxIndexSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "compactdb":
+		err := compactDbSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "supply":
+		err := supplySubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "estimatefee":
+		err := estimateFeeSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "benchmine":
+		err := benchMineSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "comparenodes":
+		err := compareNodesSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "protocolinfo":
+		err := protocolInfoSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "pausemining":
+		err := pauseMiningSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "resumemining":
+		err := resumeMiningSubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "startnode":
 		err := startNodeSubCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -436,19 +1220,58 @@ func (cli *CLI) Run() {
 			createChainSubCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockChain(*addr2GetReward, nodeId)
+		cli.createBlockChain(*addr2GetReward, nodeId, *genesisMsg, *decayFactor, *chainPoW)
 	}
 	if createWalletSubCmd.Parsed() {
-		cli.createWallet(nodeId)
+		format := core.Base58Check
+		if *walletBech32 {
+			format = core.Bech32
+		}
+		cli.createWallet(nodeId, format, *walletCompressed)
+	}
+	if createWalletsSubCmd.Parsed() {
+		if *walletCount <= 0 {
+			createWalletsSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createWallets(nodeId, *walletCount)
+	}
+	if deleteWalletSubCmd.Parsed() {
+		if *addr2Delete == "" {
+			deleteWalletSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.deleteWallet(*addr2Delete, nodeId, *forceDelete)
+	}
+	if validateAddrSubCmd.Parsed() {
+		if *addr2Validate == "" {
+			validateAddrSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.validateAddr(*addr2Validate)
 	}
 	if listAddrSubCmd.Parsed() {
 		cli.listAddrs(nodeId)
 	}
 	if printChainSubCmd.Parsed() {
-		cli.printChain(nodeId)
+		cli.printChain(nodeId, *validatePoW)
+	}
+	if getBlockSubCmd.Parsed() {
+		if *blockHash == "" {
+			getBlockSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlock(nodeId, *blockHash, *blockInJSON)
+	}
+	if getRawTxSubCmd.Parsed() {
+		if *rawTxId == "" {
+			getRawTxSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getRawTx(nodeId, *rawTxId, *rawTxInJSON)
 	}
 	if printTxSubCmd.Parsed() {
-		if blockIdx == nil || txIdx == nil {
+		if *blockIdx < 0 || *txIdx < 0 {
 			printTxSubCmd.Usage()
 			os.Exit(1)
 		}
@@ -460,12 +1283,38 @@ func (cli *CLI) Run() {
 	if getBlockNumSubCmd.Parsed() {
 		cli.getBlockNum(nodeId)
 	}
+	if getChainInfoSubCmd.Parsed() {
+		cli.getChainInfo(nodeId)
+	}
+	if difficultyHistorySubCmd.Parsed() {
+		cli.difficultyHistory(nodeId)
+	}
+	if tipInfoSubCmd.Parsed() {
+		cli.tipInfo(nodeId)
+	}
+	if listCoinbaseSubCmd.Parsed() {
+		cli.listCoinbase(nodeId)
+	}
 	if sendSubCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmt <= 0 {
+		sendToPubKeyHash, err := hex.DecodeString(*sendToHash)
+		if err != nil {
+			fmt.Printf("-dsthash is not in hex: %v\n", err)
+		}
+		if err != nil || *sendFrom == "" || (*sendTo == "") == (*sendToHash == "") || *sendAmt <= 0 || *sendMaxFee < 0 {
 			sendSubCmd.Usage()
 			os.Exit(1)
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmt, nodeId, *sendMine)
+		core.MaxTxFee = maxTxFee(*sendMaxFee, *sendAllowHighFee)
+		core.SignalReplaceable = *sendReplaceable
+		cli.send(*sendFrom, *sendTo, sendToPubKeyHash, *sendAmt, nodeId, *sendMine, *sendConsolidate)
+	}
+	if splitCoinsSubCmd.Parsed() {
+		if *addr2Split == "" || *splitInto <= 0 || *splitMaxFee < 0 {
+			splitCoinsSubCmd.Usage()
+			os.Exit(1)
+		}
+		core.MaxTxFee = maxTxFee(*splitMaxFee, *splitAllowHighFee)
+		cli.splitCoins(*addr2Split, *splitInto, nodeId, *splitMine)
 	}
 	if getBalanceSubCmd.Parsed() {
 		if *addr2QueryBalance == "" {
@@ -474,10 +1323,80 @@ func (cli *CLI) Run() {
 		}
 		cli.getBalance(*addr2QueryBalance, nodeId)
 	}
+	if listUTXOSubCmd.Parsed() {
+		if *addr2ListUTXO == "" {
+			listUTXOSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.listUTXO(*addr2ListUTXO, nodeId)
+	}
+	if richListSubCmd.Parsed() {
+		if *richListN < 0 {
+			richListSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.richList(*richListN, nodeId)
+	}
 	if rebuildUTXOSubCmd.Parsed() {
 		cli.rebuildUTXO(nodeId)
 	}
+	if rebuildTxIndexSubCmd.Parsed() {
+		cli.rebuildTxIndex(nodeId)
+	}
+	if compactDbSubCmd.Parsed() {
+		cli.compactDb(nodeId)
+	}
+	if supplySubCmd.Parsed() {
+		cli.printSupply(nodeId)
+	}
+	if verifyChainSubCmd.Parsed() {
+		if *verifyWorkers <= 0 {
+			verifyChainSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyChain(nodeId, *verifyWorkers)
+	}
+	if estimateFeeSubCmd.Parsed() {
+		if *feeNumInputs <= 0 || *feeNumOutputs <= 0 || *feePerByte < 0 {
+			estimateFeeSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.estimateFee(*feeNumInputs, *feeNumOutputs, *feePerByte)
+	}
+	if benchMineSubCmd.Parsed() {
+		if *benchSeconds <= 0 {
+			benchMineSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.benchMine(nodeId, *benchSeconds)
+	}
+	if compareNodesSubCmd.Parsed() {
+		if *nodeAddrA == "" || *nodeAddrB == "" {
+			compareNodesSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.compareNodes(*nodeAddrA, *nodeAddrB)
+	}
+	if protocolInfoSubCmd.Parsed() {
+		cli.protocolInfo()
+	}
+	if pauseMiningSubCmd.Parsed() {
+		if *pauseSocket == "" {
+			pauseMiningSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.controlMining(*pauseSocket, true)
+	}
+	if resumeMiningSubCmd.Parsed() {
+		if *resumeSocket == "" {
+			resumeMiningSubCmd.Usage()
+			os.Exit(1)
+		}
+		cli.controlMining(*resumeSocket, false)
+	}
 	if startNodeSubCmd.Parsed() {
-		cli.startNode(nodeId, *nodeMinerAddr)
+		network.TraceMessages = *traceMessages
+		network.MinRelayFeeRate = *minRelayFeeRate
+		cli.startNode(nodeId, *nodeMinerAddr, strings.Split(*seedNodes, ","), *rpcSocket)
 	}
 }
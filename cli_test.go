@@ -0,0 +1,305 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	`bytes`
+	`encoding/hex`
+	`encoding/json`
+	`fmt`
+	`github.com/stretchr/testify/assert`
+	`io/ioutil`
+	`lightChain/core`
+	`lightChain/network`
+	`os`
+	`path/filepath`
+	`strings`
+	`testing`
+)
+
+// spyPoW records how many blocks are validated.
+type spyPoW struct {
+	core.NoopPoW
+	validated int
+}
+
+func (pow *spyPoW) Validate(block *core.Block) bool {
+	pow.validated++
+	return pow.NoopPoW.Validate(block)
+}
+
+func TestPrintBlocksValidate(t *testing.T) {
+	chain := newTestChain(t)
+	spy := &spyPoW{}
+	chain.PoW = spy
+
+	printBlocks(chain, false)
+	assert.Equal(t, 0, spy.validated, "PoW is not validated in default")
+
+	printBlocks(chain, true)
+	assert.Equal(t, 2, spy.validated, "PoW of each block is validated with -validate")
+}
+
+func TestRemoveWallet(t *testing.T) {
+	wallets := &core.Wallets{WalletsMap: make(map[string]*core.Wallet)}
+	fundedAddr := wallets.CreateWallet()
+	emptyAddr := wallets.CreateWallet()
+	utxoSet := &core.UTXOSet{BlockChain: newMemChain(fundedAddr)}
+
+	assert.Nil(t, removeWallet(wallets, emptyAddr, utxoSet, false), "Wallet without coins is removed")
+	assert.NotNil(t, removeWallet(wallets, fundedAddr, utxoSet, false), "Funded wallet is not removed without -force")
+	assert.Equal(t, []string{fundedAddr}, wallets.GetAddrs())
+
+	assert.Nil(t, removeWallet(wallets, fundedAddr, utxoSet, true), "Funded wallet is removed with -force")
+	assert.Empty(t, wallets.GetAddrs())
+}
+
+// spyStore records how many read-only transactions are opened on the store.
+type spyStore struct {
+	core.Store
+	views int
+}
+
+func (store *spyStore) View(fn func(tx core.StoreTx) error) error {
+	store.views++
+	return store.Store.View(fn)
+}
+
+func TestPrintTxs(t *testing.T) {
+	chain := newTestChain(t)
+	wallet := core.NewWallet()
+	for i := 0; i < 20; i++ {
+		mineTxs(chain, string(wallet.GetAddr()))
+	}
+	var txIds []string
+	_ = chain.EachTx(func(tx *core.Transaction, block *core.Block) error {
+		txIds = append(txIds, fmt.Sprintf("TxId: %x", tx.Id))
+		return nil
+	})
+
+	numBlocks := chain.GetBlocksNum()
+	spy := &spyStore{Store: chain.Db}
+	chain.Db = spy
+	var out bytes.Buffer
+	printTxs(&out, chain)
+
+	assert.Equal(t, numBlocks, spy.views, "Each block is read once")
+	assert.Equal(t, numBlocks, strings.Count(out.String(), "== Block #"))
+	// the transactions are printed from the newest to the oldest
+	lastIdx := -1
+	for _, txId := range txIds {
+		idx := strings.Index(out.String(), txId)
+		assert.True(t, idx > lastIdx, txId)
+		lastIdx = idx
+	}
+}
+
+func TestPrintTxAt(t *testing.T) {
+	chain := newTestChain(t)
+	newestBlock, _ := chain.GetBlock(chain.Tip)
+	cli := CLI{}
+
+	var out bytes.Buffer
+	assert.Nil(t, cli.printTxAt(&out, chain, 0, 0))
+	assert.Equal(t, newestBlock.Transactions[0].String()+"\n", out.String(), "-b 0 -tx 0 is the first tx of the newest block")
+	assert.NotNil(t, cli.printTxAt(&out, chain, 2, 0), "There are only 2 blocks")
+	assert.NotNil(t, cli.printTxAt(&out, chain, 0, 1), "There is only 1 tx in the newest block")
+	assert.NotNil(t, cli.printTxAt(&out, chain, -1, 0))
+
+	// the block labels of printTxs are the indices of printTxAt
+	var all bytes.Buffer
+	printTxs(&all, chain)
+	for blockIdx := 0; blockIdx < 2; blockIdx++ {
+		out.Reset()
+		assert.Nil(t, cli.printTxAt(&out, chain, blockIdx, 0))
+		label := fmt.Sprintf("== Block #%d ==\n", blockIdx)
+		assert.Contains(t, all.String(), label+out.String())
+	}
+}
+
+func TestPrintAddrInfo(t *testing.T) {
+	wallet := core.NewWallet()
+	pubKeyHash := core.HashingPubKey(wallet.PubKey)
+	expected := fmt.Sprintf("Valid: true\nVersion: 0x00\nPubKeyHash: %x\n", pubKeyHash)
+
+	for _, addr := range []string{string(wallet.GetAddr()), string(wallet.GetAddrInFormat(core.Bech32))} {
+		var out bytes.Buffer
+		printAddrInfo(&out, addr)
+		assert.Equal(t, expected, out.String(), addr)
+	}
+
+	// a typo in the last character breaks the checksum
+	tampered := []byte(wallet.GetAddr())
+	tampered[len(tampered)-1] = map[bool]byte{true: '2', false: '3'}[tampered[len(tampered)-1] != '2']
+	for _, addr := range []string{string(tampered), "0OIl not base58!", "1"} {
+		var out bytes.Buffer
+		assert.NotPanics(t, func() {
+			printAddrInfo(&out, addr)
+		}, addr)
+		assert.True(t, strings.HasPrefix(out.String(), "Valid: false"), addr)
+		assert.False(t, strings.Contains(out.String(), "PubKeyHash"), addr)
+	}
+}
+
+func TestPrintProtocolInfo(t *testing.T) {
+	var out bytes.Buffer
+	printProtocolInfo(&out)
+	for _, spec := range network.ProtocolInfo() {
+		assert.Contains(t, out.String(), spec.Name+": "+spec.Description+"\n")
+	}
+	assert.Contains(t, out.String(), "    TipHash []uint8\n")
+}
+
+func TestPrintCoinbases(t *testing.T) {
+	chain := newTestChain(t)
+	var out bytes.Buffer
+	printCoinbases(&out, chain)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, chain.GetBlocksNum()+1, len(lines), "A header and a row per block")
+	var rows []string
+	chain.EachCoinbase(func(tx *core.Transaction, height int) {
+		rows = append(rows, fmt.Sprintf("%-8d %-14f %x", height, tx.Vout[0].Value, tx.Vout[0].PubKeyHash))
+	})
+	assert.Equal(t, rows, lines[1:])
+	assert.True(t, strings.HasPrefix(lines[1], "1 "), "The newest coinbase is printed first")
+}
+
+func TestJSONOutput(t *testing.T) {
+	wallet := core.NewWallet()
+	addr := string(wallet.GetAddr())
+	chain := newMemChain(addr)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	cli := CLI{JSON: true}
+
+	var out bytes.Buffer
+	balance, err := balanceOf(chain, addr)
+	assert.Nil(t, err)
+	cli.output(&out, balance)
+	var balanceJSON map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &balanceJSON), out.String())
+	assert.Equal(t, map[string]interface{}{"address": addr, "balance": chain.CurrentReward(0), "spendable": 0.0},
+		balanceJSON, "The genesis coinbase is immature")
+	_, err = balanceOf(chain, "invalid")
+	assert.NotNil(t, err)
+
+	out.Reset()
+	cli.output(&out, chainInfoOf(chain))
+	var infoJSON map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &infoJSON), out.String())
+	commitment, err := utxoSet.Commitment()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"blocks":             1.0,
+		"transactions":       1.0,
+		"tipHeight":          0.0,
+		"tipHash":            hex.EncodeToString(chain.Tip),
+		"targetBlockSeconds": float64(chain.Config.TargetBlockSeconds),
+		"utxoCommitment":     hex.EncodeToString(commitment),
+		"legal":              true,
+	}, infoJSON)
+
+	// the text is printed without -json
+	out.Reset()
+	cli.JSON = false
+	cli.output(&out, balance)
+	assert.Equal(t, fmt.Sprintf("The balance of '%s': %f\nThe spendable balance of '%s': %f (advisory, the immature "+
+		"coinbases are not locked yet)\n\n", addr, chain.CurrentReward(0), addr, 0.0), out.String())
+
+	// the total balance is still reported without the txid index
+	assert.Nil(t, chain.Db.Update(func(tx core.StoreTx) error {
+		return tx.DeleteBucket([]byte("TxIndex"))
+	}))
+	balance, err = balanceOf(chain, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, chain.CurrentReward(0), balance.Balance)
+	assert.Nil(t, balance.Spendable, "The spendable balance is unknown")
+	out.Reset()
+	cli.output(&out, balance)
+	assert.Contains(t, out.String(), "is unknown")
+	out.Reset()
+	cli.JSON = true
+	cli.output(&out, balance)
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &balanceJSON), out.String())
+	assert.Nil(t, balanceJSON["spendable"])
+}
+
+func TestTipInfo(t *testing.T) {
+	chain := newTestChain(t)
+	tip, err := chain.GetBlock(chain.Tip)
+	assert.Nil(t, err)
+	genesis, err := chain.GetBlock(tip.PrevBlockHash)
+	assert.Nil(t, err)
+	// the side branch forks at the genesis block, and is lower than the main branch
+	addr := string(core.NewWallet().GetAddr())
+	side := core.NewBlockWithPoW([]*core.Transaction{core.NewCoinbaseTx(addr, "", chain.CurrentReward(1), 1)},
+		genesis.Hash, 1, core.NoopPoW{})
+	chain.AddBlock(side)
+
+	info := tipInfoOf(chain)
+	assert.Equal(t, hex.EncodeToString(tip.Hash), info.TipHash)
+	assert.Equal(t, 1, info.TipHeight)
+	assert.Equal(t, []branchResult{
+		{hex.EncodeToString(tip.Hash), 1, info.TipWork, true},
+		{hex.EncodeToString(side.Hash), 1, info.TipWork, false},
+	}, info.Branches, "Both branches are reported, and the one stored first is selected")
+
+	var out bytes.Buffer
+	(&CLI{}).output(&out, info)
+	assert.Contains(t, out.String(), "* 1")
+	assert.Contains(t, out.String(), hex.EncodeToString(side.Hash))
+}
+
+func TestWalletOnlyCommands(t *testing.T) {
+	oldDataDir, oldWalletDir, oldRecordFile := core.DataDir, core.WalletDir, addrRecordFile
+	core.DataDir = filepath.Join(t.TempDir(), "db")
+	core.WalletDir = t.TempDir()
+	addrRecordFile = filepath.Join(t.TempDir(), "tmp", "addresses.dat")
+	t.Cleanup(func() {
+		core.DataDir, core.WalletDir, addrRecordFile = oldDataDir, oldWalletDir, oldRecordFile
+	})
+
+	cli := &CLI{}
+	cli.createWallet("test", core.Base58Check, false)
+	cli.createWallets("test", 2)
+	wallets, err := core.NewWallets("test")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(wallets.GetAddrs()), "The wallets are created without local lightChain")
+	cli.listAddrs("test")
+	cli.validateAddr(wallets.GetAddrs()[0])
+
+	recorded, err := ioutil.ReadFile(addrRecordFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strings.Count(string(recorded), "\n"))
+	_, err = os.Stat(core.DataDir)
+	assert.True(t, os.IsNotExist(err), "No chain db is created by the wallet commands")
+}
+
+func TestCreateChainFromCleanState(t *testing.T) {
+	oldDataDir := core.DataDir
+	core.DataDir = filepath.Join(t.TempDir(), "db")
+	t.Cleanup(func() { core.DataDir = oldDataDir })
+
+	wallet := core.NewWallet()
+	(&CLI{}).createBlockChain(string(wallet.GetAddr()), "test", "clean", 0.5, core.Sha256PoWName)
+	chain := openChain("test")
+	defer func() {
+		_ = chain.Db.Close()
+	}()
+	assert.Equal(t, 0, chain.GetChainHeight())
+	assert.Equal(t, 1, chain.GetBlocksNum())
+}
@@ -24,6 +24,10 @@ import (
 	`time`
 )
 
+// nowFunc returns the current time, which is the timestamp of the new blocks. Tests override it to mine blocks at
+// controlled timestamps.
+var nowFunc = time.Now
+
 // Block consists of the block header and the block body.
 type Block struct {
 	// block header
@@ -32,6 +36,7 @@ type Block struct {
 	Hash          []byte
 	Nonce         int
 	Height        int // the position of this block in main chain (the genesis block has Height 0)
+	Bits          int // the difficulty this block is mined at (see Difficulty)
 
 	// block body (a collection of transactions)
 	Transactions []*Transaction
@@ -39,22 +44,39 @@ type Block struct {
 
 // NewBlock generates a new block with slice of Transaction and previous block's hash.
 func NewBlock(txs []*Transaction, prevBlockHash []byte, height int) *Block {
+	return NewBlockWithPoW(txs, prevBlockHash, height, Sha256PoW{})
+}
+
+// NewBlockWithPoW generates a new block like NewBlock, but the block is mined through the given PoWStrategy.
+func NewBlockWithPoW(txs []*Transaction, prevBlockHash []byte, height int, pow PoWStrategy) *Block {
+	return NewBlockWithDifficulty(txs, prevBlockHash, height, targetBits, pow)
+}
+
+// NewBlockWithDifficulty generates a new block like NewBlockWithPoW, but the block is mined at difficulty bits, i.e.,
+// the hash of the block starts with bits 0 bits.
+func NewBlockWithDifficulty(txs []*Transaction, prevBlockHash []byte, height, bits int, pow PoWStrategy) *Block {
 	var block = &Block{
-		TimeStamp:     time.Now().Unix(),
+		TimeStamp:     nowFunc().Unix(),
 		PrevBlockHash: prevBlockHash,
 		Hash:          []byte{},
 		Nonce:         0,
 		Height:        height,
+		Bits:          bits,
 		Transactions:  txs}
 
-	pow := NewPoW(block)
-	nonce, hash := pow.Run()
+	nonce, hash := pow.Run(block)
 	block.Hash = hash
 	block.Nonce = nonce
 
 	return block
 }
 
+// Difficulty returns the number of 0 bits the hash of block starts with as its PoW target requires. The blocks mined
+// before the difficulty is stored in blocks (whose Bits is 0) are mined at targetBits.
+func (block *Block) Difficulty() int {
+	return difficultyOf(block.Bits)
+}
+
 // NewGenesisBlock generates the very first block of the chain with only one Transaction,
 // i.e. the coinbase transaction.
 func NewGenesisBlock(coinbaseTx *Transaction) *Block {
@@ -83,7 +105,7 @@ func DeserializeBlock(encodedData []byte) *Block {
 func (block *Block) HashingAllTxs() []byte {
 	var serializedTxData [][]byte
 	for _, tx := range block.Transactions {
-		serializedTxData = append(serializedTxData, tx.SerializeTx())
+		serializedTxData = append(serializedTxData, tx.serialize())
 	}
 	merkleTree, _ := NewMerkleTree(serializedTxData)
 	return merkleTree.RootNode.Data
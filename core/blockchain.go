@@ -22,57 +22,132 @@ import (
 	`encoding/hex`
 	`errors`
 	`fmt`
-	`github.com/boltdb/bolt`
 	`lightChain/utils`
 	`log`
 	`os`
+	`path/filepath`
+	`sort`
+	`sync`
 	`time`
 )
 
 const (
-	dbFile             = "./db/lightChain_%s.db" // A key-value db created by boltdb. The key is block hash, the value is block body.
-	blocksBucket       = "Blocks"                // The db has two buckets. One is blocksBucket (for blocks), another is utxoBucket (for UTXO).
-	initCoinbaseReward = 666.0                   // The initial reward to the miner who successfully mined a block.
-	rewardDecayNum     = 2016                    // Every rewardDecayNum blocks added to lightChain, halve the coinbase reward.
+	dbFile             = "lightChain_%s.db" // A key-value db created by boltdb. The key is block hash, the value is block body.
+	blocksBucket       = "Blocks"           // The db has two buckets. One is blocksBucket (for blocks), another is utxoBucket (for UTXO).
+	initCoinbaseReward = 666.0              // The initial reward to the miner who successfully mined a block.
+	rewardDecayNum     = 2016               // Every rewardDecayNum blocks added to lightChain, halve the coinbase reward (in default).
 )
 
+// ErrChainCorrupted is returned when opening a chain whose blocks bucket or tip is missing, e.g., a half-created chain.
+var ErrChainCorrupted = errors.New("chain db is empty or corrupted")
+
+// ErrChainExists is returned when creating a chain in a db which already has one, or which is held by another creator.
+var ErrChainExists = errors.New("chain already exists")
+
+// ErrNoChain is returned when opening the chain of a node which has no db file yet, i.e., the chain is not created
+// or copied to this node.
+var ErrNoChain = errors.New("no existing lightChain found, create one first")
+
+// ErrAmbiguousPrefix is returned when a hash prefix is too short to identify a block, or more than one block matches.
+var ErrAmbiguousPrefix = errors.New("ambiguous hash prefix")
+
+// MinHashPrefixLen is the minimum length (in bytes) of the hash prefix accepted by GetBlockByPrefix.
+const MinHashPrefixLen = 4
+
+// createTimeout is how long CreateBlockChain waits for the db file locked by another process.
+var createTimeout = 3 * time.Second
+
+// readOnlyTimeout is how long NewBlockChainReadOnly waits for the db file locked by a read-write opener.
+var readOnlyTimeout = 3 * time.Second
+
+// DataDir is the directory where the db files of all nodes are stored. Change it before creating or opening a chain.
+var DataDir = "./db"
+
 var genesisCoinbaseData = fmt.Sprintf("The genesis block of lightChain is created at %v", time.Now().Local())
 
 // BlockChain is a list of Block linked by hash pointers. It only saves the newest block hash and the pointer
 // to the local db file.
 type BlockChain struct {
-	Tip            []byte   // the newest block' hash
-	Db             *bolt.DB // the pointer-to-db where the chain stored
-	CoinbaseReward float64  // the coinbase reward value (decided by the chain length), this is the only way to generate new coins
+	Tip            []byte        // the newest block' hash
+	Db             Store         // the storage (a boltdb file in default) where the chain stored
+	CoinbaseReward float64       // the coinbase reward value (decided by the chain length), this is the only way to generate new coins
+	PoW            PoWStrategy   // the strategy to mine and validate blocks (resolved from Config.PoW, see PoWByName)
+	Config         GenesisConfig // the chain parameters decided by the creator
+
+	mu sync.Mutex // guards Tip, which is held until the new tip is committed
 }
 
 // CreateBlockChain creates the lightChain across the whole network. The node whose Id is nodeId (actually network.CentralNode)
-// does this creation. addr is its wallet address to receive the coinbase reward.
-func CreateBlockChain(addr, nodeId string) *BlockChain {
-	dbFile := fmt.Sprintf(dbFile, nodeId)
-	if ok, _ := utils.FileExists(dbFile); ok {
-		fmt.Println("lightChain is found in the whole network. You should not create it again.")
-		os.Exit(1)
+// does this creation. addr is its wallet address to receive the coinbase reward. config gives the chain parameters, where
+// config.GenesisMsg is embedded as the coinbase data of the genesis block (like the headline in bitcoin's genesis block).
+// ErrChainExists is returned if the node already has a chain, or another process is creating it at the same time. An
+// error wrapping ErrUnknownPoW is returned before the db file is touched if config.PoW is not known.
+func CreateBlockChain(addr, nodeId string, config GenesisConfig) (*BlockChain, error) {
+	if _, err := PoWByName(config.PoW); err != nil {
+		return nil, err
 	}
+	dbFile := getDbFile(nodeId)
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0644, nil)
+	// on a fresh checkout, the directory of the db file may not exist
+	err := os.MkdirAll(filepath.Dir(dbFile), 0755)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = db.Update(
-		func(tx *bolt.Tx) error {
+	// rather than checking the existence of dbFile before opening it, which races with another creator, rely on the
+	// exclusive file lock of boltdb and check the blocks bucket in the same transaction that creates it
+	store, err := OpenBoltStoreWithTimeout(dbFile, createTimeout)
+	if err == ErrStoreLocked {
+		return nil, ErrChainExists
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain, err := CreateBlockChainWithStore(store, addr, config)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return chain, nil
+}
+
+// CreateBlockChainWithStore works like CreateBlockChain, but the created chain is saved in store rather than the
+// db file of a node. ErrChainExists is returned if store already has the blocks bucket, and an error wrapping
+// ErrUnknownPoW if config.PoW is not known. The genesis block is mined through the PoW scheme of config.
+func CreateBlockChainWithStore(store Store, addr string, config GenesisConfig) (*BlockChain, error) {
+	pow, err := PoWByName(config.PoW)
+	if err != nil {
+		return nil, err
+	}
+	// create a coinbase tx ---> create the genesis block
+	genesisMsg := config.GenesisMsg
+	if genesisMsg == "" {
+		genesisMsg = genesisCoinbaseData
+	}
+	coinbaseTx := NewCoinbaseTx(addr, genesisMsg, initCoinbaseReward, 0)
+	return createBlockChainFrom(store, NewBlockWithPoW([]*Transaction{coinbaseTx}, []byte{}, 0, pow), config)
+}
+
+// createBlockChainFrom creates the chain with genesisBlock and config in store. ErrChainExists is returned if store
+// already has the blocks bucket, and an error wrapping ErrUnknownPoW if config.PoW is not known.
+func createBlockChainFrom(store Store, genesisBlock *Block, config GenesisConfig) (*BlockChain, error) {
+	pow, err := PoWByName(config.PoW)
+	if err != nil {
+		return nil, err
+	}
+	err = store.Update(
+		func(tx StoreTx) error {
+			if tx.Bucket([]byte(blocksBucket)) != nil {
+				return ErrChainExists
+			}
+
 			// create a bucket
 			bucket, err := tx.CreateBucket([]byte(blocksBucket))
 			if err != nil {
 				log.Panic(err)
 			}
 
-			// create a coinbase tx ---> create the genesis block
-			coinbaseTx := NewCoinbaseTx(addr, genesisCoinbaseData, initCoinbaseReward)
-			genesisBlock := NewGenesisBlock(coinbaseTx)
-
 			// add the genesis block to the blockchain
 			err = bucket.Put(genesisBlock.Hash, genesisBlock.SerializeBlock())
 			if err != nil {
@@ -84,52 +159,152 @@ func CreateBlockChain(addr, nodeId string) *BlockChain {
 			if err != nil {
 				log.Panic(err)
 			}
-			tip = genesisBlock.Hash
+			err = indexHeight(tx, genesisBlock)
+			if err != nil {
+				log.Panic(err)
+			}
+			err = saveConfig(tx, config)
+			if err != nil {
+				log.Panic(err)
+			}
+			err = saveGenesisHash(tx, genesisBlock.Hash)
+			if err != nil {
+				log.Panic(err)
+			}
 
 			return nil
 		})
+	if err == ErrChainExists {
+		return nil, err
+	}
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return &BlockChain{tip, db, initCoinbaseReward}
+	chain := BlockChain{Tip: genesisBlock.Hash, Db: store, PoW: pow, Config: config}
+	chain.DecCoinbaseReward()
+	return &chain, nil
 }
 
 // NewBlockChain requests lightChain from the whole network for the owner of nodeId and create a local db to save it.
 // It returns a pointer to local copied BlockChain. NOTE: Before calling this function, the node with nodeId should have
-// already copied the chain to its local storage.
-func NewBlockChain(nodeId string) *BlockChain {
-	dbFile := fmt.Sprintf(dbFile, nodeId)
+// already copied the chain to its local storage, otherwise ErrNoChain is returned.
+func NewBlockChain(nodeId string) (*BlockChain, error) {
+	dbFile := getDbFile(nodeId)
 	if ok, _ := utils.FileExists(dbFile); !ok {
-		fmt.Println("No existing lightChain found across the whole network. Create one first.")
-		os.Exit(1)
+		return nil, ErrNoChain
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0644, nil)
+	store, err := OpenBoltStore(dbFile)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = db.Update(
-		func(tx *bolt.Tx) error {
+	chain, err := NewBlockChainWithStore(store)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return chain, nil
+}
+
+// NewBlockChainReadOnly opens local lightChain of the owner of nodeId read-only (see OpenBoltStoreReadOnly), thus
+// the query-only callers can read it at the same time. ErrStoreLocked is returned if a read-write opener (e.g., a
+// running node) does not release it within readOnlyTimeout, and ErrNoChain is returned if the node has no chain.
+// Nothing can be written to the returned BlockChain, and its UTXO set is not rebuilt even if it is dirty.
+func NewBlockChainReadOnly(nodeId string) (*BlockChain, error) {
+	dbFile := getDbFile(nodeId)
+	if ok, _ := utils.FileExists(dbFile); !ok {
+		return nil, ErrNoChain
+	}
+
+	store, err := OpenBoltStoreReadOnly(dbFile, readOnlyTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := loadBlockChain(store)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	if utxoSet := (UTXOSet{BlockChain: chain}); utxoSet.IsDirty() {
+		fmt.Println("Warning: the UTXO set was not written completely last time. Open lightChain read-write to rebuild it.")
+	} else if utxoSet.hasLegacyEntries() {
+		fmt.Println("Warning: the UTXO set was written by an older version. Open lightChain read-write to rebuild it.")
+	}
+	return chain, nil
+}
+
+// NewBlockChainWithStore returns a pointer to the BlockChain saved in store. ErrChainCorrupted is returned if store
+// has no blocks bucket, no tip, or the tip block is missing, and an error wrapping ErrUnknownPoW if the PoW scheme of
+// the chain is not known. The UTXO set is rebuilt if it is dirty (see UTXOSet.IsDirty), or some entries of it are
+// written before TxOutputs.Indices exists.
+func NewBlockChainWithStore(store Store) (*BlockChain, error) {
+	chain, err := loadBlockChain(store)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last writing of the utxo set was interrupted, thus the set cannot be trusted
+	if utxoSet := (UTXOSet{BlockChain: chain}); utxoSet.IsDirty() {
+		fmt.Println("The UTXO set was not written completely last time. Rebuilding it...")
+		utxoSet.Rebuild()
+	} else if utxoSet.hasLegacyEntries() {
+		fmt.Println("The UTXO set was written by an older version. Rebuilding it...")
+		utxoSet.Rebuild()
+	}
+	return chain, nil
+}
+
+// loadBlockChain returns a pointer to the BlockChain saved in store without writing anything. See
+// NewBlockChainWithStore for the errors.
+func loadBlockChain(store Store) (*BlockChain, error) {
+	var tip []byte
+	var config GenesisConfig
+	err := store.View(
+		func(tx StoreTx) error {
 			bucket := tx.Bucket([]byte(blocksBucket))
-			tip = bucket.Get([]byte("l"))
-			return nil
+			if bucket == nil {
+				return ErrChainCorrupted
+			}
+			lastHash := bucket.Get([]byte("l"))
+			if lastHash == nil || bucket.Get(lastHash) == nil {
+				return ErrChainCorrupted
+			}
+			tip = append([]byte{}, lastHash...)
+
+			var err error
+			config, err = loadConfig(tx)
+			return err
 		})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	var chain = BlockChain{tip, db, initCoinbaseReward}
+	pow, err := PoWByName(config.PoW)
+	if err != nil {
+		return nil, err
+	}
+	var chain = BlockChain{Tip: tip, Db: store, PoW: pow, Config: config}
 	chain.DecCoinbaseReward()
-	return &chain
+	return &chain, nil
+}
+
+// getDbFile returns the path of the db file of the node with nodeId.
+func getDbFile(nodeId string) string {
+	return filepath.Join(DataDir, fmt.Sprintf(dbFile, nodeId))
 }
 
-// AddBlock adds block to chain by writing it to db.
-func (chain *BlockChain) AddBlock(block *Block) {
+// AddBlock adds block to chain by writing it to db, and reports whether block is written. It is idempotent: the
+// existence check and the write happen in a single db transaction, thus a block added concurrently (or again, e.g.,
+// when a download is retried) is written only once.
+func (chain *BlockChain) AddBlock(block *Block) bool {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	written := false
 	err := chain.Db.Update(
-		func(tx *bolt.Tx) error {
+		func(tx StoreTx) error {
 			bucket := tx.Bucket([]byte(blocksBucket))
 
 			// if this block has been put into blockchain beforehand, just return
@@ -143,6 +318,7 @@ func (chain *BlockChain) AddBlock(block *Block) {
 			if err != nil {
 				log.Panic(err)
 			}
+			written = true
 
 			// modify tip to the newest block
 			lastHash := bucket.Get([]byte("l"))
@@ -153,7 +329,15 @@ func (chain *BlockChain) AddBlock(block *Block) {
 				if err != nil {
 					log.Panic(err)
 				}
-				chain.Tip = block.Hash
+				err = indexHeight(tx, block)
+				if err != nil {
+					log.Panic(err)
+				}
+				err = putDirty(tx, true)
+				if err != nil {
+					log.Panic(err)
+				}
+				chain.setTip(block.Hash)
 			}
 
 			return nil
@@ -161,13 +345,29 @@ func (chain *BlockChain) AddBlock(block *Block) {
 	if err != nil {
 		log.Panic(err)
 	}
+	return written
+}
+
+// TipHash returns the hash of the tip of chain. Unlike reading chain.Tip directly, it is safe to call while the other
+// goroutines are adding blocks.
+func (chain *BlockChain) TipHash() []byte {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	return chain.Tip
+}
+
+// setTip sets chain.Tip to hash. It is called in the db transaction which updates the tip in db with chain.mu held
+// until the transaction is committed, thus the tip in memory is never read before its block is committed, and the
+// tips in db and in memory are changed in the same order.
+func (chain *BlockChain) setTip(hash []byte) {
+	chain.Tip = hash
 }
 
 // GetChainHeight returns the most recent block's height of chain.
 func (chain *BlockChain) GetChainHeight() int {
 	var lastBlock *Block
 	err := chain.Db.View(
-		func(tx *bolt.Tx) error {
+		func(tx StoreTx) error {
 			bucket := tx.Bucket([]byte(blocksBucket))
 			lastHash := bucket.Get([]byte("l"))
 			lastBlockData := bucket.Get(lastHash)
@@ -196,57 +396,213 @@ func (chain *BlockChain) GetBlocksNum() int {
 	return numBlocks
 }
 
+// Summary returns the number of blocks, the number of transactions, and the tip height of chain. Unlike calling
+// GetBlocksNum before walking the chain, they are computed in a single walk with EachTx, where each block is counted
+// at its first transaction (the coinbase, which every block packs).
+func (chain *BlockChain) Summary() (numBlocks, numTxs int, tipHeight int) {
+	var curBlock *Block
+	_ = chain.EachTx(func(tx *Transaction, block *Block) error {
+		if block != curBlock {
+			if curBlock == nil {
+				tipHeight = block.Height
+			}
+			curBlock = block
+			numBlocks++
+		}
+		numTxs++
+		return nil
+	})
+	return numBlocks, numTxs, tipHeight
+}
+
+// DifficultyPoint is the difficulty of the block at Height, which is mined at TimeStamp.
+type DifficultyPoint struct {
+	Height     int
+	TimeStamp  int64
+	Difficulty int
+}
+
+// DifficultyHistory returns the DifficultyPoint of each block of chain, from the oldest to the newest. Like Summary,
+// the blocks are walked with EachTx and each block is recorded at its first transaction.
+func (chain *BlockChain) DifficultyHistory() []DifficultyPoint {
+	var history []DifficultyPoint
+	var curBlock *Block
+	_ = chain.EachTx(func(tx *Transaction, block *Block) error {
+		if block != curBlock {
+			curBlock = block
+			history = append(history, DifficultyPoint{block.Height, block.TimeStamp, block.Difficulty()})
+		}
+		return nil
+	})
+
+	// EachTx walks from the newest to the oldest
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history
+}
+
+// GenesisHash returns the hash of the genesis block of chain, which is saved when chain is created. For chains
+// created before it is saved, the genesis block is found by walking chain.
+func (chain *BlockChain) GenesisHash() []byte {
+	var hash []byte
+	err := chain.Db.View(
+		func(tx StoreTx) error {
+			if bucket := tx.Bucket([]byte(configBucket)); bucket != nil {
+				if value := bucket.Get([]byte(genesisHashKey)); value != nil {
+					hash = append([]byte{}, value...)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+	if hash != nil {
+		return hash
+	}
+
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		if len(block.PrevBlockHash) == 0 {
+			return block.Hash
+		}
+	}
+}
+
 // ValidBlockChain checks whether chain is legal.
 func (chain *BlockChain) ValidBlockChain() bool {
 	return chain.GetBlocksNum() == chain.GetChainHeight()+1
 }
 
-// GetTx returns the specific Transaction denoted by blockIdx and txIdx.
+// GetTx returns the txIdx-th Transaction of the blockIdx-th block. Both indices start from 0, and blockIdx counts from
+// the newest block toward the genesis block, i.e. GetTx(0, 0) returns the first transaction of the newest block.
 func (chain *BlockChain) GetTx(blockIdx, txIdx int) (*Transaction, error) {
+	if blockIdx < 0 || txIdx < 0 {
+		return nil, ErrTxNotFound
+	}
 	iter := chain.Iterator()
-	numIdx := 0
-	for {
+	for numIdx := 0; ; numIdx++ {
 		block := iter.Next()
-		numIdx++
 		if numIdx == blockIdx {
+			if txIdx >= len(block.Transactions) {
+				return nil, fmt.Errorf("%w: block #%d has only %d transactions", ErrTxNotFound, blockIdx,
+					len(block.Transactions))
+			}
 			return block.Transactions[txIdx], nil
 		}
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
 	}
-	return nil, errors.New("transaction not found")
+	return nil, ErrTxNotFound
 }
 
-// DecCoinbaseReward decreases the coinbase reward every rewardDecayNum blocks.
+// DecCoinbaseReward sets chain.CoinbaseReward as the reward of the next block to be mined. The reward is derived from
+// the tip height (a single db read), rather than counting all blocks through a full chain walk.
 func (chain *BlockChain) DecCoinbaseReward() {
-	decayTimes := chain.GetBlocksNum() / rewardDecayNum
+	chain.CoinbaseReward = chain.CurrentReward(chain.GetChainHeight() + 1)
+}
+
+// CurrentReward returns the coinbase reward of the block at height. The reward starts from initCoinbaseReward and
+// is multiplied by Config.RewardDecayFactor every Config.RewardDecayNum blocks. Once the reward decays below
+// Config.RewardFloor, it is zero, thus the miners only earn the fees.
+func (chain *BlockChain) CurrentReward(height int) float64 {
+	reward := initCoinbaseReward
+	if chain.Config.RewardDecayNum <= 0 {
+		return reward
+	}
+	decayTimes := height / chain.Config.RewardDecayNum
 	for i := 0; i < decayTimes; i++ {
-		chain.CoinbaseReward /= 2
+		reward *= chain.Config.RewardDecayFactor
+		if reward < chain.Config.RewardFloor {
+			return 0
+		}
 	}
+	return reward
 }
 
-// GetBlock returns the pointer to the block whose hash is blockHash.
+// GetBlock returns the pointer to the block whose hash is blockHash. ErrBlockNotFound is returned if there is no such
+// block.
 func (chain *BlockChain) GetBlock(blockHash []byte) (*Block, error) {
 	var block *Block
 	err := chain.Db.View(
-		func(tx *bolt.Tx) error {
+		func(tx StoreTx) error {
 			bucket := tx.Bucket([]byte(blocksBucket))
+			if bucket == nil {
+				return ErrBlockNotFound
+			}
 			blockData := bucket.Get(blockHash)
 			if blockData == nil {
-				return errors.New("block not found")
+				return ErrBlockNotFound
 			}
 			block = DeserializeBlock(blockData)
 
 			return nil
 		})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	return block, nil
 }
 
+// GetBlockByPrefix returns the pointer to the unique block whose hash starts with prefix, which is at least
+// MinHashPrefixLen bytes long. The hashes of all stored blocks (including the ones on side branches) are searched. An
+// error wrapping ErrBlockNotFound is returned if no block matches, and an error wrapping ErrAmbiguousPrefix is returned
+// if prefix is too short or more than one block matches.
+func (chain *BlockChain) GetBlockByPrefix(prefix []byte) (*Block, error) {
+	if len(prefix) < MinHashPrefixLen {
+		return nil, fmt.Errorf("%w: %x is shorter than %d bytes", ErrAmbiguousPrefix, prefix, MinHashPrefixLen)
+	}
+	if block, err := chain.GetBlock(prefix); err == nil {
+		return block, nil
+	}
+
+	var matched [][]byte
+	err := chain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(blocksBucket))
+			if bucket == nil {
+				return nil
+			}
+			// the keys are sorted, thus the matched ones are contiguous from the first key not less than prefix. The key
+			// "l" of the tip is shorter than any prefix, thus only the block hashes are matched
+			cursor := bucket.Cursor()
+			for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+				matched = append(matched, append([]byte{}, key...))
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("%w: no block hash starts with %x", ErrBlockNotFound, prefix)
+	case 1:
+		return chain.GetBlock(matched[0])
+	default:
+		return nil, fmt.Errorf("%w: %d block hashes start with %x", ErrAmbiguousPrefix, len(matched), prefix)
+	}
+}
+
+// BlockDepth returns the depth of the block whose hash is blockHash, i.e., the number of blocks from it to the tip
+// (both included). The tip block has depth 1. A block left on a side branch by a fork has no depth.
+func (chain *BlockChain) BlockDepth(blockHash []byte) (int, error) {
+	block, err := chain.GetBlock(blockHash)
+	if err != nil {
+		return 0, err
+	}
+	mainHash, err := chain.BlockHashAtHeight(block.Height)
+	if err != nil || !bytes.Equal(mainHash, blockHash) {
+		return 0, fmt.Errorf("%w: %x is not on the main chain", ErrBlockNotFound, blockHash)
+	}
+	return chain.GetChainHeight() - block.Height + 1, nil
+}
+
 // GetAllBlocksHashes returns a slice of hashes, each for a block.
 func (chain *BlockChain) GetAllBlocksHashes() [][]byte {
 	var allHashes [][]byte
@@ -264,39 +620,105 @@ func (chain *BlockChain) GetAllBlocksHashes() [][]byte {
 	return allHashes
 }
 
+// GetBlocksHashesBetween returns the hashes of the blocks after the block fromHash (excluded) till the block toHash
+// (included), listed from the newest to the oldest as GetAllBlocksHashes does. An empty fromHash means the range
+// starts from the genesis block (included), and an empty toHash means the range ends at the tip. An error is returned
+// if fromHash or toHash is not on chain, or fromHash is not older than toHash.
+func (chain *BlockChain) GetBlocksHashesBetween(fromHash, toHash []byte) ([][]byte, error) {
+	allHashes := chain.GetAllBlocksHashes()
+	fromIdx, toIdx := len(allHashes), 0
+	if len(fromHash) > 0 {
+		fromIdx = indexOfHash(allHashes, fromHash)
+		if fromIdx < 0 {
+			return nil, fmt.Errorf("%w: block %x is not on chain", ErrBlockNotFound, fromHash)
+		}
+	}
+	if len(toHash) > 0 {
+		toIdx = indexOfHash(allHashes, toHash)
+		if toIdx < 0 {
+			return nil, fmt.Errorf("%w: block %x is not on chain", ErrBlockNotFound, toHash)
+		}
+	}
+	if fromIdx < toIdx {
+		return nil, fmt.Errorf("block %x is newer than block %x", fromHash, toHash)
+	}
+	return allHashes[toIdx:fromIdx], nil
+}
+
+// indexOfHash returns the index of hash in hashes, or -1 if not found.
+func indexOfHash(hashes [][]byte, hash []byte) int {
+	for idx := range hashes {
+		if bytes.Equal(hashes[idx], hash) {
+			return idx
+		}
+	}
+	return -1
+}
+
 // MineBlock appends a new block where txs are packed to chain through mining. Each new block is mined through PoW and
 // the key-value pair (block hash, serialized block data) will be stored into the db. Before mining, each transaction
-// packed in the block should be legal.
+// packed in the block should be legal, and a transaction spending the outputs of an unconfirmed parent should follow
+// the parent in txs (see OrderVerifiedTxs). The block is mined through chain.PoW at the retargeted difficulty (see
+// CalcNextDifficulty), and validated (see ValidateBlock) before stored. If another block is added to the tip during
+// mining, nothing is stored and nil is returned, since txs (e.g., the height of the coinbase) were packed for the old
+// tip. The caller should pack them again.
 func (chain *BlockChain) MineBlock(txs []*Transaction) *Block {
-	// verify all tx in txs
+	return chain.MineBlockWithPoW(txs, chain.PoW)
+}
+
+// MineBlockWithPoW works like MineBlock, but the new block is mined through the given PoWStrategy.
+func (chain *BlockChain) MineBlockWithPoW(txs []*Transaction, pow PoWStrategy) *Block {
+	// verify all tx in txs, each of which may spend the outputs of the preceding ones
+	inBlock := make(map[string]Transaction)
+	// the new block follows the tip, thus its transactions are final at the median time past of chain
+	medianTime := chain.MedianTimePast()
 	for _, tx := range txs {
-		if chain.VerifyTx(tx) != true {
+		if chain.VerifyTxWithPool(tx, inBlock) != true {
 			log.Panic("Error: invalid transaction found!")
 		}
+		if !tx.IsFinal(medianTime) {
+			log.Panic("Error: transaction locked until the future found!")
+		}
+		inBlock[hex.EncodeToString(tx.Id)] = *tx
 	}
 
-	// get the last block' hash for generating the new block
-	var lastHash []byte
-	var height int
-	err := chain.Db.View(
-		func(tx *bolt.Tx) error {
-			bucket := tx.Bucket([]byte(blocksBucket))
-			lastHash = bucket.Get([]byte("l"))
-			blockData := bucket.Get(lastHash)
-			block := DeserializeBlock(blockData)
-			height = block.Height
+	// get the last block for generating the new block
+	lastBlock, err := chain.GetBlock(chain.TipHash())
+	if err != nil {
+		log.Panic(err)
+	}
+	difficulty, err := nextDifficulty(lastBlock, chain.GetBlock, chain.Config.TargetBlockSeconds)
+	if err != nil {
+		log.Panic(err)
+	}
 
+	// construct a new block with height++ at the retargeted difficulty, validate it and store it into db
+	newBlock := NewBlockWithDifficulty(txs, lastBlock.Hash, lastBlock.Height+1, difficulty, pow)
+	if err := chain.validateBlockWith(newBlock, chain, pow); err != nil {
+		if !bytes.Equal(chain.TipHash(), lastBlock.Hash) {
 			return nil
-		})
-	if err != nil {
+		}
 		log.Panic(err)
 	}
+	if !chain.storeMinedBlock(newBlock) {
+		return nil
+	}
+	return newBlock
+}
 
-	// construct a new block with height++ and store it into db
-	newBlock := NewBlock(txs, lastHash, height+1)
-	err = chain.Db.Update(
-		func(tx *bolt.Tx) error {
+// storeMinedBlock stores newBlock as the new tip of chain, and returns true. The tip is checked in the same db
+// transaction holding chain.mu, thus nothing is written and false is returned if the tip is not the previous block of
+// newBlock any longer, e.g., another block is added by AddBlock during mining.
+func (chain *BlockChain) storeMinedBlock(newBlock *Block) bool {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	stored := false
+	err := chain.Db.Update(
+		func(tx StoreTx) error {
 			bucket := tx.Bucket([]byte(blocksBucket))
+			if !bytes.Equal(bucket.Get([]byte("l")), newBlock.PrevBlockHash) {
+				return nil
+			}
 			err := bucket.Put(newBlock.Hash, newBlock.SerializeBlock())
 			if err != nil {
 				log.Panic(err)
@@ -307,19 +729,74 @@ func (chain *BlockChain) MineBlock(txs []*Transaction) *Block {
 			if err != nil {
 				log.Panic(err)
 			}
+			err = indexHeight(tx, newBlock)
+			if err != nil {
+				log.Panic(err)
+			}
+			err = putDirty(tx, true)
+			if err != nil {
+				log.Panic(err)
+			}
 
-			chain.Tip = newBlock.Hash
+			chain.setTip(newBlock.Hash)
+			stored = true
 			return nil
 		})
 	if err != nil {
 		log.Panic(err)
 	}
+	return stored
+}
 
-	return newBlock
+// EachTx walks all blocks of chain from the newest to the oldest, and invokes fn for each transaction with the block
+// packing it. The walk stops on the first error returned by fn, and the error is returned.
+func (chain *BlockChain) EachTx(fn func(tx *Transaction, block *Block) error) error {
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			if err := fn(tx, block); err != nil {
+				return err
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			return nil
+		}
+	}
 }
 
-// FindTx returns a Transaction according to the Transaction Id, i.e. txId.
+// EachCoinbase walks all blocks of chain from the newest to the oldest, and invokes fn for the coinbase transaction of
+// each block with the block height.
+func (chain *BlockChain) EachCoinbase(fn func(tx *Transaction, height int)) {
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbaseTx() {
+				fn(tx, block.Height)
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			return
+		}
+	}
+}
+
+// FindTx returns a Transaction according to the Transaction Id, i.e. txId. The block packing it is found through the
+// txid index, or by walking back from the tip if the index misses it.
 func (chain *BlockChain) FindTx(txId []byte) (Transaction, error) {
+	if blockHash, err := chain.LookupTx(txId); err == nil {
+		if block, err := chain.GetBlock(blockHash); err == nil {
+			for _, tx := range block.Transactions {
+				if bytes.Equal(tx.Id, txId) {
+					return *tx, nil
+				}
+			}
+		}
+	}
+
 	iter := chain.Iterator()
 	for {
 		block := iter.Next()
@@ -334,53 +811,326 @@ func (chain *BlockChain) FindTx(txId []byte) (Transaction, error) {
 		}
 	}
 
-	return Transaction{}, errors.New("transaction not found")
+	return Transaction{}, ErrTxNotFound
+}
+
+// ScanForAddress returns the transactions involving addr (paying to addr, or spending the outputs of addr) in the
+// blocks from fromHeight to the tip, from the oldest to the newest. Thus a wallet which was offline can catch up from
+// the height it has seen. The blocks are found through the height index, or by walking back from the tip for the
+// chains created before the index.
+func (chain *BlockChain) ScanForAddress(addr string, fromHeight int) []Transaction {
+	pubKeyHash, err := AddrPubKeyHash(addr)
+	if err != nil {
+		return nil
+	}
+	if fromHeight < 0 {
+		fromHeight = 0
+	}
+
+	var blocks []*Block
+	for height := fromHeight; height <= chain.GetChainHeight(); height++ {
+		hash, err := chain.BlockHashAtHeight(height)
+		if err != nil {
+			blocks = chain.blocksFromHeight(fromHeight)
+			break
+		}
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	var txs []Transaction
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if tx.involves(pubKeyHash) {
+				txs = append(txs, *tx)
+			}
+		}
+	}
+	return txs
+}
+
+// blocksFromHeight returns the blocks from fromHeight to the tip, from the oldest to the newest, by walking back from
+// the tip.
+func (chain *BlockChain) blocksFromHeight(fromHeight int) []*Block {
+	var blocks []*Block
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		if block.Height < fromHeight {
+			break
+		}
+		blocks = append([]*Block{block}, blocks...)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	return blocks
 }
 
 // FindUTXO returns all the unspent outputs (a map: {key: txId, value: unspent outputs in this tx}).
 func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 	utxo := make(map[string]TxOutputs)
 	spentTxOutputs := make(map[string][]int)
-	iter := chain.Iterator()
 
-	for {
-		block := iter.Next()
-		for _, tx := range block.Transactions {
-			txId := hex.EncodeToString(tx.Id)
-
-		Outputs:
-			for txOutputIdx, txOutput := range tx.Vout {
-				if spentTxOutputs[txId] != nil {
-					// at least one txOutput of tx whose Id is txId is spent out
-					for _, spentOutIdx := range spentTxOutputs[txId] {
-						if txOutputIdx == spentOutIdx {
-							// this txOutput has been spent, goto the next txOutput
-							continue Outputs
-						}
+	// the blocks are walked from the newest to the oldest, thus the spending tx is always visited before the spent one
+	_ = chain.EachTx(func(tx *Transaction, block *Block) error {
+		txId := hex.EncodeToString(tx.Id)
+
+	Outputs:
+		for txOutputIdx, txOutput := range tx.Vout {
+			if spentTxOutputs[txId] != nil {
+				// at least one txOutput of tx whose Id is txId is spent out
+				for _, spentOutIdx := range spentTxOutputs[txId] {
+					if txOutputIdx == spentOutIdx {
+						// this txOutput has been spent, goto the next txOutput
+						continue Outputs
 					}
 				}
-				// this txOutput is not spent out, add it to utxo
-				txOutputs := utxo[txId]
-				txOutputs.Outputs = append(txOutputs.Outputs, txOutput)
-				utxo[txId] = txOutputs
-			}
-
-			// as the input of tx, it must be spent
-			// thus directly append the input tx' id and the corresponding txOutput idx to spentTxOutputs
-			if !tx.IsCoinbaseTx() {
-				for _, txInput := range tx.Vin {
-					inTxId := hex.EncodeToString(txInput.TxId)
-					spentTxOutputs[inTxId] = append(spentTxOutputs[inTxId], txInput.VoutIdx)
+			}
+			// this txOutput is not spent out, add it to utxo
+			txOutputs := utxo[txId]
+			txOutputs.add(txOutput, txOutputIdx)
+			utxo[txId] = txOutputs
+		}
+
+		// as the input of tx, it must be spent
+		// thus directly append the input tx' id and the corresponding txOutput idx to spentTxOutputs
+		if !tx.IsCoinbaseTx() {
+			for _, txInput := range tx.Vin {
+				inTxId := hex.EncodeToString(txInput.TxId)
+				spentTxOutputs[inTxId] = append(spentTxOutputs[inTxId], txInput.VoutIdx)
+			}
+		}
+		return nil
+	})
+
+	return utxo
+}
+
+// TotalSupply returns the number of coins that currently exist in chain, i.e. the sum of all the UTXO values. Since
+// value is conserved by every non-coinbase transaction, it should equal the result of IssuedSupply.
+func (chain *BlockChain) TotalSupply() float64 {
+	_, _, supply := UTXOSet{BlockChain: chain}.Stats()
+	return supply
+}
+
+// IssuedSupply returns the sum of the outputs of all the coinbase transactions in chain, i.e. all the coins that
+// have ever been issued as coinbase rewards.
+func (chain *BlockChain) IssuedSupply() float64 {
+	issued := 0.0
+	chain.EachCoinbase(func(tx *Transaction, height int) {
+		for _, txOutput := range tx.Vout {
+			issued += txOutput.Value
+		}
+	})
+
+	return issued
+}
+
+// ValidateBlock checks whether block obeys the consensus rules before it is added to chain: the PoW is validated by
+// chain.PoW, only the genesis block of chain is at height 0 (with an empty previous hash), any other block is mined at
+// the difficulty retargeted from its previous block (see CalcNextDifficulty), the block packs exactly one coinbase
+// transaction which is valid (see ValidateCoinbase) for the block height, and all the other transactions are final at
+// the median time past of the preceding blocks (see MedianTimePast and Transaction.IsFinal) and signed correctly with
+// strictly increasing nonces per sender (see CheckNonce). The previous transactions pointed by the inputs are searched
+// in the preceding transactions of block and chain. Every output is positive, and no output is spent twice, neither in
+// block nor by the blocks preceding it. The signatures are verified with VerifyWorkers workers in parallel. The
+// returned error wraps ErrInvalidBlock.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	if err := chain.validateBlock(block); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	return nil
+}
+
+// txLookup gives what is confirmed before a block to validateBlockWith: the genesis block, the preceding blocks, the
+// transactions, the highest nonces of the senders, and the median time past. It is chain itself, or the preceding
+// blocks in a dry run (see ValidateChain).
+type txLookup interface {
+	GenesisHash() []byte
+	GetBlock(blockHash []byte) (*Block, error)
+	FindTx(txId []byte) (Transaction, error)
+	HighestNonce(pubKeyHash []byte) uint64
+	medianTimePastBefore(block *Block) int64
+	spentBefore(block *Block, outpoints map[string]bool) string
+}
+
+// validateBlock does the checks of ValidateBlock.
+func (chain *BlockChain) validateBlock(block *Block) error {
+	return chain.validateBlockWith(block, chain, chain.PoW)
+}
+
+// validateBlockWith does the checks of ValidateBlock, where the genesis block, the previous transactions, and the
+// nonces are looked up in lookup, and the proof of work is validated by pow. Besides them, only chain.Config is used.
+func (chain *BlockChain) validateBlockWith(block *Block, lookup txLookup, pow PoWStrategy) error {
+	if !pow.Validate(block) {
+		return errors.New("invalid proof of work")
+	}
+	// the iteration stops at the block with an empty previous hash, thus only the genesis block can have it, and
+	// it must be the genesis block of chain
+	if len(block.PrevBlockHash) == 0 && block.Height != 0 {
+		return fmt.Errorf("block %x has an empty previous hash at height %d", block.Hash, block.Height)
+	}
+	if block.Height == 0 && !bytes.Equal(block.Hash, lookup.GenesisHash()) {
+		return fmt.Errorf("block %x at height 0 is not the genesis block %x", block.Hash, lookup.GenesisHash())
+	}
+	// otherwise the blocks mined at the minimum difficulty would be accepted forever
+	if block.Height > 0 {
+		prevBlock, err := lookup.GetBlock(block.PrevBlockHash)
+		if err != nil {
+			return fmt.Errorf("previous block %x of block %x is unknown", block.PrevBlockHash, block.Hash)
+		}
+		difficulty, err := nextDifficulty(prevBlock, lookup.GetBlock, chain.Config.TargetBlockSeconds)
+		if err != nil {
+			return err
+		}
+		if block.Difficulty() != difficulty {
+			return fmt.Errorf("block %x has difficulty %d rather than the retargeted %d", block.Hash,
+				block.Difficulty(), difficulty)
+		}
+	}
+
+	// the inputs may only refer to the transactions preceding them in block, thus txsInBlock is filled as the
+	// transactions are checked in order
+	txsInBlock := make(map[string]Transaction)
+	positions := make(map[string]int)
+	for idx, tx := range block.Transactions {
+		positions[hex.EncodeToString(tx.Id)] = idx
+	}
+
+	var coinbaseTx *Transaction
+	var jobs []sigJob
+	fees := 0.0
+	// the locktimes are compared with the median time past rather than the block time, which the miner can skew
+	medianTime := lookup.medianTimePastBefore(block)
+	// the highest nonce of each sender in block, which should strictly increase as well
+	nonces := make(map[string]uint64)
+	// the outputs spent by the preceding transactions of block, and the ones of them confirmed before block
+	spent := make(map[string]bool)
+	spentConfirmed := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		// tx itself is never looked up, since its inputs referring to itself are rejected
+		txsInBlock[hex.EncodeToString(tx.Id)] = *tx
+		if err := tx.CheckVersion(); err != nil {
+			return err
+		}
+		if tx.IsCoinbaseTx() {
+			if coinbaseTx != nil {
+				return errors.New("block packs more than one coinbase transaction")
+			}
+			coinbaseTx = tx
+			continue
+		}
+
+		if err := tx.CheckLimits(); err != nil {
+			return err
+		}
+		if !tx.IsFinal(medianTime) {
+			return fmt.Errorf("transaction %x is locked until %d, later than the median time past %d", tx.Id,
+				tx.LockTime, medianTime)
+		}
+		if tx.Nonce != 0 {
+			sender := hex.EncodeToString(tx.SenderPubKeyHash())
+			if tx.Nonce <= nonces[sender] {
+				return fmt.Errorf("transaction %x has nonce %d out of order in the block", tx.Id, tx.Nonce)
+			}
+			if err := checkNonce(tx, lookup); err != nil {
+				return err
+			}
+			nonces[sender] = tx.Nonce
+		}
+		prevTxs := make(map[string]Transaction)
+		inputValue, outputValue := 0.0, 0.0
+		for _, txInput := range tx.Vin {
+			prevTxId := hex.EncodeToString(txInput.TxId)
+			if bytes.Equal(txInput.TxId, tx.Id) {
+				return fmt.Errorf("input of transaction %x refers to the transaction itself", tx.Id)
+			}
+			prevTx, ok := txsInBlock[prevTxId]
+			if _, later := positions[prevTxId]; !ok && later {
+				return fmt.Errorf("input of transaction %x refers to the later transaction %s in the block", tx.Id,
+					prevTxId)
+			}
+			if !ok {
+				var err error
+				prevTx, err = lookup.FindTx(txInput.TxId)
+				if err != nil {
+					return fmt.Errorf("input of transaction %x refers to an unknown transaction %s", tx.Id, prevTxId)
 				}
 			}
+			if txInput.VoutIdx < 0 || txInput.VoutIdx >= len(prevTx.Vout) {
+				return fmt.Errorf("input of transaction %x refers to a nonexistent output", tx.Id)
+			}
+			outpoint := outpointOf(txInput)
+			if spent[outpoint] {
+				return fmt.Errorf("transaction %x double-spends the output %s in the block", tx.Id, outpoint)
+			}
+			spent[outpoint] = true
+			if !ok {
+				spentConfirmed[outpoint] = true
+			}
+			prevTxs[prevTxId] = prevTx
+			inputValue += prevTx.Vout[txInput.VoutIdx].Value
+		}
+		for _, txOutput := range tx.Vout {
+			// a negative output would raise the fees claimed by the coinbase
+			if txOutput.Value <= 0 {
+				return fmt.Errorf("transaction %x has a non-positive output %f", tx.Id, txOutput.Value)
+			}
+			outputValue += txOutput.Value
 		}
+		if outputValue > inputValue+valueTolerance {
+			return fmt.Errorf("transaction %x spends more than its inputs", tx.Id)
+		}
+		jobs = append(jobs, sigJob{tx, prevTxs, block.Height})
+		fees += inputValue - outputValue
+	}
+	// the outputs of the confirmed transactions are checked against the preceding blocks at once
+	if len(spentConfirmed) > 0 {
+		if outpoint := lookup.spentBefore(block, spentConfirmed); outpoint != "" {
+			return fmt.Errorf("block spends the output %s which is already spent", outpoint)
+		}
+	}
+	// the signatures are verified at last, in parallel
+	if idx := verifySignatures(jobs, VerifyWorkers); idx >= 0 {
+		return fmt.Errorf("transaction %x has invalid signature", jobs[idx].tx.Id)
+	}
 
-		if len(block.PrevBlockHash) == 0 {
+	if coinbaseTx == nil {
+		return errors.New("block packs no coinbase transaction")
+	}
+	return ValidateCoinbase(coinbaseTx, chain.CurrentReward(block.Height)+fees, block.Height)
+}
+
+// outpointOf returns the output spent by txInput as "txid:voutIdx", where txid is in hex.
+func outpointOf(txInput TxInput) string {
+	return fmt.Sprintf("%x:%d", txInput.TxId, txInput.VoutIdx)
+}
+
+// spentBefore returns the first one of outpoints (see outpointOf) which is spent by the blocks from the previous block
+// of block back to the genesis block, or "" if none of them is spent.
+func (chain *BlockChain) spentBefore(block *Block, outpoints map[string]bool) string {
+	for hash := block.PrevBlockHash; len(hash) != 0; {
+		prevBlock, err := chain.GetBlock(hash)
+		if err != nil {
 			break
 		}
+		for _, tx := range prevBlock.Transactions {
+			if tx.IsCoinbaseTx() {
+				continue
+			}
+			for _, txInput := range tx.Vin {
+				if outpoint := outpointOf(txInput); outpoints[outpoint] {
+					return outpoint
+				}
+			}
+		}
+		hash = prevBlock.PrevBlockHash
 	}
-
-	return utxo
+	return ""
 }
 
 /* The following two functions are wrappers to tx.Sign and tx.Verify. */
@@ -390,13 +1140,146 @@ func (chain *BlockChain) SignTx(tx *Transaction, privateKey ecdsa.PrivateKey) {
 	tx.Sign(privateKey, chain.getPrevTxs(tx))
 }
 
-// VerifyTx verifies the input's signature of the Transaction tx.
+// VerifyTx verifies the input's signature of the Transaction tx. The previous transactions pointed by the inputs of tx
+// should have been packed into chain, otherwise tx is regarded as invalid.
 func (chain *BlockChain) VerifyTx(tx *Transaction) bool {
+	return chain.VerifyTxWithPool(tx, nil)
+}
+
+// VerifyTxWithPool works like VerifyTx, but the previous transactions pointed by the inputs of tx can also be the
+// unconfirmed ones in pool, i.e., tx may spend the outputs of its unconfirmed parents. The key of pool is the
+// hex-encoded Id of the transaction.
+func (chain *BlockChain) VerifyTxWithPool(tx *Transaction, pool map[string]Transaction) bool {
+	if err := chain.CheckTxWithPool(tx, pool); err != nil {
+		fmt.Printf("Invalid transaction %x: %v\n", tx.Id, err)
+		return false
+	}
+	return true
+}
+
+// CheckTxWithPool does the checks of VerifyTxWithPool, and returns the reason why tx is invalid, e.g., to be reported
+// to the sender of tx. The error wraps ErrTxNotFound if an input refers to a transaction neither in chain nor in pool.
+func (chain *BlockChain) CheckTxWithPool(tx *Transaction, pool map[string]Transaction) error {
+	// check the version and the limits before touching the chain
+	if err := tx.CheckVersion(); err != nil {
+		return err
+	}
+	if err := tx.CheckLimits(); err != nil {
+		return err
+	}
+	if err := chain.CheckNonce(tx); err != nil {
+		return err
+	}
 	// this is where the bug occurs! I just fix this. :-)
 	if tx.IsCoinbaseTx() {
-		return true
+		return nil
+	}
+	prevTxs, err := chain.findPrevTxs(tx, pool)
+	if err != nil {
+		return err
+	}
+	if !tx.Verify(prevTxs) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// OrderVerifiedTxs returns the transactions in txs which are verified (see VerifyTxWithPool), where the unconfirmed
+// parents are looked up in the returned ones only. The returned transactions are in dependency order, i.e., each
+// parent precedes its children, and the ones of the same sender carrying nonces are in increasing nonce order, thus
+// they can be packed into a block in order. A child of an invalid transaction is dropped as well, so is a transaction
+// whose nonce is not higher than the one of an earlier transaction of its sender.
+func (chain *BlockChain) OrderVerifiedTxs(txs []*Transaction) []*Transaction {
+	var ordered []*Transaction
+	verified := make(map[string]Transaction)
+	nonces := make(map[string]uint64)
+	remaining := sortByNonce(txs)
+	for len(remaining) > 0 {
+		var pending []*Transaction
+		// the senders some of whose transactions are pending in this pass, thus their later nonces have to wait
+		blocked := make(map[string]bool)
+		for _, tx := range remaining {
+			sender := ""
+			if tx.Nonce != 0 {
+				sender = hex.EncodeToString(tx.SenderPubKeyHash())
+				if tx.Nonce <= nonces[sender] {
+					continue
+				}
+			}
+			if (sender == "" || !blocked[sender]) && chain.VerifyTxWithPool(tx, verified) {
+				ordered = append(ordered, tx)
+				verified[hex.EncodeToString(tx.Id)] = *tx
+				if sender != "" {
+					nonces[sender] = tx.Nonce
+				}
+			} else {
+				pending = append(pending, tx)
+				if sender != "" {
+					blocked[sender] = true
+				}
+			}
+		}
+		// no more transaction gets its parents verified
+		if len(pending) == len(remaining) {
+			break
+		}
+		remaining = pending
+	}
+	return ordered
+}
+
+// sortByNonce returns a copy of txs where the transactions of each sender carrying nonces are sorted in increasing
+// nonce order. They take the positions held by the transactions of the same sender, thus the order among the senders
+// (e.g., by fee rate) is kept.
+func sortByNonce(txs []*Transaction) []*Transaction {
+	sorted := make([]*Transaction, len(txs))
+	copy(sorted, txs)
+	positions := make(map[string][]int)
+	for idx, tx := range sorted {
+		if tx.Nonce != 0 {
+			sender := hex.EncodeToString(tx.SenderPubKeyHash())
+			positions[sender] = append(positions[sender], idx)
+		}
+	}
+	for _, idxs := range positions {
+		senderTxs := make([]*Transaction, len(idxs))
+		for i, idx := range idxs {
+			senderTxs[i] = sorted[idx]
+		}
+		sort.SliceStable(senderTxs, func(i, j int) bool { return senderTxs[i].Nonce < senderTxs[j].Nonce })
+		for i, idx := range idxs {
+			sorted[idx] = senderTxs[i]
+		}
+	}
+	return sorted
+}
+
+// findPrevTxs returns a map of transactions whose output is pointed by some input of tx, looked up in pool and chain.
+// An error is returned if some of them is found in neither, or some input refers to tx itself (which can not exist
+// before tx, but would be found if tx is pooled).
+func (chain *BlockChain) findPrevTxs(tx *Transaction, pool map[string]Transaction) (map[string]Transaction, error) {
+	prevTxs := make(map[string]Transaction)
+	for _, txInput := range tx.Vin {
+		if bytes.Equal(txInput.TxId, tx.Id) {
+			return nil, errors.New("input refers to the transaction itself")
+		}
+		prevTxId := hex.EncodeToString(txInput.TxId)
+		if prevTx, ok := pool[prevTxId]; ok {
+			prevTxs[prevTxId] = prevTx
+			continue
+		}
+		prevTx, err := chain.FindTx(txInput.TxId)
+		if err != nil {
+			return nil, fmt.Errorf("%w: input refers to an unknown transaction %s", ErrTxNotFound, prevTxId)
+		}
+		prevTxs[prevTxId] = prevTx
+	}
+	for _, txInput := range tx.Vin {
+		if txInput.VoutIdx < 0 || txInput.VoutIdx >= len(prevTxs[hex.EncodeToString(txInput.TxId)].Vout) {
+			return nil, errors.New("input refers to a nonexistent output")
+		}
 	}
-	return tx.Verify(chain.getPrevTxs(tx))
+	return prevTxs, nil
 }
 
 // getPrevTxs returns a map of transactions whose output is pointed by some input of tx.
@@ -416,11 +1299,15 @@ func (chain *BlockChain) getPrevTxs(tx *Transaction) map[string]Transaction {
 // IterOnChain is an iterator on the blockchain.
 type IterOnChain struct {
 	curBlockHash []byte
-	db           *bolt.DB
+	db           Store
 }
 
-// Iterator returns a pointer to IterOnChain.
+// Iterator returns a pointer to IterOnChain. The tip is captured under chain.mu, thus it is committed with all the
+// blocks linked from it, which are never rewritten. The iteration sees a consistent chain even if the blocks are added
+// concurrently.
 func (chain *BlockChain) Iterator() *IterOnChain {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
 	return &IterOnChain{chain.Tip, chain.Db}
 }
 
@@ -429,7 +1316,7 @@ func (chain *BlockChain) Iterator() *IterOnChain {
 func (iter *IterOnChain) Next() *Block {
 	var block *Block
 	err := iter.db.View(
-		func(tx *bolt.Tx) error {
+		func(tx StoreTx) error {
 			bucket := tx.Bucket([]byte(blocksBucket))
 			encodedBlock := bucket.Get(iter.curBlockHash)
 			block = DeserializeBlock(encodedBlock)
@@ -0,0 +1,850 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`encoding/hex`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`lightChain/utils`
+	`os`
+	`path/filepath`
+	`sync`
+	`sync/atomic`
+	`testing`
+	`time`
+)
+
+func TestCreateBlockChainGenesisMsg(t *testing.T) {
+	useTempDataDir(t)
+
+	chain1, _ := newTestChain(t, "1", "lightChain 15/Oct/2026 hello world")
+	chain2, _ := newTestChain(t, "2", "lightChain 15/Oct/2026 hello world")
+	chain3, _ := newTestChain(t, "3", "another message")
+
+	data1 := genesisOf(chain1).Transactions[0].Vin[0].PubKey
+	data2 := genesisOf(chain2).Transactions[0].Vin[0].PubKey
+	data3 := genesisOf(chain3).Transactions[0].Vin[0].PubKey
+
+	assert.Equal(t, []byte("lightChain 15/Oct/2026 hello world"), data1, "Genesis message is embedded")
+	assert.Equal(t, data1, data2, "Same message produces the same genesis coinbase data")
+	assert.NotEqual(t, data1, data3, "Different messages produce different genesis coinbase data")
+}
+
+func TestCreateBlockChainDefaultGenesisMsg(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, _ := newTestChain(t, "1", "")
+	assert.Equal(t, []byte(genesisCoinbaseData), genesisOf(chain).Transactions[0].Vin[0].PubKey)
+}
+
+func TestSupply(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	receiver := NewWallet()
+
+	for i := 0; i < 3; i++ {
+		tx, err := NewUTXOTx(wallet, string(receiver.GetAddr()), 100, &utxoSet)
+		assert.Nil(t, err)
+		mineTxs(chain, string(wallet.GetAddr()), tx)
+	}
+
+	expected := 4 * initCoinbaseReward
+	assert.Equal(t, expected, chain.IssuedSupply(), "Issued supply equals the sum of coinbase rewards")
+	assert.Equal(t, expected, chain.TotalSupply(), "Total supply equals the sum of coinbase rewards")
+}
+
+func TestCreateBlockChainInNonexistentDir(t *testing.T) {
+	dataDir := filepath.Join(useTempDataDir(t), "a", "b", "db")
+	DataDir = dataDir
+
+	newTestChain(t, "1", "")
+	ok, _ := utils.FileExists(filepath.Join(dataDir, "lightChain_1.db"))
+	assert.True(t, ok, "The directory tree of the db file is created")
+}
+
+func TestCreateBlockChainConcurrently(t *testing.T) {
+	useTempDataDir(t)
+	oldTimeout := createTimeout
+	createTimeout = 200 * time.Millisecond
+	defer func() {
+		createTimeout = oldTimeout
+	}()
+
+	type result struct {
+		chain *BlockChain
+		err   error
+	}
+	results := make(chan result, 2)
+	for _, msg := range []string{"first", "second"} {
+		go func(msg string) {
+			config := DefaultGenesisConfig()
+			config.GenesisMsg = msg
+			chain, err := CreateBlockChain(string(NewWallet().GetAddr()), "1", config)
+			results <- result{chain, err}
+		}(msg)
+	}
+	r1, r2 := <-results, <-results
+	if r1.chain == nil {
+		r1, r2 = r2, r1
+	}
+	assert.Nil(t, r1.err, "One creator succeeds")
+	assert.NotNil(t, r1.chain)
+	assert.Nil(t, r2.chain, "The other fails cleanly")
+	assert.Equal(t, ErrChainExists, r2.err)
+	winnerMsg := r1.chain.Config.GenesisMsg
+	assert.Nil(t, r1.chain.Db.Close())
+
+	// the created chain is not overwritten by a later creator
+	chain, err := CreateBlockChain(string(NewWallet().GetAddr()), "1", DefaultGenesisConfig())
+	assert.Nil(t, chain)
+	assert.Equal(t, ErrChainExists, err)
+	chain, err = NewBlockChain("1")
+	assert.Nil(t, err)
+	defer func() {
+		_ = chain.Db.Close()
+	}()
+	assert.Equal(t, winnerMsg, chain.Config.GenesisMsg)
+	assert.Equal(t, 0, chain.GetChainHeight())
+
+	// the same holds for other stores
+	store := NewMemStore()
+	_, err = CreateBlockChainWithStore(store, string(NewWallet().GetAddr()), DefaultGenesisConfig())
+	assert.Nil(t, err)
+	_, err = CreateBlockChainWithStore(store, string(NewWallet().GetAddr()), DefaultGenesisConfig())
+	assert.Equal(t, ErrChainExists, err)
+}
+
+func TestRewardDecayFactor(t *testing.T) {
+	useTempDataDir(t)
+
+	config := DefaultGenesisConfig()
+	config.RewardDecayFactor = 0.75
+	chain, _ := CreateBlockChain(string(NewWallet().GetAddr()), "1", config)
+	assert.Nil(t, chain.Db.Close())
+
+	// the factor is persisted and reloaded
+	chain, err := NewBlockChain("1")
+	assert.Nil(t, err)
+	defer func() {
+		_ = chain.Db.Close()
+	}()
+	assert.Equal(t, config, chain.Config)
+
+	expected := initCoinbaseReward
+	for interval := 0; interval < 4; interval++ {
+		height := interval * config.RewardDecayNum
+		assert.Equal(t, expected, chain.CurrentReward(height), "Reward follows a geometric schedule")
+		assert.Equal(t, expected, chain.CurrentReward(height+config.RewardDecayNum-1), "Reward is constant in an interval")
+		expected *= 0.75
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	addr := string(NewWallet().GetAddr())
+	config := DefaultGenesisConfig()
+	config.RewardFloor = 0
+	store := NewMemStore()
+	_, err := CreateBlockChainWithStore(store, addr, config)
+	assert.Nil(t, err)
+	reopened, err := NewBlockChainWithStore(store)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, reopened.Config.RewardFloor, "The zero RewardFloor is kept")
+	assert.Equal(t, config, reopened.Config)
+
+	// the config saved without the complete marker is filled with the defaults
+	legacy := config
+	legacy.PoW = ""
+	assert.Nil(t, store.Update(func(tx StoreTx) error {
+		bucket := tx.Bucket([]byte(configBucket))
+		if err := bucket.Put([]byte(configKey), utils.GobEncode(legacy)); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(completeKey))
+	}))
+	reopened, err = NewBlockChainWithStore(store)
+	assert.Nil(t, err)
+	assert.Equal(t, Sha256PoWName, reopened.Config.PoW, "The missing field takes the default")
+}
+
+func TestRewardFloor(t *testing.T) {
+	useTempDataDir(t)
+
+	wallet := NewWallet()
+	addr := string(wallet.GetAddr())
+	config := DefaultGenesisConfig()
+	config.RewardDecayNum = 1
+	config.RewardFloor = 50
+	chain, _ := CreateBlockChain(addr, "1", config)
+	defer func() {
+		_ = chain.Db.Close()
+	}()
+
+	assert.Equal(t, 83.25, chain.CurrentReward(3))
+	assert.Equal(t, 0.0, chain.CurrentReward(4), "Reward below the floor is clamped to zero")
+	assert.Equal(t, 0.0, chain.CurrentReward(100), "Reward stays zero after the floor")
+
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	for chain.GetChainHeight() < 3 {
+		mineTxs(chain, addr)
+	}
+
+	// pay a fee of 1 by lowering the change
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	tx.Vout[1].Value -= 1
+	tx.Id = tx.Hashing()
+	chain.SignTx(tx, wallet.PrivateKey)
+
+	height := chain.GetChainHeight() + 1
+	valid := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", 1, height), tx}, chain.Tip, height)
+	assert.Nil(t, chain.ValidateBlock(valid), "The fees still flow to the miner")
+	overClaimed := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", 2, height), tx}, chain.Tip, height)
+	assert.NotNil(t, chain.ValidateBlock(overClaimed), "Coinbase claiming more than the fees is rejected")
+}
+
+func TestValidateBlock(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	height := chain.GetChainHeight() + 1
+	addr := string(wallet.GetAddr())
+
+	valid := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)}, chain.Tip, height)
+	assert.Nil(t, chain.ValidateBlock(valid), "Block with a valid coinbase is accepted")
+
+	twoCoinbases := NewBlock([]*Transaction{
+		NewCoinbaseTx(addr, "1", chain.CurrentReward(height), height),
+		NewCoinbaseTx(addr, "2", chain.CurrentReward(height), height),
+	}, chain.Tip, height)
+	assert.NotNil(t, chain.ValidateBlock(twoCoinbases), "Block with two coinbases is rejected")
+
+	overpaid := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height)+1, height)}, chain.Tip, height)
+	assert.NotNil(t, chain.ValidateBlock(overpaid), "Block whose coinbase exceeds the reward is rejected")
+}
+
+func TestValidateBlockFees(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	addr := string(wallet.GetAddr())
+
+	// pay a fee of 1 by lowering the change
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	tx.Vout[1].Value -= 1
+	tx.Id = tx.Hashing()
+	chain.SignTx(tx, wallet.PrivateKey)
+
+	height := chain.GetChainHeight() + 1
+	reward := chain.CurrentReward(height)
+	rewardOnly := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", reward, height), tx}, chain.Tip, height)
+	assert.Nil(t, chain.ValidateBlock(rewardOnly), "Coinbase may leave the fees unclaimed")
+	withFees := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", reward+1, height), tx}, chain.Tip, height)
+	assert.Nil(t, chain.ValidateBlock(withFees), "Coinbase claiming the reward plus the fees is accepted")
+	overClaimed := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", reward+1.5, height), tx}, chain.Tip, height)
+	err = chain.ValidateBlock(overClaimed)
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "Coinbase claiming more than the reward plus the fees is rejected")
+}
+
+func TestValidateBlockInflation(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	addr := string(wallet.GetAddr())
+	resign := func(tx *Transaction) {
+		tx.Id = tx.Hashing()
+		chain.SignTx(tx, wallet.PrivateKey)
+	}
+	// blockOf packs txs into a block whose coinbase claims all the fees they seem to pay
+	blockOf := func(fees float64, txs ...*Transaction) *Block {
+		height := chain.GetChainHeight() + 1
+		coinbaseTx := NewCoinbaseTx(addr, "", chain.CurrentReward(height)+fees, height)
+		return NewBlock(append([]*Transaction{coinbaseTx}, txs...), chain.Tip, height)
+	}
+
+	toA, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	toB, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	assert.Equal(t, toA.Vin[0].TxId, toB.Vin[0].TxId, "Both spend the genesis output")
+
+	doubleInput := toA.Copy()
+	doubleInput.Vin = append(doubleInput.Vin, doubleInput.Vin[0])
+	resign(&doubleInput)
+	err = chain.ValidateBlock(blockOf(chain.CurrentReward(0), &doubleInput))
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "The output spent twice by a tx is rejected")
+
+	err = chain.ValidateBlock(blockOf(0, toA, toB))
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "The output spent by two txs of a block is rejected")
+
+	negative := toA.Copy()
+	negative.Vout = append(negative.Vout, TxOutput{Value: -100, PubKeyHash: HashingPubKey(wallet.PubKey)})
+	resign(&negative)
+	err = chain.ValidateBlock(blockOf(100, &negative))
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "The negative output is rejected")
+
+	spending := blockOf(0, toA)
+	assert.Nil(t, chain.ValidateBlock(spending))
+	chain.AddBlock(spending)
+	err = chain.ValidateBlock(blockOf(0, toB))
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "The output spent on chain is rejected")
+
+	errs := ValidateChain([]*Block{genesisOf(chain), spending, blockOf(0, toB)}, chain.Config, chain.PoW)
+	assert.Equal(t, 1, len(errs), "The dry run rejects the output spent by a preceding block")
+}
+
+func TestValidateGenesis(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	addr := string(wallet.GetAddr())
+	genesis := genesisOf(chain)
+	assert.Equal(t, genesis.Hash, chain.GenesisHash())
+	assert.Nil(t, chain.ValidateBlock(genesis), "The genesis block of chain is accepted")
+
+	height := chain.GetChainHeight() + 1
+	emptyPrev := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)}, []byte{}, height)
+	err := chain.ValidateBlock(emptyPrev)
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "Block with an empty previous hash at a non-zero height is rejected")
+
+	otherGenesis := NewGenesisBlock(NewCoinbaseTx(addr, "another genesis", chain.CurrentReward(0), 0))
+	err = chain.ValidateBlock(otherGenesis)
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "Block at height 0 with an unexpected hash is rejected")
+}
+
+func TestAddBlockConcurrently(t *testing.T) {
+	addr := string(NewWallet().GetAddr())
+	chain, _ := CreateBlockChainWithStore(NewMemStore(), addr, DefaultGenesisConfig())
+	height := chain.GetChainHeight() + 1
+	block := NewBlockWithPoW([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)}, chain.Tip,
+		height, NoopPoW{})
+
+	var wg sync.WaitGroup
+	var written int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if chain.AddBlock(block) {
+				atomic.AddInt32(&written, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), written, "The block is written exactly once")
+	assert.Equal(t, block.Hash, chain.Tip)
+	assert.Equal(t, 2, chain.GetBlocksNum())
+	reopened, err := NewBlockChainWithStore(chain.Db)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, reopened.Tip, "The tips in db and in memory are consistent")
+	assert.False(t, chain.AddBlock(block), "Adding the block again is a no-op")
+}
+
+// interruptingPoW adds block to chain when it is run for the first time, as if another node's block arrives during
+// mining.
+type interruptingPoW struct {
+	NoopPoW
+	chain *BlockChain
+	block *Block
+}
+
+func (pow *interruptingPoW) Run(block *Block) (int, []byte) {
+	if pow.block != nil {
+		pow.chain.AddBlock(pow.block)
+		pow.block = nil
+	}
+	return pow.NoopPoW.Run(block)
+}
+
+func TestMineBlockOnConcurrentTip(t *testing.T) {
+	addr := string(NewWallet().GetAddr())
+	chain, _ := CreateBlockChainWithStore(NewMemStore(), addr, DefaultGenesisConfig())
+	height := chain.GetChainHeight() + 1
+	arrived := NewBlockWithPoW([]*Transaction{NewCoinbaseTx(addr, "arrived", chain.CurrentReward(height), height)},
+		chain.TipHash(), height, NoopPoW{})
+
+	pow := &interruptingPoW{chain: chain, block: arrived}
+	mined := chain.MineBlockWithPoW([]*Transaction{NewCoinbaseTx(addr, "mined", chain.CurrentReward(height), height)},
+		pow)
+	assert.Nil(t, mined, "The block packed for the old tip is not stored")
+	assert.Equal(t, arrived.Hash, chain.TipHash())
+	assert.Equal(t, 2, chain.GetBlocksNum())
+
+	// packed again for the new tip
+	height++
+	mined = chain.MineBlockWithPoW([]*Transaction{NewCoinbaseTx(addr, "mined", chain.CurrentReward(height), height)},
+		NoopPoW{})
+	assert.Equal(t, arrived.Hash, mined.PrevBlockHash)
+	assert.Equal(t, mined.Hash, chain.TipHash())
+}
+
+func TestHeightIndex(t *testing.T) {
+	chain, wallet := newMemChain(t, DefaultGenesisConfig())
+	mainChain := []*Block{genesisOf(chain)}
+	for height := 1; height <= 4; height++ {
+		mainChain = append(mainChain, mineTxs(chain, string(wallet.GetAddr())))
+	}
+	for height, block := range mainChain {
+		hash, err := chain.BlockHashAtHeight(height)
+		assert.Nil(t, err)
+		assert.Equal(t, block.Hash, hash)
+	}
+	_, err := chain.BlockHashAtHeight(5)
+	assert.True(t, errors.Is(err, ErrBlockNotFound))
+
+	// a longer fork from block 1 becomes the main chain once its tip is added
+	fork := []*Block{mainChain[1]}
+	for height := 2; height <= 5; height++ {
+		block := forkBlock(fork[len(fork)-1])
+		assert.True(t, chain.AddBlock(block))
+		fork = append(fork, block)
+	}
+	assert.Equal(t, fork[len(fork)-1].Hash, chain.Tip)
+	for _, block := range append(mainChain[:2], fork[1:]...) {
+		hash, err := chain.BlockHashAtHeight(block.Height)
+		assert.Nil(t, err)
+		assert.Equal(t, block.Hash, hash, "The block at height %d follows the new tip", block.Height)
+	}
+}
+
+func TestScanForAddress(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	walletB, walletC := NewWallet(), NewWallet()
+	addrB, addrC := string(walletB.GetAddr()), string(walletC.GetAddr())
+
+	toB, err := NewUTXOTx(wallet, addrB, 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(wallet.GetAddr()), toB)
+	mineTxs(chain, string(wallet.GetAddr()))
+	toC, err := NewUTXOTx(wallet, addrC, 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(wallet.GetAddr()), toC)
+	fromB, err := NewUTXOTx(walletB, string(NewWallet().GetAddr()), 5, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(wallet.GetAddr()), fromB)
+
+	idsOf := func(txs []Transaction) [][]byte {
+		var ids [][]byte
+		for _, tx := range txs {
+			ids = append(ids, tx.Id)
+		}
+		return ids
+	}
+	assert.Equal(t, [][]byte{fromB.Id}, idsOf(chain.ScanForAddress(addrB, 2)), "Only the recent ones are scanned")
+	assert.Equal(t, [][]byte{toB.Id, fromB.Id}, idsOf(chain.ScanForAddress(addrB, 0)))
+	assert.Equal(t, [][]byte{toC.Id}, idsOf(chain.ScanForAddress(addrC, 2)))
+	assert.Empty(t, chain.ScanForAddress(addrC, 4))
+	assert.Empty(t, chain.ScanForAddress(addrB, 5), "Nothing is scanned above the tip")
+	assert.Nil(t, chain.ScanForAddress("invalid address", 0))
+
+	// the chain created before the height index is scanned by walking back from the tip
+	err = chain.Db.Update(func(tx StoreTx) error {
+		return tx.DeleteBucket([]byte(heightIndexBucket))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{fromB.Id}, idsOf(chain.ScanForAddress(addrB, 2)))
+	assert.Equal(t, [][]byte{toB.Id, fromB.Id}, idsOf(chain.ScanForAddress(addrB, 0)))
+}
+
+func TestBlockDepth(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	hashes := [][]byte{chain.Tip}
+	for i := 0; i < 3; i++ {
+		height := chain.GetChainHeight() + 1
+		coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+		hashes = append(hashes, chain.MineBlock([]*Transaction{coinbaseTx}).Hash)
+	}
+
+	// hashes are from the oldest (genesis) to the newest (tip)
+	for idx, hash := range hashes {
+		depth, err := chain.BlockDepth(hash)
+		assert.Nil(t, err)
+		assert.Equal(t, len(hashes)-idx, depth)
+	}
+
+	_, err := chain.BlockDepth([]byte("unknown"))
+	assert.NotNil(t, err, "Unknown block has no depth")
+}
+
+func TestGetBlockByPrefix(t *testing.T) {
+	chain, wallet := newMemChain(t, DefaultGenesisConfig())
+	tip := mineTxs(chain, string(wallet.GetAddr()))
+
+	block, err := chain.GetBlockByPrefix(tip.Hash[:MinHashPrefixLen])
+	assert.Nil(t, err)
+	assert.Equal(t, tip.Hash, block.Hash, "The unique prefix is resolved")
+	block, err = chain.GetBlockByPrefix(tip.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, tip.Hash, block.Hash, "The full hash is a prefix of itself")
+
+	_, err = chain.GetBlockByPrefix(tip.Hash[:MinHashPrefixLen-1])
+	assert.True(t, errors.Is(err, ErrAmbiguousPrefix), "The too short prefix is refused")
+
+	// store another block under a hash sharing the prefix of the tip
+	similarHash := append([]byte{}, tip.Hash...)
+	similarHash[len(similarHash)-1]++
+	assert.Nil(t, chain.Db.Update(func(tx StoreTx) error {
+		return tx.Bucket([]byte(blocksBucket)).Put(similarHash, tip.SerializeBlock())
+	}))
+	_, err = chain.GetBlockByPrefix(tip.Hash[:MinHashPrefixLen])
+	assert.True(t, errors.Is(err, ErrAmbiguousPrefix), "The prefix shared by two blocks is ambiguous")
+	_, err = chain.GetBlockByPrefix(tip.Hash)
+	assert.Nil(t, err, "The full hash is never ambiguous")
+
+	unknown := append([]byte{}, tip.Hash[:MinHashPrefixLen]...)
+	unknown[0]++
+	_, err = chain.GetBlockByPrefix(unknown)
+	assert.True(t, errors.Is(err, ErrBlockNotFound), "The prefix matching nothing is not found")
+}
+
+func TestGetBlocksHashesBetween(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	for i := 0; i < 4; i++ {
+		height := chain.GetChainHeight() + 1
+		chain.MineBlock([]*Transaction{NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)})
+	}
+	// from the newest to the oldest: 4, 3, 2, 1, 0
+	allHashes := chain.GetAllBlocksHashes()
+
+	hashes, err := chain.GetBlocksHashesBetween(allHashes[4], allHashes[1])
+	assert.Nil(t, err)
+	assert.Equal(t, allHashes[1:4], hashes, "Blocks after from till to are returned in order")
+
+	hashes, err = chain.GetBlocksHashesBetween(allHashes[2], nil)
+	assert.Nil(t, err)
+	assert.Equal(t, allHashes[:2], hashes, "Empty to means the tip")
+
+	hashes, err = chain.GetBlocksHashesBetween(nil, allHashes[3])
+	assert.Nil(t, err)
+	assert.Equal(t, allHashes[3:], hashes, "Empty from means the genesis block")
+
+	hashes, err = chain.GetBlocksHashesBetween(chain.Tip, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, hashes, "Nothing is after the tip")
+
+	_, err = chain.GetBlocksHashesBetween(allHashes[1], allHashes[3])
+	assert.NotNil(t, err, "From newer than to is rejected")
+	_, err = chain.GetBlocksHashesBetween([]byte("unknown"), nil)
+	assert.NotNil(t, err, "Unknown from is rejected")
+}
+
+func TestCoinbaseRewardOnOpen(t *testing.T) {
+	store := NewMemStore()
+	config := DefaultGenesisConfig()
+	config.RewardDecayNum = 2
+	wallet := NewWallet()
+	chain, _ := CreateBlockChainWithStore(store, string(wallet.GetAddr()), config)
+	assert.Equal(t, initCoinbaseReward, chain.CoinbaseReward)
+
+	for i := 0; i < 4; i++ {
+		height := chain.GetChainHeight() + 1
+		chain.MineBlock([]*Transaction{NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)})
+
+		// the reward of the next block is derived from the tip height on open
+		reopened, err := NewBlockChainWithStore(store)
+		assert.Nil(t, err)
+		assert.Equal(t, chain.CurrentReward(reopened.GetBlocksNum()), reopened.CoinbaseReward)
+		assert.Equal(t, chain.CurrentReward(height+1), reopened.CoinbaseReward)
+	}
+	reopened, _ := NewBlockChainWithStore(store)
+	assert.Equal(t, initCoinbaseReward/4, reopened.CoinbaseReward)
+}
+
+func BenchmarkNewBlockChainWithStore(b *testing.B) {
+	store := NewMemStore()
+	addr := string(NewWallet().GetAddr())
+	chain, _ := CreateBlockChainWithStore(store, addr, DefaultGenesisConfig())
+	for i := 0; i < 200; i++ {
+		height := chain.GetChainHeight() + 1
+		chain.MineBlockWithPoW([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)}, NoopPoW{})
+	}
+
+	// opening the chain costs the same no matter how long the chain is
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = NewBlockChainWithStore(store)
+	}
+}
+
+func TestNewBlockChainCorrupted(t *testing.T) {
+	useTempDataDir(t)
+
+	// an empty db file
+	file, err := os.Create(getDbFile("1"))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	_, err = NewBlockChain("1")
+	assert.Equal(t, ErrChainCorrupted, err, "Empty db file is reported")
+
+	// a db file with the blocks bucket but no tip
+	store, err := OpenBoltStore(getDbFile("2"))
+	assert.Nil(t, err)
+	assert.Nil(t, store.Update(func(tx StoreTx) error {
+		_, err := tx.CreateBucket([]byte(blocksBucket))
+		return err
+	}))
+	assert.Nil(t, store.Close())
+	_, err = NewBlockChain("2")
+	assert.Equal(t, ErrChainCorrupted, err, "Db file without tip is reported")
+
+	// a tip pointing to a missing block
+	store = NewMemStore()
+	assert.Nil(t, store.Update(func(tx StoreTx) error {
+		bucket, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("l"), []byte("missing"))
+	}))
+	_, err = NewBlockChainWithStore(store)
+	assert.Equal(t, ErrChainCorrupted, err, "Tip without block is reported")
+}
+
+func TestNewBlockChainWithoutDb(t *testing.T) {
+	useTempDataDir(t)
+	_, err := NewBlockChain("1")
+	assert.Equal(t, ErrNoChain, err)
+	_, err = NewBlockChainReadOnly("1")
+	assert.Equal(t, ErrNoChain, err)
+}
+
+func TestNewBlockChainReadOnly(t *testing.T) {
+	useTempDataDir(t)
+	oldTimeout := readOnlyTimeout
+	readOnlyTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { readOnlyTimeout = oldTimeout })
+
+	chain, wallet := newTestChain(t, "1", "")
+	height := chain.GetChainHeight() + 1
+	chain.MineBlock([]*Transaction{NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)})
+	_, err := NewBlockChainReadOnly("1")
+	assert.Equal(t, ErrStoreLocked, err, "The chain held by a read-write opener is not opened")
+	assert.Nil(t, chain.Db.Close())
+
+	reader, err := NewBlockChainReadOnly("1")
+	assert.Nil(t, err)
+	defer func() {
+		_ = reader.Db.Close()
+	}()
+	another, err := NewBlockChainReadOnly("1")
+	assert.Nil(t, err, "The read-only opens share the chain")
+	defer func() {
+		_ = another.Db.Close()
+	}()
+	assert.Equal(t, chain.Tip, reader.Tip)
+	assert.Equal(t, chain.Tip, another.Tip)
+	assert.Equal(t, 2, another.GetBlocksNum())
+
+	err = reader.Db.Update(func(tx StoreTx) error {
+		return nil
+	})
+	assert.Equal(t, ErrStoreReadOnly, err, "The writes are refused")
+}
+
+func TestIterateConcurrently(t *testing.T) {
+	addr := string(NewWallet().GetAddr())
+	chain, _ := CreateBlockChainWithStore(NewMemStore(), addr, DefaultGenesisConfig())
+	genesis := genesisOf(chain)
+	var blocks []*Block
+	prevHash := genesis.Hash
+	for height := 1; height <= 50; height++ {
+		coinbaseTx := NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)
+		block := NewBlockWithPoW([]*Transaction{coinbaseTx}, prevHash, height, NoopPoW{})
+		blocks = append(blocks, block)
+		prevHash = block.Hash
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, block := range blocks {
+			chain.AddBlock(block)
+		}
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		iter := chain.Iterator()
+		block := iter.Next()
+		for block.Height > 0 {
+			prev := iter.Next()
+			assert.Equal(t, block.PrevBlockHash, prev.Hash)
+			assert.Equal(t, block.Height-1, prev.Height, "Each block links to its parent")
+			block = prev
+		}
+		assert.Equal(t, genesis.Hash, block.Hash, "The iteration ends at the genesis block")
+	}
+
+	iter := chain.Iterator()
+	assert.Equal(t, blocks[len(blocks)-1].Hash, iter.Next().Hash)
+}
+
+func TestEachTx(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	expected := map[string]int{hex.EncodeToString(genesisOf(chain).Transactions[0].Id): 0}
+	for i := 0; i < 3; i++ {
+		height := chain.GetChainHeight() + 1
+		tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+		assert.Nil(t, err)
+		coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+		block := chain.MineBlock([]*Transaction{coinbaseTx, tx})
+		utxoSet.Update(block)
+		expected[hex.EncodeToString(tx.Id)] = block.Height
+		expected[hex.EncodeToString(coinbaseTx.Id)] = block.Height
+	}
+
+	visited := make(map[string]int)
+	err := chain.EachTx(func(tx *Transaction, block *Block) error {
+		txId := hex.EncodeToString(tx.Id)
+		_, ok := visited[txId]
+		assert.False(t, ok, "Each transaction is visited once")
+		visited[txId] = block.Height
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, visited, "Every transaction is visited with its block")
+
+	stop := errors.New("stop")
+	numVisited := 0
+	err = chain.EachTx(func(tx *Transaction, block *Block) error {
+		numVisited++
+		if numVisited == 3 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err, "The error returned by fn is returned")
+	assert.Equal(t, 3, numVisited, "Returning an error stops the walk")
+}
+
+func TestEachCoinbase(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	expected := map[int][]byte{0: genesisOf(chain).Transactions[0].Id}
+	for i := 0; i < 3; i++ {
+		height := chain.GetChainHeight() + 1
+		tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+		assert.Nil(t, err)
+		coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+		utxoSet.Update(chain.MineBlock([]*Transaction{tx, coinbaseTx}))
+		expected[height] = coinbaseTx.Id
+	}
+
+	visited := make(map[int][]byte)
+	var heights []int
+	chain.EachCoinbase(func(tx *Transaction, height int) {
+		assert.True(t, tx.IsCoinbaseTx())
+		_, ok := visited[height]
+		assert.False(t, ok, "Exactly one coinbase is visited per block")
+		visited[height] = tx.Id
+		heights = append(heights, height)
+	})
+	assert.Equal(t, expected, visited, "Every coinbase is visited with its height")
+	assert.Equal(t, []int{3, 2, 1, 0}, heights, "The coinbases are visited from the newest")
+}
+
+func TestSummary(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	numBlocks, numTxs, tipHeight := chain.Summary()
+	assert.Equal(t, []int{1, 1, 0}, []int{numBlocks, numTxs, tipHeight}, "The genesis block packs the coinbase only")
+
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	for i := 1; i <= 3; i++ {
+		height := chain.GetChainHeight() + 1
+		tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+		assert.Nil(t, err)
+		txs := []*Transaction{NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height), tx}
+		// block i packs i transactions other than the coinbase
+		for j := 1; j < i; j++ {
+			tx = spendChange(wallet, tx, string(NewWallet().GetAddr()), 1)
+			txs = append(txs, tx)
+		}
+		utxoSet.Update(chain.MineBlock(txs))
+	}
+
+	// count independently by iterating the blocks
+	expectedBlocks, expectedTxs := 0, 0
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		expectedBlocks++
+		expectedTxs += len(block.Transactions)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	numBlocks, numTxs, tipHeight = chain.Summary()
+	assert.Equal(t, expectedBlocks, numBlocks)
+	assert.Equal(t, chain.GetBlocksNum(), numBlocks)
+	assert.Equal(t, expectedTxs, numTxs)
+	assert.Equal(t, 10, numTxs)
+	assert.Equal(t, chain.GetChainHeight(), tipHeight)
+	assert.Equal(t, 3, tipHeight)
+}
+
+func TestGetTx(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	genesis := genesisOf(chain)
+	coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CoinbaseReward, chain.GetChainHeight()+1)
+	chain.MineBlock([]*Transaction{coinbaseTx})
+
+	tx, err := chain.GetTx(0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, coinbaseTx.Id, tx.Id, "Block 0 is the newest block")
+	tx, err = chain.GetTx(1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Transactions[0].Id, tx.Id, "The last block is the genesis block")
+
+	for _, idx := range [][2]int{{2, 0}, {0, 1}, {-1, 0}, {0, -1}} {
+		_, err = chain.GetTx(idx[0], idx[1])
+		assert.NotNil(t, err, idx)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file finds the branches stored in the db of a chain. AddBlock stores every valid block, including the ones of
+// the competing branches after a fork, thus the stored blocks form a tree rooted at the genesis block.
+
+package core
+
+import (
+	`bytes`
+	`encoding/hex`
+	`log`
+	`math/big`
+	`sort`
+)
+
+// BranchTip is the newest block of a branch stored in chain.
+type BranchTip struct {
+	Hash     []byte
+	Height   int
+	Work     *big.Int // the accumulated work of the branch, i.e., the sum of 2^difficulty of its blocks
+	Selected bool     // whether it is the tip of chain
+}
+
+// BranchTips returns the tips of the branches stored in chain, i.e., the blocks no stored block points to, from the
+// highest (the one with more Work first at the same height, then the selected one). The tip of chain is Selected:
+// AddBlock moves the tip only to a higher block, thus the highest branch is selected, and the one stored first of the
+// same height wins.
+func (chain *BlockChain) BranchTips() []BranchTip {
+	type storedBlock struct {
+		prevHash   string
+		height     int
+		difficulty int
+	}
+	blocks := make(map[string]storedBlock)
+	err := chain.Db.View(
+		func(tx StoreTx) error {
+			cursor := tx.Bucket([]byte(blocksBucket)).Cursor()
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if bytes.Equal(k, []byte("l")) {
+					continue
+				}
+				block := DeserializeBlock(v)
+				blocks[hex.EncodeToString(k)] = storedBlock{hex.EncodeToString(block.PrevBlockHash), block.Height,
+					block.Difficulty()}
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	isParent := make(map[string]bool)
+	for _, block := range blocks {
+		isParent[block.prevHash] = true
+	}
+	// the work of each block is accumulated from the genesis block, and memorized since the branches share blocks
+	works := make(map[string]*big.Int)
+	var workOf func(hash string) *big.Int
+	workOf = func(hash string) *big.Int {
+		block, ok := blocks[hash]
+		if !ok {
+			return new(big.Int)
+		}
+		if work, ok := works[hash]; ok {
+			return work
+		}
+		work := new(big.Int).Lsh(big.NewInt(1), uint(block.difficulty))
+		work.Add(work, workOf(block.prevHash))
+		works[hash] = work
+		return work
+	}
+
+	var tips []BranchTip
+	tipHash := hex.EncodeToString(chain.TipHash())
+	for hash, block := range blocks {
+		if isParent[hash] {
+			continue
+		}
+		hashBytes, _ := hex.DecodeString(hash)
+		tips = append(tips, BranchTip{hashBytes, block.height, workOf(hash), hash == tipHash})
+	}
+	sort.Slice(tips, func(i, j int) bool {
+		if tips[i].Height != tips[j].Height {
+			return tips[i].Height > tips[j].Height
+		}
+		if cmp := tips[i].Work.Cmp(tips[j].Work); cmp != 0 {
+			return cmp > 0
+		}
+		if tips[i].Selected != tips[j].Selected {
+			return tips[i].Selected
+		}
+		return bytes.Compare(tips[i].Hash, tips[j].Hash) < 0
+	})
+	return tips
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`github.com/stretchr/testify/assert`
+	`math/big`
+	`testing`
+)
+
+func TestBranchTips(t *testing.T) {
+	chain, _ := newMemChain(t, DefaultGenesisConfig())
+	genesis := genesisOf(chain)
+	blockWork := new(big.Int).Lsh(big.NewInt(1), uint(genesis.Difficulty()))
+	workAt := func(height int) *big.Int {
+		return new(big.Int).Mul(blockWork, big.NewInt(int64(height+1)))
+	}
+
+	tips := chain.BranchTips()
+	assert.Equal(t, []BranchTip{{genesis.Hash, 0, workAt(0), true}}, tips, "A chain without fork has one branch")
+
+	// the main branch is 2 blocks higher than the genesis block, and the side branch forks at the genesis block
+	main1 := forkBlock(genesis)
+	chain.AddBlock(main1)
+	main2 := forkBlock(main1)
+	chain.AddBlock(main2)
+	side1 := forkBlock(genesis)
+	chain.AddBlock(side1)
+	assert.Equal(t, []BranchTip{{main2.Hash, 2, workAt(2), true}, {side1.Hash, 1, workAt(1), false}},
+		chain.BranchTips(), "The higher branch is selected")
+
+	// the side branch overtakes the main branch
+	side2 := forkBlock(side1)
+	chain.AddBlock(side2)
+	assert.Equal(t, main2.Hash, chain.Tip, "The branch of the same height does not replace the tip")
+	side3 := forkBlock(side2)
+	chain.AddBlock(side3)
+	assert.Equal(t, []BranchTip{{side3.Hash, 3, workAt(3), true}, {main2.Hash, 2, workAt(2), false}},
+		chain.BranchTips())
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file lists the unspent outputs with the heights of the blocks packing them, and derives their coin ages from
+// the heights, i.e., the value of each output multiplied by its confirmations. The heights also tell whether the
+// coinbase outputs are mature, thus the spendable balance is derived from them as well.
+
+package core
+
+import (
+	`bytes`
+	`encoding/hex`
+	`fmt`
+	`log`
+	`sort`
+)
+
+// CoinbaseMaturity is the number of confirmations a coinbase output needs to be counted in the spendable balance (see
+// UTXOSet.SpendableBalance). NOTE: it is not enforced by the consensus rules nor the coin selection (see
+// FindSpendableOutputs) yet, only the balance reports it.
+var CoinbaseMaturity = 100
+
+// UTXO is an unspent output in the UTXO set, where Index is its index in the outputs of the transaction TxId, and
+// Height is the height of the block packing the transaction. Coinbase is true if the transaction is a coinbase.
+type UTXO struct {
+	TxId     []byte
+	Index    int
+	Output   TxOutput
+	Height   int
+	Coinbase bool
+}
+
+// Confirmations returns the number of the blocks confirming utxo on the chain whose tip is at tipHeight, including
+// the block packing it.
+func (utxo UTXO) Confirmations(tipHeight int) int {
+	return tipHeight - utxo.Height + 1
+}
+
+// CoinAge returns the value of utxo multiplied by its confirmations on the chain whose tip is at tipHeight.
+func (utxo UTXO) CoinAge(tipHeight int) float64 {
+	return utxo.Output.Value * float64(utxo.Confirmations(tipHeight))
+}
+
+// Mature reports whether utxo is spendable on the chain whose tip is at tipHeight, i.e., it is not a coinbase output,
+// or it has CoinbaseMaturity confirmations at least.
+func (utxo UTXO) Mature(tipHeight int) bool {
+	return !utxo.Coinbase || utxo.Confirmations(tipHeight) >= CoinbaseMaturity
+}
+
+// ListUTXO returns the unspent outputs of the owner of pubKeyHash from the oldest, and the ones in the same block are
+// ordered by their transactions and indices. The heights (and whether the transactions are coinbases) are looked up
+// through the txid index, thus an error wrapping ErrTxNotFound is returned if a transaction is not indexed (see
+// BlockChain.RebuildTxIndex).
+func (utxoSet UTXOSet) ListUTXO(pubKeyHash []byte) ([]UTXO, error) {
+	var utxos []UTXO
+	// the heights are looked up after the UTXO set is cursored, since each lookup opens another db transaction
+	err := utxoSet.BlockChain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoBucket))
+			cursor := bucket.Cursor()
+
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				txOutputs := DeserializeOutputs(value)
+				for i, txOutput := range txOutputs.Outputs {
+					if txOutput.IsLockedWithKey(pubKeyHash) {
+						txId := append([]byte{}, key...)
+						utxos = append(utxos, UTXO{TxId: txId, Index: txOutputs.OutputIdx(i), Output: txOutput})
+					}
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// the packing of each transaction, i.e., the height of its block and whether it is a coinbase
+	type packing struct {
+		height   int
+		coinbase bool
+	}
+	packings := make(map[string]packing)
+	for i := range utxos {
+		txId := hex.EncodeToString(utxos[i].TxId)
+		packed, ok := packings[txId]
+		if !ok {
+			blockHash, err := utxoSet.BlockChain.LookupTx(utxos[i].TxId)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s is not indexed", err, txId)
+			}
+			block, err := utxoSet.BlockChain.GetBlock(blockHash)
+			if err != nil {
+				return nil, err
+			}
+			packed.height = block.Height
+			for _, blockTx := range block.Transactions {
+				if bytes.Equal(blockTx.Id, utxos[i].TxId) {
+					packed.coinbase = blockTx.IsCoinbaseTx()
+				}
+			}
+			packings[txId] = packed
+		}
+		utxos[i].Height, utxos[i].Coinbase = packed.height, packed.coinbase
+	}
+
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].Height != utxos[j].Height {
+			return utxos[i].Height < utxos[j].Height
+		}
+		if c := bytes.Compare(utxos[i].TxId, utxos[j].TxId); c != 0 {
+			return c < 0
+		}
+		return utxos[i].Index < utxos[j].Index
+	})
+	return utxos, nil
+}
+
+// CoinAge returns the sum of the coin ages (see UTXO.CoinAge) of the unspent outputs of the owner of pubKeyHash, at
+// the current tip height. Spending an output resets its coin age, since the new outputs are confirmed from scratch.
+// It panics if the heights cannot be looked up, use ListUTXO to handle the error instead.
+func (utxoSet UTXOSet) CoinAge(pubKeyHash []byte) float64 {
+	utxos, err := utxoSet.ListUTXO(pubKeyHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	tipHeight := utxoSet.BlockChain.GetChainHeight()
+	coinAge := 0.0
+	for _, utxo := range utxos {
+		coinAge += utxo.CoinAge(tipHeight)
+	}
+	return coinAge
+}
+
+// SpendableBalance returns the sum of the values of the mature (see UTXO.Mature) unspent outputs of the owner of
+// pubKeyHash at the current tip height, which is less than the balance (see UTXOSet.Balance) if some coinbase outputs
+// are immature. Like ListUTXO, an error is returned if a transaction is not indexed.
+func (utxoSet UTXOSet) SpendableBalance(pubKeyHash []byte) (float64, error) {
+	utxos, err := utxoSet.ListUTXO(pubKeyHash)
+	if err != nil {
+		return 0, err
+	}
+	tipHeight := utxoSet.BlockChain.GetChainHeight()
+	spendable := 0.0
+	for _, utxo := range utxos {
+		if utxo.Mature(tipHeight) {
+			spendable += utxo.Output.Value
+		}
+	}
+	return spendable, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+func TestCoinAge(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	pubKeyHash := HashingPubKey(wallet.PubKey)
+	miner := string(NewWallet().GetAddr())
+
+	// the genesis output is confirmed by the genesis block only
+	assert.Equal(t, initCoinbaseReward, utxoSet.CoinAge(pubKeyHash))
+	for confirmations := 2; confirmations <= 3; confirmations++ {
+		mineTxs(chain, miner)
+		assert.Equal(t, initCoinbaseReward*float64(confirmations), utxoSet.CoinAge(pubKeyHash),
+			"The coin age increases as blocks are mined")
+	}
+	utxos, err := utxoSet.ListUTXO(pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(utxos))
+	assert.Equal(t, 0, utxos[0].Height)
+	assert.Equal(t, 3, utxos[0].Confirmations(chain.GetChainHeight()))
+
+	// the output is spent and recreated
+	tx, err := NewConsolidationTx(wallet, DefaultFeePerByte, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, miner, tx)
+	assert.Equal(t, tx.Vout[0].Value, utxoSet.CoinAge(pubKeyHash), "The coin age resets after spending")
+	mineTxs(chain, miner)
+	assert.Equal(t, 2*tx.Vout[0].Value, utxoSet.CoinAge(pubKeyHash))
+	utxos, err = utxoSet.ListUTXO(pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []UTXO{{TxId: tx.Id, Index: 0, Output: tx.Vout[0], Height: 3}}, utxos)
+
+	assert.Equal(t, 0.0, utxoSet.CoinAge(HashingPubKey(NewWallet().PubKey)), "An empty wallet has no coin age")
+}
+
+func TestSpendableBalance(t *testing.T) {
+	oldMaturity := CoinbaseMaturity
+	CoinbaseMaturity = 3
+	t.Cleanup(func() { CoinbaseMaturity = oldMaturity })
+
+	chain, wallet := newMemChain(t, DefaultGenesisConfig())
+	addr := string(wallet.GetAddr())
+	utxoSet := UTXOSet{BlockChain: chain}
+	pubKeyHash := HashingPubKey(wallet.PubKey)
+
+	// the freshly-mined coinbase is immature
+	spendable, err := utxoSet.SpendableBalance(pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, spendable)
+	assert.Greater(t, utxoSet.Balance(pubKeyHash), spendable)
+
+	// the genesis coinbase matures with 3 confirmations, while the later ones are still immature
+	mineTxs(chain, addr)
+	mineTxs(chain, addr)
+	spendable, err = utxoSet.SpendableBalance(pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, chain.CurrentReward(0), spendable)
+	assert.Greater(t, utxoSet.Balance(pubKeyHash), spendable)
+
+	// the outputs received from a transfer are spendable at once
+	receiver := NewWallet()
+	mineTxs(chain, addr)
+	tx, err := NewUTXOTx(wallet, string(receiver.GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, addr, tx)
+	spendable, err = utxoSet.SpendableBalance(HashingPubKey(receiver.PubKey))
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, spendable)
+
+	// all the coinbases mature once no more blocks are mined to the wallet
+	for i := 0; i < CoinbaseMaturity-1; i++ {
+		mineTxs(chain, string(receiver.GetAddr()))
+	}
+	spendable, err = utxoSet.SpendableBalance(pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, utxoSet.Balance(pubKeyHash), spendable, "The spendable balance converges to the balance")
+}
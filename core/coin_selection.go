@@ -0,0 +1,166 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the strategies to select the unspent outputs (coins) to be spent by a new transaction.
+
+package core
+
+import (
+	`encoding/hex`
+	`log`
+	`math`
+	`sort`
+)
+
+// SelectionStrategy decides which unspent outputs of the sender are spent to support an amount.
+type SelectionStrategy int
+
+const (
+	// FirstFit selects the outputs in the order they are stored until the amount is met (the default).
+	FirstFit SelectionStrategy = iota
+	// LargestFirst selects the outputs from the largest value, which spends the fewest outputs.
+	LargestFirst
+	// SmallestFirst selects the outputs from the smallest value, which consolidates the dust.
+	SmallestFirst
+	// BranchAndBound searches for a set of outputs whose values sum up to exactly the amount, thus no change is
+	// needed. If no such set is found within bnbMaxTries, it falls back to LargestFirst.
+	BranchAndBound
+)
+
+// bnbMaxTries bounds the number of search steps of BranchAndBound, since the search is exponential in the worst case.
+const bnbMaxTries = 100000
+
+// spendableOutput is an unspent output in the UTXO set.
+type spendableOutput struct {
+	txId      string
+	outputIdx int
+	value     float64
+}
+
+// spendableOutputsOf returns all the unspent outputs of the owner of pubKeyHash, in the order they are stored.
+func (utxoSet UTXOSet) spendableOutputsOf(pubKeyHash []byte) []spendableOutput {
+	var outputs []spendableOutput
+	err := utxoSet.BlockChain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoBucket))
+			cursor := bucket.Cursor()
+
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				txId := hex.EncodeToString(key)
+				txOutputs := DeserializeOutputs(value)
+				for i, txOutput := range txOutputs.Outputs {
+					if txOutput.IsLockedWithKey(pubKeyHash) {
+						outputs = append(outputs, spendableOutput{txId, txOutputs.OutputIdx(i), txOutput.Value})
+					}
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+	return outputs
+}
+
+// FindSpendableOutputsStrategy is like FindSpendableOutputs, but the unspent outputs are selected with strategy.
+func (utxoSet UTXOSet) FindSpendableOutputsStrategy(pubKeyHash []byte, amount float64,
+	strategy SelectionStrategy) (float64, map[string][]int) {
+	outputs := utxoSet.spendableOutputsOf(pubKeyHash)
+
+	var selected []spendableOutput
+	switch strategy {
+	case LargestFirst:
+		selected = accumulateOutputs(sortOutputs(outputs, true), amount)
+	case SmallestFirst:
+		selected = accumulateOutputs(sortOutputs(outputs, false), amount)
+	case BranchAndBound:
+		sorted := sortOutputs(outputs, true)
+		if selected = branchAndBound(sorted, amount); selected == nil {
+			selected = accumulateOutputs(sorted, amount)
+		}
+	default:
+		selected = accumulateOutputs(outputs, amount)
+	}
+
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0.0
+	for _, output := range selected {
+		accumulated += output.value
+		unspentOutputs[output.txId] = append(unspentOutputs[output.txId], output.outputIdx)
+	}
+	return accumulated, unspentOutputs
+}
+
+// sortOutputs sorts outputs by value in descending order if descending is true, otherwise in ascending order. The
+// outputs with the same value keep their stored order.
+func sortOutputs(outputs []spendableOutput, descending bool) []spendableOutput {
+	sorted := append([]spendableOutput{}, outputs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if descending {
+			return sorted[i].value > sorted[j].value
+		}
+		return sorted[i].value < sorted[j].value
+	})
+	return sorted
+}
+
+// accumulateOutputs selects outputs in order until their values are not less than amount.
+func accumulateOutputs(outputs []spendableOutput, amount float64) []spendableOutput {
+	var selected []spendableOutput
+	accumulated := 0.0
+	for _, output := range outputs {
+		if accumulated >= amount {
+			break
+		}
+		accumulated += output.value
+		selected = append(selected, output)
+	}
+	return selected
+}
+
+// branchAndBound searches outputs (sorted in descending order) for a subset whose values sum up to amount. nil is
+// returned if no such subset is found within bnbMaxTries.
+func branchAndBound(outputs []spendableOutput, amount float64) []spendableOutput {
+	// remaining[i] is the sum of values of outputs[i:], which bounds what the undecided outputs can still add
+	remaining := make([]float64, len(outputs)+1)
+	for i := len(outputs) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + outputs[i].value
+	}
+
+	tries := 0
+	var chosen []spendableOutput
+	var search func(idx int, sum float64) bool
+	search = func(idx int, sum float64) bool {
+		if math.Abs(sum-amount) <= valueTolerance {
+			return true
+		}
+		tries++
+		if tries > bnbMaxTries || idx == len(outputs) || sum > amount || sum+remaining[idx] < amount-valueTolerance {
+			return false
+		}
+		// include outputs[idx] first, then exclude it
+		chosen = append(chosen, outputs[idx])
+		if search(idx+1, sum+outputs[idx].value) {
+			return true
+		}
+		chosen = chosen[:len(chosen)-1]
+		return search(idx+1, sum)
+	}
+	if !search(0, 0) {
+		return nil
+	}
+	return chosen
+}
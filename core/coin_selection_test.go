@@ -0,0 +1,119 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`encoding/hex`
+	`github.com/stretchr/testify/assert`
+	`sort`
+	`testing`
+)
+
+// newCoinsChain creates a chain where a new wallet owns one output of each value in values.
+func newCoinsChain(t *testing.T, values []float64) (*BlockChain, *Wallet) {
+	chain, _ := newMemChain(t, DefaultGenesisConfig())
+	wallet := NewWallet()
+	for _, value := range values {
+		coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", value, chain.GetChainHeight()+1)
+		UTXOSet{BlockChain: chain}.Update(chain.MineBlock([]*Transaction{coinbaseTx}))
+	}
+	return chain, wallet
+}
+
+// selectedValues returns the sorted values of the outputs selected by FindSpendableOutputsStrategy.
+func selectedValues(t *testing.T, chain *BlockChain, unspentOutputs map[string][]int) []float64 {
+	var values []float64
+	for txId, outputIndices := range unspentOutputs {
+		decodedTxId, _ := hex.DecodeString(txId)
+		tx, err := chain.FindTx(decodedTxId)
+		assert.Nil(t, err)
+		for _, outputIdx := range outputIndices {
+			values = append(values, tx.Vout[outputIdx].Value)
+		}
+	}
+	sort.Float64s(values)
+	return values
+}
+
+func TestFindSpendableOutputsStrategy(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newCoinsChain(t, []float64{8, 1, 20, 4, 3})
+	utxoSet := UTXOSet{BlockChain: chain}
+	pubKeyHash := HashingPubKey(wallet.PubKey)
+
+	cases := []struct {
+		strategy SelectionStrategy
+		amount   float64
+		expected []float64
+	}{
+		{LargestFirst, 7, []float64{20}},
+		{LargestFirst, 25, []float64{8, 20}},
+		{SmallestFirst, 7, []float64{1, 3, 4}},
+		{SmallestFirst, 12, []float64{1, 3, 4, 8}},
+		{BranchAndBound, 7, []float64{3, 4}},
+		{BranchAndBound, 13, []float64{1, 4, 8}},
+		{BranchAndBound, 36, []float64{1, 3, 4, 8, 20}},
+		// no exact match, fall back to LargestFirst
+		{BranchAndBound, 2.5, []float64{20}},
+		// not enough coins at all
+		{BranchAndBound, 100, []float64{1, 3, 4, 8, 20}},
+	}
+	for _, c := range cases {
+		accumulated, unspentOutputs := utxoSet.FindSpendableOutputsStrategy(pubKeyHash, c.amount, c.strategy)
+		values := selectedValues(t, chain, unspentOutputs)
+		assert.Equal(t, c.expected, values, "strategy %d, amount %v", c.strategy, c.amount)
+		sum := 0.0
+		for _, value := range values {
+			sum += value
+		}
+		assert.Equal(t, sum, accumulated)
+	}
+
+	// FirstFit is the default of FindSpendableOutputs
+	accumulated, unspentOutputs := utxoSet.FindSpendableOutputs(pubKeyHash, 7)
+	firstFitAccumulated, firstFitOutputs := utxoSet.FindSpendableOutputsStrategy(pubKeyHash, 7, FirstFit)
+	assert.Equal(t, firstFitAccumulated, accumulated)
+	assert.Equal(t, firstFitOutputs, unspentOutputs)
+	assert.True(t, accumulated >= 7)
+}
+
+func TestNewUTXOTxStrategy(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newCoinsChain(t, []float64{8, 1, 20, 4, 3})
+	utxoSet := UTXOSet{BlockChain: chain}
+
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 7, &utxoSet, WithStrategy(BranchAndBound))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tx.Vin))
+	assert.Equal(t, 1, len(tx.Vout), "The exact match needs no change")
+
+	tx, err = NewUTXOTx(wallet, string(NewWallet().GetAddr()), 7, &utxoSet, WithStrategy(LargestFirst))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tx.Vin))
+	assert.Equal(t, 13.0, tx.Vout[1].Value, "The change of the largest output")
+
+	// the strategy is combined with the other options
+	dstPubKeyHash := HashingPubKey(NewWallet().PubKey)
+	tx, err = NewUTXOTx(wallet, "", 7, &utxoSet, WithNonce(5), WithStrategy(LargestFirst), WithDstHash(dstPubKeyHash))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tx.Vin))
+	assert.Equal(t, uint64(5), tx.Nonce)
+	assert.Equal(t, dstPubKeyHash, tx.Vout[0].PubKeyHash)
+	assert.True(t, chain.VerifyTx(tx))
+}
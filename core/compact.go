@@ -0,0 +1,112 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the compaction of the boltdb file. boltdb never shrinks its file, thus the pages freed by
+// pruning or rebuilding the UTXO set are only reused but never returned to the disk.
+
+package core
+
+import (
+	`fmt`
+	`github.com/boltdb/bolt`
+	`os`
+	`time`
+)
+
+// compactTimeout is how long to wait for the file lock of the db file to compact. The file is locked by the running
+// node, thus the compaction gives up in that case instead of waiting for the node to stop.
+var compactTimeout = time.Second
+
+// CompactDb compacts the db file of the node whose id is nodeId (see CompactBoltFile).
+func CompactDb(nodeId string) (int64, int64, error) {
+	return CompactBoltFile(getDbFile(nodeId))
+}
+
+// compactHook is called before dbFile is replaced by CompactBoltFile, e.g., by the tests opening dbFile during the
+// compaction.
+var compactHook = func() {}
+
+// CompactBoltFile copies all the buckets of the boltdb file dbFile to a new file, and replaces dbFile with it
+// atomically. It returns the size of dbFile before and after the compaction. ErrStoreLocked is returned if dbFile is
+// used by another process, e.g., a running node. The exclusive file lock of dbFile is held for the whole compaction,
+// i.e., until dbFile is replaced, thus no one can write to it meanwhile, and the ones waiting for the lock give up
+// with ErrStoreLocked rather than hold the replaced file (see openBolt).
+func CompactBoltFile(dbFile string) (int64, int64, error) {
+	info, err := os.Stat(dbFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	src, err := openBolt(dbFile, &bolt.Options{Timeout: compactTimeout})
+	if err != nil {
+		return 0, 0, err
+	}
+	// the lock is released only after dbFile is replaced
+	defer func() {
+		_ = src.Close()
+	}()
+
+	compactedFile := dbFile + ".compact"
+	_ = os.Remove(compactedFile)
+	dst, err := bolt.Open(compactedFile, info.Mode(), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = src.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, srcBucket *bolt.Bucket) error {
+				dstBucket, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(dstBucket, srcBucket)
+			})
+		})
+	})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(compactedFile)
+		return 0, 0, fmt.Errorf("failed to compact %s: %v", dbFile, err)
+	}
+
+	compactHook()
+	// a rename is atomic, thus dbFile is either the original one or the compacted one if it fails in the middle
+	if err := os.Rename(compactedFile, dbFile); err != nil {
+		_ = os.Remove(compactedFile)
+		return 0, 0, err
+	}
+	compactedInfo, err := os.Stat(dbFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), compactedInfo.Size(), nil
+}
+
+// copyBucket copies all the key-value pairs and the nested buckets of src to dst.
+func copyBucket(dst, src *bolt.Bucket) error {
+	return src.ForEach(func(key, value []byte) error {
+		if value == nil {
+			// a nested bucket
+			dstNested, err := dst.CreateBucket(key)
+			if err != nil {
+				return err
+			}
+			return copyBucket(dstNested, src.Bucket(key))
+		}
+		return dst.Put(key, value)
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`github.com/stretchr/testify/assert`
+	`os`
+	`testing`
+	`time`
+)
+
+func TestCompactDb(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	addr := string(wallet.GetAddr())
+	utxoSet := UTXOSet{BlockChain: chain}
+	for i := 0; i < 20; i++ {
+		height := chain.GetChainHeight() + 1
+		chain.MineBlockWithPoW([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)}, NoopPoW{})
+		// each rebuild frees the pages of the old UTXO set
+		utxoSet.Rebuild()
+	}
+	tip := chain.Tip
+	blocks := chain.GetAllBlocksHashes()
+	commitment, err := utxoSet.Commitment()
+	assert.Nil(t, err)
+
+	oldTimeout := compactTimeout
+	compactTimeout = 100 * time.Millisecond
+	defer func() {
+		compactTimeout = oldTimeout
+	}()
+	_, _, err = CompactDb("1")
+	assert.Equal(t, ErrStoreLocked, err, "The db file used by the chain is not compacted")
+
+	assert.Nil(t, chain.Db.Close())
+	before, after, err := CompactDb("1")
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, after, before)
+	info, err := os.Stat(getDbFile("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, after, info.Size())
+	_, err = os.Stat(getDbFile("1") + ".compact")
+	assert.True(t, os.IsNotExist(err), "The compacted file replaces the db file")
+
+	chain, err = NewBlockChain("1")
+	assert.Nil(t, err)
+	defer func() {
+		_ = chain.Db.Close()
+	}()
+	assert.Equal(t, tip, chain.Tip)
+	assert.Equal(t, blocks, chain.GetAllBlocksHashes())
+	for _, hash := range blocks {
+		_, err := chain.GetBlock(hash)
+		assert.Nil(t, err)
+	}
+	compactedCommitment, err := UTXOSet{BlockChain: chain}.Commitment()
+	assert.Nil(t, err)
+	assert.Equal(t, commitment, compactedCommitment)
+}
+
+func TestOpenDuringCompaction(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, _ := newTestChain(t, "1", "")
+	assert.Nil(t, chain.Db.Close())
+	dbFile := getDbFile("1")
+
+	// the opener starts waiting for the lock during the compaction
+	opened := make(chan error, 2)
+	compactHook = func() {
+		_, err := OpenBoltStoreWithTimeout(dbFile, 100*time.Millisecond)
+		opened <- err
+		go func() {
+			store, err := OpenBoltStoreWithTimeout(dbFile, 5*time.Second)
+			if err == nil {
+				_ = store.Close()
+			}
+			opened <- err
+		}()
+		time.Sleep(200 * time.Millisecond)
+	}
+	defer func() {
+		compactHook = func() {}
+	}()
+	_, _, err := CompactBoltFile(dbFile)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrStoreLocked, <-opened, "The db file is locked during the compaction")
+	assert.Equal(t, ErrStoreLocked, <-opened, "The opener waiting for the lock does not hold the replaced file")
+
+	chain, err = NewBlockChain("1")
+	assert.Nil(t, err)
+	assert.Nil(t, chain.Db.Close())
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`bytes`
+	`encoding/gob`
+	`lightChain/utils`
+)
+
+const (
+	configBucket   = "Config" // The bucket for chain parameters. Key: configKey, Value: the serialized GenesisConfig.
+	configKey      = "genesis"
+	genesisHashKey = "genesisHash" // Key: genesisHashKey, Value: the hash of the genesis block (the checkpoint at height 0).
+	completeKey    = "complete"    // Key: completeKey, Value: a marker that the saved GenesisConfig has all the fields.
+)
+
+// GenesisConfig is the set of chain parameters decided by the creator of lightChain. It is persisted when the chain
+// is created and reloaded every time the chain is opened, thus all the nodes share the same parameters.
+type GenesisConfig struct {
+	GenesisMsg         string  // the coinbase data of the genesis block (genesisCoinbaseData is used if empty)
+	RewardDecayNum     int     // every RewardDecayNum blocks added to lightChain, the coinbase reward decays
+	RewardDecayFactor  float64 // when decaying, the coinbase reward is multiplied by RewardDecayFactor
+	RewardFloor        float64 // the decayed coinbase reward below RewardFloor is clamped to zero (the end of issuance)
+	TargetBlockSeconds int64   // the expected seconds between two blocks, which the difficulty retargeting aims for
+	PoW                string  // the name of the PoW scheme to mine and validate blocks (see PoWByName)
+}
+
+// DefaultGenesisConfig returns the GenesisConfig of the classic lightChain: halve the reward every rewardDecayNum blocks,
+// until the reward is less than the coin unit.
+func DefaultGenesisConfig() GenesisConfig {
+	return GenesisConfig{
+		GenesisMsg:         "",
+		RewardDecayNum:     rewardDecayNum,
+		RewardDecayFactor:  0.5,
+		RewardFloor:        CoinUnit,
+		TargetBlockSeconds: targetBlockSeconds,
+		PoW:                Sha256PoWName,
+	}
+}
+
+// saveConfig writes config into the configBucket, marked as complete (see loadConfig).
+func saveConfig(tx StoreTx, config GenesisConfig) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(configBucket))
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put([]byte(configKey), utils.GobEncode(config)); err != nil {
+		return err
+	}
+	return bucket.Put([]byte(completeKey), []byte{1})
+}
+
+// saveGenesisHash writes the hash of the genesis block into the configBucket.
+func saveGenesisHash(tx StoreTx, hash []byte) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(configBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(genesisHashKey), hash)
+}
+
+// loadConfig reads the GenesisConfig from the configBucket. Chains created before GenesisConfig was introduced have
+// no such bucket, the DefaultGenesisConfig is returned for them. A config marked as complete is decoded as it is saved,
+// thus its zero fields (e.g., RewardFloor 0) are kept. Gob omits the zero fields, thus an unmarked config, which is
+// saved before some fields were introduced, is decoded over the DefaultGenesisConfig to fill the missing ones.
+func loadConfig(tx StoreTx) (GenesisConfig, error) {
+	config := DefaultGenesisConfig()
+	bucket := tx.Bucket([]byte(configBucket))
+	if bucket == nil {
+		return config, nil
+	}
+	data := bucket.Get([]byte(configKey))
+	if data == nil {
+		return config, nil
+	}
+	if bucket.Get([]byte(completeKey)) != nil {
+		config = GenesisConfig{}
+	}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&config)
+	return config, err
+}
@@ -0,0 +1,34 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the sentinel errors of the core package. The functions return them (or errors wrapping them), thus
+// the callers can match the failure with errors.Is, e.g., to map it to a status code.
+
+package core
+
+import (
+	`errors`
+)
+
+var (
+	ErrBlockNotFound      = errors.New("block not found")
+	ErrTxNotFound         = errors.New("transaction not found")
+	ErrInvalidBlock       = errors.New("invalid block")
+	ErrInsufficientFunds  = errors.New("insufficient funds")
+	ErrInvalidAddress     = errors.New("invalid address")
+	ErrStaleUTXO          = errors.New("stale utxo entry")
+	ErrInvalidChecksumLen = errors.New("invalid checksum length")
+)
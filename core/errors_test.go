@@ -0,0 +1,63 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+func TestSentinelErrors(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	unknownHash := make([]byte, 32)
+
+	_, err := chain.GetBlock(unknownHash)
+	assert.True(t, errors.Is(err, ErrBlockNotFound), "GetBlock")
+	_, err = chain.GetBlocksHashesBetween(unknownHash, nil)
+	assert.True(t, errors.Is(err, ErrBlockNotFound), "GetBlocksHashesBetween")
+
+	_, err = chain.FindTx(unknownHash)
+	assert.True(t, errors.Is(err, ErrTxNotFound), "FindTx")
+	_, err = chain.LookupTx(unknownHash)
+	assert.True(t, errors.Is(err, ErrTxNotFound), "LookupTx")
+	_, err = chain.GetTx(1, 0)
+	assert.True(t, errors.Is(err, ErrTxNotFound), "GetTx out of blocks")
+	_, err = chain.GetTx(0, 1)
+	assert.True(t, errors.Is(err, ErrTxNotFound), "GetTx out of transactions")
+
+	height := chain.GetChainHeight() + 1
+	overClaimed := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height)+1, height)
+	err = chain.ValidateBlock(NewBlock([]*Transaction{overClaimed}, chain.Tip, height))
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "ValidateBlock")
+
+	_, err = NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10000, &utxoSet)
+	assert.True(t, errors.Is(err, ErrInsufficientFunds), "NewUTXOTx with a poor sender")
+	_, err = NewUTXOTx(wallet, "not an address", 10, &utxoSet)
+	assert.True(t, errors.Is(err, ErrInvalidAddress), "NewUTXOTx to an invalid address")
+	_, err = AddrPubKeyHash("")
+	assert.True(t, errors.Is(err, ErrInvalidAddress), "AddrPubKeyHash")
+	_, _, err = DecodeAddr("0OIl")
+	assert.True(t, errors.Is(err, ErrInvalidAddress), "DecodeAddr")
+
+	// the sentinels are distinct
+	assert.False(t, errors.Is(ErrBlockNotFound, ErrTxNotFound))
+}
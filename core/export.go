@@ -0,0 +1,228 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the export of a chain to a file and the import from it. Both are streamed block by block, thus
+// a large chain is never held in memory as a whole.
+
+package core
+
+import (
+	`bufio`
+	`bytes`
+	`encoding/binary`
+	`encoding/gob`
+	`errors`
+	`fmt`
+	`io`
+	`lightChain/utils`
+	`os`
+	`path/filepath`
+)
+
+// maxFrameSize is the upper bound of a frame read from an export, thus a corrupted length never allocates too much.
+const maxFrameSize = 64 << 20
+
+// ErrInvalidExport is returned when importing from an export which is corrupted or does not form a chain.
+var ErrInvalidExport = errors.New("invalid export")
+
+// ExportTo writes chain to w, and returns the number of exported blocks. The GenesisConfig of chain is written first,
+// followed by the blocks of the main chain from the genesis block to the tip (found through the height index). Each is
+// framed by its length (8 bytes in big endian), and only one block is held in memory at a time.
+func (chain *BlockChain) ExportTo(w io.Writer) (int, error) {
+	if err := writeFrame(w, utils.GobEncode(chain.Config)); err != nil {
+		return 0, err
+	}
+	tipHeight := chain.GetChainHeight()
+	for height := 0; height <= tipHeight; height++ {
+		blockHash, err := chain.BlockHashAtHeight(height)
+		if err != nil {
+			return height, err
+		}
+		block, err := chain.GetBlock(blockHash)
+		if err != nil {
+			return height, err
+		}
+		if err := writeFrame(w, block.SerializeBlock()); err != nil {
+			return height, err
+		}
+	}
+	return tipHeight + 1, nil
+}
+
+// ExportToFile writes chain to the file path like ExportTo.
+func (chain *BlockChain) ExportToFile(path string) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(file)
+	n, err := chain.ExportTo(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// ImportChain creates the chain of the node with nodeId from the export in the file path (see ExportToFile).
+// ErrChainExists is returned if the node already has a chain, and ErrStoreLocked is returned if the db file is held by
+// another process (e.g., a running node). If the import fails, the db file created by it is removed, thus the import
+// can be retried.
+func ImportChain(path, nodeId string) (*BlockChain, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	dbFile := getDbFile(nodeId)
+	if err := os.MkdirAll(filepath.Dir(dbFile), 0755); err != nil {
+		return nil, err
+	}
+	existed, _ := utils.FileExists(dbFile)
+	store, err := OpenBoltStoreWithTimeout(dbFile, createTimeout)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := ImportChainWithStore(bufio.NewReader(file), store)
+	if err != nil {
+		_ = store.Close()
+		// the blocks imported before the failure are dropped with the file
+		if !existed {
+			_ = os.Remove(dbFile)
+		}
+		return nil, err
+	}
+	return chain, nil
+}
+
+// ImportChainWithStore creates the chain in store from the export read from r (see ExportTo). The blocks are read one
+// by one, and each block after the genesis block is validated (see BlockChain.ValidateBlock) and should follow the
+// previous one, with its hash matching its content. The UTXO set is rebuilt at last. The returned error wraps
+// ErrInvalidExport if the export is corrupted, or ErrInvalidBlock if a block is invalid. The blocks before the invalid
+// one are kept in store.
+func ImportChainWithStore(r io.Reader, store Store) (*BlockChain, error) {
+	var config GenesisConfig
+	frame, err := readFrame(r)
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the export is empty", ErrInvalidExport)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(frame)).Decode(&config); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+	}
+	genesisBlock, err := readBlockFrame(r)
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the export has no block", ErrInvalidExport)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if genesisBlock.Height != 0 || len(genesisBlock.PrevBlockHash) != 0 {
+		return nil, fmt.Errorf("%w: the first block %x is not a genesis block", ErrInvalidExport, genesisBlock.Hash)
+	}
+	pow, err := PoWByName(config.PoW)
+	if err != nil {
+		return nil, err
+	}
+	if !pow.ValidateHeader(genesisBlock.Header()) {
+		return nil, fmt.Errorf("%w: genesis block %x has invalid proof of work", ErrInvalidBlock, genesisBlock.Hash)
+	}
+	chain, err := createBlockChainFrom(store, genesisBlock, config)
+	if err != nil {
+		return nil, err
+	}
+
+	prevBlock := genesisBlock
+	for {
+		block, err := readBlockFrame(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(block.PrevBlockHash, prevBlock.Hash) || block.Height != prevBlock.Height+1 {
+			return nil, fmt.Errorf("%w: block %x does not follow block %x", ErrInvalidExport, block.Hash,
+				prevBlock.Hash)
+		}
+		if !chain.PoW.ValidateHeader(block.Header()) {
+			return nil, fmt.Errorf("%w: block %x has invalid proof of work", ErrInvalidBlock, block.Hash)
+		}
+		if err := chain.ValidateBlock(block); err != nil {
+			return nil, err
+		}
+		chain.AddBlock(block)
+		prevBlock = block
+	}
+
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	return chain, nil
+}
+
+// writeFrame writes data to w framed by its length.
+func writeFrame(w io.Writer, data []byte) error {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(data)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readFrame reads the data framed by writeFrame from r. io.EOF is returned if r ends before the frame.
+func readFrame(r io.Reader) ([]byte, error) {
+	var length [8]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+	}
+	size := binary.BigEndian.Uint64(length[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("%w: a frame of %d bytes exceeds %d bytes", ErrInvalidExport, size, maxFrameSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+	}
+	return data, nil
+}
+
+// readBlockFrame reads a block framed by writeFrame from r. io.EOF is returned if r ends before the frame.
+func readBlockFrame(r io.Reader) (*Block, error) {
+	frame, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	var block Block
+	if err := gob.NewDecoder(bytes.NewReader(frame)).Decode(&block); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+	}
+	return &block, nil
+}
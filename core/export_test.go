@@ -0,0 +1,130 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`bytes`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`io/ioutil`
+	`lightChain/utils`
+	`path/filepath`
+	`testing`
+	`time`
+)
+
+// countingWriter counts the bytes written to it, and records the largest single write, without keeping the bytes.
+type countingWriter struct {
+	total    int
+	largest  int
+	numWrite int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.total += len(p)
+	w.numWrite++
+	if len(p) > w.largest {
+		w.largest = len(p)
+	}
+	return len(p), nil
+}
+
+func TestExportChain(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	const numBlocks = 40
+	largestBlock := 0
+	for i := 0; i < numBlocks; i++ {
+		height := chain.GetChainHeight() + 1
+		txs := []*Transaction{NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)}
+		if i%10 == 0 {
+			tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 1, &utxoSet)
+			assert.Nil(t, err)
+			txs = append(txs, tx)
+		}
+		block := chain.MineBlock(txs)
+		utxoSet.Update(block)
+		if size := len(block.SerializeBlock()); size > largestBlock {
+			largestBlock = size
+		}
+	}
+
+	// the blocks are written one by one, never more than one at a time
+	var counter countingWriter
+	n, err := chain.ExportTo(&counter)
+	assert.Nil(t, err)
+	assert.Equal(t, numBlocks+1, n)
+	assert.Equal(t, 2*(numBlocks+2), counter.numWrite, "The config and each block are written with their lengths")
+	assert.LessOrEqual(t, counter.largest, largestBlock, "A write holds one block at most")
+	assert.Less(t, counter.largest*10, counter.total)
+
+	var export bytes.Buffer
+	_, err = chain.ExportTo(&export)
+	assert.Nil(t, err)
+	imported, err := ImportChainWithStore(bytes.NewReader(export.Bytes()), NewMemStore())
+	assert.Nil(t, err)
+	assert.Equal(t, chain.Tip, imported.Tip, "The import reconstructs the chain")
+	assert.Equal(t, chain.GetChainHeight(), imported.GetChainHeight())
+	assert.Equal(t, chain.Config, imported.Config)
+	commitment, err := utxoSet.Commitment()
+	assert.Nil(t, err)
+	importedCommitment, err := (UTXOSet{BlockChain: imported}).Commitment()
+	assert.Nil(t, err)
+	assert.Equal(t, commitment, importedCommitment, "The UTXO set is rebuilt")
+
+	// a truncated export is rejected
+	_, err = ImportChainWithStore(bytes.NewReader(export.Bytes()[:export.Len()-1]), NewMemStore())
+	assert.True(t, errors.Is(err, ErrInvalidExport))
+	_, err = ImportChainWithStore(bytes.NewReader(nil), NewMemStore())
+	assert.True(t, errors.Is(err, ErrInvalidExport))
+
+	// through a file
+	path := filepath.Join(t.TempDir(), "chain.export")
+	n, err = chain.ExportToFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, numBlocks+1, n)
+	fromFile, err := ImportChain(path, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, chain.Tip, fromFile.Tip)
+	_ = fromFile.Db.Close()
+	_, err = ImportChain(path, "2")
+	assert.Equal(t, ErrChainExists, err, "The chain of a node is imported once")
+
+	// a failed import leaves nothing behind, thus it can be retried
+	truncated := filepath.Join(t.TempDir(), "truncated.export")
+	assert.Nil(t, ioutil.WriteFile(truncated, export.Bytes()[:export.Len()-1], 0644))
+	_, err = ImportChain(truncated, "3")
+	assert.True(t, errors.Is(err, ErrInvalidExport))
+	ok, _ := utils.FileExists(getDbFile("3"))
+	assert.False(t, ok, "The db file of the failed import is removed")
+	retried, err := ImportChain(path, "3")
+	assert.Nil(t, err, "The import is retried")
+	assert.Equal(t, chain.Tip, retried.Tip)
+
+	// the db file held by another process is not mistaken for an existing chain
+	oldTimeout := createTimeout
+	createTimeout = 100 * time.Millisecond
+	defer func() {
+		createTimeout = oldTimeout
+	}()
+	_, err = ImportChain(path, "3")
+	assert.Equal(t, ErrStoreLocked, err)
+	_ = retried.Db.Close()
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the size of transactions and the fee estimation based on it.
+
+package core
+
+import (
+	`encoding/hex`
+	`errors`
+	`fmt`
+	`math`
+)
+
+// DefaultFeePerByte is the fee rate (coins per byte of the serialized transaction) of the node's fee policy.
+const DefaultFeePerByte = 1e-5
+
+// DefaultMaxTxFee is the default of MaxTxFee.
+const DefaultMaxTxFee = 0.1
+
+// MaxTxFee is the sanity cap (in coins) of the fee of the transactions created by this node, e.g., with NewUTXOTx and
+// NewSplitTx. It guards against burning the coins with a mistaken amount or fee rate. A negative MaxTxFee lifts the
+// cap.
+var MaxTxFee = DefaultMaxTxFee
+
+// ErrFeeTooHigh is returned when creating a transaction whose fee exceeds MaxTxFee.
+var ErrFeeTooHigh = errors.New("fee too high")
+
+// checkMaxFee returns an error wrapping ErrFeeTooHigh if fee exceeds MaxTxFee.
+func checkMaxFee(fee float64) error {
+	if MaxTxFee >= 0 && fee > MaxTxFee+valueTolerance {
+		return fmt.Errorf("%w: %f coins exceeds the cap %f", ErrFeeTooHigh, fee, MaxTxFee)
+	}
+	return nil
+}
+
+// Size returns the size (in bytes) of the serialized tx. All the fee computations are based on it. The serialized
+// bytes are cached in tx and shared by its later copies (see SerializeTx), which is reset by the methods changing tx
+// (e.g., Sign). The code setting the fields of tx directly after Size is called should call resetCache. The consensus
+// checks (e.g., the Merkle root) never use the cache, thus a tampered transaction can not pass them with stale bytes.
+func (tx *Transaction) Size() int {
+	if tx.serialized == nil {
+		tx.serialized = tx.serialize()
+	}
+	return len(tx.serialized)
+}
+
+// resetCache drops the serialized bytes cached by Size.
+func (tx *Transaction) resetCache() {
+	tx.serialized = nil
+}
+
+// EstimateTxSize estimates the size of a signed transaction with numInputs inputs and numOutputs outputs. It builds
+// a transaction of the shape, whose fields are filled with data as long as the real ones, and returns its Size.
+func EstimateTxSize(numInputs, numOutputs int) int {
+	tx := Transaction{Version: TxVersion}
+	for i := 0; i < numInputs; i++ {
+		tx.Vin = append(tx.Vin, TxInput{
+			TxId:      filledBytes(32),
+			VoutIdx:   i,
+			Signature: filledBytes(64),
+			PubKey:    filledBytes(64),
+		})
+	}
+	for i := 0; i < numOutputs; i++ {
+		// a value without trailing zero bits takes the most bytes in gob
+		tx.Vout = append(tx.Vout, TxOutput{Value: math.Pi, PubKeyHash: filledBytes(20)})
+	}
+	tx.Id = filledBytes(32)
+	return tx.Size()
+}
+
+// EstimateFee estimates the fee of a transaction with numInputs inputs and numOutputs outputs at the rate feePerByte.
+func EstimateFee(numInputs, numOutputs int, feePerByte float64) float64 {
+	return float64(EstimateTxSize(numInputs, numOutputs)) * feePerByte
+}
+
+// TxFee returns the fee of tx, i.e., the value of its inputs minus the value of its outputs. The previous
+// transactions are looked up in pool and chain, thus tx may spend the outputs of the unconfirmed ones. An error is
+// returned if some of them is found in neither.
+func (chain *BlockChain) TxFee(tx *Transaction, pool map[string]Transaction) (float64, error) {
+	prevTxs, err := chain.findPrevTxs(tx, pool)
+	if err != nil {
+		return 0, err
+	}
+	fee := 0.0
+	for _, txInput := range tx.Vin {
+		fee += prevTxs[hex.EncodeToString(txInput.TxId)].Vout[txInput.VoutIdx].Value
+	}
+	for _, txOutput := range tx.Vout {
+		fee -= txOutput.Value
+	}
+	return fee, nil
+}
+
+// filledBytes returns a byte slice of length n without zero bytes.
+func filledBytes(n int) []byte {
+	data := make([]byte, n)
+	for idx := range data {
+		data[idx] = 0xff
+	}
+	return data
+}
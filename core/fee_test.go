@@ -0,0 +1,136 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`encoding/hex`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`math`
+	`testing`
+)
+
+func TestEstimateFee(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	for i := 0; i < 2; i++ {
+		height := chain.GetChainHeight() + 1
+		chain.MineBlock([]*Transaction{NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)})
+	}
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	dstAddr := string(NewWallet().GetAddr())
+
+	// 1 input and 2 outputs (payment + change), 3 inputs and 2 outputs
+	for _, amount := range []float64{100, 1500} {
+		tx, err := NewUTXOTx(wallet, dstAddr, amount, &utxoSet)
+		assert.Nil(t, err)
+
+		estimated := EstimateTxSize(len(tx.Vin), len(tx.Vout))
+		assert.InDelta(t, tx.Size(), estimated, float64(tx.Size())*0.05, "Estimated size is close to the actual size")
+		assert.GreaterOrEqual(t, estimated, tx.Size(), "Estimated size does not underestimate")
+		assert.Equal(t, float64(estimated)*DefaultFeePerByte, EstimateFee(len(tx.Vin), len(tx.Vout), DefaultFeePerByte))
+	}
+
+	assert.Less(t, EstimateTxSize(1, 1), EstimateTxSize(2, 1), "More inputs cost more")
+	assert.Less(t, EstimateTxSize(1, 1), EstimateTxSize(1, 2), "More outputs cost more")
+}
+
+func TestTxFee(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	// pay a fee of 1 by lowering the change
+	parent, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	changeIdx := len(parent.Vout) - 1
+	parent.Vout[changeIdx].Value -= 1
+	fee, err := chain.TxFee(parent, nil)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1, fee, valueTolerance)
+
+	// the child spends the unconfirmed change, paying a fee of 0.5
+	child := &Transaction{
+		Version: TxVersion,
+		Vin:     []TxInput{{TxId: parent.Id, VoutIdx: changeIdx, PubKey: wallet.PubKey}},
+		Vout:    []TxOutput{*NewTxOutput(parent.Vout[changeIdx].Value-0.5, string(wallet.GetAddr()))},
+	}
+	child.Id = child.Hashing()
+	fee, err = chain.TxFee(child, map[string]Transaction{hex.EncodeToString(parent.Id): *parent})
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.5, fee, valueTolerance, "The parent is looked up in pool")
+	_, err = chain.TxFee(child, nil)
+	assert.NotNil(t, err, "The fee of a transaction with unknown parents is unknown")
+}
+
+func TestMaxTxFee(t *testing.T) {
+	useTempDataDir(t)
+	t.Cleanup(func() {
+		MaxTxFee = DefaultMaxTxFee
+	})
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	// the only output of the wallet is split at a rate paying twice the cap
+	feePerByte := 2 * DefaultMaxTxFee / float64(EstimateTxSize(1, 2))
+	_, err := NewSplitTx(wallet, 2, feePerByte, &utxoSet)
+	assert.True(t, errors.Is(err, ErrFeeTooHigh), "The fee exceeding the cap is rejected")
+
+	MaxTxFee = -1
+	tx, err := NewSplitTx(wallet, 2, feePerByte, &utxoSet)
+	assert.Nil(t, err, "The fee is allowed once the cap is lifted")
+	fee, err := chain.TxFee(tx, nil)
+	assert.Nil(t, err)
+	assert.Greater(t, fee, DefaultMaxTxFee)
+
+	MaxTxFee = DefaultMaxTxFee
+	_, err = NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err, "The transaction without fee is under the cap")
+}
+
+func TestSizeCache(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	tx, err := newUnsignedUTXOTx(wallet.PubKey, string(NewWallet().GetAddr()), 10, FirstFit, &utxoSet)
+	assert.Nil(t, err)
+
+	unsignedSize := tx.Size()
+	assert.Equal(t, len(tx.SerializeTx()), unsignedSize)
+	assert.NotNil(t, tx.serialized, "The serialized bytes are cached")
+	assert.Equal(t, unsignedSize, tx.Size(), "The cache returns consistent results")
+
+	chain.SignTx(tx, wallet.PrivateKey)
+	assert.Nil(t, tx.serialized, "Sign resets the cache")
+	assert.Equal(t, len(tx.SerializeTx()), tx.Size())
+	assert.Greater(t, tx.Size(), unsignedSize, "The signatures are counted")
+
+	decoded := DeserializeTx(tx.SerializeTx())
+	assert.Equal(t, tx.Size(), decoded.Size(), "The cache is not serialized")
+	tx.Vout[0].Value = math.Pi
+	tx.resetCache()
+	assert.Equal(t, len(tx.SerializeTx()), tx.Size())
+	assert.NotEqual(t, decoded.Size(), tx.Size())
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the block header, which is used to sync the header chain before downloading the block bodies.
+
+package core
+
+import (
+	`bytes`
+	`fmt`
+	`math/big`
+)
+
+// Header is the header of a Block. Instead of the transactions, it keeps their Merkle root, thus the PoW of a block
+// can be validated without the block body.
+type Header struct {
+	TimeStamp     int64
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Hash          []byte
+	Nonce         int
+	Height        int
+	Bits          int
+}
+
+// Header returns the header of block.
+func (block *Block) Header() *Header {
+	return &Header{
+		TimeStamp:     block.TimeStamp,
+		PrevBlockHash: block.PrevBlockHash,
+		MerkleRoot:    block.HashingAllTxs(),
+		Hash:          block.Hash,
+		Nonce:         block.Nonce,
+		Height:        block.Height,
+		Bits:          block.Bits,
+	}
+}
+
+// Difficulty returns the difficulty of the block of header (see Block.Difficulty).
+func (header *Header) Difficulty() int {
+	return difficultyOf(header.Bits)
+}
+
+// PoWData returns the data hashed in the PoW of the block of header with nonce. The block is mined if the hash of it
+// (by the PoWStrategy of the chain, see PoWStrategy.Hash) is below Target. It lets an external miner grind the nonce
+// without the block body.
+func (header *Header) PoWData(nonce int) []byte {
+	return powData(header.PrevBlockHash, header.MerkleRoot, header.TimeStamp, header.Difficulty(), nonce)
+}
+
+// Target returns the PoW target of the block of header at its difficulty.
+func (header *Header) Target() *big.Int {
+	return targetOf(header.Difficulty())
+}
+
+// ValidateHeaders checks whether headers (from the oldest to the newest) form a valid header chain which can be
+// appended to chain: the PoW of each header is validated by chain.PoW, each header points to the previous one with
+// the height increased by 1, and the first header is either the genesis header of chain or points to a block on chain.
+func (chain *BlockChain) ValidateHeaders(headers []*Header) error {
+	for idx, header := range headers {
+		if !chain.PoW.ValidateHeader(header) {
+			return fmt.Errorf("header %x has invalid proof of work", header.Hash)
+		}
+
+		if idx > 0 {
+			prevHeader := headers[idx-1]
+			if !bytes.Equal(header.PrevBlockHash, prevHeader.Hash) || header.Height != prevHeader.Height+1 {
+				return fmt.Errorf("header %x does not follow header %x", header.Hash, prevHeader.Hash)
+			}
+			continue
+		}
+		if len(header.PrevBlockHash) == 0 {
+			if header.Height != 0 {
+				return fmt.Errorf("genesis header %x has height %d", header.Hash, header.Height)
+			}
+			if !bytes.Equal(header.Hash, chain.GenesisHash()) {
+				return fmt.Errorf("header %x at height 0 is not the genesis header %x", header.Hash, chain.GenesisHash())
+			}
+			continue
+		}
+		prevBlock, err := chain.GetBlock(header.PrevBlockHash)
+		if err != nil {
+			return fmt.Errorf("header %x points to an unknown block %x", header.Hash, header.PrevBlockHash)
+		}
+		if header.Height != prevBlock.Height+1 {
+			return fmt.Errorf("header %x does not follow block %x", header.Hash, prevBlock.Hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+// headersOf returns the headers of all blocks of chain from the oldest to the newest.
+func headersOf(chain *BlockChain) []*Header {
+	var headers []*Header
+	blockHashes := chain.GetAllBlocksHashes()
+	for idx := len(blockHashes) - 1; idx >= 0; idx-- {
+		block, _ := chain.GetBlock(blockHashes[idx])
+		headers = append(headers, block.Header())
+	}
+	return headers
+}
+
+func TestValidateHeaders(t *testing.T) {
+	chain, wallet := newMemChain(t, DefaultGenesisConfig())
+	addr := string(wallet.GetAddr())
+	for i := 0; i < 3; i++ {
+		mineTxs(chain, addr)
+	}
+	headers := headersOf(chain)
+	otherChain, _ := CreateBlockChainWithStore(NewMemStore(), addr, DefaultGenesisConfig())
+
+	assert.Nil(t, chain.ValidateHeaders(headers), "The header chain from genesis is valid")
+	assert.Nil(t, otherChain.ValidateHeaders(headers), "The header chain from the same genesis is valid")
+	assert.Nil(t, chain.ValidateHeaders(headers[2:]), "The header chain extending a block on chain is valid")
+	assert.NotNil(t, otherChain.ValidateHeaders(headers[2:]), "The header chain extending an unknown block is invalid")
+	assert.NotNil(t, chain.ValidateHeaders([]*Header{headers[0], headers[2]}), "Unlinked headers are invalid")
+
+	// the chain with another genesis block
+	foreignChain, _ := newMemChain(t, DefaultGenesisConfig())
+	foreignHeaders := headersOf(foreignChain)
+	assert.NotEqual(t, headers[0].Hash, foreignHeaders[0].Hash)
+	assert.NotNil(t, chain.ValidateHeaders(foreignHeaders), "The header chain of another genesis is invalid")
+
+	tampered := *headers[1]
+	tampered.MerkleRoot = headers[2].MerkleRoot
+	assert.False(t, Sha256PoW{}.ValidateHeader(&tampered))
+	assert.NotNil(t, chain.ValidateHeaders([]*Header{headers[0], &tampered}), "Header with invalid PoW is invalid")
+	assert.True(t, NoopPoW{}.ValidateHeader(&tampered))
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`bytes`
+	`fmt`
+	`lightChain/utils`
+)
+
+// The bucket for the height index. Key: the height (in big endian), Value: the hash of the block at that height on the
+// main chain, i.e., the chain ending at the tip.
+const heightIndexBucket = "HeightIndex"
+
+// indexHeight sets block as the block at its height in the height index. The blocks it descends from are indexed at
+// their heights as well, until the indexed one is met, thus the index follows the new tip after a fork. The
+// transactions of each block indexed are added to the txid index (see indexTxs) as well, thus a transaction packed on
+// both branches is looked up in the block of the new main chain. It should be called in the same db transaction which
+// sets block as the tip.
+func indexHeight(tx StoreTx, block *Block) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(heightIndexBucket))
+	if err != nil {
+		return err
+	}
+	blocks := tx.Bucket([]byte(blocksBucket))
+
+	for {
+		key := utils.Int2Hex(int64(block.Height))
+		if bytes.Equal(bucket.Get(key), block.Hash) {
+			return nil
+		}
+		if err := bucket.Put(key, block.Hash); err != nil {
+			return err
+		}
+		if err := indexTxs(tx, block); err != nil {
+			return err
+		}
+		if len(block.PrevBlockHash) == 0 {
+			return nil
+		}
+		prevData := blocks.Get(block.PrevBlockHash)
+		if prevData == nil {
+			// the parent is missing, thus the blocks below are left as indexed
+			return nil
+		}
+		block = DeserializeBlock(prevData)
+	}
+}
+
+// onMainChain checks whether block is the block at its height in the height index, i.e., it is not left on a side
+// branch by a fork.
+func onMainChain(tx StoreTx, block *Block) bool {
+	bucket := tx.Bucket([]byte(heightIndexBucket))
+	if bucket == nil {
+		return false
+	}
+	return bytes.Equal(bucket.Get(utils.Int2Hex(int64(block.Height))), block.Hash)
+}
+
+// BlockHashAtHeight returns the hash of the block at height on the main chain through the height index.
+func (chain *BlockChain) BlockHashAtHeight(height int) ([]byte, error) {
+	var blockHash []byte
+	err := chain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(heightIndexBucket))
+			if bucket == nil {
+				return fmt.Errorf("%w: no block is indexed at height %d", ErrBlockNotFound, height)
+			}
+			value := bucket.Get(utils.Int2Hex(int64(height)))
+			if value == nil {
+				return fmt.Errorf("%w: no block is indexed at height %d", ErrBlockNotFound, height)
+			}
+			// the value is only valid during the db transaction, thus copy it
+			blockHash = append([]byte{}, value...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return blockHash, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the helpers shared by the tests of the core package, e.g., the chains to test against.
+
+package core
+
+import (
+	`bytes`
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+// useTempDataDir points DataDir to a fresh temporary directory, and restores it when the test finishes.
+func useTempDataDir(t *testing.T) string {
+	oldDataDir := DataDir
+	DataDir = t.TempDir()
+	t.Cleanup(func() {
+		DataDir = oldDataDir
+	})
+	return DataDir
+}
+
+// newTestChain creates a chain for nodeId whose genesis coinbase reward goes to a new wallet.
+func newTestChain(t *testing.T, nodeId, genesisMsg string) (*BlockChain, *Wallet) {
+	wallet := NewWallet()
+	config := DefaultGenesisConfig()
+	config.GenesisMsg = genesisMsg
+	chain, err := CreateBlockChain(string(wallet.GetAddr()), nodeId, config)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = chain.Db.Close()
+	})
+	return chain, wallet
+}
+
+// newMemChain creates an in-memory chain with config whose genesis coinbase reward goes to a new wallet. Its UTXO set
+// is built, thus blocks can be mined on it with mineTxs at once.
+func newMemChain(t testing.TB, config GenesisConfig) (*BlockChain, *Wallet) {
+	wallet := NewWallet()
+	chain, err := CreateBlockChainWithStore(NewMemStore(), string(wallet.GetAddr()), config)
+	assert.Nil(t, err)
+	UTXOSet{BlockChain: chain}.Rebuild()
+	return chain, wallet
+}
+
+// mineTxs mines a block packing a coinbase transaction paying addr followed by txs on the tip of chain, and updates
+// the UTXO set of chain with it.
+func mineTxs(chain *BlockChain, addr string, txs ...*Transaction) *Block {
+	height := chain.GetChainHeight() + 1
+	coinbaseTx := NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)
+	block := chain.MineBlock(append([]*Transaction{coinbaseTx}, txs...))
+	UTXOSet{BlockChain: chain}.Update(block)
+	return block
+}
+
+// forkBlock mines a block packing a coinbase transaction only on parent with NoopPoW, which is not added to any chain.
+func forkBlock(parent *Block) *Block {
+	height := parent.Height + 1
+	coinbaseTx := NewCoinbaseTx(string(NewWallet().GetAddr()), "", 10, height)
+	return NewBlockWithPoW([]*Transaction{coinbaseTx}, parent.Hash, height, NoopPoW{})
+}
+
+// genesisOf returns the genesis block of chain.
+func genesisOf(chain *BlockChain) *Block {
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		if len(block.PrevBlockHash) == 0 {
+			return block
+		}
+	}
+}
+
+// copyChain returns a memory store holding a copy of chain.
+func copyChain(t *testing.T, chain *BlockChain) Store {
+	var export bytes.Buffer
+	_, err := chain.ExportTo(&export)
+	assert.Nil(t, err)
+	store := NewMemStore()
+	_, err = ImportChainWithStore(&export, store)
+	assert.Nil(t, err)
+	return store
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the JSON format of Block and Transaction (for the integration with JavaScript tools). All the
+// hashes and keys are rendered as hex strings, and values are rendered as numbers. Each type overrides its byte slice
+// fields on top of an alias of itself, thus newly added fields are exported automatically.
+
+package core
+
+import (
+	`encoding/hex`
+	`encoding/json`
+)
+
+// hexBytes is a byte slice rendered as a hex string in JSON. A nil slice is rendered as null, thus the difference
+// between nil and empty slice survives the round trip.
+type hexBytes []byte
+
+func (b hexBytes) MarshalJSON() ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+func (b *hexBytes) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*b = nil
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(str)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// MarshalJSON renders block as JSON.
+func (block Block) MarshalJSON() ([]byte, error) {
+	type alias Block
+	return json.Marshal(struct {
+		alias
+		PrevBlockHash hexBytes
+		Hash          hexBytes
+	}{alias(block), block.PrevBlockHash, block.Hash})
+}
+
+// UnmarshalJSON decodes block from the JSON rendered by MarshalJSON.
+func (block *Block) UnmarshalJSON(data []byte) error {
+	type alias Block
+	aux := struct {
+		*alias
+		PrevBlockHash hexBytes
+		Hash          hexBytes
+	}{alias: (*alias)(block)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	block.PrevBlockHash, block.Hash = aux.PrevBlockHash, aux.Hash
+	return nil
+}
+
+// MarshalJSON renders tx as JSON.
+func (tx Transaction) MarshalJSON() ([]byte, error) {
+	type alias Transaction
+	return json.Marshal(struct {
+		alias
+		Id hexBytes
+	}{alias(tx), tx.Id})
+}
+
+// UnmarshalJSON decodes tx from the JSON rendered by MarshalJSON.
+func (tx *Transaction) UnmarshalJSON(data []byte) error {
+	type alias Transaction
+	aux := struct {
+		*alias
+		Id hexBytes
+	}{alias: (*alias)(tx)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	tx.Id = aux.Id
+	tx.resetCache()
+	return nil
+}
+
+// MarshalJSON renders txInput as JSON.
+func (txInput TxInput) MarshalJSON() ([]byte, error) {
+	type alias TxInput
+	return json.Marshal(struct {
+		alias
+		TxId      hexBytes
+		Signature hexBytes
+		PubKey    hexBytes
+	}{alias(txInput), txInput.TxId, txInput.Signature, txInput.PubKey})
+}
+
+// UnmarshalJSON decodes txInput from the JSON rendered by MarshalJSON.
+func (txInput *TxInput) UnmarshalJSON(data []byte) error {
+	type alias TxInput
+	aux := struct {
+		*alias
+		TxId      hexBytes
+		Signature hexBytes
+		PubKey    hexBytes
+	}{alias: (*alias)(txInput)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	txInput.TxId, txInput.Signature, txInput.PubKey = aux.TxId, aux.Signature, aux.PubKey
+	return nil
+}
+
+// MarshalJSON renders txOutput as JSON.
+func (txOutput TxOutput) MarshalJSON() ([]byte, error) {
+	type alias TxOutput
+	return json.Marshal(struct {
+		alias
+		PubKeyHash hexBytes
+	}{alias(txOutput), txOutput.PubKeyHash})
+}
+
+// UnmarshalJSON decodes txOutput from the JSON rendered by MarshalJSON.
+func (txOutput *TxOutput) UnmarshalJSON(data []byte) error {
+	type alias TxOutput
+	aux := struct {
+		*alias
+		PubKeyHash hexBytes
+	}{alias: (*alias)(txOutput)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	txOutput.PubKeyHash = aux.PubKeyHash
+	return nil
+}
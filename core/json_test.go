@@ -0,0 +1,63 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`encoding/hex`
+	`encoding/json`
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+func TestBlockJSONRoundTrip(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 100, &utxoSet)
+	assert.Nil(t, err)
+	coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CoinbaseReward, chain.GetChainHeight()+1)
+	chain.MineBlock([]*Transaction{coinbaseTx, tx})
+
+	// the block read from db (decoded by gob) and the genesis block
+	for _, block := range []*Block{chain.Iterator().Next(), genesisOf(chain)} {
+		data, err := json.Marshal(block)
+		assert.Nil(t, err)
+
+		var decoded Block
+		assert.Nil(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, *block, decoded, "Block survives the JSON round trip")
+	}
+}
+
+func TestTransactionJSONFormat(t *testing.T) {
+	tx := NewCoinbaseTx(string(NewWallet().GetAddr()), "coinbase data", 10, 0)
+	data, err := json.Marshal(tx)
+	assert.Nil(t, err)
+
+	var fields map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, hex.EncodeToString(tx.Id), fields["Id"], "Id is rendered as a hex string")
+	vout := fields["Vout"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, 10.0, vout["Value"], "Value is rendered as a number")
+	assert.Equal(t, hex.EncodeToString(tx.Vout[0].PubKeyHash), vout["PubKeyHash"])
+
+	var decoded Transaction
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *tx, decoded, "Transaction survives the JSON round trip")
+}
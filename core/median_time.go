@@ -0,0 +1,70 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file computes the median time past (like BIP 113 in bitcoin): the median timestamp of the latest blocks, which
+// the locktimes are compared with, thus a miner cannot unlock a transaction early with a single skewed timestamp.
+
+package core
+
+import (
+	`sort`
+)
+
+// medianTimeSpan is the number of the latest blocks whose timestamps the median time past is taken from.
+const medianTimeSpan = 11
+
+// MedianTimePast returns the median timestamp of the latest medianTimeSpan blocks ending at the tip of chain (or of
+// all the blocks near the genesis block). The transactions packed into the next block should be final at it (see
+// Transaction.IsFinal).
+func (chain *BlockChain) MedianTimePast() int64 {
+	var timestamps []int64
+	iter := chain.Iterator()
+	for len(timestamps) < medianTimeSpan {
+		block := iter.Next()
+		timestamps = append(timestamps, block.TimeStamp)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	return medianOf(timestamps)
+}
+
+// medianTimePastBefore returns the median time past of the blocks preceding block, i.e., the ones ending at its
+// parent. The walk stops at a missing block, and 0 is returned if block has no parent.
+func (chain *BlockChain) medianTimePastBefore(block *Block) int64 {
+	var timestamps []int64
+	for hash := block.PrevBlockHash; len(hash) != 0 && len(timestamps) < medianTimeSpan; {
+		prevBlock, err := chain.GetBlock(hash)
+		if err != nil {
+			break
+		}
+		timestamps = append(timestamps, prevBlock.TimeStamp)
+		hash = prevBlock.PrevBlockHash
+	}
+	return medianOf(timestamps)
+}
+
+// medianOf returns the median of timestamps (the later one of the middle two if their number is even), or 0 if there
+// is none. timestamps is sorted in place.
+func medianOf(timestamps []int64) int64 {
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+	return timestamps[len(timestamps)/2]
+}
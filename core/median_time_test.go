@@ -0,0 +1,45 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`github.com/stretchr/testify/assert`
+	`testing`
+	`time`
+)
+
+func TestMedianTimePast(t *testing.T) {
+	const base = 1600000000
+	setClock := useClock(t)
+	setClock(time.Unix(base, 0))
+	addr := string(NewWallet().GetAddr())
+	chain, err := CreateBlockChainWithStore(NewMemStore(), addr, DefaultGenesisConfig())
+	assert.Nil(t, err)
+	chain.PoW = NoopPoW{}
+	assert.Equal(t, int64(base), chain.MedianTimePast(), "The median of the genesis block only is its timestamp")
+
+	// the timestamps of the new blocks and the expected median after each, a skewed one barely moves the median
+	offsets := []int64{100, 50, 300, 20, 200, 100000, 150, 120, 90, 400, 250, 30}
+	medians := []int64{100, 50, 100, 50, 100, 100, 150, 120, 120, 120, 150, 150}
+	for i, offset := range offsets {
+		setClock(time.Unix(base+offset, 0))
+		height := chain.GetChainHeight() + 1
+		chain.MineBlock([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)})
+		assert.Equal(t, base+medians[i], chain.MedianTimePast(), "The median after block %d", height)
+		assert.Equal(t, chain.MedianTimePast(), chain.medianTimePastBefore(&Block{PrevBlockHash: chain.Tip}))
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements MemoryHardPoW, a PoWStrategy whose hashing fills and mixes a block of memory for each nonce,
+// and the selection of the PoW scheme of a chain by its name in GenesisConfig.
+
+package core
+
+import (
+	`bytes`
+	`crypto/sha256`
+	`encoding/binary`
+	`errors`
+	`fmt`
+	`math/big`
+)
+
+// The names of the PoW schemes, one of which is stored in GenesisConfig.PoW.
+const (
+	Sha256PoWName     = "sha256"
+	MemoryHardPoWName = "memhard"
+)
+
+// ErrUnknownPoW is returned when the PoW scheme of a chain is not known by this node.
+var ErrUnknownPoW = errors.New("unknown proof of work")
+
+// PoWByName returns the PoWStrategy of the PoW scheme name. The empty name is Sha256PoWName, which the chains created
+// before the scheme was selectable use. An error wrapping ErrUnknownPoW is returned for the other names.
+func PoWByName(name string) (PoWStrategy, error) {
+	switch name {
+	case "", Sha256PoWName:
+		return Sha256PoW{}, nil
+	case MemoryHardPoWName:
+		return MemoryHardPoW{}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownPoW, name)
+}
+
+// memHardBlocks is the number of the 32-byte blocks of the memory filled and mixed for each nonce, i.e., 32 KiB.
+const memHardBlocks = 1024
+
+// MemoryHardPoW is the PoWStrategy whose hash of each nonce is computed through memHardHash, thus the mining is bound
+// by the memory access rather than the sha256 throughput. It is selected by MemoryHardPoWName.
+type MemoryHardPoW struct{}
+
+// Run finds the nonce of block whose memory-hard hash satisfies the target at the difficulty of block.
+func (MemoryHardPoW) Run(block *Block) (int, []byte) {
+	var hashInt big.Int
+	target := targetOf(block.Difficulty())
+	merkleRoot := block.HashingAllTxs()
+
+	fmt.Println("Start to mine a new block with the memory-hard PoW...")
+	for nonce := 0; nonce < maxNonce; nonce++ {
+		hash := memHardHash(powData(block.PrevBlockHash, merkleRoot, block.TimeStamp, block.Difficulty(), nonce))
+		hashInt.SetBytes(hash)
+		if hashInt.Cmp(target) == -1 {
+			return nonce, hash
+		}
+	}
+	return maxNonce, nil
+}
+
+// Validate checks the nonce of block like ValidateHeader.
+func (pow MemoryHardPoW) Validate(block *Block) bool {
+	return pow.ValidateHeader(block.Header())
+}
+
+// ValidateHeader checks that the memory-hard hash of header is computed from its content and nonce, and satisfies the
+// target.
+func (pow MemoryHardPoW) ValidateHeader(header *Header) bool {
+	var hashInt big.Int
+
+	hash := pow.Hash(header)
+	hashInt.SetBytes(hash)
+
+	return bytes.Equal(hash, header.Hash) && header.Difficulty() >= targetBits &&
+		-1 == hashInt.Cmp(targetOf(header.Difficulty()))
+}
+
+// Hash returns the memory-hard hash of the PoW data of header at its nonce (see Header.PoWData).
+func (MemoryHardPoW) Hash(header *Header) []byte {
+	return memHardHash(header.PoWData(header.Nonce))
+}
+
+// memHardHash fills memHardBlocks blocks of memory with a sha256 chain seeded by data, then mixes a running hash with
+// the blocks at the indices derived from the hash itself (in the way of scrypt's ROMix), and returns the final hash.
+// The random reads require the whole memory to be kept for each nonce.
+func memHardHash(data []byte) []byte {
+	memory := make([][sha256.Size]byte, memHardBlocks)
+	memory[0] = sha256.Sum256(data)
+	for i := 1; i < memHardBlocks; i++ {
+		memory[i] = sha256.Sum256(memory[i-1][:])
+	}
+
+	x := memory[memHardBlocks-1]
+	var mixed [sha256.Size]byte
+	for i := 0; i < memHardBlocks; i++ {
+		j := binary.BigEndian.Uint32(x[:4]) % memHardBlocks
+		for k := range mixed {
+			mixed[k] = x[k] ^ memory[j][k]
+		}
+		x = sha256.Sum256(mixed[:])
+	}
+	return x[:]
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+// newPoWChain creates an in-memory chain with the PoW scheme pow.
+func newPoWChain(t *testing.T, pow string) (*BlockChain, Store) {
+	config := DefaultGenesisConfig()
+	config.PoW = pow
+	chain, _ := newMemChain(t, config)
+	return chain, chain.Db
+}
+
+func TestPoWByName(t *testing.T) {
+	for name, expected := range map[string]PoWStrategy{
+		"":                Sha256PoW{},
+		Sha256PoWName:     Sha256PoW{},
+		MemoryHardPoWName: MemoryHardPoW{},
+	} {
+		pow, err := PoWByName(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, pow)
+	}
+	_, err := PoWByName("scrypt")
+	assert.True(t, errors.Is(err, ErrUnknownPoW))
+
+	config := DefaultGenesisConfig()
+	config.PoW = "scrypt"
+	_, err = CreateBlockChainWithStore(NewMemStore(), string(NewWallet().GetAddr()), config)
+	assert.True(t, errors.Is(err, ErrUnknownPoW), "The chain with an unknown PoW scheme is not created")
+}
+
+func TestPoWSelectedByConfig(t *testing.T) {
+	shaChain, _ := newPoWChain(t, Sha256PoWName)
+	memChain, memStore := newPoWChain(t, MemoryHardPoWName)
+	assert.Equal(t, Sha256PoW{}, shaChain.PoW)
+	assert.Equal(t, MemoryHardPoW{}, memChain.PoW)
+	assert.True(t, memChain.PoW.Validate(genesisOf(memChain)), "The genesis block is mined through the chain's PoW")
+	assert.False(t, Sha256PoW{}.Validate(genesisOf(memChain)))
+
+	// the scheme is persisted, thus resolved again on open
+	reopened, err := NewBlockChainWithStore(memStore)
+	assert.Nil(t, err)
+	assert.Equal(t, MemoryHardPoW{}, reopened.PoW)
+
+	addr := string(NewWallet().GetAddr())
+	for _, chain := range []*BlockChain{shaChain, memChain} {
+		height := chain.GetChainHeight() + 1
+		txs := []*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)}
+		shaBlock := NewBlockWithPoW(txs, chain.Tip, height, Sha256PoW{})
+		memBlock := NewBlockWithPoW(txs, chain.Tip, height, MemoryHardPoW{})
+		if chain == shaChain {
+			assert.Nil(t, chain.ValidateBlock(shaBlock))
+			assert.True(t, errors.Is(chain.ValidateBlock(memBlock), ErrInvalidBlock),
+				"The block mined through the memory-hard PoW is rejected by the sha256 chain")
+			assert.False(t, chain.PoW.ValidateHeader(memBlock.Header()))
+		} else {
+			assert.Nil(t, chain.ValidateBlock(memBlock))
+			assert.True(t, errors.Is(chain.ValidateBlock(shaBlock), ErrInvalidBlock),
+				"The block mined through the sha256 PoW is rejected by the memory-hard chain")
+			assert.False(t, chain.PoW.ValidateHeader(shaBlock.Header()))
+		}
+	}
+
+	// blocks mined by the chain itself follow its scheme
+	height := memChain.GetChainHeight() + 1
+	block := memChain.MineBlock([]*Transaction{NewCoinbaseTx(addr, "", memChain.CurrentReward(height), height)})
+	assert.True(t, MemoryHardPoW{}.Validate(block))
+}
+
+func TestPoWHash(t *testing.T) {
+	addr := string(NewWallet().GetAddr())
+	txs := []*Transaction{NewCoinbaseTx(addr, "", initCoinbaseReward, 1)}
+	for _, pow := range []PoWStrategy{Sha256PoW{}, MemoryHardPoW{}} {
+		block := NewBlockWithPoW(txs, []byte("prev"), 1, pow)
+		assert.Equal(t, block.Hash, pow.Hash(block.Header()), "The hash of the mined block is the one of its header")
+	}
+	block := NewBlockWithPoW(txs, []byte("prev"), 1, Sha256PoW{})
+	assert.NotEqual(t, block.Hash, MemoryHardPoW{}.Hash(block.Header()))
+}
@@ -17,8 +17,10 @@
 package core
 
 import (
+	`bytes`
 	`crypto/sha256`
 	`errors`
+	`fmt`
 	`log`
 )
 
@@ -85,3 +87,47 @@ func NewMerkleTree(data [][]byte) (*MerkleTree, error) {
 		return &MerkleTree{}, errors.New("sth. wrong when constructing the merkle tree")
 	}
 }
+
+// MerkleProof proves that a leaf is in a Merkle tree. Hashes are the hashes of the siblings from the leaf up to the
+// root, and the i-th bit of Index tells whether the node at level i is a left (0) or a right (1) child.
+type MerkleProof struct {
+	Index  int
+	Hashes [][]byte
+}
+
+// Proof returns the MerkleProof of the idx-th leaf of tree.
+func (tree *MerkleTree) Proof(idx int) (MerkleProof, error) {
+	depth := 0
+	for node := tree.RootNode; node.Left != nil; node = node.Left {
+		depth++
+	}
+	if idx < 0 || idx >= 1<<depth {
+		return MerkleProof{}, fmt.Errorf("leaf %d is out of the Merkle tree with %d leaves", idx, 1<<depth)
+	}
+
+	proof := MerkleProof{Index: idx, Hashes: make([][]byte, depth)}
+	node := tree.RootNode
+	for level := depth - 1; level >= 0; level-- {
+		if (idx>>level)&1 == 0 {
+			proof.Hashes[level] = node.Right.Data
+			node = node.Left
+		} else {
+			proof.Hashes[level] = node.Left.Data
+			node = node.Right
+		}
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof checks whether data is a leaf of the Merkle tree whose root hash is root through proof.
+func VerifyMerkleProof(root, data []byte, proof MerkleProof) bool {
+	hash := sha256.Sum256(data)
+	for level, sibling := range proof.Hashes {
+		if (proof.Index>>level)&1 == 0 {
+			hash = sha256.Sum256(append(hash[:], sibling...))
+		} else {
+			hash = sha256.Sum256(append(append([]byte{}, sibling...), hash[:]...))
+		}
+	}
+	return bytes.Equal(hash[:], root)
+}
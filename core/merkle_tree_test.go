@@ -92,3 +92,24 @@ func TestNewMerkleTree(t *testing.T) {
 		"Merkle tree root hash is correct",
 	)
 }
+
+func TestMerkleProof(t *testing.T) {
+	data := [][]byte{
+		[]byte("node1"),
+		[]byte("node2"),
+		[]byte("node3"),
+		[]byte("node4"),
+	}
+	for num := 1; num <= len(data); num++ {
+		mTree, _ := NewMerkleTree(data[:num])
+		root := mTree.RootNode.Data
+		for idx := 0; idx < num; idx++ {
+			proof, err := mTree.Proof(idx)
+			assert.Nil(t, err)
+			assert.True(t, VerifyMerkleProof(root, data[idx], proof), "The proof of leaf %d of %d is valid", idx, num)
+			assert.False(t, VerifyMerkleProof(root, []byte("node5"), proof), "The proof is invalid for other data")
+		}
+		_, err := mTree.Proof(4)
+		assert.NotNil(t, err)
+	}
+}
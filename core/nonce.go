@@ -0,0 +1,62 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`bytes`
+	`fmt`
+)
+
+// SenderPubKeyHash returns the hashing of the public key which signs the first input of tx, i.e. the sender of tx.
+// nil is returned for the coinbase transaction, which has no sender.
+func (tx *Transaction) SenderPubKeyHash() []byte {
+	if tx.IsCoinbaseTx() || len(tx.Vin) == 0 {
+		return nil
+	}
+	return HashingPubKey(tx.Vin[0].PubKey)
+}
+
+// HighestNonce returns the highest nonce used by the sender (whose public key hash is pubKeyHash) on chain. 0 is
+// returned if the sender never uses the nonce.
+func (chain *BlockChain) HighestNonce(pubKeyHash []byte) uint64 {
+	var highest uint64
+	_ = chain.EachTx(func(tx *Transaction, block *Block) error {
+		if tx.Nonce > highest && bytes.Equal(tx.SenderPubKeyHash(), pubKeyHash) {
+			highest = tx.Nonce
+		}
+		return nil
+	})
+	return highest
+}
+
+// CheckNonce returns an error if the nonce of tx is not higher than the highest nonce used by its sender on chain.
+// The nonce is optional, thus a tx with zero nonce always passes. Since the outputs are consumed by reference, a tx
+// cannot be replayed anyway, but the strictly increasing nonce detects the out-of-order submissions of a sender.
+func (chain *BlockChain) CheckNonce(tx *Transaction) error {
+	return checkNonce(tx, chain)
+}
+
+// checkNonce does the check of CheckNonce, where the highest nonce of the sender is looked up in lookup.
+func checkNonce(tx *Transaction, lookup txLookup) error {
+	if tx.Nonce == 0 || tx.IsCoinbaseTx() {
+		return nil
+	}
+	if highest := lookup.HighestNonce(tx.SenderPubKeyHash()); tx.Nonce <= highest {
+		return fmt.Errorf("transaction nonce %d is not higher than the highest nonce %d of the sender", tx.Nonce, highest)
+	}
+	return nil
+}
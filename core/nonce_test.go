@@ -0,0 +1,110 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`encoding/hex`
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+func TestCheckNonce(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	// the nonce is optional
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	assert.True(t, chain.VerifyTx(tx))
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+	assert.Equal(t, uint64(0), chain.HighestNonce(HashingPubKey(wallet.PubKey)))
+
+	// in order
+	tx, err = NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet, WithNonce(2))
+	assert.Nil(t, err)
+	assert.True(t, chain.VerifyTx(tx), "The transaction with an increasing nonce is accepted")
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+	assert.Equal(t, uint64(2), chain.HighestNonce(HashingPubKey(wallet.PubKey)))
+
+	// replayed and out of order
+	for _, nonce := range []uint64{2, 1} {
+		tx, err = NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet, WithNonce(nonce))
+		assert.Nil(t, err)
+		assert.True(t, tx.Verify(chain.getPrevTxs(tx)), "The signature is valid")
+		assert.NotNil(t, chain.CheckNonce(tx))
+		assert.False(t, chain.VerifyTx(tx), "The transaction with a used nonce is rejected")
+	}
+
+	// the nonce of another sender is independent
+	otherWallet := NewWallet()
+	tx, err = NewUTXOTx(wallet, string(otherWallet.GetAddr()), 10, &utxoSet, WithNonce(3))
+	assert.Nil(t, err)
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+	tx, err = NewUTXOTx(otherWallet, string(wallet.GetAddr()), 5, &utxoSet, WithNonce(1))
+	assert.Nil(t, err)
+	assert.True(t, chain.VerifyTx(tx))
+}
+
+func TestNonceIsSigned(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet, WithNonce(1))
+	assert.Nil(t, err)
+
+	tx.Nonce = 5
+	assert.False(t, chain.VerifyTx(tx), "The tampered nonce breaks the signature")
+}
+
+func TestOrderByNonce(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	height := chain.GetChainHeight() + 1
+	mined := chain.MineBlock([]*Transaction{NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CoinbaseReward, height)})
+	// each transaction of wallet spends a coinbase output of its own
+	spendWithNonce := func(prev *Transaction, nonce uint64) *Transaction {
+		tx := &Transaction{
+			Version: TxVersion,
+			Vin:     []TxInput{{TxId: prev.Id, VoutIdx: 0, PubKey: wallet.PubKey}},
+			Vout:    []TxOutput{*NewTxOutput(prev.Vout[0].Value, string(NewWallet().GetAddr()))},
+			Nonce:   nonce,
+		}
+		tx.Id = tx.Hashing()
+		tx.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.Id): *prev})
+		return tx
+	}
+	first := spendWithNonce(genesisOf(chain).Transactions[0], 1)
+	second := spendWithNonce(mined.Transactions[0], 2)
+
+	ordered := chain.OrderVerifiedTxs([]*Transaction{second, first})
+	assert.Equal(t, []*Transaction{first, second}, ordered, "The transactions of a sender are ordered by nonce")
+
+	height++
+	coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+	assert.Panics(t, func() {
+		chain.MineBlock([]*Transaction{second, first, coinbaseTx})
+	}, "The block with nonces out of order is not stored")
+	block := chain.MineBlock(append(ordered, coinbaseTx))
+	assert.Equal(t, block.Hash, chain.TipHash())
+	assert.Equal(t, uint64(2), chain.HighestNonce(HashingPubKey(wallet.PubKey)))
+}
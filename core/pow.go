@@ -21,45 +21,185 @@ import (
 	`crypto/sha256`
 	`fmt`
 	`lightChain/utils`
+	`log`
 	`math`
 	`math/big`
+	`time`
 )
 
 const (
 	// Number of 0 bits at the beginning of the hash for PoW, tuned for changing difficulty of mining.
-	// Larger this number, more difficult the mining.
+	// Larger this number, more difficult the mining. It is the default and the minimum difficulty of blocks.
 	targetBits = 4
 
 	// The trial (ranging from 0 to maxNonce) upper bound of nonce.
 	maxNonce = math.MaxInt64
+
+	// Every retargetInterval blocks, the difficulty is retargeted (see BlockChain.CalcNextDifficulty).
+	retargetInterval = 10
+
+	// The expected seconds between two blocks in default, see GenesisConfig.TargetBlockSeconds.
+	targetBlockSeconds = 10
 )
 
+// PoWStrategy is the rule to mine (Run) and check (Validate) a block, check a block header (ValidateHeader) without
+// the block body, and compute the hash of a header at its nonce (Hash), e.g., for an external miner. It is the seam
+// for replacing the real PoW, e.g., with NoopPoW in tests.
+type PoWStrategy interface {
+	Run(block *Block) (int, []byte)
+	Validate(block *Block) bool
+	ValidateHeader(header *Header) bool
+	Hash(header *Header) []byte
+}
+
+// Sha256PoW is the PoWStrategy used in production. It mines and validates blocks through ProofOfWork.
+type Sha256PoW struct{}
+
+// Run finds the nonce and hash of block through ProofOfWork.
+func (Sha256PoW) Run(block *Block) (int, []byte) {
+	return NewPoW(block).Run()
+}
+
+// Validate checks the nonce of block through ProofOfWork.
+func (Sha256PoW) Validate(block *Block) bool {
+	return NewPoW(block).Validate()
+}
+
+// ValidateHeader checks that the hash of header is computed from its content and nonce, and satisfies the target.
+func (pow Sha256PoW) ValidateHeader(header *Header) bool {
+	var hashInt big.Int
+
+	hash := pow.Hash(header)
+	hashInt.SetBytes(hash)
+
+	return bytes.Equal(hash, header.Hash) && header.Difficulty() >= targetBits &&
+		-1 == hashInt.Cmp(targetOf(header.Difficulty()))
+}
+
+// Hash returns the sha256 of the PoW data of header at its nonce (see Header.PoWData).
+func (Sha256PoW) Hash(header *Header) []byte {
+	hash := sha256.Sum256(header.PoWData(header.Nonce))
+	return hash[:]
+}
+
+// NoopPoW is a PoWStrategy which does not grind hashes at all: the nonce is always 0 and every block is regarded as
+// validated. It makes the tests fast and reproducible. Never use it in production!
+type NoopPoW struct{}
+
+// Run returns nonce 0 and the corresponding hash of block.
+func (NoopPoW) Run(block *Block) (int, []byte) {
+	hash := sha256.Sum256(NewPoW(block).prepareData(0))
+	return 0, hash[:]
+}
+
+// Validate always returns true.
+func (NoopPoW) Validate(block *Block) bool {
+	return true
+}
+
+// ValidateHeader always returns true.
+func (NoopPoW) ValidateHeader(header *Header) bool {
+	return true
+}
+
+// Hash returns the sha256 of the PoW data of header at its nonce, like Sha256PoW.
+func (NoopPoW) Hash(header *Header) []byte {
+	return Sha256PoW{}.Hash(header)
+}
+
+// ProofOfWork is the hashcash-like PoW on a block: find a nonce such that sha256(data) < target.
 type ProofOfWork struct {
 	block  *Block
 	target *big.Int
 }
 
-// NewPoW defines the PoW for each block.
+// NewPoW defines the PoW for each block at the difficulty of the block.
 func NewPoW(block *Block) *ProofOfWork {
-	// set the target as 1 << (256 - targetBits)
+	return &ProofOfWork{block, targetOf(block.Difficulty())}
+}
+
+// difficultyOf returns the difficulty stored as bits, where 0 means targetBits.
+func difficultyOf(bits int) int {
+	if bits == 0 {
+		return targetBits
+	}
+	return bits
+}
+
+// targetOf returns the PoW target at difficulty bits, i.e., 1 << (256 - bits).
+func targetOf(bits int) *big.Int {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
-	return &ProofOfWork{block, target}
+	target.Lsh(target, uint(256-bits))
+	return target
 }
 
 // prepareData joins the existing data into a byte slice, for the purpose of hashing.
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
+	return powData(pow.block.PrevBlockHash, pow.block.HashingAllTxs(), pow.block.TimeStamp, pow.block.Difficulty(),
+		nonce)
+}
+
+// powData joins the data of a block to be hashed in PoW. merkleRoot is the hashing result of all the transactions, and
+// bits is the difficulty of the block.
+func powData(prevBlockHash, merkleRoot []byte, timeStamp int64, bits, nonce int) []byte {
 	return bytes.Join(
 		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashingAllTxs(),
-			utils.Int2Hex(pow.block.TimeStamp),
-			utils.Int2Hex(int64(targetBits)),
+			prevBlockHash,
+			merkleRoot,
+			utils.Int2Hex(timeStamp),
+			utils.Int2Hex(int64(bits)),
 			utils.Int2Hex(int64(nonce))},
 		[]byte{},
 	)
 }
 
+// CalcNextDifficulty returns the difficulty of the block following the tip of chain (see nextDifficulty).
+func (chain *BlockChain) CalcNextDifficulty() int {
+	tip, err := chain.GetBlock(chain.TipHash())
+	if err != nil {
+		log.Panic(err)
+	}
+	difficulty, err := nextDifficulty(tip, chain.GetBlock, chain.Config.TargetBlockSeconds)
+	if err != nil {
+		log.Panic(err)
+	}
+	return difficulty
+}
+
+// nextDifficulty returns the difficulty of the block following prev, where the blocks preceding prev are found by
+// getBlock. The difficulty is retargeted at every height which is a multiple of retargetInterval: if the blocks since
+// the last retargeting were mined more than twice as fast as targetSeconds (see GenesisConfig.TargetBlockSeconds),
+// the difficulty is increased by 1 (which doubles the work of a block), and if more than twice as slow, decreased by
+// 1 (but never below targetBits). Otherwise, the difficulty of prev is kept.
+func nextDifficulty(prev *Block, getBlock func(hash []byte) (*Block, error), targetSeconds int64) (int, error) {
+	difficulty := prev.Difficulty()
+	if (prev.Height+1)%retargetInterval != 0 {
+		return difficulty, nil
+	}
+
+	// the first block since the last retargeting
+	first := prev
+	for first.Height > prev.Height+1-retargetInterval {
+		var err error
+		first, err = getBlock(first.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if targetSeconds <= 0 {
+		targetSeconds = targetBlockSeconds
+	}
+	expected := int64(prev.Height-first.Height) * targetSeconds
+	actual := prev.TimeStamp - first.TimeStamp
+	switch {
+	case actual*2 < expected:
+		difficulty++
+	case actual > expected*2 && difficulty > targetBits:
+		difficulty--
+	}
+	return difficulty, nil
+}
+
 // Run finds the satisfied hash of data by trying different nonce.
 func (pow *ProofOfWork) Run() (int, []byte) {
 	var hashInt big.Int
@@ -81,7 +221,8 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 	return nonce, hash[:]
 }
 
-// Validate the mining result (nonce).
+// Validate the mining result (nonce). A block mined below the minimum difficulty targetBits is invalid, and so is the
+// block whose hash is not the one computed from its content and nonce (e.g., mined by another PoW scheme).
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
@@ -89,5 +230,28 @@ func (pow *ProofOfWork) Validate() bool {
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
 
-	return -1 == hashInt.Cmp(pow.target)
+	return bytes.Equal(hash[:], pow.block.Hash) && pow.block.Difficulty() >= targetBits &&
+		-1 == hashInt.Cmp(pow.target)
+}
+
+// BenchmarkMining runs the PoW loop of chain.PoW on a synthetic block (which packs a coinbase transaction only) for
+// duration, and returns the hash attempts per second at the current difficulty. The loop never stops on a satisfied
+// hash, and nothing is added to chain.
+func (chain *BlockChain) BenchmarkMining(duration time.Duration) float64 {
+	coinbaseTx := NewCoinbaseTx(string(NewWallet().GetAddr()), "", 0, 0)
+	block := &Block{TimeStamp: nowFunc().Unix(), PrevBlockHash: []byte{}, Bits: chain.CalcNextDifficulty(),
+		Transactions: []*Transaction{coinbaseTx}}
+	header := block.Header()
+	target := header.Target()
+
+	var hashInt big.Int
+	attempts := 0
+	start := time.Now()
+	for nonce := 0; time.Since(start) < duration; nonce++ {
+		header.Nonce = nonce
+		hashInt.SetBytes(chain.PoW.Hash(header))
+		_ = hashInt.Cmp(target) // compare as Run does, to make the cost of an attempt the same
+		attempts++
+	}
+	return float64(attempts) / time.Since(start).Seconds()
 }
@@ -0,0 +1,193 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`github.com/stretchr/testify/assert`
+	`testing`
+	`time`
+)
+
+func TestNoopPoW(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	chain.PoW = NoopPoW{}
+
+	coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CoinbaseReward, chain.GetChainHeight()+1)
+	block := chain.MineBlock([]*Transaction{coinbaseTx})
+
+	assert.Equal(t, 0, block.Nonce, "NoopPoW always returns nonce 0")
+	assert.True(t, chain.PoW.Validate(block), "Block mined with NoopPoW is accepted by the chain using NoopPoW")
+	assert.Equal(t, block.Hash, chain.Tip, "Block mined with NoopPoW becomes the tip")
+	assert.Equal(t, 2, chain.GetBlocksNum())
+}
+
+func TestSha256PoW(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CoinbaseReward, chain.GetChainHeight()+1)
+	block := chain.MineBlock([]*Transaction{coinbaseTx})
+
+	assert.True(t, Sha256PoW{}.Validate(block), "Block mined with the real PoW is validated")
+	assert.True(t, block.Hash[0] < 1<<(8-targetBits), "The hash of the mined block meets the target")
+}
+
+func TestBenchmarkMining(t *testing.T) {
+	shaChain, _ := newPoWChain(t, Sha256PoWName)
+	memChain, _ := newPoWChain(t, MemoryHardPoWName)
+	hashRate := shaChain.BenchmarkMining(100 * time.Millisecond)
+	assert.True(t, hashRate > 0, "The hashrate is positive")
+	assert.True(t, hashRate < 1e9, "The hashrate is plausible for a single CPU core")
+	assert.Equal(t, 1, shaChain.GetBlocksNum(), "Nothing is added to the chain")
+
+	memHashRate := memChain.BenchmarkMining(100 * time.Millisecond)
+	assert.True(t, memHashRate > 0)
+	assert.True(t, memHashRate < hashRate, "The memory-hard PoW of the chain is benchmarked")
+}
+
+func TestDifficultyHistory(t *testing.T) {
+	addr := string(NewWallet().GetAddr())
+	chain, _ := CreateBlockChainWithStore(NewMemStore(), addr, DefaultGenesisConfig())
+	for _, difficulty := range []int{targetBits, 6, 8, 5} {
+		height := chain.GetChainHeight() + 1
+		coinbaseTx := NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)
+		block := NewBlockWithDifficulty([]*Transaction{coinbaseTx}, chain.Tip, height, difficulty, Sha256PoW{})
+		assert.True(t, Sha256PoW{}.ValidateHeader(block.Header()))
+		if difficulty == chain.CalcNextDifficulty() {
+			assert.Nil(t, chain.ValidateBlock(block))
+		} else {
+			assert.NotNil(t, chain.ValidateBlock(block), "Block mined off the retargeted difficulty is rejected")
+		}
+		chain.AddBlock(block)
+	}
+	var blocks []*Block
+	for _, hash := range chain.GetAllBlocksHashes() {
+		block, _ := chain.GetBlock(hash)
+		blocks = append([]*Block{block}, blocks...)
+	}
+	assert.Equal(t, 3, len(ValidateChain(blocks, chain.Config, chain.PoW)), "The dry run checks the difficulties too")
+
+	history := chain.DifficultyHistory()
+	var heights, difficulties []int
+	for idx, point := range history {
+		heights = append(heights, point.Height)
+		difficulties = append(difficulties, point.Difficulty)
+		if idx > 0 {
+			assert.True(t, point.TimeStamp >= history[idx-1].TimeStamp)
+		}
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, heights, "The history is listed from the oldest")
+	assert.Equal(t, []int{targetBits, targetBits, 6, 8, 5}, difficulties, "The history reflects the changes in order")
+
+	tip, err := chain.GetBlock(chain.Tip)
+	assert.Nil(t, err)
+	assert.True(t, tip.Hash[0] < 1<<(8-5), "The hash of the tip meets its difficulty")
+
+	// the difficulty is committed in the hash, and can not go below the minimum
+	tampered := tip.Header()
+	tampered.Bits = 6
+	assert.False(t, Sha256PoW{}.ValidateHeader(tampered))
+	easy := NewBlockWithDifficulty(tip.Transactions, tip.PrevBlockHash, tip.Height, targetBits-1, Sha256PoW{})
+	assert.False(t, Sha256PoW{}.Validate(easy), "Block below the minimum difficulty is invalid")
+	assert.False(t, Sha256PoW{}.ValidateHeader(easy.Header()))
+}
+
+// useClock makes nowFunc return the time set by the returned function during the test.
+func useClock(t *testing.T) func(now time.Time) {
+	oldNowFunc := nowFunc
+	clock := time.Now()
+	nowFunc = func() time.Time {
+		return clock
+	}
+	t.Cleanup(func() {
+		nowFunc = oldNowFunc
+	})
+	return func(now time.Time) {
+		clock = now
+	}
+}
+
+// addSpacedBlocks mines n blocks on chain through setClock, each of which is timestamped spacing seconds after its
+// parent, at the retargeted difficulty (see BlockChain.CalcNextDifficulty).
+func addSpacedBlocks(chain *BlockChain, setClock func(now time.Time), addr string, n int, spacing int64) {
+	for i := 0; i < n; i++ {
+		tip, _ := chain.GetBlock(chain.Tip)
+		setClock(time.Unix(tip.TimeStamp+spacing, 0))
+		height := tip.Height + 1
+		chain.MineBlockWithPoW([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)}, NoopPoW{})
+	}
+}
+
+func TestCalcNextDifficulty(t *testing.T) {
+	setClock := useClock(t)
+	addr := string(NewWallet().GetAddr())
+	for _, c := range []struct {
+		target, spacing int64
+		expected        int
+	}{
+		{10, 10, targetBits},
+		{10, 4, targetBits + 1},
+		{10, 30, targetBits},
+		{60, 60, targetBits},
+		{60, 30, targetBits},
+		{60, 10, targetBits + 1},
+	} {
+		config := DefaultGenesisConfig()
+		config.TargetBlockSeconds = c.target
+		chain, _ := CreateBlockChainWithStore(NewMemStore(), addr, config)
+		addSpacedBlocks(chain, setClock, addr, retargetInterval-1, c.spacing)
+		assert.Equal(t, c.expected, chain.CalcNextDifficulty(),
+			"Retargeting for %ds blocks mined every %ds", c.target, c.spacing)
+
+		// the difficulty is kept between two retargetings
+		addSpacedBlocks(chain, setClock, addr, 1, c.spacing)
+		assert.Equal(t, c.expected, chain.CalcNextDifficulty())
+	}
+
+	// the difficulty is decreased after the slow blocks
+	config := DefaultGenesisConfig()
+	config.TargetBlockSeconds = 60
+	chain, _ := CreateBlockChainWithStore(NewMemStore(), addr, config)
+	addSpacedBlocks(chain, setClock, addr, retargetInterval-1, 10)
+	addSpacedBlocks(chain, setClock, addr, retargetInterval, 150)
+	assert.Equal(t, targetBits, chain.CalcNextDifficulty())
+	var difficulties []int
+	for _, point := range chain.DifficultyHistory() {
+		difficulties = append(difficulties, point.Difficulty)
+	}
+	assert.Equal(t, targetBits+1, difficulties[retargetInterval], "The blocks are mined at the retargeted difficulty")
+}
+
+func TestTargetBlockSeconds(t *testing.T) {
+	useTempDataDir(t)
+
+	assert.Equal(t, int64(targetBlockSeconds), DefaultGenesisConfig().TargetBlockSeconds)
+	config := DefaultGenesisConfig()
+	config.TargetBlockSeconds = 600
+	chain, _ := CreateBlockChain(string(NewWallet().GetAddr()), "1", config)
+	assert.Nil(t, chain.Db.Close())
+
+	// the interval is persisted and reloaded
+	chain, err := NewBlockChain("1")
+	assert.Nil(t, err)
+	defer func() {
+		_ = chain.Db.Close()
+	}()
+	assert.Equal(t, int64(600), chain.Config.TargetBlockSeconds)
+}
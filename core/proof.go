@@ -0,0 +1,96 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the output proof, with which a light client can check that a transaction output is packed into
+// a block by only trusting the block header.
+
+package core
+
+import (
+	`bytes`
+	`fmt`
+)
+
+// OutputProof proves that the VoutIdx-th output of Tx is packed into the block of Header. Merkle proves that Tx is in
+// the Merkle tree of the block transactions, whose root is Header.MerkleRoot.
+type OutputProof struct {
+	Tx      *Transaction
+	VoutIdx int
+	Header  *Header
+	Merkle  MerkleProof
+}
+
+// ProveOutput returns the OutputProof of the voutIdx-th output of the Transaction whose Id is txId. The block packing
+// that tx is found through the txid index.
+func (chain *BlockChain) ProveOutput(txId []byte, voutIdx int) (OutputProof, error) {
+	blockHash, err := chain.LookupTx(txId)
+	if err != nil {
+		return OutputProof{}, err
+	}
+	block, err := chain.GetBlock(blockHash)
+	if err != nil {
+		return OutputProof{}, err
+	}
+
+	var serializedTxData [][]byte
+	txIdx := -1
+	for idx, tx := range block.Transactions {
+		serializedTxData = append(serializedTxData, tx.serialize())
+		if bytes.Equal(tx.Id, txId) {
+			txIdx = idx
+		}
+	}
+	if txIdx < 0 {
+		return OutputProof{}, fmt.Errorf("%w: %x in block %x", ErrTxNotFound, txId, blockHash)
+	}
+	tx := block.Transactions[txIdx]
+	if voutIdx < 0 || voutIdx >= len(tx.Vout) {
+		return OutputProof{}, fmt.Errorf("output %d is out of tx %x with %d outputs", voutIdx, txId, len(tx.Vout))
+	}
+
+	merkleTree, err := NewMerkleTree(serializedTxData)
+	if err != nil {
+		return OutputProof{}, err
+	}
+	merkleProof, err := merkleTree.Proof(txIdx)
+	if err != nil {
+		return OutputProof{}, err
+	}
+	return OutputProof{Tx: tx, VoutIdx: voutIdx, Header: block.Header(), Merkle: merkleProof}, nil
+}
+
+// Output returns the proved output.
+func (proof OutputProof) Output() TxOutput {
+	return proof.Tx.Vout[proof.VoutIdx]
+}
+
+// Verify checks proof against the trusted header: the proof is made for that header, proof.VoutIdx is an output of
+// proof.Tx, and proof.Tx is in the Merkle tree whose root is trusted.MerkleRoot.
+func (proof OutputProof) Verify(trusted *Header) error {
+	if proof.Tx == nil || proof.Header == nil {
+		return fmt.Errorf("incomplete output proof")
+	}
+	if !bytes.Equal(proof.Header.Hash, trusted.Hash) {
+		return fmt.Errorf("output proof is for block %x, not the trusted block %x", proof.Header.Hash, trusted.Hash)
+	}
+	if proof.VoutIdx < 0 || proof.VoutIdx >= len(proof.Tx.Vout) {
+		return fmt.Errorf("output %d is out of tx %x with %d outputs", proof.VoutIdx, proof.Tx.Id, len(proof.Tx.Vout))
+	}
+	if !VerifyMerkleProof(trusted.MerkleRoot, proof.Tx.serialize(), proof.Merkle) {
+		return fmt.Errorf("tx %x is not in the Merkle tree of block %x", proof.Tx.Id, trusted.Hash)
+	}
+	return nil
+}
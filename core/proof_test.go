@@ -0,0 +1,60 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+func TestProveOutput(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	height := chain.GetChainHeight() + 1
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	child := spendChange(wallet, tx, string(NewWallet().GetAddr()), 1)
+	block := chain.MineBlock([]*Transaction{
+		NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height), tx, child})
+
+	for _, packed := range block.Transactions {
+		for voutIdx := range packed.Vout {
+			proof, err := chain.ProveOutput(packed.Id, voutIdx)
+			assert.Nil(t, err)
+			assert.Equal(t, packed.Vout[voutIdx], proof.Output())
+			assert.Nil(t, proof.Verify(block.Header()), "The proof is valid against the header of the packing block")
+		}
+	}
+
+	proof, err := chain.ProveOutput(child.Id, 0)
+	assert.Nil(t, err)
+	assert.NotNil(t, proof.Verify(genesisOf(chain).Header()), "The proof is invalid against another header")
+	forged := *block.Header()
+	forged.MerkleRoot = genesisOf(chain).Header().MerkleRoot
+	assert.NotNil(t, proof.Verify(&forged), "The proof is invalid against another Merkle root")
+	proof.VoutIdx = len(child.Vout)
+	assert.NotNil(t, proof.Verify(block.Header()), "The proof of a nonexistent output is invalid")
+
+	_, err = chain.ProveOutput(child.Id, len(child.Vout))
+	assert.NotNil(t, err)
+	_, err = chain.ProveOutput([]byte("nonexistent"), 0)
+	assert.True(t, errors.Is(err, ErrTxNotFound))
+}
@@ -0,0 +1,244 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the UTXO set snapshot for fast bootstrapping: a node trusting the snapshot (like a checkpoint)
+// imports it instead of rebuilding the UTXO set from the genesis block.
+
+package core
+
+import (
+	`bytes`
+	`crypto/sha256`
+	`encoding/gob`
+	`errors`
+	`fmt`
+	`io/ioutil`
+	`lightChain/utils`
+	`math`
+	`os`
+	`path/filepath`
+	`sort`
+)
+
+// ErrSnapshotCommitment is returned when the commitment of a snapshot does not match its content.
+var ErrSnapshotCommitment = errors.New("snapshot commitment mismatch")
+
+// ErrSnapshotTip is returned when importing a snapshot which is not taken at a block of the local main chain.
+var ErrSnapshotTip = errors.New("snapshot is not on the local chain")
+
+// UTXOSnapshot is the serialized UTXO set at the tip whose hash is TipHash and height is Height. Entries are the
+// key-value pairs of the utxo bucket in the byte-sorted order of keys, and Commitment is the hashing of the outputs in
+// them (see UTXOSet.Commitment), which can be checked against a trusted checkpoint.
+type UTXOSnapshot struct {
+	TipHash    []byte
+	Height     int
+	Entries    []SnapshotEntry
+	Commitment []byte
+}
+
+// SnapshotEntry is a key-value pair of the utxo bucket.
+type SnapshotEntry struct {
+	TxId    []byte
+	Outputs []byte // the serialized TxOutputs
+}
+
+// snapshotEntries returns all the key-value pairs of the utxo bucket in the byte-sorted order of keys.
+func (utxoSet UTXOSet) snapshotEntries() ([]SnapshotEntry, error) {
+	var entries []SnapshotEntry
+	err := utxoSet.BlockChain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoBucket))
+			if bucket == nil {
+				return ErrBucketNotFound
+			}
+			cursor := bucket.Cursor()
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				// the key and value are only valid during the db transaction, thus copy them
+				entries = append(entries, SnapshotEntry{append([]byte{}, key...), append([]byte{}, value...)})
+			}
+			return nil
+		})
+	return entries, err
+}
+
+// commitmentOf hashes the unspent outputs decoded from entries, in the order of entries and then of the output indices:
+// the txid, the index, the value and the public key hash of each output. The lengths of the txid and the public key
+// hash are hashed as well, thus the boundaries between them are committed. The serialized outputs are not hashed as
+// they are, thus the same outputs commit the same whichever encoding (e.g., of an older version) stores them.
+func commitmentOf(entries []SnapshotEntry) ([]byte, error) {
+	hasher := sha256.New()
+	for _, entry := range entries {
+		var txOutputs TxOutputs
+		if err := gob.NewDecoder(bytes.NewReader(entry.Outputs)).Decode(&txOutputs); err != nil {
+			return nil, fmt.Errorf("failed to decode the outputs of %x: %v", entry.TxId, err)
+		}
+		if txOutputs.Indices != nil && len(txOutputs.Indices) != len(txOutputs.Outputs) {
+			return nil, fmt.Errorf("the outputs of %x do not match their indices", entry.TxId)
+		}
+		order := make([]int, len(txOutputs.Outputs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool {
+			return txOutputs.OutputIdx(order[a]) < txOutputs.OutputIdx(order[b])
+		})
+
+		for _, i := range order {
+			txOutput := txOutputs.Outputs[i]
+			hasher.Write(utils.Int2Hex(int64(len(entry.TxId))))
+			hasher.Write(entry.TxId)
+			hasher.Write(utils.Int2Hex(int64(txOutputs.OutputIdx(i))))
+			hasher.Write(utils.Int2Hex(int64(math.Float64bits(txOutput.Value))))
+			hasher.Write(utils.Int2Hex(int64(len(txOutput.PubKeyHash))))
+			hasher.Write(txOutput.PubKeyHash)
+		}
+	}
+	return hasher.Sum(nil), nil
+}
+
+// Commitment returns the hashing of the UTXO set, which equals the Commitment of a snapshot exported from it.
+func (utxoSet UTXOSet) Commitment() ([]byte, error) {
+	entries, err := utxoSet.snapshotEntries()
+	if err != nil {
+		return nil, err
+	}
+	return commitmentOf(entries)
+}
+
+// ExportSnapshot writes the UTXO set, the tip hash and height of the chain, and the commitment to the file path.
+func (utxoSet UTXOSet) ExportSnapshot(path string) error {
+	entries, err := utxoSet.snapshotEntries()
+	if err != nil {
+		return err
+	}
+	commitment, err := commitmentOf(entries)
+	if err != nil {
+		return err
+	}
+	snapshot := UTXOSnapshot{
+		TipHash:    utxoSet.BlockChain.TipHash(),
+		Height:     utxoSet.BlockChain.GetChainHeight(),
+		Entries:    entries,
+		Commitment: commitment,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, utils.GobEncode(snapshot), 0644)
+}
+
+// ReadSnapshot reads the snapshot from the file path. ErrSnapshotCommitment is returned if its content does not match
+// its commitment.
+func ReadSnapshot(path string) (*UTXOSnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot UTXOSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	commitment, err := commitmentOf(snapshot.Entries)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotCommitment, err)
+	}
+	if !bytes.Equal(commitment, snapshot.Commitment) {
+		return nil, ErrSnapshotCommitment
+	}
+	return &snapshot, nil
+}
+
+// ImportSnapshot replaces the UTXO set in the db file of the node with nodeId with the snapshot read from the file
+// path, and returns the snapshot. The caller should check snapshot.Commitment against a trusted checkpoint. The blocks
+// of the chain of the node are needed up to the snapshot at least, i.e., the headers and bodies are downloaded (or
+// imported, see ImportChain) first, since the set is only meaningful along them. An error wrapping ErrSnapshotTip is
+// returned if the snapshot is not taken at a block of the main chain of the node. The db file is removed on error if
+// it is created by ImportSnapshot.
+func ImportSnapshot(path, nodeId string) (*UTXOSnapshot, error) {
+	dbFile := getDbFile(nodeId)
+	existed, _ := utils.FileExists(dbFile)
+	if err := os.MkdirAll(filepath.Dir(dbFile), 0755); err != nil {
+		return nil, err
+	}
+	store, err := OpenBoltStore(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := ImportSnapshotWithStore(path, store)
+	_ = store.Close()
+	if err != nil && !existed {
+		_ = os.Remove(dbFile)
+	}
+	return snapshot, err
+}
+
+// ImportSnapshotWithStore works like ImportSnapshot, but the UTXO set in store is replaced. The snapshot may be taken
+// at a block below the tip of the main chain in store, in which case the set is rolled forward to the tip with the
+// blocks after the snapshot.
+func ImportSnapshotWithStore(path string, store Store) (*UTXOSnapshot, error) {
+	snapshot, err := ReadSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the whole set is replaced in a single db transaction, thus it is never left partially written
+	err = store.Update(
+		func(tx StoreTx) error {
+			blocks, heights := tx.Bucket([]byte(blocksBucket)), tx.Bucket([]byte(heightIndexBucket))
+			if blocks == nil || heights == nil {
+				return fmt.Errorf("%w: no chain is found, download or import the chain up to the snapshot first",
+					ErrSnapshotTip)
+			}
+			if !bytes.Equal(heights.Get(utils.Int2Hex(int64(snapshot.Height))), snapshot.TipHash) {
+				return fmt.Errorf("%w: the snapshot is at %x (height %d), which is not on the local main chain",
+					ErrSnapshotTip, snapshot.TipHash, snapshot.Height)
+			}
+			if err := tx.DeleteBucket([]byte(utxoBucket)); err != nil && err != ErrBucketNotFound {
+				return err
+			}
+			bucket, err := tx.CreateBucket([]byte(utxoBucket))
+			if err != nil {
+				return err
+			}
+			for _, entry := range snapshot.Entries {
+				if err := bucket.Put(entry.TxId, entry.Outputs); err != nil {
+					return fmt.Errorf("failed to import the outputs of %x: %v", entry.TxId, err)
+				}
+			}
+
+			// roll the set forward with the blocks after the snapshot
+			localTip := blocks.Get([]byte("l"))
+			tipBlock := DeserializeBlock(blocks.Get(localTip))
+			for height := snapshot.Height + 1; height <= tipBlock.Height; height++ {
+				encodedBlock := blocks.Get(heights.Get(utils.Int2Hex(int64(height))))
+				if encodedBlock == nil {
+					return fmt.Errorf("%w: no block is indexed at height %d", ErrBlockNotFound, height)
+				}
+				applyBlock(bucket, DeserializeBlock(encodedBlock))
+			}
+			if err := putTipHash(tx, localTip); err != nil {
+				return err
+			}
+			if meta := tx.Bucket([]byte(utxoMetaBucket)); meta != nil {
+				return meta.Delete([]byte(utxoDirtyKey))
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
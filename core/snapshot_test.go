@@ -0,0 +1,179 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`io/ioutil`
+	`lightChain/utils`
+	`path/filepath`
+	`testing`
+)
+
+func TestUTXOSnapshot(t *testing.T) {
+	dataDir := useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	receiver := NewWallet()
+	for i := 0; i < 3; i++ {
+		mineTxs(chain, string(receiver.GetAddr()))
+	}
+
+	path := filepath.Join(dataDir, "snapshots", "utxo.snapshot")
+	assert.Nil(t, utxoSet.ExportSnapshot(path))
+	commitment, err := utxoSet.Commitment()
+	assert.Nil(t, err)
+
+	// import into another store holding the same chain
+	_, err = ImportSnapshotWithStore(path, NewMemStore())
+	assert.True(t, errors.Is(err, ErrSnapshotTip), "The snapshot is not imported without the chain")
+	store := copyChain(t, chain)
+	snapshot, err := ImportSnapshotWithStore(path, store)
+	assert.Nil(t, err)
+	assert.Equal(t, chain.Tip, snapshot.TipHash)
+	assert.Equal(t, 3, snapshot.Height)
+	assert.Equal(t, commitment, snapshot.Commitment, "The snapshot is verifiable against the checkpoint")
+
+	imported := UTXOSet{BlockChain: &BlockChain{Db: store}}
+	importedCommitment, err := imported.Commitment()
+	assert.Nil(t, err)
+	assert.Equal(t, commitment, importedCommitment, "The imported set is the same")
+	for _, w := range []*Wallet{wallet, receiver} {
+		pubKeyHash := HashingPubKey(w.PubKey)
+		assert.Equal(t, utxoSet.Balance(pubKeyHash), imported.Balance(pubKeyHash))
+	}
+	numTxs, numOutputs, totalValue := utxoSet.Stats()
+	importedNumTxs, importedNumOutputs, importedTotalValue := imported.Stats()
+	assert.Equal(t, []interface{}{numTxs, numOutputs, totalValue},
+		[]interface{}{importedNumTxs, importedNumOutputs, importedTotalValue})
+
+	// import into the db file of another node, which is not created without the chain
+	_, err = ImportSnapshot(path, "2")
+	assert.True(t, errors.Is(err, ErrSnapshotTip))
+	existed, _ := utils.FileExists(getDbFile("2"))
+	assert.False(t, existed, "No empty db file is left")
+	chainPath := filepath.Join(dataDir, "chain.export")
+	_, err = chain.ExportToFile(chainPath)
+	assert.Nil(t, err)
+	copied, err := ImportChain(chainPath, "2")
+	assert.Nil(t, err)
+	_ = copied.Db.Close()
+	snapshot, err = ImportSnapshot(path, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, commitment, snapshot.Commitment)
+
+	// the snapshot below the tip is rolled forward to the tip
+	for i := 0; i < 2; i++ {
+		height := chain.GetChainHeight() + 1
+		tx, err := NewUTXOTx(wallet, string(receiver.GetAddr()), 10, &utxoSet)
+		assert.Nil(t, err)
+		coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+		utxoSet.Update(chain.MineBlock([]*Transaction{coinbaseTx, tx}))
+	}
+	store = copyChain(t, chain)
+	_, err = ImportSnapshotWithStore(path, store)
+	assert.Nil(t, err, "The snapshot of an old block on the main chain is imported")
+	imported = UTXOSet{BlockChain: &BlockChain{Db: store}}
+	importedCommitment, err = imported.Commitment()
+	assert.Nil(t, err)
+	tipCommitment, err := utxoSet.Commitment()
+	assert.Nil(t, err)
+	assert.Equal(t, tipCommitment, importedCommitment, "The imported set is rolled forward to the tip")
+	assert.Equal(t, chain.Tip, imported.TipHash())
+
+	// the snapshot of another chain is rejected
+	otherChain, err := CreateBlockChainWithStore(NewMemStore(), string(wallet.GetAddr()), DefaultGenesisConfig())
+	assert.Nil(t, err)
+	_, err = ImportSnapshotWithStore(path, otherChain.Db)
+	assert.True(t, errors.Is(err, ErrSnapshotTip), "The snapshot off the main chain is rejected")
+}
+
+func TestUTXOSnapshotTampered(t *testing.T) {
+	dataDir := useTempDataDir(t)
+
+	chain, _ := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	path := filepath.Join(dataDir, "utxo.snapshot")
+	assert.Nil(t, utxoSet.ExportSnapshot(path))
+
+	snapshot, err := ReadSnapshot(path)
+	assert.Nil(t, err)
+	snapshot.Entries[0].Outputs = TxOutputs{Outputs: []TxOutput{{Value: 1e9}}}.SerializeOutputs()
+	assert.Nil(t, ioutil.WriteFile(path, utils.GobEncode(snapshot), 0644))
+
+	store := NewMemStore()
+	_, err = ImportSnapshotWithStore(path, store)
+	assert.Equal(t, ErrSnapshotCommitment, err, "The tampered snapshot is rejected")
+	assert.Nil(t, store.View(func(tx StoreTx) error {
+		assert.Nil(t, tx.Bucket([]byte(utxoBucket)), "Nothing is imported")
+		return nil
+	}))
+}
+
+func TestUTXOCommitment(t *testing.T) {
+	chain, wallet := newMemChain(t, DefaultGenesisConfig())
+	utxoSet := UTXOSet{BlockChain: chain}
+	commitment, err := utxoSet.Commitment()
+	assert.Nil(t, err)
+
+	utxoSet.Rebuild()
+	rebuiltCommitment, err := utxoSet.Commitment()
+	assert.Nil(t, err)
+	assert.Equal(t, commitment, rebuiltCommitment, "The commitment is stable across rebuilds")
+
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+	spentCommitment, err := utxoSet.Commitment()
+	assert.Nil(t, err)
+	assert.NotEqual(t, commitment, spentCommitment, "The commitment changes after a spend")
+
+	utxoSet.Rebuild()
+	rebuiltCommitment, err = utxoSet.Commitment()
+	assert.Nil(t, err)
+	assert.Equal(t, spentCommitment, rebuiltCommitment, "The updated set commits the same as the rebuilt one")
+}
+
+func TestUTXOCommitmentCanonical(t *testing.T) {
+	outputs := []TxOutput{*NewTxOutput(10, string(NewWallet().GetAddr())), *NewTxOutput(5, string(NewWallet().GetAddr()))}
+	txId := []byte("tx")
+	indexed := TxOutputs{}
+	indexed.add(outputs[0], 0)
+	indexed.add(outputs[1], 1)
+
+	// the outputs written before the indices exist
+	legacy, err := commitmentOf([]SnapshotEntry{{txId, TxOutputs{Outputs: outputs}.SerializeOutputs()}})
+	assert.Nil(t, err)
+	current, err := commitmentOf([]SnapshotEntry{{txId, indexed.SerializeOutputs()}})
+	assert.Nil(t, err)
+	assert.Equal(t, legacy, current, "The same outputs commit the same whichever encoding stores them")
+
+	spent := TxOutputs{}
+	spent.add(outputs[1], 1)
+	partial, err := commitmentOf([]SnapshotEntry{{txId, spent.SerializeOutputs()}})
+	assert.Nil(t, err)
+	shifted, err := commitmentOf([]SnapshotEntry{{txId, TxOutputs{Outputs: outputs[1:]}.SerializeOutputs()}})
+	assert.Nil(t, err)
+	assert.NotEqual(t, partial, shifted, "The index of each output is committed")
+
+	_, err = commitmentOf([]SnapshotEntry{{txId, []byte("garbage")}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,378 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the Store interface behind BlockChain, with two implementations provided: the boltdb based
+// one (used by the nodes) and the in-memory one (used for embedding and testing).
+
+package core
+
+import (
+	`errors`
+	`github.com/boltdb/bolt`
+	`os`
+	`sort`
+	`sync`
+	`time`
+)
+
+var (
+	ErrBucketNotFound = errors.New("bucket not found")
+	ErrBucketExists   = errors.New("bucket already exists")
+	ErrTxNotWritable  = errors.New("store transaction is not writable")
+	ErrStoreLocked    = errors.New("db file is locked by another process")
+	ErrStoreReadOnly  = errors.New("store is read-only")
+)
+
+// Store is a key-value storage where the data are organized in buckets. All the reads and writes happen in
+// a StoreTx: View opens a read-only one, Update opens a read-write one, which is committed if fn returns nil
+// and rolled back otherwise.
+type Store interface {
+	View(fn func(tx StoreTx) error) error
+	Update(fn func(tx StoreTx) error) error
+	Close() error
+}
+
+// StoreTx is a transaction on Store.
+type StoreTx interface {
+	Bucket(name []byte) StoreBucket // returns nil if the bucket does not exist
+	CreateBucket(name []byte) (StoreBucket, error)
+	CreateBucketIfNotExists(name []byte) (StoreBucket, error)
+	DeleteBucket(name []byte) error
+}
+
+// StoreBucket is a collection of key-value pairs. The returned value of Get is only valid during the StoreTx.
+type StoreBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Cursor() StoreCursor
+}
+
+// StoreCursor iterates over the key-value pairs of a StoreBucket in the byte-sorted order of keys. A nil key
+// means the iteration is finished. Seek moves to the first key not less than seek.
+type StoreCursor interface {
+	First() ([]byte, []byte)
+	Next() ([]byte, []byte)
+	Seek(seek []byte) ([]byte, []byte)
+}
+
+/* The following implements Store with boltdb. */
+
+// boltStore is the Store backed by a boltdb file.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens (creates if not exists) the boltdb file dbFile and returns it as a Store.
+func OpenBoltStore(dbFile string) (Store, error) {
+	return OpenBoltStoreWithTimeout(dbFile, 0)
+}
+
+// OpenBoltStoreWithTimeout works like OpenBoltStore, but gives up with ErrStoreLocked if the file lock of dbFile
+// cannot be obtained within timeout. boltdb locks the file exclusively, thus the file is held by at most one Store at a
+// time. A zero timeout waits forever.
+func OpenBoltStoreWithTimeout(dbFile string, timeout time.Duration) (Store, error) {
+	db, err := openBolt(dbFile, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db}, nil
+}
+
+// OpenBoltStoreReadOnly opens the existing boltdb file dbFile read-only. Unlike the read-write one, the file lock is
+// shared, thus any number of read-only Stores can open dbFile at the same time. Still, it cannot be obtained while a
+// read-write Store (e.g., of a running node) holds dbFile, and ErrStoreLocked is returned after timeout. A zero timeout
+// waits forever. The Update of the returned Store always fails with ErrStoreReadOnly.
+func OpenBoltStoreReadOnly(dbFile string, timeout time.Duration) (Store, error) {
+	db, err := openBolt(dbFile, &bolt.Options{Timeout: timeout, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db}, nil
+}
+
+// openBolt opens the boltdb file dbFile with options, and returns ErrStoreLocked if its lock cannot be obtained within
+// the timeout of options. The file opened may also be replaced while waiting for its lock (see CompactBoltFile), in
+// which case the replaced one would be held, thus ErrStoreLocked is returned as well.
+func openBolt(dbFile string, options *bolt.Options) (*bolt.DB, error) {
+	before, statErr := os.Stat(dbFile)
+	db, err := bolt.Open(dbFile, 0644, options)
+	if err == bolt.ErrTimeout {
+		return nil, ErrStoreLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the file created by bolt.Open did not exist before, thus it has not been replaced
+	if after, err := os.Stat(dbFile); statErr == nil && (err != nil || !os.SameFile(before, after)) {
+		_ = db.Close()
+		return nil, ErrStoreLocked
+	}
+	return db, nil
+}
+
+func (store *boltStore) View(fn func(tx StoreTx) error) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (store *boltStore) Update(fn func(tx StoreTx) error) error {
+	return boltErr(store.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	}))
+}
+
+func (store *boltStore) Close() error {
+	return store.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (tx boltTx) Bucket(name []byte) StoreBucket {
+	bucket := tx.tx.Bucket(name)
+	if bucket == nil {
+		return nil
+	}
+	return boltBucket{bucket}
+}
+
+func (tx boltTx) CreateBucket(name []byte) (StoreBucket, error) {
+	bucket, err := tx.tx.CreateBucket(name)
+	if err != nil {
+		return nil, boltErr(err)
+	}
+	return boltBucket{bucket}, nil
+}
+
+func (tx boltTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	bucket, err := tx.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, boltErr(err)
+	}
+	return boltBucket{bucket}, nil
+}
+
+func (tx boltTx) DeleteBucket(name []byte) error {
+	return boltErr(tx.tx.DeleteBucket(name))
+}
+
+type boltBucket struct {
+	bucket *bolt.Bucket
+}
+
+func (bucket boltBucket) Get(key []byte) []byte {
+	return bucket.bucket.Get(key)
+}
+
+func (bucket boltBucket) Put(key, value []byte) error {
+	return boltErr(bucket.bucket.Put(key, value))
+}
+
+func (bucket boltBucket) Delete(key []byte) error {
+	return boltErr(bucket.bucket.Delete(key))
+}
+
+func (bucket boltBucket) Cursor() StoreCursor {
+	return bucket.bucket.Cursor()
+}
+
+// boltErr translates the errors of boltdb into the errors of Store.
+func boltErr(err error) error {
+	switch err {
+	case bolt.ErrBucketNotFound:
+		return ErrBucketNotFound
+	case bolt.ErrBucketExists:
+		return ErrBucketExists
+	case bolt.ErrTxNotWritable:
+		return ErrTxNotWritable
+	case bolt.ErrDatabaseReadOnly:
+		return ErrStoreReadOnly
+	}
+	return err
+}
+
+/* The following implements Store with maps in memory. */
+
+// memStore is the Store backed by maps in memory. It is a copy-on-write store: an Update works on a copy of the
+// touched buckets, and the copy replaces the original only when the Update succeeds.
+type memStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemStore returns an empty in-memory Store.
+func NewMemStore() Store {
+	return &memStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (store *memStore) View(fn func(tx StoreTx) error) error {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return fn(&memTx{buckets: store.buckets})
+}
+
+func (store *memStore) Update(fn func(tx StoreTx) error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	tx := &memTx{buckets: make(map[string]map[string][]byte), copied: make(map[string]bool), writable: true}
+	for name, bucket := range store.buckets {
+		tx.buckets[name] = bucket
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	store.buckets = tx.buckets
+	return nil
+}
+
+func (store *memStore) Close() error {
+	return nil
+}
+
+type memTx struct {
+	buckets  map[string]map[string][]byte
+	copied   map[string]bool // the buckets which have been copied for writing in this tx
+	writable bool
+}
+
+func (tx *memTx) Bucket(name []byte) StoreBucket {
+	if _, ok := tx.buckets[string(name)]; !ok {
+		return nil
+	}
+	return &memBucket{tx, string(name)}
+}
+
+func (tx *memTx) CreateBucket(name []byte) (StoreBucket, error) {
+	if !tx.writable {
+		return nil, ErrTxNotWritable
+	}
+	if _, ok := tx.buckets[string(name)]; ok {
+		return nil, ErrBucketExists
+	}
+	tx.buckets[string(name)] = make(map[string][]byte)
+	tx.copied[string(name)] = true
+	return &memBucket{tx, string(name)}, nil
+}
+
+func (tx *memTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	if bucket := tx.Bucket(name); bucket != nil {
+		return bucket, nil
+	}
+	return tx.CreateBucket(name)
+}
+
+func (tx *memTx) DeleteBucket(name []byte) error {
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+	if _, ok := tx.buckets[string(name)]; !ok {
+		return ErrBucketNotFound
+	}
+	delete(tx.buckets, string(name))
+	return nil
+}
+
+// writableBucket returns the bucket named name for writing, copying it on the first write in tx.
+func (tx *memTx) writableBucket(name string) (map[string][]byte, error) {
+	if !tx.writable {
+		return nil, ErrTxNotWritable
+	}
+	bucket, ok := tx.buckets[name]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+	if !tx.copied[name] {
+		copiedBucket := make(map[string][]byte, len(bucket))
+		for key, value := range bucket {
+			copiedBucket[key] = value
+		}
+		tx.buckets[name] = copiedBucket
+		tx.copied[name] = true
+		bucket = copiedBucket
+	}
+	return bucket, nil
+}
+
+type memBucket struct {
+	tx   *memTx
+	name string
+}
+
+func (bucket *memBucket) Get(key []byte) []byte {
+	return bucket.tx.buckets[bucket.name][string(key)]
+}
+
+func (bucket *memBucket) Put(key, value []byte) error {
+	data, err := bucket.tx.writableBucket(bucket.name)
+	if err != nil {
+		return err
+	}
+	data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (bucket *memBucket) Delete(key []byte) error {
+	data, err := bucket.tx.writableBucket(bucket.name)
+	if err != nil {
+		return err
+	}
+	delete(data, string(key))
+	return nil
+}
+
+func (bucket *memBucket) Cursor() StoreCursor {
+	var keys []string
+	for key := range bucket.tx.buckets[bucket.name] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return &memCursor{bucket, keys, 0}
+}
+
+// memCursor iterates over the keys of a memBucket snapshotted when the cursor is created.
+type memCursor struct {
+	bucket *memBucket
+	keys   []string
+	idx    int
+}
+
+func (cursor *memCursor) First() ([]byte, []byte) {
+	cursor.idx = 0
+	return cursor.current()
+}
+
+func (cursor *memCursor) Next() ([]byte, []byte) {
+	cursor.idx++
+	return cursor.current()
+}
+
+func (cursor *memCursor) Seek(seek []byte) ([]byte, []byte) {
+	cursor.idx = sort.SearchStrings(cursor.keys, string(seek))
+	return cursor.current()
+}
+
+func (cursor *memCursor) current() ([]byte, []byte) {
+	if cursor.idx >= len(cursor.keys) {
+		return nil, nil
+	}
+	key := cursor.keys[cursor.idx]
+	return []byte(key), cursor.bucket.Get([]byte(key))
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`path/filepath`
+	`testing`
+)
+
+// storeResult collects the observable results of the chain operations in runChainOps.
+type storeResult struct {
+	blocksNum, height, utxoTxs int
+	supply, balance            float64
+	reopenedTip                []byte
+	tip                        []byte
+}
+
+// runChainOps creates a chain in store, transfers coins in several blocks, and collects the results. The coinbase
+// rewards go to miner, thus sender always has exactly one unspent output and the coin selection is deterministic.
+func runChainOps(t *testing.T, store Store, sender, receiver, miner *Wallet) storeResult {
+	config := DefaultGenesisConfig()
+	config.GenesisMsg = "same genesis"
+	chain, _ := CreateBlockChainWithStore(store, string(sender.GetAddr()), config)
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	for i := 0; i < 3; i++ {
+		tx, err := NewUTXOTx(sender, string(receiver.GetAddr()), 10*float64(i+1), &utxoSet)
+		assert.Nil(t, err)
+		mineTxs(chain, string(miner.GetAddr()), tx)
+	}
+
+	balance := 0.0
+	for _, txOutput := range utxoSet.FindUTXO(HashingPubKey(receiver.PubKey)) {
+		balance += txOutput.Value
+	}
+
+	reopened, err := NewBlockChainWithStore(store)
+	assert.Nil(t, err)
+
+	return storeResult{
+		blocksNum:   chain.GetBlocksNum(),
+		height:      chain.GetChainHeight(),
+		utxoTxs:     utxoSet.CountTxs(),
+		supply:      chain.TotalSupply(),
+		balance:     balance,
+		reopenedTip: reopened.Tip,
+		tip:         chain.Tip,
+	}
+}
+
+func TestStoreBackends(t *testing.T) {
+	sender, receiver, miner := NewWallet(), NewWallet(), NewWallet()
+
+	diskStore, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	assert.Nil(t, err)
+	defer func() {
+		_ = diskStore.Close()
+	}()
+	memoryStore := NewMemStore()
+
+	boltResult := runChainOps(t, diskStore, sender, receiver, miner)
+	memResult := runChainOps(t, memoryStore, sender, receiver, miner)
+
+	assert.Equal(t, boltResult.tip, boltResult.reopenedTip, "Reopened bolt chain has the same tip")
+	assert.Equal(t, memResult.tip, memResult.reopenedTip, "Reopened in-memory chain has the same tip")
+	// the block hashes depend on the mining time, thus are not compared
+	boltResult.tip, boltResult.reopenedTip, memResult.tip, memResult.reopenedTip = nil, nil, nil, nil
+	assert.Equal(t, boltResult, memResult, "Both backends give identical results")
+	assert.Equal(t, 4, memResult.blocksNum)
+	assert.Equal(t, 60.0, memResult.balance)
+}
+
+func TestMemStoreRollback(t *testing.T) {
+	store := NewMemStore()
+	err := store.Update(func(tx StoreTx) error {
+		bucket, err := tx.CreateBucket([]byte("bucket"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("key"), []byte("value"))
+	})
+	assert.Nil(t, err)
+
+	// a failed update leaves nothing behind
+	err = store.Update(func(tx StoreTx) error {
+		_ = tx.Bucket([]byte("bucket")).Put([]byte("key"), []byte("changed"))
+		_, _ = tx.CreateBucket([]byte("another"))
+		return errors.New("failed")
+	})
+	assert.NotNil(t, err)
+
+	_ = store.View(func(tx StoreTx) error {
+		assert.Equal(t, []byte("value"), tx.Bucket([]byte("bucket")).Get([]byte("key")))
+		assert.Nil(t, tx.Bucket([]byte("another")))
+		assert.Equal(t, ErrTxNotWritable, tx.Bucket([]byte("bucket")).Put([]byte("key"), nil))
+		return nil
+	})
+}
+
+func TestStoreCursorSeek(t *testing.T) {
+	diskStore, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	assert.Nil(t, err)
+	defer func() {
+		_ = diskStore.Close()
+	}()
+
+	for _, store := range []Store{diskStore, NewMemStore()} {
+		err := store.Update(func(tx StoreTx) error {
+			bucket, err := tx.CreateBucket([]byte("bucket"))
+			if err != nil {
+				return err
+			}
+			for _, key := range []string{"c", "b2", "a", "b1"} {
+				if err := bucket.Put([]byte(key), []byte("value of "+key)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+
+		_ = store.View(func(tx StoreTx) error {
+			cursor := tx.Bucket([]byte("bucket")).Cursor()
+			key, value := cursor.Seek([]byte("b"))
+			assert.Equal(t, []byte("b1"), key, "Seek moves to the first key not less than seek")
+			assert.Equal(t, []byte("value of b1"), value)
+			key, _ = cursor.Next()
+			assert.Equal(t, []byte("b2"), key)
+			key, _ = cursor.Seek([]byte("c"))
+			assert.Equal(t, []byte("c"), key, "The key equal to seek is included")
+			key, _ = cursor.Seek([]byte("d"))
+			assert.Nil(t, key, "No key is left after seek")
+			return nil
+		})
+	}
+}
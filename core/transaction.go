@@ -22,32 +22,72 @@ import (
 	`crypto/elliptic`
 	`crypto/rand`
 	`crypto/sha256`
+	`encoding/binary`
 	`encoding/gob`
 	`encoding/hex`
+	`errors`
 	`fmt`
+	`golang.org/x/crypto/ripemd160`
+	`io`
 	`lightChain/utils`
 	`log`
+	`math`
 	`math/big`
 	`strings`
 )
 
-// Transaction consists of its Id, a collection of TxInput, and a collection of output TxOutput.
+// Transaction consists of its Id, its Version, a collection of TxInput, a collection of output TxOutput, an
+// optional Nonce of the sender (see CheckNonce), an optional LockTime (see IsFinal), and whether it is Replaceable.
 type Transaction struct {
-	Id   []byte
-	Vin  []TxInput
-	Vout []TxOutput
+	Id          []byte
+	Version     int
+	Vin         []TxInput
+	Vout        []TxOutput
+	Nonce       uint64
+	LockTime    int64 // a Unix timestamp, tx can only be packed once the median time past is not earlier than it
+	Replaceable bool  // tx opts into being replaced in the pools by a conflicting tx paying a higher fee
+
+	serialized []byte // the cached result of serialize, see Size
+}
+
+// TxVersion is the version set in newly created transactions, and also the highest version this node understands.
+// Consensus changes on transactions are gated by bumping it.
+var TxVersion = 1
+
+// SignalReplaceable decides whether the transactions created by this node, e.g., with NewUTXOTx and NewSplitTx, are
+// Replaceable. It is off in default, thus the receivers can rely on the pooled transactions.
+var SignalReplaceable = false
+
+// CheckVersion returns an error if the version of tx is not understood by this node.
+func (tx *Transaction) CheckVersion() error {
+	if tx.Version < 1 || tx.Version > TxVersion {
+		return fmt.Errorf("transaction version %d is unknown", tx.Version)
+	}
+	return nil
 }
 
 // String formalizes the output style of a Transaction.
 func (tx Transaction) String() string {
 	var outStr []string
 	outStr = append(outStr, fmt.Sprintf("TxId: %x", tx.Id))
+	outStr = append(outStr, fmt.Sprintf("Version: %d", tx.Version))
+	outStr = append(outStr, fmt.Sprintf("Nonce: %d", tx.Nonce))
+	outStr = append(outStr, fmt.Sprintf("LockTime: %d", tx.LockTime))
+	// the flag is only printed if it is set, thus the signatures made before it was introduced are still valid
+	if tx.Replaceable {
+		outStr = append(outStr, "Replaceable: true")
+	}
 	for txInputIdx, txInput := range tx.Vin {
 		outStr = append(outStr, fmt.Sprintf("----input #%d", txInputIdx))
 		outStr = append(outStr, fmt.Sprintf("--------TxId: %x", txInput.TxId))
 		outStr = append(outStr, fmt.Sprintf("--------OutIdx: %x", txInput.VoutIdx))
 		outStr = append(outStr, fmt.Sprintf("--------Signature: %x", txInput.Signature))
 		outStr = append(outStr, fmt.Sprintf("--------PubKey: %x", txInput.PubKey))
+		// the format is only printed if it is not the default, thus the signatures made before it was introduced
+		// are still valid
+		if txInput.KeyFormat != UncompressedKey {
+			outStr = append(outStr, fmt.Sprintf("--------KeyFormat: %d", txInput.KeyFormat))
+		}
 	}
 	for txOutputIdx, txOutput := range tx.Vout {
 		outStr = append(outStr, fmt.Sprintf("----output #%d", txOutputIdx))
@@ -57,18 +97,84 @@ func (tx Transaction) String() string {
 	return strings.Join(outStr, "\n")
 }
 
+// MaxTxInputs and MaxTxOutputs cap the number of inputs and outputs a Transaction may have. A transaction with
+// enormous inputs forces the verifier to do a huge number of chain scans, which enables a cheap DoS.
+var (
+	MaxTxInputs  = 1000
+	MaxTxOutputs = 1000
+)
+
+// CheckLimits returns an error if the number of inputs or outputs of tx exceeds MaxTxInputs or MaxTxOutputs.
+func (tx *Transaction) CheckLimits() error {
+	if len(tx.Vin) > MaxTxInputs {
+		return fmt.Errorf("transaction has %d inputs, exceeding the limit %d", len(tx.Vin), MaxTxInputs)
+	}
+	if len(tx.Vout) > MaxTxOutputs {
+		return fmt.Errorf("transaction has %d outputs, exceeding the limit %d", len(tx.Vout), MaxTxOutputs)
+	}
+	return nil
+}
+
+// IsFinal reports whether tx can be packed into a block with timestamp, i.e., the LockTime of tx is not in the future
+// relative to timestamp. A zero LockTime never locks.
+func (tx *Transaction) IsFinal(timestamp int64) bool {
+	return tx.LockTime <= timestamp
+}
+
 /* The following defines the data structure of TxInput and operations on it. */
 
 // TxInput includes all information required for the input of a Transaction: TxId, VoutIdx, Signature, and PubKey.
 // Wherein, TxId is the Id of some previous Transaction, one output of which is pointed by current Transaction's some input.
 // VoutIdx is the index of the pointed output of the previous Transaction.
 // Signature is the data bytes signed with sender's private key.
-// PubKey is the public key of sender.
+// PubKey is the public key of sender, in the format KeyFormat.
 type TxInput struct {
 	TxId      []byte
 	VoutIdx   int
 	Signature []byte
 	PubKey    []byte
+	KeyFormat KeyFormat
+}
+
+// KeyFormat is the format of the public key bytes.
+type KeyFormat byte
+
+const (
+	// UncompressedKey is X || Y, where X and Y are padded to the same length (the default).
+	UncompressedKey KeyFormat = iota
+	// CompressedKey is 0x02 (or 0x03 if Y is odd) || X, which is about half the size of UncompressedKey.
+	CompressedKey
+)
+
+// keyFormatOf detects the format of the public key bytes pubKey of a P-256 key.
+func keyFormatOf(pubKey []byte) KeyFormat {
+	keyLen := (elliptic.P256().Params().BitSize + 7) / 8
+	if len(pubKey) == 1+keyLen && (pubKey[0] == 2 || pubKey[0] == 3) {
+		return CompressedKey
+	}
+	return UncompressedKey
+}
+
+// newTxInput returns a TxInput spending the output VoutIdx of the transaction txId with pubKey, whose KeyFormat is
+// detected from pubKey. The input is not signed.
+func newTxInput(txId []byte, voutIdx int, pubKey []byte) TxInput {
+	return TxInput{TxId: txId, VoutIdx: voutIdx, PubKey: pubKey, KeyFormat: keyFormatOf(pubKey)}
+}
+
+// parsePubKey returns the point of the public key bytes pubKey on curve in format. ok is false if pubKey is not
+// a valid compressed key while format is CompressedKey, or format is unknown.
+func parsePubKey(curve elliptic.Curve, pubKey []byte, format KeyFormat) (x, y *big.Int, ok bool) {
+	switch format {
+	case UncompressedKey:
+		keyLength := len(pubKey)
+		x, y = new(big.Int).SetBytes(pubKey[:(keyLength/2)]), new(big.Int).SetBytes(pubKey[(keyLength/2):])
+		return x, y, true
+	case CompressedKey:
+		x, y = elliptic.UnmarshalCompressed(curve, pubKey)
+		return x, y, x != nil
+	default:
+		return nil, nil, false
+	}
 }
 
 /* The following defines the data structure of TxOutput and operations on it. */
@@ -81,10 +187,12 @@ type TxOutput struct {
 	PubKeyHash []byte
 }
 
-// Lock signs txOutput with the receiver's address addr.
+// Lock signs txOutput with the receiver's address addr, which is in either format (see AddrFormat).
 func (txOutput *TxOutput) Lock(addr string) {
-	fullPayload := utils.Base58Decoding([]byte(addr))
-	pubKeyHash := fullPayload[1 : len(fullPayload)-4]
+	pubKeyHash, err := AddrPubKeyHash(addr)
+	if err != nil {
+		log.Panic(err)
+	}
 	txOutput.PubKeyHash = pubKeyHash
 }
 
@@ -101,9 +209,47 @@ func NewTxOutput(value float64, addr string) *TxOutput {
 	return txOutput
 }
 
-// TxOutputs is a collection of TxOutput.
+// NewTxOutputFromHash is like NewTxOutput, but the output is locked to the receiver's pubKeyHash directly, thus the
+// address is not needed. An error wrapping ErrInvalidAddress is returned if pubKeyHash is not a ripemd160 hash.
+func NewTxOutputFromHash(value float64, pubKeyHash []byte) (*TxOutput, error) {
+	if len(pubKeyHash) != ripemd160.Size {
+		return nil, fmt.Errorf("%w: pubKeyHash has %d bytes, not %d", ErrInvalidAddress, len(pubKeyHash),
+			ripemd160.Size)
+	}
+	return &TxOutput{value, append([]byte{}, pubKeyHash...)}, nil
+}
+
+// TxOutputs is a collection of TxOutput, e.g., the unspent outputs of a transaction in the UTXO set. Indices[i] is the
+// index of Outputs[i] in the outputs of its transaction, which is kept since the spent outputs are removed. A nil
+// Indices means Outputs are all the outputs of the transaction in order (the UTXO set written before Indices exists,
+// which is rebuilt when the chain is opened, see NewBlockChainWithStore).
 type TxOutputs struct {
 	Outputs []TxOutput
+	Indices []int
+}
+
+// OutputIdx returns the index of txOutputs.Outputs[i] in the outputs of its transaction.
+func (txOutputs TxOutputs) OutputIdx(i int) int {
+	if txOutputs.Indices == nil {
+		return i
+	}
+	return txOutputs.Indices[i]
+}
+
+// Find returns the output whose index in the outputs of its transaction is voutIdx, and whether it is in txOutputs.
+func (txOutputs TxOutputs) Find(voutIdx int) (TxOutput, bool) {
+	for i, txOutput := range txOutputs.Outputs {
+		if txOutputs.OutputIdx(i) == voutIdx {
+			return txOutput, true
+		}
+	}
+	return TxOutput{}, false
+}
+
+// add appends txOutput, whose index in the outputs of its transaction is voutIdx, to txOutputs.
+func (txOutputs *TxOutputs) add(txOutput TxOutput, voutIdx int) {
+	txOutputs.Outputs = append(txOutputs.Outputs, txOutput)
+	txOutputs.Indices = append(txOutputs.Indices, voutIdx)
 }
 
 // SerializeOutputs returns encoded bytes for the input txOutputs.
@@ -126,46 +272,299 @@ func DeserializeOutputs(encodedData []byte) TxOutputs {
 
 /* The following defines the operations on Transaction. */
 
+// valueTolerance tolerates the rounding error of float64 when comparing the coin values.
+const valueTolerance = 1e-9
+
 // NewCoinbaseTx returns a pointer to a newly created coinbase transaction. dstAddr is the address of wallet who does
-// this creation (also the address to accept reward).
-func NewCoinbaseTx(dstAddr, data string, curCoinbaseReward float64) *Transaction {
+// this creation (also the address to accept reward). height is the height of the block which packs this coinbase. If
+// data is empty, it is filled with random bytes from crypto/rand.
+func NewCoinbaseTx(dstAddr, data string, curCoinbaseReward float64, height int) *Transaction {
+	return NewCoinbaseTxFromReader(dstAddr, data, curCoinbaseReward, height, rand.Reader)
+}
+
+// NewCoinbaseTxFromReader works like NewCoinbaseTx, but the empty data is filled with the bytes read from r, thus the
+// same bytes always lead to the same coinbase transaction (e.g., a seeded reader in tests, see NewWalletFromReader).
+func NewCoinbaseTxFromReader(dstAddr, data string, curCoinbaseReward float64, height int, r io.Reader) *Transaction {
 	if data == "" {
 		// In bitcoin, these data are used to calculate nonce. But we just randomly sample chars in the simplified case.
 		randData := make([]byte, 20)
-		_, err := rand.Read(randData)
+		_, err := io.ReadFull(r, randData)
 		if err != nil {
 			log.Panic(err)
 		}
 		data = fmt.Sprintf("%x", randData)
 	}
-	// txIn is from nowhere, thus its PubKey is set by data
-	txIn := TxInput{[]byte{}, -1, nil, []byte(data)}
+	// txIn is from nowhere, thus its PubKey is set by data, and its Signature is set by the block height (like the
+	// height in bitcoin's coinbase scriptSig), which makes coinbase txs at different heights have different Ids
+	txIn := TxInput{[]byte{}, -1, utils.Int2Hex(int64(height)), []byte(data), UncompressedKey}
 	txOut := NewTxOutput(curCoinbaseReward, dstAddr)
-	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}}
+	tx := Transaction{Version: TxVersion, Vin: []TxInput{txIn}, Vout: []TxOutput{*txOut}}
 	tx.Id = tx.Hashing()
 	return &tx
 }
 
+// involves checks whether tx pays to the owner of pubKeyHash, or spends the outputs of the owner.
+func (tx *Transaction) involves(pubKeyHash []byte) bool {
+	for _, txOutput := range tx.Vout {
+		if txOutput.IsLockedWithKey(pubKeyHash) {
+			return true
+		}
+	}
+	if tx.IsCoinbaseTx() {
+		return false
+	}
+	for _, txInput := range tx.Vin {
+		if bytes.Equal(HashingPubKey(txInput.PubKey), pubKeyHash) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsCoinbaseTx judges whether the caller is a coinbase Transaction, i.e. the transaction for
 // generating new coins (as the transaction fee for the successful miner).
 func (tx *Transaction) IsCoinbaseTx() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].TxId) == 0 && tx.Vin[0].VoutIdx == -1
 }
 
+// CoinbaseHeight returns the block height embedded in the coinbase transaction tx.
+func (tx *Transaction) CoinbaseHeight() (int, error) {
+	if !tx.IsCoinbaseTx() {
+		return 0, errors.New("not a coinbase transaction")
+	}
+	heightData := tx.Vin[0].Signature
+	if len(heightData) != 8 {
+		return 0, errors.New("no height embedded in the coinbase transaction")
+	}
+	return int(binary.BigEndian.Uint64(heightData)), nil
+}
+
+// ValidateCoinbase checks the structure of the coinbase transaction tx packed in the block at height: it has exactly
+// one input from nowhere, which embeds height, and at least one output. Besides, the outputs should not exceed
+// allowedValue, i.e. the coinbase reward plus the fees collected from the other transactions of the block.
+func ValidateCoinbase(tx *Transaction, allowedValue float64, height int) error {
+	if !tx.IsCoinbaseTx() {
+		return errors.New("coinbase should have exactly one input which refers to nothing")
+	}
+	if len(tx.Vout) == 0 {
+		return errors.New("coinbase should have at least one output")
+	}
+	embeddedHeight, err := tx.CoinbaseHeight()
+	if err != nil {
+		return err
+	}
+	if embeddedHeight != height {
+		return fmt.Errorf("coinbase embeds height %d, but it is packed at height %d", embeddedHeight, height)
+	}
+
+	totalValue := 0.0
+	for _, txOutput := range tx.Vout {
+		if txOutput.Value < 0 {
+			return errors.New("coinbase has an output with negative value")
+		}
+		totalValue += txOutput.Value
+	}
+	if totalValue > allowedValue+valueTolerance {
+		return fmt.Errorf("coinbase outputs %f coins, exceeding the allowed %f", totalValue, allowedValue)
+	}
+	return nil
+}
+
+// ErrInvalidAmount is returned when creating a transaction with a non-positive amount, or an amount with more precision
+// than CoinUnit.
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ErrSelfTransfer is returned when creating a transaction sending coins back to the sender, which would pay both the
+// amount and the change to the sender. Consolidate the coins with NewConsolidationTx instead.
+var ErrSelfTransfer = errors.New("self transfer")
+
+// CoinUnit is the smallest unit of the coin. Any amount sent should be a multiple of it.
+const CoinUnit = 1e-8
+
+// checkAmount returns an error wrapping ErrInvalidAmount if amount cannot be sent.
+func checkAmount(amount float64) error {
+	if !(amount > 0) {
+		return fmt.Errorf("%w: %v is not positive", ErrInvalidAmount, amount)
+	}
+	if math.Abs(amount-math.Round(amount/CoinUnit)*CoinUnit) > valueTolerance {
+		return fmt.Errorf("%w: %v is more precise than the coin unit %v", ErrInvalidAmount, amount, CoinUnit)
+	}
+	return nil
+}
+
+// TxOption sets an optional setting of the transaction created by NewUTXOTx.
+type TxOption func(*txOptions)
+
+// txOptions holds the optional settings of the transaction created by NewUTXOTx.
+type txOptions struct {
+	nonce         uint64            // carried by the transaction unless zero
+	strategy      SelectionStrategy // how the spent outputs of the sender are selected
+	dstPubKeyHash []byte            // the receiver instead of dstAddr if not nil
+}
+
+// WithNonce sets the nonce carried by the transaction, which should be higher than any nonce of the sender on chain
+// (see CheckNonce). A zero nonce means the nonce is not used (the default).
+func WithNonce(nonce uint64) TxOption {
+	return func(options *txOptions) {
+		options.nonce = nonce
+	}
+}
+
+// WithStrategy sets the strategy the spent outputs of the sender are selected with (FirstFit in default).
+func WithStrategy(strategy SelectionStrategy) TxOption {
+	return func(options *txOptions) {
+		options.strategy = strategy
+	}
+}
+
+// WithDstHash sends the coins to the receiver's dstPubKeyHash directly (see NewTxOutputFromHash), thus the dstAddr
+// passed to NewUTXOTx should be empty.
+func WithDstHash(dstPubKeyHash []byte) TxOption {
+	return func(options *txOptions) {
+		options.dstPubKeyHash = dstPubKeyHash
+	}
+}
+
 // NewUTXOTx returns a pointer to a newly created UTXO transaction. When creating an UTXO transaction.
 // Firstly, we need to find the wallet of sender according to srcAddr; Then, we need to check whether this
 // wallet has enough coins to support this tx. If yes, construct Vin (with src wallet's PubKey) and Vout.
-// Finally, sign this tx with src wallet's private key.
-func NewUTXOTx(senderWallet *Wallet, dstAddr string, amount float64, utxoSet *UTXOSet) *Transaction {
+// Finally, sign this tx with src wallet's private key. The nonce, the coin selection strategy and the receiver's
+// pubKeyHash can be set through opts in any combination. An error wrapping ErrInvalidAmount is returned if amount
+// cannot be sent, ErrInvalidAddress if dstAddr is not valid (or not empty along with WithDstHash), ErrSelfTransfer if
+// the receiver is the sender, ErrInsufficientFunds if the sender is short of coins, and ErrFeeTooHigh if the fee
+// implied by the inputs and the outputs exceeds MaxTxFee.
+func NewUTXOTx(senderWallet *Wallet, dstAddr string, amount float64, utxoSet *UTXOSet, opts ...TxOption) (*Transaction,
+	error) {
+	options := txOptions{strategy: FirstFit}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	dstPubKeyHash := options.dstPubKeyHash
+	if dstPubKeyHash == nil {
+		var err error
+		if dstPubKeyHash, err = dstAddrPubKeyHash(dstAddr); err != nil {
+			return nil, err
+		}
+	} else if dstAddr != "" {
+		return nil, fmt.Errorf("%w: both %q and the pubkey hash %x are given as the receiver", ErrInvalidAddress,
+			dstAddr, dstPubKeyHash)
+	}
+	return newUTXOTx(senderWallet, dstPubKeyHash, amount, options.nonce, options.strategy, utxoSet)
+}
+
+// NewSplitTx returns a signed transaction sending all the coins of senderWallet back to itself in into equal
+// outputs, e.g., to spend them in parallel later. The fee is estimated at feePerByte (see EstimateFee), and the
+// remainder below CoinUnit is paid as the fee as well. At most MaxTxInputs unspent outputs are spent. An error wrapping
+// ErrInvalidAmount is returned if into is not in [1, MaxTxOutputs], ErrInsufficientFunds if the coins can not cover
+// the fee and a CoinUnit per output, and ErrFeeTooHigh if the fee exceeds MaxTxFee.
+func NewSplitTx(senderWallet *Wallet, into int, feePerByte float64, utxoSet *UTXOSet) (*Transaction, error) {
+	if into < 1 || into > MaxTxOutputs {
+		return nil, fmt.Errorf("%w: cannot split into %d outputs", ErrInvalidAmount, into)
+	}
+	outputs := utxoSet.spendableOutputsOf(HashingPubKey(senderWallet.PubKey))
+	if len(outputs) > MaxTxInputs {
+		outputs = outputs[:MaxTxInputs]
+	}
+
+	var vin []TxInput
+	total := 0.0
+	for _, output := range outputs {
+		txId, err := hex.DecodeString(output.txId)
+		if err != nil {
+			log.Panic(err)
+		}
+		vin = append(vin, newTxInput(txId, output.outputIdx, senderWallet.PubKey))
+		total += output.value
+	}
+	fee := EstimateFee(len(vin), into, feePerByte)
+	split := math.Floor((total-fee)/float64(into)/CoinUnit) * CoinUnit
+	if split < CoinUnit {
+		return nil, fmt.Errorf("%w: %f coins cannot be split into %d outputs with the fee %f", ErrInsufficientFunds,
+			total, into, fee)
+	}
+	if err := checkMaxFee(total - split*float64(into)); err != nil {
+		return nil, err
+	}
+
+	var vout []TxOutput
+	srcAddr := string(senderWallet.GetAddr())
+	for i := 0; i < into; i++ {
+		vout = append(vout, *NewTxOutput(split, srcAddr))
+	}
+	tx := Transaction{Version: TxVersion, Vin: vin, Vout: vout, Replaceable: SignalReplaceable}
+	tx.Id = tx.Hashing()
+	utxoSet.BlockChain.SignTx(&tx, senderWallet.PrivateKey)
+	return &tx, nil
+}
+
+// NewConsolidationTx returns a signed transaction consolidating the coins of senderWallet into a single output back to
+// itself, thus fewer outputs are spent later. It is NewSplitTx into 1 output.
+func NewConsolidationTx(senderWallet *Wallet, feePerByte float64, utxoSet *UTXOSet) (*Transaction, error) {
+	return NewSplitTx(senderWallet, 1, feePerByte, utxoSet)
+}
+
+// dstAddrPubKeyHash returns the pubKeyHash of the receiver's address dstAddr. An error wrapping ErrInvalidAddress is
+// returned if dstAddr is not valid.
+func dstAddrPubKeyHash(dstAddr string) ([]byte, error) {
+	if !ValidateAddr(dstAddr) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, dstAddr)
+	}
+	return AddrPubKeyHash(dstAddr)
+}
+
+// newUTXOTx creates and signs an UTXO transaction carrying nonce, whose inputs are selected with strategy.
+func newUTXOTx(senderWallet *Wallet, dstPubKeyHash []byte, amount float64, nonce uint64, strategy SelectionStrategy,
+	utxoSet *UTXOSet) (*Transaction, error) {
+	tx, err := newUnsignedUTXOTxToHash(senderWallet.PubKey, dstPubKeyHash, amount, strategy, utxoSet)
+	if err != nil {
+		return nil, err
+	}
+	if nonce != 0 {
+		tx.Nonce = nonce
+		tx.Id = tx.Hashing()
+		tx.resetCache()
+	}
+
+	// sign each input of this transaction with sender's privateKey
+	utxoSet.BlockChain.SignTx(tx, senderWallet.PrivateKey)
+	return tx, nil
+}
+
+// newUnsignedUTXOTx constructs the Vin and Vout of an UTXO transaction from the sender (whose public key is
+// senderPubKey) to dstAddr. The outputs of sender are selected with strategy. The returned transaction is not signed.
+func newUnsignedUTXOTx(senderPubKey []byte, dstAddr string, amount float64, strategy SelectionStrategy,
+	utxoSet *UTXOSet) (*Transaction, error) {
+	dstPubKeyHash, err := dstAddrPubKeyHash(dstAddr)
+	if err != nil {
+		return nil, err
+	}
+	return newUnsignedUTXOTxToHash(senderPubKey, dstPubKeyHash, amount, strategy, utxoSet)
+}
+
+// newUnsignedUTXOTxToHash is like newUnsignedUTXOTx, but the receiver is given by dstPubKeyHash.
+func newUnsignedUTXOTxToHash(senderPubKey, dstPubKeyHash []byte, amount float64, strategy SelectionStrategy,
+	utxoSet *UTXOSet) (*Transaction, error) {
+	if err := checkAmount(amount); err != nil {
+		return nil, err
+	}
+	dstOutput, err := NewTxOutputFromHash(amount, dstPubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
 	var vin []TxInput
 	var vout []TxOutput
 
-	pubKeyHash := HashingPubKey(senderWallet.PubKey)
+	pubKeyHash := HashingPubKey(senderPubKey)
+	if bytes.Equal(dstPubKeyHash, pubKeyHash) {
+		return nil, fmt.Errorf("%w: the receiver is the sender", ErrSelfTransfer)
+	}
 
 	// find enough unspent outputs from sender to support this tx
-	accumulated, unspentOutputs := utxoSet.FindSpendableOutputs(pubKeyHash, amount)
+	accumulated, unspentOutputs := utxoSet.FindSpendableOutputsStrategy(pubKeyHash, amount, strategy)
 	if accumulated < amount {
-		log.Panic("Error: the sender does not have enough coins to support this transaction")
+		return nil, fmt.Errorf("%w: the sender does not have enough coins to support this transaction",
+			ErrInsufficientFunds)
 	}
 
 	// construct Vin
@@ -175,25 +574,27 @@ func NewUTXOTx(senderWallet *Wallet, dstAddr string, amount float64, utxoSet *UT
 			log.Panic(err)
 		}
 		for _, outputIdx := range outputIndices {
-			vin = append(vin, TxInput{decodedTxId, outputIdx, nil, senderWallet.PubKey})
+			vin = append(vin, newTxInput(decodedTxId, outputIdx, senderPubKey))
 		}
 	}
 
 	// construct Vout
-	vout = append(vout, *NewTxOutput(amount, dstAddr))
-	if accumulated > amount {
-		// generate the change transaction
+	vout = append(vout, *dstOutput)
+	outputValue := amount
+	if accumulated-amount > valueTolerance {
+		// generate the change transaction (the rounding error of an exact match is not worth a change)
 		// TODO: support new addr generation.
-		srcAddr := fmt.Sprintf("%s", senderWallet.GetAddr())
+		srcAddr := fmt.Sprintf("%s", GenerateAddr(senderPubKey))
 		vout = append(vout, *NewTxOutput(accumulated-amount, srcAddr))
+		outputValue += accumulated - amount
+	}
+	if err := checkMaxFee(accumulated - outputValue); err != nil {
+		return nil, err
 	}
 
-	tx := Transaction{nil, vin, vout}
+	tx := Transaction{Version: TxVersion, Vin: vin, Vout: vout, Replaceable: SignalReplaceable}
 	tx.Id = tx.Hashing()
-
-	// sign each input of this transaction with sender's privateKey
-	utxoSet.BlockChain.SignTx(&tx, senderWallet.PrivateKey)
-	return &tx
+	return &tx, nil
 }
 
 // Sign signs each input of the Transaction tx with the sender wallet's private key (set the Signature segment of
@@ -216,21 +617,31 @@ func (tx *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTxs map[string]Tran
 		// the pubKeyHash plays the role of hash pointer
 		copiedTx.Vin[txInputIdx].PubKey = prevTx.Vout[txInput.VoutIdx].PubKeyHash
 
-		// call the copiedTx.String() in default
-		txData2Sign := fmt.Sprintf("%x\n", copiedTx)
-		r, s, err := ecdsa.Sign(rand.Reader, &privateKey, []byte(txData2Sign))
+		r, s, err := ecdsa.Sign(rand.Reader, &privateKey, copiedTx.signingDigest())
 		if err != nil {
 			log.Panic(err)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+		// r and s are padded to the same length, otherwise Verify cannot split the signature into halves correctly
+		keyLen := (privateKey.Curve.Params().BitSize + 7) / 8
+		signature := append(r.FillBytes(make([]byte, keyLen)), s.FillBytes(make([]byte, keyLen))...)
 
 		tx.Vin[txInputIdx].Signature = signature
 		copiedTx.Vin[txInputIdx].PubKey = nil
 	}
+	tx.resetCache()
+}
+
+// signingDigest returns the digest of the copied tx to be signed or verified. ecdsa only takes the leading bytes of
+// the data as long as the curve order, thus the data (which calls tx.String() in default) are hashed first to make
+// the signature cover the whole transaction.
+func (tx Transaction) signingDigest() []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%x\n", tx)))
+	return digest[:]
 }
 
 // Copy copies tx into a newly created Transaction. This Copy will copy everything of tx except the
-// Signature and PubKey of txInput of tx.Vin.
+// Signature and PubKey of txInput of tx.Vin. Since the copy is what gets signed, the Version, the Nonce, the LockTime,
+// and the Replaceable flag are signed as well.
 func (tx *Transaction) Copy() Transaction {
 	var vin []TxInput
 	var vout []TxOutput
@@ -240,6 +651,7 @@ func (tx *Transaction) Copy() Transaction {
 			VoutIdx:   txInput.VoutIdx,
 			Signature: nil,
 			PubKey:    nil, // copiedTx.Vin[:].PubKey will be set as tx.Vin[:].PubKeyHash
+			KeyFormat: txInput.KeyFormat,
 		})
 	}
 	for _, txOutput := range tx.Vout {
@@ -248,7 +660,8 @@ func (tx *Transaction) Copy() Transaction {
 			PubKeyHash: txOutput.PubKeyHash,
 		})
 	}
-	return Transaction{tx.Id, vin, vout}
+	return Transaction{Id: tx.Id, Version: tx.Version, Vin: vin, Vout: vout, Nonce: tx.Nonce, LockTime: tx.LockTime,
+		Replaceable: tx.Replaceable}
 }
 
 // Verify checks whether all the inputs of Transaction tx are legal. Wherein, this function checks whether the inputs
@@ -271,18 +684,17 @@ func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 		copiedTx.Vin[txInputIdx].Signature = nil
 		copiedTx.Vin[txInputIdx].PubKey = prevTx.Vout[txInput.VoutIdx].PubKeyHash
 
-		x, y := big.Int{}, big.Int{}
-		keyLength := len(txInput.PubKey)
-		x.SetBytes(txInput.PubKey[:(keyLength / 2)])
-		y.SetBytes(txInput.PubKey[(keyLength / 2):])
+		x, y, ok := parsePubKey(curve, txInput.PubKey, txInput.KeyFormat)
+		if !ok {
+			return false
+		}
 
 		r, s := big.Int{}, big.Int{}
 		sigLength := len(txInput.Signature)
 		r.SetBytes(txInput.Signature[:(sigLength / 2)])
 		s.SetBytes(txInput.Signature[(sigLength / 2):])
 
-		data2Verify := fmt.Sprintf("%x\n", copiedTx)
-		if ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}, []byte(data2Verify), &r, &s) == false {
+		if ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, copiedTx.signingDigest(), &r, &s) == false {
 			return false
 		}
 		copiedTx.Vin[txInputIdx].PubKey = nil
@@ -295,12 +707,22 @@ func (tx *Transaction) Hashing() []byte {
 	var hash [32]byte
 	copiedTx := *tx
 	copiedTx.Id = []byte{}
-	hash = sha256.Sum256(copiedTx.SerializeTx())
+	hash = sha256.Sum256(copiedTx.serialize())
 	return hash[:]
 }
 
-// SerializeTx converts the content of tx into a serialized byte slice.
+// SerializeTx converts the content of tx into a serialized byte slice. The bytes cached by Size are returned if any,
+// thus the copies of tx made after Size is called (e.g., the pooled transactions) are not serialized again.
 func (tx Transaction) SerializeTx() []byte {
+	if tx.serialized != nil {
+		return tx.serialized
+	}
+	return tx.serialize()
+}
+
+// serialize converts the content of tx into a serialized byte slice without the cache. The consensus checks (e.g.,
+// the hashing and the Merkle root) use it.
+func (tx Transaction) serialize() []byte {
 	return utils.GobEncode(tx)
 }
 
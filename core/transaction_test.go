@@ -0,0 +1,449 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`bytes`
+	`encoding/hex`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`testing`
+	`time`
+)
+
+func TestUnsignedTxAcrossAirGap(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, coldWallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	// online: build with the public key only
+	utx, err := chain.BuildUnsignedTx(coldWallet.PubKey, string(NewWallet().GetAddr()), 100)
+	assert.Nil(t, err)
+	for _, txInput := range utx.Tx.Vin {
+		assert.Nil(t, txInput.Signature, "The built transaction is not signed")
+	}
+	data := utx.SerializeUnsignedTx()
+
+	// offline: sign without chain access
+	received := DeserializeUnsignedTx(data)
+	signedTx := SignUnsignedTx(&received, coldWallet.PrivateKey)
+
+	// online again: verify and mine
+	assert.True(t, chain.VerifyTx(signedTx), "The transaction signed offline is verified")
+	coinbaseTx := NewCoinbaseTx(string(coldWallet.GetAddr()), "", chain.CoinbaseReward, chain.GetChainHeight()+1)
+	block := chain.MineBlock([]*Transaction{coinbaseTx, signedTx})
+	assert.True(t, chain.HasTx(signedTx.Id))
+	assert.Equal(t, block.Hash, chain.Tip)
+
+	// signing with a wrong key fails the verification
+	wrongTx := SignUnsignedTx(&received, NewWallet().PrivateKey)
+	assert.False(t, chain.VerifyTx(wrongTx), "The transaction signed by a wrong key is rejected")
+}
+
+func TestBuildUnsignedTxInsufficientFunds(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	_, err := chain.BuildUnsignedTx(wallet.PubKey, string(NewWallet().GetAddr()), 10000)
+	assert.NotNil(t, err)
+}
+
+func TestTxLimits(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 100, &utxoSet)
+	assert.Nil(t, err)
+
+	oldMaxTxInputs, oldMaxTxOutputs := MaxTxInputs, MaxTxOutputs
+	defer func() {
+		MaxTxInputs, MaxTxOutputs = oldMaxTxInputs, oldMaxTxOutputs
+	}()
+
+	// boundary: exactly 1 input and 2 outputs (payment + change)
+	MaxTxInputs, MaxTxOutputs = 1, 2
+	assert.Nil(t, tx.CheckLimits())
+	assert.True(t, chain.VerifyTx(tx), "Transaction at the limits is accepted")
+
+	tooManyInputs := tx.Copy()
+	tooManyInputs.Vin = append(tooManyInputs.Vin, tooManyInputs.Vin[0])
+	assert.NotNil(t, tooManyInputs.CheckLimits())
+	assert.False(t, chain.VerifyTx(&tooManyInputs), "Transaction exceeding the input cap is rejected")
+
+	tooManyOutputs := tx.Copy()
+	tooManyOutputs.Vout = append(tooManyOutputs.Vout, tooManyOutputs.Vout[0])
+	assert.NotNil(t, tooManyOutputs.CheckLimits())
+	assert.False(t, chain.VerifyTx(&tooManyOutputs), "Transaction exceeding the output cap is rejected")
+}
+
+func TestValidateCoinbase(t *testing.T) {
+	addr := string(NewWallet().GetAddr())
+
+	tx := NewCoinbaseTx(addr, "", 10, 5)
+	assert.Nil(t, ValidateCoinbase(tx, 10, 5), "Valid coinbase is accepted")
+	assert.NotNil(t, ValidateCoinbase(tx, 9, 5), "Coinbase exceeding the allowed value is rejected")
+	assert.NotNil(t, ValidateCoinbase(tx, 10, 6), "Coinbase embedding a wrong height is rejected")
+
+	twoInputs := NewCoinbaseTx(addr, "", 10, 5)
+	twoInputs.Vin = append(twoInputs.Vin, twoInputs.Vin[0])
+	assert.NotNil(t, ValidateCoinbase(twoInputs, 10, 5), "Coinbase with two inputs is rejected")
+
+	noOutput := NewCoinbaseTx(addr, "", 10, 5)
+	noOutput.Vout = nil
+	assert.NotNil(t, ValidateCoinbase(noOutput, 10, 5), "Coinbase without outputs is rejected")
+
+	negative := NewCoinbaseTx(addr, "", 10, 5)
+	negative.Vout = append(negative.Vout, TxOutput{Value: -5, PubKeyHash: negative.Vout[0].PubKeyHash})
+	assert.NotNil(t, ValidateCoinbase(negative, 10, 5), "Coinbase with a negative output is rejected")
+}
+
+func TestNewCoinbaseTxFromReader(t *testing.T) {
+	addr := string(NewWallet().GetAddr())
+	seeded := func(seed byte) *Transaction {
+		return NewCoinbaseTxFromReader(addr, "", 10, 5, bytes.NewReader(bytes.Repeat([]byte{seed}, 20)))
+	}
+
+	assert.Equal(t, seeded(1).Id, seeded(1).Id, "The same data lead to the same coinbase transaction")
+	assert.NotEqual(t, seeded(1).Id, seeded(2).Id)
+	assert.Nil(t, ValidateCoinbase(seeded(1), 10, 5))
+	assert.NotEqual(t, NewCoinbaseTx(addr, "", 10, 5).Id, NewCoinbaseTx(addr, "", 10, 5).Id,
+		"The data are random in production")
+}
+
+func TestTxVersion(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 100, &utxoSet)
+	assert.Nil(t, err)
+	assert.Equal(t, TxVersion, tx.Version, "Version is set in the constructor")
+	assert.True(t, chain.VerifyTx(tx), "Transaction of the known version is accepted")
+
+	unknown := *tx
+	unknown.Version = TxVersion + 1
+	assert.NotNil(t, unknown.CheckVersion())
+	assert.False(t, chain.VerifyTx(&unknown), "Transaction of an unknown version is rejected")
+
+	oldTxVersion := TxVersion
+	defer func() {
+		TxVersion = oldTxVersion
+	}()
+	// even if the node understands the changed version, the signature does not match
+	TxVersion++
+	assert.Nil(t, unknown.CheckVersion())
+	assert.False(t, chain.VerifyTx(&unknown), "Version is included in the signing digest")
+
+	height := chain.GetChainHeight() + 1
+	coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+	TxVersion = oldTxVersion
+	block := NewBlock([]*Transaction{coinbaseTx}, chain.Tip, height)
+	assert.NotNil(t, chain.ValidateBlock(block), "Block packing a transaction of an unknown version is rejected")
+}
+
+func TestInvalidAmount(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	dstAddr := string(NewWallet().GetAddr())
+
+	for _, amount := range []float64{0, -10, 0.000000005, 10.123456785} {
+		tx, err := NewUTXOTx(wallet, dstAddr, amount, &utxoSet)
+		assert.Nil(t, tx)
+		assert.True(t, errors.Is(err, ErrInvalidAmount), "Amount %v is rejected", amount)
+
+		_, err = chain.BuildUnsignedTx(wallet.PubKey, dstAddr, amount)
+		assert.True(t, errors.Is(err, ErrInvalidAmount), "Amount %v is rejected", amount)
+	}
+
+	_, err := NewUTXOTx(wallet, dstAddr, 10.12345678, &utxoSet)
+	assert.Nil(t, err, "Amount in the coin unit is accepted")
+}
+
+// spendChange returns a transaction of wallet sending amount from the change output (the last output) of the unconfirmed
+// parent to dstAddr.
+func spendChange(wallet *Wallet, parent *Transaction, dstAddr string, amount float64) *Transaction {
+	changeIdx := len(parent.Vout) - 1
+	change := parent.Vout[changeIdx].Value
+	vout := []TxOutput{*NewTxOutput(amount, dstAddr), *NewTxOutput(change-amount, string(wallet.GetAddr()))}
+	child := Transaction{Version: TxVersion, Vin: []TxInput{{TxId: parent.Id, VoutIdx: changeIdx, PubKey: wallet.PubKey}}, Vout: vout}
+	child.Id = child.Hashing()
+	child.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(parent.Id): *parent})
+	return &child
+}
+
+func TestChainedUnconfirmedTxs(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	parent, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 100, &utxoSet)
+	assert.Nil(t, err)
+	child := spendChange(wallet, parent, string(NewWallet().GetAddr()), 50)
+
+	assert.False(t, chain.VerifyTx(child), "The parent is not on chain")
+	assert.True(t, chain.VerifyTxWithPool(child, map[string]Transaction{hex.EncodeToString(parent.Id): *parent}),
+		"The parent is in the pool")
+
+	// the child is ordered after its parent, and the orphan is dropped
+	orphan := spendChange(wallet, child, string(NewWallet().GetAddr()), 10)
+	orphan.Vin[0].TxId = make([]byte, 32)
+	ordered := chain.OrderVerifiedTxs([]*Transaction{child, orphan, parent})
+	assert.Equal(t, []*Transaction{parent, child}, ordered)
+
+	coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CoinbaseReward, chain.GetChainHeight()+1)
+	block := chain.MineBlock(append([]*Transaction{coinbaseTx}, ordered...))
+	assert.Nil(t, chain.ValidateBlock(block))
+	utxoSet.Update(block)
+	assert.Equal(t, chain.IssuedSupply(), chain.TotalSupply())
+	assert.Equal(t, 2*initCoinbaseReward-150, utxoSet.Balance(HashingPubKey(wallet.PubKey)))
+}
+
+// datedBlock mines a block like NewBlock, but the block is dated at timestamp rather than now.
+func datedBlock(txs []*Transaction, prevBlockHash []byte, height int, timestamp int64) *Block {
+	block := &Block{TimeStamp: timestamp, PrevBlockHash: prevBlockHash, Height: height, Transactions: txs}
+	block.Nonce, block.Hash = Sha256PoW{}.Run(block)
+	return block
+}
+
+func TestLockTime(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 100, &utxoSet)
+	assert.Nil(t, err)
+	lockTime := time.Now().Unix() + 3600
+	tx.LockTime = lockTime
+	tx.Id = tx.Hashing()
+	chain.SignTx(tx, wallet.PrivateKey)
+	assert.True(t, chain.VerifyTx(tx), "A locked transaction is still valid for the pool")
+
+	tampered := *tx
+	tampered.LockTime = 0
+	assert.False(t, chain.VerifyTx(&tampered), "LockTime is included in the signing digest")
+
+	height := chain.GetChainHeight() + 1
+	coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+	txs := []*Transaction{coinbaseTx, tx}
+	assert.NotNil(t, chain.ValidateBlock(datedBlock(txs, chain.Tip, height, lockTime+1)),
+		"Block dated after the locktime is still rejected before the median time past reaches it")
+	setClock := useClock(t)
+	setClock(time.Unix(lockTime, 0))
+	assert.Panics(t, func() {
+		chain.MineBlock(txs)
+	}, "The miner does not pack a transaction locked until the median time past")
+
+	// the median of the genesis block and a block at the locktime is the locktime
+	chain.MineBlock([]*Transaction{coinbaseTx})
+	assert.Equal(t, lockTime, chain.MedianTimePast())
+	height = chain.GetChainHeight() + 1
+	coinbaseTx = NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+	txs = []*Transaction{coinbaseTx, tx}
+	assert.Nil(t, chain.ValidateBlock(datedBlock(txs, chain.Tip, height, lockTime)),
+		"Block is accepted once the median time past reaches the locktime")
+	block := chain.MineBlock(txs)
+	assert.Equal(t, lockTime, block.TimeStamp, "The miner packs it once the median time past reaches the locktime")
+
+	assert.True(t, tx.IsFinal(lockTime))
+	assert.False(t, tx.IsFinal(lockTime-1))
+	assert.True(t, (&Transaction{}).IsFinal(0), "A zero locktime never locks")
+}
+
+func TestNewUTXOTxDstHash(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	receiver := NewWallet()
+	pubKeyHash := HashingPubKey(receiver.PubKey)
+
+	output, err := NewTxOutputFromHash(10, pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, NewTxOutput(10, string(receiver.GetAddr())), output, "The output is locked as if to the address")
+	for _, hash := range [][]byte{nil, pubKeyHash[1:], append(pubKeyHash, 0)} {
+		_, err = NewTxOutputFromHash(10, hash)
+		assert.True(t, errors.Is(err, ErrInvalidAddress), "Hash of %d bytes is rejected", len(hash))
+		_, err = NewUTXOTx(wallet, "", 10, &utxoSet, WithDstHash(hash))
+		assert.True(t, errors.Is(err, ErrInvalidAddress), "Hash of %d bytes is rejected", len(hash))
+	}
+	_, err = NewUTXOTx(wallet, string(receiver.GetAddr()), 10, &utxoSet, WithDstHash(pubKeyHash))
+	assert.True(t, errors.Is(err, ErrInvalidAddress), "The receiver is given either by the address or by the hash")
+
+	minerAddr := string(wallet.GetAddr())
+	tx, err := NewUTXOTx(wallet, "", 10, &utxoSet, WithDstHash(pubKeyHash))
+	assert.Nil(t, err)
+	mineTxs(chain, minerAddr, tx)
+	assert.Equal(t, 10.0, utxoSet.Balance(pubKeyHash))
+
+	// the output locked via the raw hash is spendable by the owner of the key
+	spending, err := NewUTXOTx(receiver, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	height := chain.GetChainHeight() + 1
+	coinbaseTx := NewCoinbaseTx(minerAddr, "", chain.CurrentReward(height), height)
+	block := NewBlock([]*Transaction{coinbaseTx, spending}, chain.Tip, height)
+	assert.Nil(t, chain.ValidateBlock(block))
+	utxoSet.Update(chain.MineBlock(block.Transactions))
+	assert.Equal(t, 0.0, utxoSet.Balance(pubKeyHash))
+}
+
+func TestNewSplitTx(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	pubKeyHash := HashingPubKey(wallet.PubKey)
+	balance := utxoSet.Balance(pubKeyHash)
+
+	tx, err := NewSplitTx(wallet, 4, DefaultFeePerByte, &utxoSet)
+	assert.Nil(t, err)
+	assert.True(t, chain.VerifyTx(tx))
+	fee, err := chain.TxFee(tx, nil)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, fee+valueTolerance, EstimateFee(len(tx.Vin), 4, DefaultFeePerByte), "The fee is paid")
+	assert.Less(t, fee, EstimateFee(len(tx.Vin), 4, DefaultFeePerByte)+4*CoinUnit)
+
+	// mine it with the reward going to another wallet
+	mineTxs(chain, string(NewWallet().GetAddr()), tx)
+	utxo := utxoSet.FindUTXO(pubKeyHash)
+	assert.Equal(t, 4, len(utxo), "The balance is split into 4 outputs")
+	for _, txOutput := range utxo {
+		assert.Equal(t, tx.Vout[0].Value, txOutput.Value, "The outputs are equal")
+	}
+	assert.InDelta(t, balance-fee, utxoSet.Balance(pubKeyHash), valueTolerance)
+
+	for _, into := range []int{0, -1, MaxTxOutputs + 1} {
+		_, err = NewSplitTx(wallet, into, DefaultFeePerByte, &utxoSet)
+		assert.True(t, errors.Is(err, ErrInvalidAmount), "Splitting into %d outputs is rejected", into)
+	}
+	_, err = NewSplitTx(wallet, 4, balance, &utxoSet)
+	assert.True(t, errors.Is(err, ErrInsufficientFunds), "The fee exceeding the balance is rejected")
+	_, err = NewSplitTx(NewWallet(), 4, DefaultFeePerByte, &utxoSet)
+	assert.True(t, errors.Is(err, ErrInsufficientFunds), "An empty wallet is rejected")
+}
+
+func TestSelfReferencingTx(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	addr := string(wallet.GetAddr())
+	// the Id is chosen by the crafter, and the only input refers to its own output
+	selfRef := &Transaction{
+		Version: TxVersion,
+		Id:      []byte("a transaction spending itself"),
+		Vout:    []TxOutput{*NewTxOutput(1000, addr)},
+	}
+	selfRef.Vin = []TxInput{{TxId: selfRef.Id, VoutIdx: 0, PubKey: wallet.PubKey}}
+	selfRef.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(selfRef.Id): *selfRef})
+
+	assert.NotPanics(t, func() {
+		assert.False(t, chain.VerifyTx(selfRef), "Transaction spending itself is rejected")
+	})
+	pool := map[string]Transaction{hex.EncodeToString(selfRef.Id): *selfRef}
+	assert.False(t, chain.VerifyTxWithPool(selfRef, pool), "Transaction spending itself is rejected even if pooled")
+
+	height := chain.GetChainHeight() + 1
+	coinbaseTx := NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)
+	block := NewBlock([]*Transaction{coinbaseTx, selfRef}, chain.Tip, height)
+	assert.True(t, errors.Is(chain.ValidateBlock(block), ErrInvalidBlock), "Block packing it is rejected")
+}
+
+func TestForwardReferencingTx(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	parent, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	child := spendChange(wallet, parent, string(NewWallet().GetAddr()), 5)
+
+	height := chain.GetChainHeight() + 1
+	coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+	forward := NewBlock([]*Transaction{coinbaseTx, child, parent}, chain.Tip, height)
+	assert.True(t, errors.Is(chain.ValidateBlock(forward), ErrInvalidBlock), "The child before its parent is rejected")
+	ordered := NewBlock([]*Transaction{coinbaseTx, parent, child}, chain.Tip, height)
+	assert.Nil(t, chain.ValidateBlock(ordered), "The parent before its child is accepted")
+}
+
+func TestSelfTransfer(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	pubKeyHash := HashingPubKey(wallet.PubKey)
+
+	// split the coins so that the wallet holds several outputs
+	split, err := NewSplitTx(wallet, 4, DefaultFeePerByte, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(NewWallet().GetAddr()), split)
+	assert.Equal(t, 4, len(utxoSet.FindUTXO(pubKeyHash)))
+	balance := utxoSet.Balance(pubKeyHash)
+
+	_, err = NewUTXOTx(wallet, string(wallet.GetAddr()), 1, &utxoSet)
+	assert.True(t, errors.Is(err, ErrSelfTransfer), "Sending to the sender itself is rejected")
+	_, err = NewUTXOTx(wallet, "", 1, &utxoSet, WithDstHash(pubKeyHash))
+	assert.True(t, errors.Is(err, ErrSelfTransfer), "Sending to the pubkey hash of the sender is rejected")
+
+	tx, err := NewConsolidationTx(wallet, DefaultFeePerByte, &utxoSet)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(tx.Vin), "All the outputs are spent")
+	assert.Equal(t, 1, len(tx.Vout))
+	fee, err := chain.TxFee(tx, nil)
+	assert.Nil(t, err)
+	mineTxs(chain, string(NewWallet().GetAddr()), tx)
+	assert.Equal(t, 1, len(utxoSet.FindUTXO(pubKeyHash)), "The coins are consolidated into a single output")
+	assert.InDelta(t, balance-fee, utxoSet.Balance(pubKeyHash), valueTolerance)
+}
+
+func TestSignalReplaceable(t *testing.T) {
+	useTempDataDir(t)
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	assert.False(t, tx.Replaceable, "The transactions are not replaceable in default")
+
+	SignalReplaceable = true
+	defer func() {
+		SignalReplaceable = false
+	}()
+	tx, err = NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	assert.True(t, tx.Replaceable)
+	assert.True(t, chain.VerifyTx(tx), "The flag is signed")
+	split, err := NewSplitTx(wallet, 2, DefaultFeePerByte, &utxoSet)
+	assert.Nil(t, err)
+	assert.True(t, split.Replaceable)
+}
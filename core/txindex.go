@@ -0,0 +1,109 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`fmt`
+)
+
+// The bucket for the txid index. Key: TxId, Value: the hash of the block which packs that tx.
+const txIndexBucket = "TxIndex"
+
+// indexTxs adds all the transactions of block to the txid index. It is called by indexHeight, thus only the blocks
+// on the main chain are indexed.
+func indexTxs(tx StoreTx, block *Block) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(txIndexBucket))
+	if err != nil {
+		return err
+	}
+	for _, transaction := range block.Transactions {
+		if err := bucket.Put(transaction.Id, block.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildTxIndex drops the txid index and repopulates it from scratch with the transactions of all blocks on the
+// main chain, i.e., the blocks linked from the tip, and returns the number of the indexed transactions. It repairs
+// the index of chains created before it was introduced, or corrupted.
+func (chain *BlockChain) RebuildTxIndex() (int, error) {
+	numTxs := 0
+	err := chain.Db.Update(
+		func(tx StoreTx) error {
+			err := tx.DeleteBucket([]byte(txIndexBucket))
+			if err != nil && err != ErrBucketNotFound {
+				return err
+			}
+
+			// the blocks are walked in this db transaction, since the iterator opens another one
+			blocks := tx.Bucket([]byte(blocksBucket))
+			for hash := blocks.Get([]byte("l")); len(hash) != 0; {
+				encodedBlock := blocks.Get(hash)
+				if encodedBlock == nil {
+					return fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+				}
+				block := DeserializeBlock(encodedBlock)
+				if err := indexTxs(tx, block); err != nil {
+					return err
+				}
+				numTxs += len(block.Transactions)
+				hash = block.PrevBlockHash
+			}
+			return nil
+		})
+	if err != nil {
+		return 0, err
+	}
+	return numTxs, nil
+}
+
+// LookupTx returns the hash of the block which packs the Transaction whose Id is txId through the txid index. The
+// block left on a side branch by a fork does not count, i.e., ErrTxNotFound is returned unless the tx is packed on the
+// main chain.
+func (chain *BlockChain) LookupTx(txId []byte) ([]byte, error) {
+	var blockHash []byte
+	err := chain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(txIndexBucket))
+			if bucket == nil {
+				return ErrTxNotFound
+			}
+			value := bucket.Get(txId)
+			if value == nil {
+				return ErrTxNotFound
+			}
+			encodedBlock := tx.Bucket([]byte(blocksBucket)).Get(value)
+			if encodedBlock == nil || !onMainChain(tx, DeserializeBlock(encodedBlock)) {
+				return ErrTxNotFound
+			}
+			// the value is only valid during the db transaction, thus copy it
+			blockHash = append([]byte{}, value...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return blockHash, nil
+}
+
+// HasTx checks whether the Transaction whose Id is txId has already been packed into the main chain (see LookupTx).
+func (chain *BlockChain) HasTx(txId []byte) bool {
+	_, err := chain.LookupTx(txId)
+	return err == nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`encoding/hex`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+func TestRebuildTxIndex(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	for i := 0; i < 3; i++ {
+		height := chain.GetChainHeight() + 1
+		tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 10, &utxoSet)
+		assert.Nil(t, err)
+		coinbaseTx := NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+		utxoSet.Update(chain.MineBlock([]*Transaction{coinbaseTx, tx}))
+	}
+	expected := make(map[string][]byte)
+	_ = chain.EachTx(func(tx *Transaction, block *Block) error {
+		expected[hex.EncodeToString(tx.Id)] = block.Hash
+		return nil
+	})
+
+	// clear the index deliberately
+	err := chain.Db.Update(func(tx StoreTx) error {
+		return tx.DeleteBucket([]byte(txIndexBucket))
+	})
+	assert.Nil(t, err)
+	for txId := range expected {
+		id, _ := hex.DecodeString(txId)
+		_, err := chain.LookupTx(id)
+		assert.True(t, errors.Is(err, ErrTxNotFound))
+	}
+
+	numTxs, err := chain.RebuildTxIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected), numTxs, "Every transaction is indexed")
+	for txId, blockHash := range expected {
+		id, _ := hex.DecodeString(txId)
+		hash, err := chain.LookupTx(id)
+		assert.Nil(t, err)
+		assert.Equal(t, blockHash, hash, "Transaction %s is looked up in its block", txId)
+	}
+
+	// a corrupted entry is overwritten, and a stale one is dropped
+	var someTxId string
+	for someTxId = range expected {
+		break
+	}
+	id, _ := hex.DecodeString(someTxId)
+	err = chain.Db.Update(func(tx StoreTx) error {
+		bucket := tx.Bucket([]byte(txIndexBucket))
+		if err := bucket.Put(id, []byte("corrupted")); err != nil {
+			return err
+		}
+		return bucket.Put([]byte("stale"), chain.Tip)
+	})
+	assert.Nil(t, err)
+	numTxs, err = chain.RebuildTxIndex()
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected), numTxs)
+	hash, err := chain.LookupTx(id)
+	assert.Nil(t, err)
+	assert.Equal(t, expected[someTxId], hash)
+	assert.False(t, chain.HasTx([]byte("stale")))
+}
+
+func TestLookupTxOnFork(t *testing.T) {
+	chain, _ := newMemChain(t, DefaultGenesisConfig())
+	genesis := genesisOf(chain)
+
+	// the side branch is not higher than the main branch, thus its coinbase is not confirmed
+	main1 := forkBlock(genesis)
+	chain.AddBlock(main1)
+	side1 := forkBlock(genesis)
+	chain.AddBlock(side1)
+	assert.True(t, chain.HasTx(main1.Transactions[0].Id))
+	assert.False(t, chain.HasTx(side1.Transactions[0].Id), "The transaction on the side branch is not confirmed")
+	_, err := chain.BlockDepth(side1.Hash)
+	assert.True(t, errors.Is(err, ErrBlockNotFound), "The block on the side branch has no depth")
+
+	// the side branch overtakes the main branch
+	side2 := forkBlock(side1)
+	chain.AddBlock(side2)
+	_, err = chain.LookupTx(main1.Transactions[0].Id)
+	assert.True(t, errors.Is(err, ErrTxNotFound), "The transaction left by the fork is not confirmed")
+	hash, err := chain.LookupTx(side1.Transactions[0].Id)
+	assert.Nil(t, err)
+	assert.Equal(t, side1.Hash, hash)
+	depth, err := chain.BlockDepth(side1.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, depth)
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the partial signing workflow for offline (cold) wallets: the online node builds an UnsignedTx
+// with BlockChain.BuildUnsignedTx, the UnsignedTx is carried across the air gap in serialized form, and the offline
+// wallet signs it with SignUnsignedTx, which requires no access to the chain.
+
+package core
+
+import (
+	`bytes`
+	`crypto/ecdsa`
+	`encoding/gob`
+	`lightChain/utils`
+	`log`
+)
+
+// UnsignedTx is a partially signed transaction. Tx has populated inputs and outputs but nil signatures. PrevTxs
+// contains every transaction pointed by the inputs of Tx, which is all the signer needs to know about the chain.
+type UnsignedTx struct {
+	Tx      Transaction
+	PrevTxs map[string]Transaction // {key: hex-encoded Id of the previous tx, value: the previous tx itself}
+}
+
+// BuildUnsignedTx builds an UnsignedTx which sends amount of coins from the owner of senderPubKey to dstAddr.
+// Only the public key of sender is required, thus the private key can be kept offline.
+func (chain *BlockChain) BuildUnsignedTx(senderPubKey []byte, dstAddr string, amount float64) (*UnsignedTx, error) {
+	utxoSet := UTXOSet{BlockChain: chain}
+	tx, err := newUnsignedUTXOTx(senderPubKey, dstAddr, amount, FirstFit, &utxoSet)
+	if err != nil {
+		return nil, err
+	}
+	return &UnsignedTx{Tx: *tx, PrevTxs: chain.getPrevTxs(tx)}, nil
+}
+
+// SignUnsignedTx signs all the inputs of utx with privateKey and returns the signed transaction. It does not touch
+// the chain, thus can be called on an offline wallet.
+func SignUnsignedTx(utx *UnsignedTx, privateKey ecdsa.PrivateKey) *Transaction {
+	tx := utx.Tx
+	tx.Vin = append([]TxInput{}, utx.Tx.Vin...)
+	tx.Sign(privateKey, utx.PrevTxs)
+	return &tx
+}
+
+// SerializeUnsignedTx converts the content of utx into a serialized byte slice.
+func (utx UnsignedTx) SerializeUnsignedTx() []byte {
+	return utils.GobEncode(utx)
+}
+
+// DeserializeUnsignedTx converts a serialized byte slice into an UnsignedTx instance.
+func DeserializeUnsignedTx(data []byte) UnsignedTx {
+	var utx UnsignedTx
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&utx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return utx
+}
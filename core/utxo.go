@@ -18,12 +18,21 @@ package core
 
 import (
 	`encoding/hex`
-	`github.com/boltdb/bolt`
+	`fmt`
 	`log`
+	`reflect`
+	`sort`
 )
 
-// The bucket for store utxo. Key: TxId, Value: Unspent outputs in that tx.
-const utxoBucket = "ChainState"
+const (
+	// The bucket for store utxo. Key: TxId, Value: Unspent outputs in that tx.
+	utxoBucket = "ChainState"
+	// The bucket for the metadata of the utxo set. Key: utxoDirtyKey, Value: any non-nil value if the set is dirty;
+	// Key: utxoTipKey, Value: the hash of the block which the set is up to date with.
+	utxoMetaBucket = "ChainStateMeta"
+	utxoDirtyKey   = "dirty"
+	utxoTipKey     = "tip"
+)
 
 type UTXOSet struct {
 	BlockChain *BlockChain
@@ -31,36 +40,10 @@ type UTXOSet struct {
 
 // FindSpendableOutputs returns the coin quantity (the sum of legal output's value) and the corresponding slice of
 // unspent transactions' outputs (UTXO) for the owner of pubKeyHash, where the coin quantity is expected to not less
-// than amount. Since all utxos are stored in db when new tx is created, we just directly read them from db.
+// than amount. Since all utxos are stored in db when new tx is created, we just directly read them from db. The outputs
+// are selected with FirstFit, see FindSpendableOutputsStrategy for the other strategies.
 func (utxoSet UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount float64) (float64, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-	accumulated := 0.0
-	db := utxoSet.BlockChain.Db
-
-	err := db.View(
-		func(tx *bolt.Tx) error {
-			bucket := tx.Bucket([]byte(utxoBucket))
-			cursor := bucket.Cursor()
-
-			// get txOutputs of each tx
-			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
-				txId := hex.EncodeToString(key)
-				txOutputs := DeserializeOutputs(value)
-
-				for txOutputIdx, txOutput := range txOutputs.Outputs {
-					if txOutput.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-						accumulated += txOutput.Value
-						unspentOutputs[txId] = append(unspentOutputs[txId], txOutputIdx)
-					}
-				}
-			}
-			return nil
-		})
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return accumulated, unspentOutputs
+	return utxoSet.FindSpendableOutputsStrategy(pubKeyHash, amount, FirstFit)
 }
 
 // FindUTXO returns the UTXO for the owner of pubKeyHash. Since all utxos are stored in db when new tx is created,
@@ -70,7 +53,7 @@ func (utxoSet UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 	db := utxoSet.BlockChain.Db
 
 	err := db.View(
-		func(tx *bolt.Tx) error {
+		func(tx StoreTx) error {
 			bucket := tx.Bucket([]byte(utxoBucket))
 			cursor := bucket.Cursor()
 
@@ -92,13 +75,22 @@ func (utxoSet UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 	return utxo
 }
 
+// Balance returns the sum of the UTXO values for the owner of pubKeyHash.
+func (utxoSet UTXOSet) Balance(pubKeyHash []byte) float64 {
+	balance := 0.0
+	for _, txOutput := range utxoSet.FindUTXO(pubKeyHash) {
+		balance += txOutput.Value
+	}
+	return balance
+}
+
 // CountTxs returns the number of Transaction in the UTXO set of current lightChain.
 func (utxoSet UTXOSet) CountTxs() int {
 	counter := 0
 	db := utxoSet.BlockChain.Db
 
 	err := db.View(
-		func(tx *bolt.Tx) error {
+		func(tx StoreTx) error {
 			bucket := tx.Bucket([]byte(utxoBucket))
 			cursor := bucket.Cursor()
 
@@ -114,15 +106,248 @@ func (utxoSet UTXOSet) CountTxs() int {
 	return counter
 }
 
+// Stats returns the number of Transaction, the number of unspent outputs, and the total value of them in the UTXO set
+// of current lightChain. The UTXO set is cursored only once.
+func (utxoSet UTXOSet) Stats() (numTxs, numOutputs int, totalValue float64) {
+	db := utxoSet.BlockChain.Db
+
+	err := db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoBucket))
+			cursor := bucket.Cursor()
+
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				numTxs++
+				for _, txOutput := range DeserializeOutputs(value).Outputs {
+					numOutputs++
+					totalValue += txOutput.Value
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return numTxs, numOutputs, totalValue
+}
+
+// AddressBalance is the balance of the owner of PubKeyHash in the UTXO set. Address is PubKeyHash re-encoded in
+// base58check (see PubKeyHashAddr).
+type AddressBalance struct {
+	PubKeyHash []byte
+	Address    string
+	Balance    float64
+}
+
+// RichList returns the top n owners by balance in the UTXO set, from the richest. The unspent outputs are grouped by
+// their pubKeyHash, thus the UTXO set is cursored only once. Owners with the same balance are ordered by pubKeyHash.
+// All the owners with a nonzero balance are returned if n <= 0.
+func (utxoSet UTXOSet) RichList(n int) []AddressBalance {
+	balances := make(map[string]float64)
+	err := utxoSet.BlockChain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoBucket))
+			cursor := bucket.Cursor()
+
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				for _, txOutput := range DeserializeOutputs(value).Outputs {
+					balances[string(txOutput.PubKeyHash)] += txOutput.Value
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var richList []AddressBalance
+	for pubKeyHash, balance := range balances {
+		if balance <= 0 {
+			continue
+		}
+		richList = append(richList, AddressBalance{
+			PubKeyHash: []byte(pubKeyHash),
+			Address:    string(PubKeyHashAddr([]byte(pubKeyHash))),
+			Balance:    balance,
+		})
+	}
+	sort.Slice(richList, func(i, j int) bool {
+		if richList[i].Balance != richList[j].Balance {
+			return richList[i].Balance > richList[j].Balance
+		}
+		return string(richList[i].PubKeyHash) < string(richList[j].PubKeyHash)
+	})
+	if n > 0 && len(richList) > n {
+		richList = richList[:n]
+	}
+	return richList
+}
+
+// Audit cross-checks every entry of the UTXO set against chain: the transaction of the entry is on chain, and the
+// outputs of the entry are exactly its unspent outputs (found by BlockChain.FindUTXO). The transactions with unspent
+// outputs but no entry are reported as well. Each discrepancy is reported as an error wrapping ErrStaleUTXO, and
+// nothing is fixed (see Rebuild). It is a diagnostic for the incremental Update.
+func (utxoSet UTXOSet) Audit() []error {
+	entries := make(map[string]TxOutputs)
+	err := utxoSet.BlockChain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoBucket))
+			cursor := bucket.Cursor()
+
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				entries[hex.EncodeToString(key)] = DeserializeOutputs(value)
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var errs []error
+	unspent := utxoSet.BlockChain.FindUTXO()
+	for txId, txOutputs := range entries {
+		key, _ := hex.DecodeString(txId)
+		if !utxoSet.BlockChain.HasTx(key) {
+			errs = append(errs, fmt.Errorf("%w: tx %s is not on chain", ErrStaleUTXO, txId))
+			continue
+		}
+		expected, ok := unspent[txId]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: all the outputs of tx %s are spent", ErrStaleUTXO, txId))
+			continue
+		}
+		if !reflect.DeepEqual(txOutputs.Outputs, expected.Outputs) || !sameIndices(txOutputs, expected) {
+			errs = append(errs, fmt.Errorf("%w: tx %s has outputs %v, but the unspent ones on chain are %v",
+				ErrStaleUTXO, txId, txOutputs.Outputs, expected.Outputs))
+		}
+	}
+	for txId := range unspent {
+		if _, ok := entries[txId]; !ok {
+			errs = append(errs, fmt.Errorf("%w: tx %s has unspent outputs but no entry", ErrStaleUTXO, txId))
+		}
+	}
+	return errs
+}
+
+// sameIndices checks whether the outputs of a and b have the same indices in their transaction.
+func sameIndices(a, b TxOutputs) bool {
+	if len(a.Outputs) != len(b.Outputs) {
+		return false
+	}
+	for i := range a.Outputs {
+		if a.OutputIdx(i) != b.OutputIdx(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsDirty reports whether a Rebuild or an Update of the UTXO set was interrupted (e.g., by an unclean shutdown), in
+// which case the set may be partially written, or a new tip is stored and the set is not updated to it yet.
+func (utxoSet UTXOSet) IsDirty() bool {
+	dirty := false
+	err := utxoSet.BlockChain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoMetaBucket))
+			dirty = bucket != nil && bucket.Get([]byte(utxoDirtyKey)) != nil
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+	return dirty
+}
+
+// hasLegacyEntries reports whether some entry of the UTXO set is written before TxOutputs.Indices exists. The outputs
+// of such an entry are misread at the shifted indices once some of them are spent, thus the set has to be rebuilt.
+func (utxoSet UTXOSet) hasLegacyEntries() bool {
+	legacy := false
+	err := utxoSet.BlockChain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoBucket))
+			if bucket == nil {
+				return nil
+			}
+			cursor := bucket.Cursor()
+			for key, value := cursor.First(); key != nil && !legacy; key, value = cursor.Next() {
+				txOutputs := DeserializeOutputs(value)
+				legacy = len(txOutputs.Outputs) > 0 && txOutputs.Indices == nil
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+	return legacy
+}
+
+// TipHash returns the hash of the block which the UTXO set is up to date with, i.e., the last block it is rebuilt or
+// updated to, or nil if it is unknown (e.g., the set is written by an older version). The set lags behind the tip of
+// the chain during a download (see network.handleBlock).
+func (utxoSet UTXOSet) TipHash() []byte {
+	var tip []byte
+	err := utxoSet.BlockChain.Db.View(
+		func(tx StoreTx) error {
+			if bucket := tx.Bucket([]byte(utxoMetaBucket)); bucket != nil {
+				tip = append([]byte{}, bucket.Get([]byte(utxoTipKey))...)
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(tip) == 0 {
+		return nil
+	}
+	return tip
+}
+
+// putTipHash records in tx that the UTXO set is up to date with the block of hash (see TipHash).
+func putTipHash(tx StoreTx, hash []byte) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(utxoMetaBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(utxoTipKey), hash)
+}
+
+// setDirty sets (or clears) the dirty flag of the UTXO set. The flag is set before writing the set, and cleared only
+// after the writing succeeds, thus it survives an interrupted writing.
+func (utxoSet UTXOSet) setDirty(dirty bool) {
+	err := utxoSet.BlockChain.Db.Update(
+		func(tx StoreTx) error {
+			return putDirty(tx, dirty)
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// putDirty sets (or clears) the dirty flag of the UTXO set in tx. The flag is also set in the db transaction which
+// moves the tip of the chain, since the set is stale until it is updated to the new tip, even if the node crashes
+// right after the block is stored.
+func putDirty(tx StoreTx, dirty bool) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(utxoMetaBucket))
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return bucket.Put([]byte(utxoDirtyKey), []byte{1})
+	}
+	return bucket.Delete([]byte(utxoDirtyKey))
+}
+
 // Rebuild rebuilds the UTXO set according to current status of lightChain.
 func (utxoSet UTXOSet) Rebuild() {
 	db := utxoSet.BlockChain.Db
+	utxoSet.setDirty(true)
 
 	// delete the old utxo bucket and create a brand new one
 	err := db.Update(
-		func(tx *bolt.Tx) error {
+		func(tx StoreTx) error {
 			err := tx.DeleteBucket([]byte(utxoBucket))
-			if err != nil && err != bolt.ErrBucketNotFound {
+			if err != nil && err != ErrBucketNotFound {
 				log.Panic(err)
 			}
 
@@ -137,10 +362,14 @@ func (utxoSet UTXOSet) Rebuild() {
 	}
 
 	// call BlockChain.FindUTXO to get the new utxo set, and save the content of it into the newly created bucket
+	tip := utxoSet.BlockChain.TipHash()
 	newUtxo := utxoSet.BlockChain.FindUTXO()
 	err = db.Update(
-		func(tx *bolt.Tx) error {
+		func(tx StoreTx) error {
 			bucket := tx.Bucket([]byte(utxoBucket))
+			if err := putTipHash(tx, tip); err != nil {
+				log.Panic(err)
+			}
 
 			for txId, txOutputs := range newUtxo {
 				key, err := hex.DecodeString(txId)
@@ -157,64 +386,71 @@ func (utxoSet UTXOSet) Rebuild() {
 	if err != nil {
 		log.Panic(err)
 	}
+	utxoSet.setDirty(false)
 }
 
 // Update updates the utxo set according to the newly mined block. Here block must be the tip block of lightChain.
 // For this reason, we just need to check each input of the pointed beforehand txs.
 func (utxoSet UTXOSet) Update(block *Block) {
 	db := utxoSet.BlockChain.Db
+	utxoSet.setDirty(true)
 
 	err := db.Update(
-		func(tx *bolt.Tx) error {
-			bucket := tx.Bucket([]byte(utxoBucket))
+		func(tx StoreTx) error {
+			applyBlock(tx.Bucket([]byte(utxoBucket)), block)
+			return putTipHash(tx, block.Hash)
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+	utxoSet.setDirty(false)
+}
 
-			// according to the inputs of each tx in this block, find the beforehand txs whose outputs are the inputs of this tx.
-			// for those beforehand txs, add their not spent-out outputs to utxo (if exist)
-			for _, tx := range block.Transactions {
-				if !tx.IsCoinbaseTx() {
-					for _, vin := range tx.Vin {
-						updatedOutputs := TxOutputs{}
-						outs := DeserializeOutputs(bucket.Get(vin.TxId))
-						for outIdx, out := range outs.Outputs {
-							// note that an output can never be pointed by multiple inputs!
-							// Thus, if outIdx is not vin.VoutIdx, outIdx is not pointed by any vin. Thus this out is unspent
-							if outIdx != vin.VoutIdx {
-								// out is not spent out in this newly mined block, add it to utxo
-								updatedOutputs.Outputs = append(updatedOutputs.Outputs, out)
-							}
-						}
-						// when rebuild utxo, we allocate a k-v pair for every tx
-						// if some tx's outputs are all been spent out, just remove the corresponding k-v pair
-						if len(updatedOutputs.Outputs) == 0 {
-							err := bucket.Delete(vin.TxId)
-							if err != nil {
-								log.Panic(err)
-							}
-						} else {
-							// otherwise, just update k-v pair
-							err := bucket.Put(vin.TxId, updatedOutputs.SerializeOutputs())
-							if err != nil {
-								log.Panic(err)
-							}
-						}
+// applyBlock updates the utxo bucket according to block, which follows the block the bucket is up to date with. It
+// is shared by Update and ImportSnapshotWithStore.
+func applyBlock(bucket StoreBucket, block *Block) {
+	// according to the inputs of each tx in this block, find the beforehand txs whose outputs are the inputs of this tx.
+	// for those beforehand txs, add their not spent-out outputs to utxo (if exist)
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbaseTx() {
+			for _, vin := range tx.Vin {
+				updatedOutputs := TxOutputs{}
+				outs := DeserializeOutputs(bucket.Get(vin.TxId))
+				for i, out := range outs.Outputs {
+					// note that an output can never be pointed by multiple inputs!
+					// Thus, if outIdx is not vin.VoutIdx, outIdx is not pointed by any vin. Thus this out is unspent
+					// the index in the tx is kept, since the outputs spent before are removed
+					if outIdx := outs.OutputIdx(i); outIdx != vin.VoutIdx {
+						// out is not spent out in this newly mined block, add it to utxo
+						updatedOutputs.add(out, outIdx)
 					}
 				}
-
-				// of course all the outputs in the newly packed tx are unspent out, just add them to utxo
-				newOutputs := TxOutputs{}
-				for _, out := range tx.Vout {
-					newOutputs.Outputs = append(newOutputs.Outputs, out)
-				}
-
-				err := bucket.Put(tx.Id, newOutputs.SerializeOutputs())
-				if err != nil {
-					log.Panic(err)
+				// when rebuild utxo, we allocate a k-v pair for every tx
+				// if some tx's outputs are all been spent out, just remove the corresponding k-v pair
+				if len(updatedOutputs.Outputs) == 0 {
+					err := bucket.Delete(vin.TxId)
+					if err != nil {
+						log.Panic(err)
+					}
+				} else {
+					// otherwise, just update k-v pair
+					err := bucket.Put(vin.TxId, updatedOutputs.SerializeOutputs())
+					if err != nil {
+						log.Panic(err)
+					}
 				}
 			}
+		}
 
-			return nil
-		})
-	if err != nil {
-		log.Panic(err)
+		// of course all the outputs in the newly packed tx are unspent out, just add them to utxo
+		newOutputs := TxOutputs{}
+		for outIdx, out := range tx.Vout {
+			newOutputs.add(out, outIdx)
+		}
+
+		err := bucket.Put(tx.Id, newOutputs.SerializeOutputs())
+		if err != nil {
+			log.Panic(err)
+		}
 	}
 }
@@ -0,0 +1,283 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`bytes`
+	`encoding/hex`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+func TestUTXOSetStats(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	numTxs, numOutputs, totalValue := utxoSet.Stats()
+	assert.Equal(t, 1, numTxs)
+	assert.Equal(t, 1, numOutputs)
+	assert.Equal(t, initCoinbaseReward, totalValue)
+
+	// the genesis output is spent into a payment and a change, and a new coinbase is mined
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 100, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(NewWallet().GetAddr()), tx)
+
+	numTxs, numOutputs, totalValue = utxoSet.Stats()
+	assert.Equal(t, 2, numTxs)
+	assert.Equal(t, utxoSet.CountTxs(), numTxs)
+	assert.Equal(t, 3, numOutputs)
+	assert.Equal(t, 2*initCoinbaseReward, totalValue)
+	assert.Equal(t, chain.TotalSupply(), totalValue)
+}
+
+func TestUTXOSetRichList(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	// the genesis output is spent into 600 coins to receiver and a change, and the reward goes to miner
+	receiver, miner := NewWallet(), NewWallet()
+	tx, err := NewUTXOTx(wallet, string(receiver.GetAddr()), 600, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(miner.GetAddr()), tx)
+
+	richList := utxoSet.RichList(0)
+	assert.Equal(t, 3, len(richList), "All the owners are listed if n <= 0")
+	for _, owner := range []*Wallet{wallet, receiver, miner} {
+		pubKeyHash := HashingPubKey(owner.PubKey)
+		found := false
+		for _, entry := range richList {
+			if bytes.Equal(entry.PubKeyHash, pubKeyHash) {
+				found = true
+				assert.Equal(t, string(owner.GetAddr()), entry.Address, "The pubKeyHash is re-encoded to the address")
+				assert.Equal(t, utxoSet.Balance(pubKeyHash), entry.Balance)
+			}
+		}
+		assert.True(t, found)
+	}
+	for i := 1; i < len(richList); i++ {
+		assert.GreaterOrEqual(t, richList[i-1].Balance, richList[i].Balance, "The richest comes first")
+	}
+	var ranking []string
+	for _, entry := range richList {
+		ranking = append(ranking, entry.Address)
+	}
+	assert.Equal(t, []string{string(miner.GetAddr()), string(receiver.GetAddr()), string(wallet.GetAddr())}, ranking)
+	assert.Equal(t, initCoinbaseReward, richList[0].Balance)
+	assert.Equal(t, 600.0, richList[1].Balance)
+
+	assert.Equal(t, richList[:2], utxoSet.RichList(2), "The top 2 owners are returned")
+	assert.Equal(t, richList, utxoSet.RichList(10))
+}
+
+// crashStore simulates an unclean shutdown: the read-write transactions fail once updatesLeft ones have been
+// committed.
+type crashStore struct {
+	Store
+	updatesLeft int
+}
+
+func (store *crashStore) Update(fn func(tx StoreTx) error) error {
+	if store.updatesLeft == 0 {
+		return errors.New("crashed")
+	}
+	store.updatesLeft--
+	return store.Store.Update(fn)
+}
+
+func TestUTXOSetDirtyAfterCrash(t *testing.T) {
+	for _, crashed := range []string{"Update", "Rebuild"} {
+		store := NewMemStore()
+		addr := string(NewWallet().GetAddr())
+		chain, _ := CreateBlockChainWithStore(store, addr, DefaultGenesisConfig())
+		utxoSet := UTXOSet{BlockChain: chain}
+		utxoSet.Rebuild()
+		assert.False(t, utxoSet.IsDirty(), "The completed rebuild leaves the set clean")
+
+		height := chain.GetChainHeight() + 1
+		block := chain.MineBlock([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)})
+
+		// crash right after the dirty flag is written
+		chain.Db = &crashStore{Store: store, updatesLeft: 1}
+		assert.Panics(t, func() {
+			if crashed == "Update" {
+				utxoSet.Update(block)
+			} else {
+				utxoSet.Rebuild()
+			}
+		}, crashed)
+		chain.Db = store
+		assert.True(t, utxoSet.IsDirty(), "The interrupted %s leaves the set dirty", crashed)
+		_, _, totalValue := utxoSet.Stats()
+		assert.NotEqual(t, chain.IssuedSupply(), totalValue, "The set is stale")
+
+		// the next startup rebuilds the set
+		reopened, err := NewBlockChainWithStore(store)
+		assert.Nil(t, err)
+		reopenedUTXOSet := UTXOSet{BlockChain: reopened}
+		assert.False(t, reopenedUTXOSet.IsDirty())
+		numTxs, _, totalValue := reopenedUTXOSet.Stats()
+		assert.Equal(t, 2, numTxs)
+		assert.Equal(t, reopened.IssuedSupply(), totalValue, "The rebuilt set is correct")
+	}
+}
+
+func TestUTXOSetDirtyAfterNewTip(t *testing.T) {
+	store := NewMemStore()
+	addr := string(NewWallet().GetAddr())
+	chain, _ := CreateBlockChainWithStore(store, addr, DefaultGenesisConfig())
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	// crash right after the block is stored, before the set is updated
+	height := chain.GetChainHeight() + 1
+	chain.MineBlock([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)})
+	assert.True(t, utxoSet.IsDirty(), "The set is stale once the tip moves")
+
+	reopened, err := NewBlockChainWithStore(store)
+	assert.Nil(t, err)
+	reopenedUTXOSet := UTXOSet{BlockChain: reopened}
+	assert.False(t, reopenedUTXOSet.IsDirty())
+	_, _, totalValue := reopenedUTXOSet.Stats()
+	assert.Equal(t, reopened.IssuedSupply(), totalValue, "The rebuilt set includes the new tip")
+}
+
+func TestUTXOSetCleanAfterUpdate(t *testing.T) {
+	store := NewMemStore()
+	addr := string(NewWallet().GetAddr())
+	chain, _ := CreateBlockChainWithStore(store, addr, DefaultGenesisConfig())
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	mineTxs(chain, addr)
+	assert.False(t, utxoSet.IsDirty(), "The completed update leaves the set clean")
+}
+
+func TestUTXOSetAudit(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	genesisTx := genesisOf(chain).Transactions[0]
+	tx, err := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 100, &utxoSet)
+	assert.Nil(t, err)
+	coinbaseTx := mineTxs(chain, string(NewWallet().GetAddr()), tx).Transactions[0]
+	assert.Empty(t, utxoSet.Audit(), "The UTXO set updated incrementally audits clean")
+	utxoSet.Rebuild()
+	assert.Empty(t, utxoSet.Audit(), "The rebuilt UTXO set audits clean")
+
+	// corrupt the set: an entry of a nonexistent tx, an entry of a spent tx, a missing entry, and a partial entry
+	fakeTxId := []byte("nonexistent tx")
+	err = chain.Db.Update(func(dbTx StoreTx) error {
+		bucket := dbTx.Bucket([]byte(utxoBucket))
+		assert.Nil(t, bucket.Put(fakeTxId, TxOutputs{Outputs: tx.Vout}.SerializeOutputs()))
+		assert.Nil(t, bucket.Put(genesisTx.Id, TxOutputs{Outputs: genesisTx.Vout}.SerializeOutputs()))
+		assert.Nil(t, bucket.Delete(coinbaseTx.Id))
+		assert.Nil(t, bucket.Put(tx.Id, TxOutputs{Outputs: tx.Vout[:1]}.SerializeOutputs()))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	errs := utxoSet.Audit()
+	assert.Equal(t, 4, len(errs), "Each stale entry is reported")
+	reported := ""
+	for _, err := range errs {
+		assert.True(t, errors.Is(err, ErrStaleUTXO))
+		reported += err.Error() + "\n"
+	}
+	for _, txId := range [][]byte{fakeTxId, genesisTx.Id, coinbaseTx.Id, tx.Id} {
+		assert.Contains(t, reported, hex.EncodeToString(txId))
+	}
+	assert.Equal(t, 4, len(utxoSet.Audit()), "Nothing is fixed by the audit")
+}
+
+func TestUTXOKeepsOutputIndices(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	miner := string(NewWallet().GetAddr())
+	mine := func(tx *Transaction) {
+		assert.Nil(t, chain.ValidateBlock(mineTxs(chain, miner, tx)))
+	}
+
+	// the receiver spends the output 0, thus only the change (the output 1) of tx is left
+	receiver := NewWallet()
+	tx, err := NewUTXOTx(wallet, string(receiver.GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mine(tx)
+	spending, err := NewUTXOTx(receiver, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mine(spending)
+	txOutputs, ok := utxoSet.outputsOf(tx.Id)
+	assert.True(t, ok)
+	assert.Equal(t, []int{1}, txOutputs.Indices, "The index of the change is kept")
+	assert.Empty(t, utxoSet.Audit())
+
+	// the change is spendable at its index
+	spending, err = NewUTXOTx(wallet, string(NewWallet().GetAddr()), 1, &utxoSet)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, spending.Vin[0].VoutIdx)
+	assert.True(t, chain.VerifyTx(spending))
+	mine(spending)
+	_, ok = utxoSet.outputsOf(tx.Id)
+	assert.False(t, ok, "All the outputs of tx are spent")
+	assert.Equal(t, chain.IssuedSupply(), chain.TotalSupply())
+	utxoSet.Rebuild()
+	assert.Empty(t, utxoSet.Audit())
+}
+
+func TestUTXORebuildsLegacyEntries(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	miner := string(NewWallet().GetAddr())
+	receiver := NewWallet()
+	tx, err := NewUTXOTx(wallet, string(receiver.GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, miner, tx)
+	spending, err := NewUTXOTx(receiver, string(NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, miner, spending)
+
+	// the entry written by an older version keeps the change only, without its index
+	err = chain.Db.Update(func(dbTx StoreTx) error {
+		return dbTx.Bucket([]byte(utxoBucket)).Put(tx.Id, TxOutputs{Outputs: tx.Vout[1:]}.SerializeOutputs())
+	})
+	assert.Nil(t, err)
+	txOutputs, _ := utxoSet.outputsOf(tx.Id)
+	_, found := txOutputs.Find(1)
+	assert.False(t, found, "The change is misread at the index 0")
+
+	reopened, err := NewBlockChainWithStore(chain.Db)
+	assert.Nil(t, err)
+	reopenedUTXOSet := UTXOSet{BlockChain: reopened}
+	txOutputs, _ = reopenedUTXOSet.outputsOf(tx.Id)
+	assert.Equal(t, []int{1}, txOutputs.Indices, "The set is rebuilt when the chain is opened")
+	assert.Empty(t, reopenedUTXOSet.Audit())
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file validates the transactions of a block against a UTXO set alone (e.g., imported from a snapshot), thus a
+// node which has not synced the full chain can still validate the blocks it relays.
+
+package core
+
+import (
+	`bytes`
+	`encoding/hex`
+	`errors`
+	`fmt`
+	`log`
+)
+
+// outputsOf returns the unspent outputs of the transaction whose Id is txId, and whether it has any.
+func (utxoSet UTXOSet) outputsOf(txId []byte) (TxOutputs, bool) {
+	var txOutputs TxOutputs
+	found := false
+	err := utxoSet.BlockChain.Db.View(
+		func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoBucket))
+			if bucket == nil {
+				return ErrBucketNotFound
+			}
+			if value := bucket.Get(txId); value != nil {
+				txOutputs, found = DeserializeOutputs(value), true
+			}
+			return nil
+		})
+	if err != nil {
+		log.Panic(err)
+	}
+	return txOutputs, found
+}
+
+// ValidateBlockAgainstUTXO checks the transactions of block against utxo, without walking any chain: each input spends
+// an output which is in utxo (or created by a preceding transaction of block) and not spent by another input of block,
+// the public key of each input is the owner of the output, the signatures are valid, each output is positive, no
+// transaction spends more than its inputs, and the only coinbase transaction claims at most the reward (decided by
+// utxo.BlockChain.Config) plus the fees. utxo should be the set right before block. Unlike ValidateBlock, neither the
+// PoW nor the nonces are checked, and the locktimes are compared with the block time if the blocks preceding block are
+// not stored with utxo. The returned error wraps ErrInvalidBlock.
+func ValidateBlockAgainstUTXO(block *Block, utxo *UTXOSet) error {
+	if err := validateBlockAgainstUTXO(block, utxo); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlock, err)
+	}
+	return nil
+}
+
+// validateBlockAgainstUTXO does the checks of ValidateBlockAgainstUTXO.
+func validateBlockAgainstUTXO(block *Block, utxo *UTXOSet) error {
+	// the outputs created and spent by the preceding transactions of block
+	created := make(map[string]TxOutputs)
+	spent := make(map[string]bool)
+
+	var coinbaseTx *Transaction
+	var jobs []sigJob
+	fees := 0.0
+	// a set imported from a snapshot has no blocks to take the median time past from
+	medianTime := block.TimeStamp
+	if _, err := utxo.BlockChain.GetBlock(block.PrevBlockHash); err == nil {
+		medianTime = utxo.BlockChain.medianTimePastBefore(block)
+	}
+	for _, tx := range block.Transactions {
+		if err := tx.CheckVersion(); err != nil {
+			return err
+		}
+		if tx.IsCoinbaseTx() {
+			if coinbaseTx != nil {
+				return errors.New("block packs more than one coinbase transaction")
+			}
+			coinbaseTx = tx
+		} else {
+			if err := tx.CheckLimits(); err != nil {
+				return err
+			}
+			if !tx.IsFinal(medianTime) {
+				return fmt.Errorf("transaction %x is locked until %d, later than the median time past %d", tx.Id,
+					tx.LockTime, medianTime)
+			}
+
+			// the previous transactions only carry the spent outputs, which is all tx.Verify reads
+			prevTxs := make(map[string]Transaction)
+			inputValue, outputValue := 0.0, 0.0
+			for _, txInput := range tx.Vin {
+				prevTxId := hex.EncodeToString(txInput.TxId)
+				outpoint := fmt.Sprintf("%s:%d", prevTxId, txInput.VoutIdx)
+				if spent[outpoint] {
+					return fmt.Errorf("transaction %x double-spends the output %s", tx.Id, outpoint)
+				}
+				txOutputs, ok := created[prevTxId]
+				if !ok {
+					txOutputs, ok = utxo.outputsOf(txInput.TxId)
+				}
+				txOutput, found := txOutputs.Find(txInput.VoutIdx)
+				if !ok || !found {
+					return fmt.Errorf("input of transaction %x spends the output %s which is not in the UTXO set",
+						tx.Id, outpoint)
+				}
+				if !bytes.Equal(HashingPubKey(txInput.PubKey), txOutput.PubKeyHash) {
+					return fmt.Errorf("input of transaction %x spends the output %s of another owner", tx.Id, outpoint)
+				}
+				spent[outpoint] = true
+
+				prevTx := prevTxs[prevTxId]
+				prevTx.Id = txInput.TxId
+				for len(prevTx.Vout) <= txInput.VoutIdx {
+					prevTx.Vout = append(prevTx.Vout, TxOutput{})
+				}
+				prevTx.Vout[txInput.VoutIdx] = txOutput
+				prevTxs[prevTxId] = prevTx
+				inputValue += txOutput.Value
+			}
+			for _, txOutput := range tx.Vout {
+				// otherwise a negative output would pay for the excess of the others
+				if txOutput.Value <= 0 {
+					return fmt.Errorf("transaction %x has a non-positive output %f", tx.Id, txOutput.Value)
+				}
+				outputValue += txOutput.Value
+			}
+			if outputValue > inputValue+valueTolerance {
+				return fmt.Errorf("transaction %x spends more than its inputs", tx.Id)
+			}
+			jobs = append(jobs, sigJob{tx, prevTxs, block.Height})
+			fees += inputValue - outputValue
+		}
+
+		txOutputs := TxOutputs{}
+		for outIdx, txOutput := range tx.Vout {
+			txOutputs.add(txOutput, outIdx)
+		}
+		created[hex.EncodeToString(tx.Id)] = txOutputs
+	}
+	if idx := verifySignatures(jobs, VerifyWorkers); idx >= 0 {
+		return fmt.Errorf("transaction %x has invalid signature", jobs[idx].tx.Id)
+	}
+
+	if coinbaseTx == nil {
+		return errors.New("block packs no coinbase transaction")
+	}
+	return ValidateCoinbase(coinbaseTx, utxo.BlockChain.CurrentReward(block.Height)+fees, block.Height)
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`path/filepath`
+	`strings`
+	`testing`
+)
+
+func TestValidateBlockAgainstUTXO(t *testing.T) {
+	dataDir := useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	addr := string(wallet.GetAddr())
+	receiver := NewWallet()
+	nextBlock := func(txs ...*Transaction) *Block {
+		height := chain.GetChainHeight() + 1
+		coinbaseTx := NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)
+		return NewBlock(append([]*Transaction{coinbaseTx}, txs...), chain.Tip, height)
+	}
+
+	// spend an output, and the change of it in the same block
+	tx, err := NewUTXOTx(wallet, string(receiver.GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	block := nextBlock(tx, spendChange(wallet, tx, string(receiver.GetAddr()), 5))
+	assert.Nil(t, ValidateBlockAgainstUTXO(block, &utxoSet))
+
+	// validate against a snapshot of the set, without the chain
+	path := filepath.Join(dataDir, "utxo.snapshot")
+	assert.Nil(t, utxoSet.ExportSnapshot(path))
+	store := copyChain(t, chain)
+	_, err = ImportSnapshotWithStore(path, store)
+	assert.Nil(t, err)
+	snapshot := UTXOSet{BlockChain: &BlockChain{Db: store, Config: chain.Config}}
+	assert.Nil(t, ValidateBlockAgainstUTXO(block, &snapshot), "The block is valid against the matching snapshot")
+
+	// the outputs spent by the block are gone after it is mined
+	utxoSet.Update(chain.MineBlock(block.Transactions))
+	err = ValidateBlockAgainstUTXO(nextBlock(tx), &utxoSet)
+	assert.True(t, errors.Is(err, ErrInvalidBlock), "The double-spending block is rejected")
+	assert.True(t, strings.Contains(err.Error(), "not in the UTXO set"), err.Error())
+
+	// double-spending inside a block
+	tx, err = NewUTXOTx(wallet, string(receiver.GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	doubleSpend := tx.Copy()
+	doubleSpend.Vin = tx.Vin
+	doubleSpend.Vout = []TxOutput{*NewTxOutput(1, addr)}
+	doubleSpend.Id = doubleSpend.Hashing()
+	chain.SignTx(&doubleSpend, wallet.PrivateKey)
+	err = ValidateBlockAgainstUTXO(nextBlock(tx, &doubleSpend), &utxoSet)
+	assert.True(t, strings.Contains(err.Error(), "double-spends"), err.Error())
+
+	// the output is spent with the key of another owner
+	thief := NewWallet()
+	stolen := tx.Copy()
+	for i := range stolen.Vin {
+		stolen.Vin[i].PubKey = thief.PubKey
+	}
+	stolen.Vout = []TxOutput{*NewTxOutput(1, string(thief.GetAddr()))}
+	stolen.Id = stolen.Hashing()
+	chain.SignTx(&stolen, thief.PrivateKey)
+	err = ValidateBlockAgainstUTXO(nextBlock(&stolen), &utxoSet)
+	assert.True(t, strings.Contains(err.Error(), "of another owner"), err.Error())
+
+	// an invalid signature, and an over-claimed coinbase
+	forged := tx.Copy()
+	forged.Vin = tx.Vin
+	forged.Vout = []TxOutput{*NewTxOutput(1, addr)}
+	forged.Id = forged.Hashing()
+	err = ValidateBlockAgainstUTXO(nextBlock(&forged), &utxoSet)
+	assert.True(t, strings.Contains(err.Error(), "invalid signature"), err.Error())
+	// a negative output paying for the excess of another one
+	inflated := tx.Copy()
+	inflated.Vin = tx.Vin
+	inflated.Vout = []TxOutput{*NewTxOutput(tx.Vout[0].Value+100, addr), *NewTxOutput(1, addr)}
+	inflated.Vout[1].Value = -100
+	inflated.Id = inflated.Hashing()
+	chain.SignTx(&inflated, wallet.PrivateKey)
+	err = ValidateBlockAgainstUTXO(nextBlock(&inflated), &utxoSet)
+	assert.True(t, strings.Contains(err.Error(), "non-positive output"), err.Error())
+
+	assert.Equal(t, chain.TipHash(), utxoSet.TipHash(), "The set is up to date with the mined block")
+	height := chain.GetChainHeight() + 1
+	overClaimed := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(height)+1, height)},
+		chain.Tip, height)
+	assert.NotNil(t, ValidateBlockAgainstUTXO(overClaimed, &utxoSet))
+}
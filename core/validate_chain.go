@@ -0,0 +1,144 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file validates a chain of blocks (e.g., received from another node) as a whole before any of them is stored,
+// thus the validation does not depend on the storage.
+
+package core
+
+import (
+	`bytes`
+	`encoding/hex`
+	`errors`
+	`fmt`
+)
+
+// dryRunLookup is the txLookup of the blocks validated so far by ValidateChain.
+type dryRunLookup struct {
+	genesisHash []byte
+	blocks      map[string]*Block
+	txs         map[string]Transaction
+	nonces      map[string]uint64 // the highest nonce of each sender
+	timestamps  []int64           // the timestamps of the latest blocks, at most medianTimeSpan
+	spent       map[string]bool   // the spent outputs (see outpointOf)
+}
+
+// GenesisHash returns the hash of the first block.
+func (lookup *dryRunLookup) GenesisHash() []byte {
+	return lookup.genesisHash
+}
+
+// GetBlock returns the block whose hash is blockHash in the blocks validated so far.
+func (lookup *dryRunLookup) GetBlock(blockHash []byte) (*Block, error) {
+	block, ok := lookup.blocks[hex.EncodeToString(blockHash)]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return block, nil
+}
+
+// FindTx finds the transaction whose Id is txId in the blocks validated so far.
+func (lookup *dryRunLookup) FindTx(txId []byte) (Transaction, error) {
+	tx, ok := lookup.txs[hex.EncodeToString(txId)]
+	if !ok {
+		return Transaction{}, ErrTxNotFound
+	}
+	return tx, nil
+}
+
+// HighestNonce returns the highest nonce used by the sender (whose public key hash is pubKeyHash) in the blocks
+// validated so far.
+func (lookup *dryRunLookup) HighestNonce(pubKeyHash []byte) uint64 {
+	return lookup.nonces[hex.EncodeToString(pubKeyHash)]
+}
+
+// medianTimePastBefore returns the median time past of the blocks validated so far, which precede block.
+func (lookup *dryRunLookup) medianTimePastBefore(block *Block) int64 {
+	return medianOf(append([]int64{}, lookup.timestamps...))
+}
+
+// spentBefore returns one of outpoints which is spent by the blocks validated so far, or "" if none is.
+func (lookup *dryRunLookup) spentBefore(block *Block, outpoints map[string]bool) string {
+	for outpoint := range outpoints {
+		if lookup.spent[outpoint] {
+			return outpoint
+		}
+	}
+	return ""
+}
+
+// add adds block, its transactions, the spent outputs, and its timestamp to lookup.
+func (lookup *dryRunLookup) add(block *Block) {
+	lookup.blocks[hex.EncodeToString(block.Hash)] = block
+	lookup.timestamps = append(lookup.timestamps, block.TimeStamp)
+	if len(lookup.timestamps) > medianTimeSpan {
+		lookup.timestamps = lookup.timestamps[1:]
+	}
+	for _, tx := range block.Transactions {
+		lookup.txs[hex.EncodeToString(tx.Id)] = *tx
+		sender := hex.EncodeToString(tx.SenderPubKeyHash())
+		if tx.Nonce > lookup.nonces[sender] {
+			lookup.nonces[sender] = tx.Nonce
+		}
+		if !tx.IsCoinbaseTx() {
+			for _, txInput := range tx.Vin {
+				lookup.spent[outpointOf(txInput)] = true
+			}
+		}
+	}
+}
+
+// ValidateChain validates blocks (from the genesis block to the newest) as a whole chain without touching any db: the
+// first block is a genesis block, each of the others points to the previous one with the height increased by 1, and
+// every block obeys the consensus rules of ValidateBlock with the chain parameters config and pow, where the inputs
+// refer to the transactions of the preceding blocks. All the errors found are returned (nil if blocks form a valid
+// chain), each wrapping ErrInvalidBlock. An invalid block is still regarded as a part of the chain, thus the blocks
+// after it are validated against it rather than failing as well.
+func ValidateChain(blocks []*Block, config GenesisConfig, pow PoWStrategy) []error {
+	if len(blocks) == 0 {
+		return []error{fmt.Errorf("%w: no blocks", ErrInvalidBlock)}
+	}
+	// only chain.PoW and chain.Config are used by validateBlockWith, thus chain needs no db
+	chain := &BlockChain{PoW: pow, Config: config}
+	lookup := &dryRunLookup{
+		genesisHash: blocks[0].Hash,
+		blocks:      make(map[string]*Block),
+		txs:         make(map[string]Transaction),
+		nonces:      make(map[string]uint64),
+		spent:       make(map[string]bool),
+	}
+
+	var errs []error
+	report := func(block *Block, err error) {
+		errs = append(errs, fmt.Errorf("%w: block %x at height %d: %v", ErrInvalidBlock, block.Hash, block.Height, err))
+	}
+	for idx, block := range blocks {
+		if idx == 0 && len(block.PrevBlockHash) != 0 {
+			report(block, errors.New("the first block is not a genesis block"))
+		}
+		if idx > 0 {
+			prevBlock := blocks[idx-1]
+			if !bytes.Equal(block.PrevBlockHash, prevBlock.Hash) || block.Height != prevBlock.Height+1 {
+				report(block, fmt.Errorf("block does not follow block %x", prevBlock.Hash))
+			}
+		}
+		if err := chain.validateBlockWith(block, lookup, chain.PoW); err != nil {
+			report(block, err)
+		}
+		lookup.add(block)
+	}
+	return errs
+}
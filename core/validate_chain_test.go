@@ -0,0 +1,70 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`strings`
+	`testing`
+)
+
+func TestValidateChain(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	addr := string(wallet.GetAddr())
+	receiver := NewWallet()
+	tx, err := NewUTXOTx(wallet, string(receiver.GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, addr, tx)
+	// spend the outputs of the previous blocks
+	tx, err = NewUTXOTx(receiver, addr, 4, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, addr, tx)
+	tx, err = NewUTXOTx(receiver, addr, 1, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, addr, tx)
+
+	blocks := chain.blocksFromHeight(0)
+	assert.Equal(t, 4, len(blocks))
+	assert.Empty(t, ValidateChain(blocks, chain.Config, chain.PoW), "The blocks of chain form a valid chain")
+
+	// replace the newest block with the one whose coinbase over-claims the reward
+	overClaimed := NewBlock([]*Transaction{NewCoinbaseTx(addr, "", chain.CurrentReward(3)+1, 3),
+		blocks[3].Transactions[1]}, blocks[2].Hash, 3)
+	errs := ValidateChain([]*Block{blocks[0], blocks[1], blocks[2], overClaimed}, chain.Config, chain.PoW)
+	assert.Equal(t, 1, len(errs), "Only the invalid block is reported")
+	assert.True(t, errors.Is(errs[0], ErrInvalidBlock))
+	assert.True(t, strings.Contains(errs[0].Error(), "at height 3: coinbase outputs"), errs[0].Error())
+
+	// a missing block breaks the link
+	errs = ValidateChain([]*Block{blocks[0], blocks[1], blocks[3]}, chain.Config, chain.PoW)
+	assert.NotEmpty(t, errs)
+	assert.True(t, strings.Contains(errs[0].Error(), "at height 3: block does not follow"), errs[0].Error())
+
+	errs = ValidateChain(blocks[1:], chain.Config, chain.PoW)
+	assert.NotEmpty(t, errs, "The chain without the genesis block is rejected")
+	assert.True(t, strings.Contains(errs[0].Error(), "not a genesis block"), errs[0].Error())
+	assert.NotEmpty(t, ValidateChain(nil, chain.Config, chain.PoW))
+
+	// nothing is written to the db
+	assert.Equal(t, 3, chain.GetChainHeight())
+	assert.Equal(t, blocks[3].Hash, chain.Tip)
+}
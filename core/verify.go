@@ -0,0 +1,122 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file verifies the signatures of many transactions (e.g., of a whole chain) in parallel. Signature verification
+// is read-only and independent per transaction, thus it is spread across a pool of workers.
+
+package core
+
+import (
+	`encoding/hex`
+	`fmt`
+	`runtime`
+	`sync`
+)
+
+// VerifyWorkers is the number of workers verifying signatures in parallel (see VerifyBlocks).
+var VerifyWorkers = runtime.NumCPU()
+
+// sigJob is a transaction to be verified with its previous transactions.
+type sigJob struct {
+	tx      *Transaction
+	prevTxs map[string]Transaction
+	height  int
+}
+
+// verifySignatures verifies the jobs with workers in parallel, and returns the index of the first job which fails,
+// or -1 if all of them pass. The result does not depend on the number of workers or the scheduling.
+func verifySignatures(jobs []sigJob, workers int) int {
+	if workers < 1 {
+		workers = 1
+	}
+	valid := make([]bool, len(jobs))
+	jobIndices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobIndices {
+				valid[idx] = jobs[idx].tx.Verify(jobs[idx].prevTxs)
+			}
+		}()
+	}
+	for idx := range jobs {
+		jobIndices <- idx
+	}
+	close(jobIndices)
+	wg.Wait()
+
+	for idx := range valid {
+		if !valid[idx] {
+			return idx
+		}
+	}
+	return -1
+}
+
+// verifyBatchSize is the number of blocks VerifyAll verifies at a time, thus the whole chain is never held in memory.
+var verifyBatchSize = 64
+
+// VerifyBlocks verifies the signatures of all the transactions in blocks (from the oldest to the newest) with
+// VerifyWorkers workers in parallel. The previous transactions are searched in the transactions preceding them in
+// blocks, and then in chain. The returned error reports the first failure in the order of blocks.
+func (chain *BlockChain) VerifyBlocks(blocks []*Block) error {
+	// a transaction joins pool after its job is queued, thus it can only be spent by the ones following it
+	pool := make(map[string]Transaction)
+	var jobs []sigJob
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbaseTx() {
+				prevTxs, err := chain.findPrevTxs(tx, pool)
+				if err != nil {
+					return fmt.Errorf("transaction %x in block %d: %v", tx.Id, block.Height, err)
+				}
+				jobs = append(jobs, sigJob{tx, prevTxs, block.Height})
+			}
+			pool[hex.EncodeToString(tx.Id)] = *tx
+		}
+	}
+
+	if idx := verifySignatures(jobs, VerifyWorkers); idx >= 0 {
+		return fmt.Errorf("transaction %x in block %d has invalid signature", jobs[idx].tx.Id, jobs[idx].height)
+	}
+	return nil
+}
+
+// VerifyAll verifies the signatures of all the transactions in chain, see VerifyBlocks. The blocks of the main chain
+// are found through the height index, and verified verifyBatchSize blocks at a time from the genesis block.
+func (chain *BlockChain) VerifyAll() error {
+	tipHeight := chain.GetChainHeight()
+	for fromHeight := 0; fromHeight <= tipHeight; fromHeight += verifyBatchSize {
+		var blocks []*Block
+		for height := fromHeight; height <= tipHeight && height < fromHeight+verifyBatchSize; height++ {
+			blockHash, err := chain.BlockHashAtHeight(height)
+			if err != nil {
+				return err
+			}
+			block, err := chain.GetBlock(blockHash)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
+		}
+		if err := chain.VerifyBlocks(blocks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
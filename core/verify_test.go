@@ -0,0 +1,120 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+// newVerifyChain creates an in-memory chain with numBlocks blocks mined after the genesis block, each of which packs
+// txsPerBlock chained transactions other than the coinbase.
+func newVerifyChain(t testing.TB, numBlocks, txsPerBlock int) *BlockChain {
+	chain, wallet := newMemChain(t, DefaultGenesisConfig())
+	chain.PoW = NoopPoW{}
+	utxoSet := UTXOSet{BlockChain: chain}
+	for i := 0; i < numBlocks; i++ {
+		tx, _ := NewUTXOTx(wallet, string(NewWallet().GetAddr()), 1, &utxoSet)
+		txs := []*Transaction{tx}
+		for j := 1; j < txsPerBlock; j++ {
+			tx = spendChange(wallet, tx, string(NewWallet().GetAddr()), 1)
+			txs = append(txs, tx)
+		}
+		mineTxs(chain, string(wallet.GetAddr()), txs...)
+	}
+	return chain
+}
+
+// withVerifyWorkers sets VerifyWorkers to workers, and restores it when the test finishes.
+func withVerifyWorkers(t testing.TB, workers int) {
+	oldWorkers := VerifyWorkers
+	VerifyWorkers = workers
+	t.Cleanup(func() {
+		VerifyWorkers = oldWorkers
+	})
+}
+
+func TestVerifyAll(t *testing.T) {
+	chain := newVerifyChain(t, 5, 3)
+	for _, workers := range []int{1, 3, 16} {
+		withVerifyWorkers(t, workers)
+		assert.Nil(t, chain.VerifyAll(), "workers: %d", workers)
+	}
+
+	// tamper the signatures of a transaction in block 2 and another one in block 4
+	var blocks []*Block
+	iter := chain.Iterator()
+	for i := 0; i < 6; i++ {
+		blocks = append([]*Block{iter.Next()}, blocks...)
+	}
+	for _, height := range []int{4, 2} {
+		tx := blocks[height].Transactions[2]
+		tx.Vin[0].Signature = append([]byte{}, tx.Vin[0].Signature...)
+		tx.Vin[0].Signature[0] ^= 0xff
+	}
+
+	withVerifyWorkers(t, 1)
+	serialErr := chain.VerifyBlocks(blocks)
+	assert.NotNil(t, serialErr)
+	assert.Contains(t, serialErr.Error(), "in block 2", "The first failure is reported")
+	for _, workers := range []int{2, 4, 16} {
+		withVerifyWorkers(t, workers)
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, serialErr, chain.VerifyBlocks(blocks), "Parallel verification matches the serial one")
+		}
+	}
+
+	// the same verification is done when validating a received block
+	withVerifyWorkers(t, 4)
+	assert.NotNil(t, chain.ValidateBlock(blocks[4]))
+}
+
+func BenchmarkVerifyAll(b *testing.B) {
+	chain := newVerifyChain(b, 50, 3)
+	for _, workers := range []int{1, 4} {
+		b.Run(map[int]string{1: "serial", 4: "4workers"}[workers], func(b *testing.B) {
+			withVerifyWorkers(b, workers)
+			for i := 0; i < b.N; i++ {
+				if err := chain.VerifyAll(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyAllInBatches(t *testing.T) {
+	chain := newVerifyChain(t, 5, 2)
+	oldBatchSize := verifyBatchSize
+	verifyBatchSize = 2
+	t.Cleanup(func() {
+		verifyBatchSize = oldBatchSize
+	})
+	assert.Nil(t, chain.VerifyAll(), "The transactions spending the ones in the previous batches are verified")
+
+	// a transaction can only spend the ones preceding it among the blocks not on chain
+	otherChain, wallet := newMemChain(t, DefaultGenesisConfig())
+	addr := string(wallet.GetAddr())
+	genesisCoinbase := genesisOf(otherChain).Transactions[0]
+	parent := spendChange(wallet, genesisCoinbase, string(NewWallet().GetAddr()), 1)
+	child := spendChange(wallet, parent, string(NewWallet().GetAddr()), 1)
+	coinbaseTx := NewCoinbaseTx(addr, "", otherChain.CurrentReward(1), 1)
+	inOrder := &Block{Height: 1, Transactions: []*Transaction{coinbaseTx, parent, child}}
+	assert.Nil(t, otherChain.VerifyBlocks([]*Block{inOrder}))
+	outOfOrder := &Block{Height: 1, Transactions: []*Transaction{coinbaseTx, child, parent}}
+	assert.NotNil(t, otherChain.VerifyBlocks([]*Block{outOfOrder}), "The child preceding its parent is invalid")
+}
@@ -28,23 +28,99 @@ import (
 	`errors`
 	`fmt`
 	`golang.org/x/crypto/ripemd160`
+	`io`
 	`io/ioutil`
 	`lightChain/utils`
 	`log`
+	`math/big`
+	`os`
+	`path/filepath`
+	`strings`
 )
 
 const (
 	version         = byte(0x00)
-	walletFile      = "./wallets/wallets_%s.dat"
+	walletFile      = "wallets_%s.dat"
 	addrCheckSumLen = 4
+	maxChecksumLen  = sha256.Size // the checksum is a prefix of a sha256 hash
+	bech32HRP       = "lc"        // the human-readable part of bech32 addresses
 )
 
+// AddrFormat is the outer encoding of an address. The payload (version + pubKeyHash) is the same in every format.
+type AddrFormat int
+
+const (
+	// Base58Check encodes the payload and its checksum in base58 (the default).
+	Base58Check AddrFormat = iota
+	// Bech32 encodes the payload in bech32 with bech32HRP, which has a BCH checksum built in.
+	Bech32
+)
+
+// WalletDir is the directory where the wallet files of all nodes are stored. Each node has its own wallet file in it.
+// Change it before loading or saving wallets.
+var WalletDir = "./wallets"
+
+// GetWalletFile returns the path of the wallet file of the node with nodeId.
+func GetWalletFile(nodeId string) string {
+	return filepath.Join(WalletDir, fmt.Sprintf(walletFile, nodeId))
+}
+
 // Wallet consists of a private key (generated by the ecdsa) and a public key.
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PubKey     []byte
 }
 
+// curves maps the stable identifiers of the supported curves to the curves themselves.
+var curves = map[string]elliptic.Curve{
+	elliptic.P256().Params().Name: elliptic.P256(),
+}
+
+func init() {
+	// register the concrete curve type once, thus gob sees the same registration in every run
+	gob.Register(elliptic.P256())
+}
+
+// walletGob is the gob encoded form of Wallet. The curve is stored by its name rather than by the gob interface
+// encoding of elliptic.Curve, whose concrete type has no exported fields.
+type walletGob struct {
+	Curve  string
+	D      []byte
+	PubKey []byte
+}
+
+// GobEncode implements gob.GobEncoder.
+func (wallet *Wallet) GobEncode() ([]byte, error) {
+	if wallet.PrivateKey.Curve == nil || wallet.PrivateKey.D == nil {
+		return nil, errors.New("wallet has no private key")
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(walletGob{
+		Curve:  wallet.PrivateKey.Curve.Params().Name,
+		D:      wallet.PrivateKey.D.Bytes(),
+		PubKey: wallet.PubKey,
+	})
+	return buf.Bytes(), err
+}
+
+// GobDecode implements gob.GobDecoder. The public key point is recovered from the private key.
+func (wallet *Wallet) GobDecode(data []byte) error {
+	var content walletGob
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&content); err != nil {
+		return err
+	}
+	curve, ok := curves[content.Curve]
+	if !ok {
+		return fmt.Errorf("unsupported curve %q", content.Curve)
+	}
+
+	d := new(big.Int).SetBytes(content.D)
+	x, y := curve.ScalarBaseMult(content.D)
+	wallet.PrivateKey = ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+	wallet.PubKey = content.PubKey
+	return nil
+}
+
 // NewWallet creates a new Wallet instance and returns the pointer to it.
 func NewWallet() *Wallet {
 	// create a private-public key pair by ecdsa
@@ -53,9 +129,51 @@ func NewWallet() *Wallet {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
 
-	return &Wallet{*private, pubKey}
+	return &Wallet{*private, pubKeyOf(private.PublicKey)}
+}
+
+// NewWalletFromReader works like NewWallet, but the private key is derived from the bytes read from r, thus the same
+// bytes always lead to the same wallet (e.g., a seeded reader in tests). ecdsa.GenerateKey is not used since it may
+// ignore the given reader. NOTE: r must be a secure source of random bytes if the wallet holds real coins.
+func NewWalletFromReader(r io.Reader) *Wallet {
+	curve := elliptic.P256()
+	params := curve.Params()
+	// read 64 more bits than needed and reduce them into [1, N-1], the bias is negligible (see FIPS 186-4, B.4.1)
+	b := make([]byte, params.BitSize/8+8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		log.Panic(err)
+	}
+	d := new(big.Int).SetBytes(b)
+	n := new(big.Int).Sub(params.N, big.NewInt(1))
+	d.Mod(d, n)
+	d.Add(d, big.NewInt(1))
+
+	x, y := curve.ScalarBaseMult(d.FillBytes(make([]byte, (params.BitSize+7)/8)))
+	private := ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+	return &Wallet{private, pubKeyOf(private.PublicKey)}
+}
+
+// NewCompressedWallet works like NewWallet, but the public key is in the compressed format (see CompressedKey). The
+// address of the wallet differs from the one of the same key in the uncompressed format, since the hashed public key
+// bytes differ.
+func NewCompressedWallet() *Wallet {
+	wallet := NewWallet()
+	publicKey := wallet.PrivateKey.PublicKey
+	wallet.PubKey = elliptic.MarshalCompressed(publicKey.Curve, publicKey.X, publicKey.Y)
+	return wallet
+}
+
+// KeyFormat returns the format of the public key of wallet.
+func (wallet *Wallet) KeyFormat() KeyFormat {
+	return keyFormatOf(wallet.PubKey)
+}
+
+// pubKeyOf returns the public key bytes X || Y of publicKey. X and Y are padded to the same length, otherwise
+// Transaction.Verify cannot split the public key into halves correctly.
+func pubKeyOf(publicKey ecdsa.PublicKey) []byte {
+	keyLen := (publicKey.Curve.Params().BitSize + 7) / 8
+	return append(publicKey.X.FillBytes(make([]byte, keyLen)), publicKey.Y.FillBytes(make([]byte, keyLen))...)
 }
 
 // GetAddr generates the address of a wallet based on the wallet's public key, sha256 algorithm, and base58 encoding.
@@ -63,14 +181,108 @@ func NewWallet() *Wallet {
 // nobody cannot extract pubKey from an address. By contrast, we can check whether a pubKey is used for generating
 // an address.
 func (wallet *Wallet) GetAddr() []byte {
-	pubKeyHash := HashingPubKey(wallet.PubKey)
+	return GenerateAddr(wallet.PubKey)
+}
+
+// GenerateAddr generates the address from the public key pubKey. See Wallet.GetAddr for details.
+func GenerateAddr(pubKey []byte) []byte {
+	return encodeAddr(HashingPubKey(pubKey), addrCheckSumLen)
+}
+
+// GenerateAddrWithChecksumLen generates the address from the public key pubKey with a checksum of checksumLen bytes.
+// The address can only be validated with the same checksumLen. An error wrapping ErrInvalidChecksumLen is returned if
+// checksumLen is not in [1, maxChecksumLen].
+func GenerateAddrWithChecksumLen(pubKey []byte, checksumLen int) ([]byte, error) {
+	if !validChecksumLen(checksumLen) {
+		return nil, fmt.Errorf("%w: %d bytes, expected 1 to %d", ErrInvalidChecksumLen, checksumLen, maxChecksumLen)
+	}
+	return encodeAddr(HashingPubKey(pubKey), checksumLen), nil
+}
+
+// PubKeyHashAddr encodes pubKeyHash into the address (in base58check) that GenerateAddr generates from the public key.
+// It re-encodes the pubKeyHash locking an output for display.
+func PubKeyHashAddr(pubKeyHash []byte) []byte {
+	return encodeAddr(pubKeyHash, addrCheckSumLen)
+}
+
+// encodeAddr encodes pubKeyHash into the base58check address with a checksum of checksumLen bytes.
+func encodeAddr(pubKeyHash []byte, checksumLen int) []byte {
 	versionedPayload := append([]byte{version}, pubKeyHash...)
-	checksum := getChecksum(versionedPayload)
+	checksum := getChecksum(versionedPayload, checksumLen)
 	// version + pubKeyHash + checksum ---> base58 encoding
 	fullPayload := append(versionedPayload, checksum...)
 	return utils.Base58Encoding(fullPayload)
 }
 
+// GenerateBech32Addr generates the bech32 address from the public key pubKey. It carries the same payload as the
+// address generated by GenerateAddr.
+func GenerateBech32Addr(pubKey []byte) []byte {
+	versionedPayload := append([]byte{version}, HashingPubKey(pubKey)...)
+	return utils.Bech32Encoding(bech32HRP, versionedPayload)
+}
+
+// GetAddrInFormat generates the address of a wallet in format.
+func (wallet *Wallet) GetAddrInFormat(format AddrFormat) []byte {
+	if format == Bech32 {
+		return GenerateBech32Addr(wallet.PubKey)
+	}
+	return wallet.GetAddr()
+}
+
+// isBech32Addr detects whether addr is in the bech32 format. No base58check address is ambiguous, because the
+// base58check address always starts with '1' (encoded from version 0x00).
+func isBech32Addr(addr string) bool {
+	return strings.HasPrefix(strings.ToLower(addr), bech32HRP+"1")
+}
+
+// decodeBase58Check decodes the base58check address addr with a checksum of checksumLen bytes. The addresses encoded
+// before the leading zero bytes were kept by utils.Base58Encoding start with a single '1' however many zero bytes the
+// payload starts with, thus their decoding lacks the zero bytes of the pubKeyHash. Such a short decoding is padded
+// with the zero bytes back to the length of a well-formed payload if the checksum of the padded one matches, thus the
+// address is still valid.
+func decodeBase58Check(addr string, checksumLen int) ([]byte, error) {
+	fullPayload, err := utils.Base58Decoding([]byte(addr))
+	if err != nil {
+		return nil, err
+	}
+	size := 1 + ripemd160.Size + checksumLen
+	if len(fullPayload) >= size || !validChecksumLen(checksumLen) {
+		return fullPayload, nil
+	}
+	padded := append(make([]byte, size-len(fullPayload)), fullPayload...)
+	versionedPayload := padded[:size-checksumLen]
+	if bytes.Equal(padded[size-checksumLen:], getChecksum(versionedPayload, checksumLen)) {
+		return padded, nil
+	}
+	return fullPayload, nil
+}
+
+// AddrPubKeyHash extracts the pubKeyHash from addr in either format. Note that the base58check checksum is not
+// checked here, use ValidateAddr for that. The returned error wraps ErrInvalidAddress, e.g., if the pubKeyHash is not
+// of ripemd160.Size bytes.
+func AddrPubKeyHash(addr string) ([]byte, error) {
+	if isBech32Addr(addr) {
+		_, payload, err := utils.Bech32Decoding([]byte(addr))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+		}
+		if len(payload) != 1+ripemd160.Size {
+			return nil, fmt.Errorf("%w: bech32 address carries %d bytes, not a version and a pubKeyHash",
+				ErrInvalidAddress, len(payload))
+		}
+		return payload[1:], nil
+	}
+	fullPayload, err := decodeBase58Check(addr, addrCheckSumLen)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	if len(fullPayload) != 1+ripemd160.Size+addrCheckSumLen {
+		return nil, fmt.Errorf("%w: base58check address carries %d bytes, not a version, a pubKeyHash and a "+
+			"checksum", ErrInvalidAddress, len(fullPayload))
+	}
+	return fullPayload[1 : len(fullPayload)-addrCheckSumLen], nil
+}
+
 // HashingPubKey hashes the public key and returns the result.
 func HashingPubKey(pubKey []byte) []byte {
 	sha := sha256.Sum256(pubKey)
@@ -82,23 +294,84 @@ func HashingPubKey(pubKey []byte) []byte {
 	return hasher.Sum(nil)
 }
 
-// getChecksum generates the checksum (a 4-byte slice) of given payload.
-func getChecksum(payload []byte) []byte {
+// getChecksum generates the checksum (a checksumLen-byte slice) of given payload. checksumLen must be valid (see
+// validChecksumLen).
+func getChecksum(payload []byte, checksumLen int) []byte {
 	sha1 := sha256.Sum256(payload)
 	sha2 := sha256.Sum256(sha1[:])
-	return sha2[:addrCheckSumLen]
+	return sha2[:checksumLen]
 }
 
-// ValidateAddr checks whether addr is a valid address. It can be used to detect whether addr is tampered by evil guys.
+// validChecksumLen checks whether checksumLen is in [1, maxChecksumLen].
+func validChecksumLen(checksumLen int) bool {
+	return checksumLen >= 1 && checksumLen <= maxChecksumLen
+}
+
+// ValidateAddr checks whether addr is a valid address in either format, which carries a pubKeyHash of
+// ripemd160.Size bytes. It can be used to detect whether addr is tampered by evil guys.
 func ValidateAddr(addr string) bool {
-	fullPayload := utils.Base58Decoding([]byte(addr))
+	if isBech32Addr(addr) {
+		hrp, payload, err := utils.Bech32Decoding([]byte(addr))
+		return err == nil && hrp == bech32HRP && len(payload) == 1+ripemd160.Size && payload[0] == version
+	}
+	return ValidateAddrWithChecksumLen(addr, addrCheckSumLen)
+}
+
+// DecodeAddr decodes addr in either format, and returns its version byte and pubKeyHash. An error wrapping
+// ErrInvalidAddress is returned if addr is malformed, its pubKeyHash is not of ripemd160.Size bytes, or its checksum
+// does not match.
+func DecodeAddr(addr string) (byte, []byte, error) {
+	if isBech32Addr(addr) {
+		hrp, payload, err := utils.Bech32Decoding([]byte(addr))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+		}
+		if hrp != bech32HRP {
+			return 0, nil, fmt.Errorf("%w: unknown human-readable part %q", ErrInvalidAddress, hrp)
+		}
+		if len(payload) != 1+ripemd160.Size {
+			return 0, nil, fmt.Errorf("%w: bech32 address carries %d bytes, not a version and a pubKeyHash",
+				ErrInvalidAddress, len(payload))
+		}
+		return payload[0], payload[1:], nil
+	}
+
+	fullPayload, err := decodeBase58Check(addr, addrCheckSumLen)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	if len(fullPayload) != 1+ripemd160.Size+addrCheckSumLen {
+		return 0, nil, fmt.Errorf("%w: base58check address carries %d bytes, not a version, a pubKeyHash and a "+
+			"checksum", ErrInvalidAddress, len(fullPayload))
+	}
+	versionedPayload := fullPayload[:len(fullPayload)-addrCheckSumLen]
+	if !bytes.Equal(fullPayload[len(versionedPayload):], getChecksum(versionedPayload, addrCheckSumLen)) {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidAddress)
+	}
+	return versionedPayload[0], versionedPayload[1:], nil
+}
+
+// ValidateAddrWithChecksumLen checks whether addr is a valid address generated with a checksum of checksumLen bytes,
+// which carries a pubKeyHash of ripemd160.Size bytes. No address is valid if checksumLen is not in [1, maxChecksumLen].
+func ValidateAddrWithChecksumLen(addr string, checksumLen int) bool {
+	if !validChecksumLen(checksumLen) {
+		return false
+	}
+	fullPayload, err := decodeBase58Check(addr, checksumLen)
+	if err != nil {
+		return false
+	}
+	// version + pubKeyHash + checksum
+	if len(fullPayload) != 1+ripemd160.Size+checksumLen {
+		return false
+	}
 
 	// get version, pubKeyHash, and checksum from fullPayload
 	actualVersion := fullPayload[0]
-	actualPubKeyHash := fullPayload[1 : len(fullPayload)-addrCheckSumLen]
-	actualChecksum := fullPayload[len(fullPayload)-addrCheckSumLen:]
+	actualPubKeyHash := fullPayload[1 : len(fullPayload)-checksumLen]
+	actualChecksum := fullPayload[len(fullPayload)-checksumLen:]
 
-	targetChecksum := getChecksum(append([]byte{actualVersion}, actualPubKeyHash...))
+	targetChecksum := getChecksum(append([]byte{actualVersion}, actualPubKeyHash...), checksumLen)
 
 	return bytes.Compare(actualChecksum, targetChecksum) == 0
 }
@@ -108,12 +381,12 @@ type Wallets struct {
 	WalletsMap map[string]*Wallet // {key: address of the wallet, value: the wallet itself}
 }
 
-// NewWallets returns a Wallets pointer from local walletFile.
+// NewWallets returns a Wallets pointer from the wallet file of the node with nodeId.
 func NewWallets(nodeId string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.WalletsMap = make(map[string]*Wallet)
 
-	walletFile := fmt.Sprintf(walletFile, nodeId)
+	walletFile := GetWalletFile(nodeId)
 	if ok, _ := utils.FileExists(walletFile); !ok {
 		return &wallets, nil
 	}
@@ -122,9 +395,9 @@ func NewWallets(nodeId string) (*Wallets, error) {
 	return &wallets, err
 }
 
-// LoadFromFile loads file content to wallets.
+// LoadFromFile loads the content of the wallet file of the node with nodeId to wallets.
 func (wallets *Wallets) LoadFromFile(nodeId string) error {
-	walletFile := fmt.Sprintf(walletFile, nodeId)
+	walletFile := GetWalletFile(nodeId)
 	if ok, err := utils.FileExists(walletFile); !ok {
 		return err
 	}
@@ -135,7 +408,6 @@ func (wallets *Wallets) LoadFromFile(nodeId string) error {
 	}
 
 	var tmpWallets Wallets
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(rawContent))
 	err = decoder.Decode(&tmpWallets)
 	if err != nil {
@@ -146,20 +418,29 @@ func (wallets *Wallets) LoadFromFile(nodeId string) error {
 	return nil
 }
 
-// Save2File saves the content of wallets into a local file.
+// Save2File saves the content of wallets into the wallet file of the node with nodeId. WalletDir is created if not exists.
+// The content is written to a temporary file first, which then replaces the wallet file atomically, thus the wallet
+// file is never left partially written.
 func (wallets *Wallets) Save2File(nodeId string) {
-	walletFile := fmt.Sprintf(walletFile, nodeId)
+	walletFile := GetWalletFile(nodeId)
 
 	var buf bytes.Buffer
-	gob.Register(elliptic.P256())
-
 	encoder := gob.NewEncoder(&buf)
 	err := encoder.Encode(*wallets)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = ioutil.WriteFile(walletFile, buf.Bytes(), 0644)
+	err = os.MkdirAll(filepath.Dir(walletFile), 0755)
+	if err != nil {
+		log.Panic(err)
+	}
+	tmpFile := walletFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, buf.Bytes(), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+	err = os.Rename(tmpFile, walletFile)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -182,10 +463,40 @@ func (wallets *Wallets) GetWallet(addr string) (Wallet, error) {
 	return *wallets.WalletsMap[addr], nil
 }
 
+// RemoveWallet removes the Wallet of addr from wallets. Note that the coins owned by addr are unrecoverable once the
+// wallet file is saved.
+func (wallets *Wallets) RemoveWallet(addr string) error {
+	if _, ok := wallets.WalletsMap[addr]; !ok {
+		return errors.New("address not found in wallets")
+	}
+	delete(wallets.WalletsMap, addr)
+	return nil
+}
+
 // CreateWallet creates a new Wallet, add it (and its address) to wallets and returns the address.
 func (wallets *Wallets) CreateWallet() string {
-	wallet := NewWallet()
-	addr := fmt.Sprintf("%s", wallet.GetAddr())
+	return wallets.CreateWalletInFormat(Base58Check)
+}
+
+// CreateWallets creates count new Wallets with CreateWallet, and returns their addresses in the order they are created.
+// Nothing is saved, thus call Save2File once after all of them are created.
+func (wallets *Wallets) CreateWallets(count int) []string {
+	addrs := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		addrs = append(addrs, wallets.CreateWallet())
+	}
+	return addrs
+}
+
+// CreateWalletInFormat is like CreateWallet, but the address of the new Wallet is in format. Since wallets are keyed by
+// their addresses, the format is kept per wallet.
+func (wallets *Wallets) CreateWalletInFormat(format AddrFormat) string {
+	return wallets.AddWallet(NewWallet(), format)
+}
+
+// AddWallet adds wallet (e.g., created by NewCompressedWallet) to wallets, and returns its address in format.
+func (wallets *Wallets) AddWallet(wallet *Wallet, format AddrFormat) string {
+	addr := fmt.Sprintf("%s", wallet.GetAddrInFormat(format))
 
 	wallets.WalletsMap[addr] = wallet
 	return addr
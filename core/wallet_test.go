@@ -0,0 +1,379 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	`bytes`
+	`crypto/elliptic`
+	`encoding/hex`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`lightChain/utils`
+	`os`
+	`path/filepath`
+	`strings`
+	`testing`
+)
+
+// useTempWalletDir points WalletDir to a fresh temporary directory, and restores it when the test finishes.
+func useTempWalletDir(t *testing.T) string {
+	oldWalletDir := WalletDir
+	WalletDir = t.TempDir()
+	t.Cleanup(func() {
+		WalletDir = oldWalletDir
+	})
+	return WalletDir
+}
+
+func TestWalletsSaveAndLoad(t *testing.T) {
+	useTempWalletDir(t)
+
+	wallets, err := NewWallets("1")
+	assert.Nil(t, err)
+	addr1 := wallets.CreateWallet()
+	addr2 := wallets.CreateWallet()
+	wallets.Save2File("1")
+
+	// load into a fresh Wallets, as another run of the node does
+	loaded, err := NewWallets("1")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{addr1, addr2}, loaded.GetAddrs())
+
+	for _, addr := range []string{addr1, addr2} {
+		saved, _ := wallets.GetWallet(addr)
+		wallet, err := loaded.GetWallet(addr)
+		assert.Nil(t, err)
+		assert.Equal(t, elliptic.P256(), wallet.PrivateKey.Curve, "The curve survives")
+		assert.Equal(t, saved.PrivateKey.D, wallet.PrivateKey.D, "The private key survives")
+		assert.Equal(t, saved.PrivateKey.X, wallet.PrivateKey.X)
+		assert.Equal(t, saved.PrivateKey.Y, wallet.PrivateKey.Y)
+		assert.Equal(t, saved.PubKey, wallet.PubKey)
+		assert.Equal(t, addr, string(wallet.GetAddr()))
+	}
+}
+
+func TestCreateWallets(t *testing.T) {
+	useTempWalletDir(t)
+
+	wallets, err := NewWallets("1")
+	assert.Nil(t, err)
+	existing := wallets.CreateWallet()
+	wallets.Save2File("1")
+
+	addrs := wallets.CreateWallets(10)
+	assert.Equal(t, 10, len(addrs))
+	assert.NotContains(t, addrs, existing)
+	// nothing is saved until Save2File
+	loaded, err := NewWallets("1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{existing}, loaded.GetAddrs())
+
+	wallets.Save2File("1")
+	loaded, err = NewWallets("1")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, append(addrs, existing), loaded.GetAddrs(), "All the wallets are saved at once")
+	_, err = os.Stat(GetWalletFile("1") + ".tmp")
+	assert.True(t, os.IsNotExist(err), "The temporary file replaces the wallet file")
+}
+
+func TestLoadedWalletSigns(t *testing.T) {
+	useTempWalletDir(t)
+
+	wallets, _ := NewWallets("1")
+	addr := wallets.CreateWallet()
+	wallets.Save2File("1")
+	loaded, _ := NewWallets("1")
+	wallet, _ := loaded.GetWallet(addr)
+
+	// a transaction signed with the loaded key is verified against the public key saved before
+	prevTx := NewCoinbaseTx(addr, "", 10, 1)
+	prevTxs := map[string]Transaction{hex.EncodeToString(prevTx.Id): *prevTx}
+	tx := &Transaction{
+		Version: TxVersion,
+		Vin:     []TxInput{{TxId: prevTx.Id, VoutIdx: 0, PubKey: wallets.WalletsMap[addr].PubKey}},
+		Vout:    []TxOutput{*NewTxOutput(10, addr)},
+	}
+	tx.Id = tx.Hashing()
+	tx.Sign(wallet.PrivateKey, prevTxs)
+	assert.True(t, tx.Verify(prevTxs), "The loaded private key signs valid transactions")
+}
+
+func TestAddrChecksumLen(t *testing.T) {
+	wallet := NewWallet()
+
+	addr := string(wallet.GetAddr())
+	defaultAddr, err := GenerateAddrWithChecksumLen(wallet.PubKey, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, addr, string(defaultAddr), "4 is the default checksum length")
+	assert.True(t, ValidateAddr(addr))
+	assert.True(t, ValidateAddrWithChecksumLen(addr, 4))
+
+	generated, err := GenerateAddrWithChecksumLen(wallet.PubKey, 8)
+	assert.Nil(t, err)
+	longAddr := string(generated)
+	assert.True(t, ValidateAddrWithChecksumLen(longAddr, 8))
+
+	// validation must use the same length the address was generated with
+	assert.False(t, ValidateAddrWithChecksumLen(addr, 8), "Address of length 4 is invalid at length 8")
+	assert.False(t, ValidateAddrWithChecksumLen(longAddr, 4), "Address of length 8 is invalid at length 4")
+	assert.False(t, ValidateAddr(longAddr))
+
+	// the checksum is 1 to 32 bytes
+	for _, checksumLen := range []int{-1, 0, maxChecksumLen + 1} {
+		_, err = GenerateAddrWithChecksumLen(wallet.PubKey, checksumLen)
+		assert.True(t, errors.Is(err, ErrInvalidChecksumLen), "Checksum of %d bytes is rejected", checksumLen)
+		assert.False(t, ValidateAddrWithChecksumLen(addr, checksumLen))
+	}
+	generated, err = GenerateAddrWithChecksumLen(wallet.PubKey, maxChecksumLen)
+	assert.Nil(t, err)
+	assert.True(t, ValidateAddrWithChecksumLen(string(generated), maxChecksumLen))
+}
+
+func TestWalletsPerNode(t *testing.T) {
+	// the directory tree is created when saving
+	WalletDir = filepath.Join(useTempWalletDir(t), "a", "wallets")
+
+	wallets1, _ := NewWallets("1")
+	addr1 := wallets1.CreateWallet()
+	wallets1.Save2File("1")
+	wallets2, _ := NewWallets("2")
+	addr2 := wallets2.CreateWallet()
+	wallets2.Save2File("2")
+	assert.NotEqual(t, GetWalletFile("1"), GetWalletFile("2"), "Nodes have separate wallet files")
+
+	loaded1, _ := NewWallets("1")
+	loaded2, _ := NewWallets("2")
+	assert.Equal(t, []string{addr1}, loaded1.GetAddrs(), "Wallets of a node do not interfere with another node")
+	assert.Equal(t, []string{addr2}, loaded2.GetAddrs())
+
+	loaded3, err := NewWallets("3")
+	assert.Nil(t, err)
+	assert.Empty(t, loaded3.GetAddrs(), "Node without wallet file has no wallet")
+}
+
+func TestRemoveWallet(t *testing.T) {
+	useTempWalletDir(t)
+
+	wallets, _ := NewWallets("1")
+	addr1 := wallets.CreateWallet()
+	addr2 := wallets.CreateWallet()
+
+	assert.Nil(t, wallets.RemoveWallet(addr1))
+	assert.Equal(t, []string{addr2}, wallets.GetAddrs(), "The removed wallet is dropped")
+	assert.NotNil(t, wallets.RemoveWallet(addr1), "Removing an unknown wallet fails")
+
+	wallets.Save2File("1")
+	loaded, _ := NewWallets("1")
+	assert.Equal(t, []string{addr2}, loaded.GetAddrs(), "The removal is persisted")
+}
+
+func TestAddrFormats(t *testing.T) {
+	wallet := NewWallet()
+	pubKeyHash := HashingPubKey(wallet.PubKey)
+
+	base58Addr := string(wallet.GetAddrInFormat(Base58Check))
+	bech32Addr := string(wallet.GetAddrInFormat(Bech32))
+	assert.Equal(t, base58Addr, string(wallet.GetAddr()))
+	assert.Equal(t, bech32Addr, string(GenerateBech32Addr(wallet.PubKey)))
+	assert.True(t, strings.HasPrefix(bech32Addr, "lc1"))
+
+	// the same pubKeyHash round-trips through both formats
+	for _, addr := range []string{base58Addr, bech32Addr, strings.ToUpper(bech32Addr)} {
+		assert.True(t, ValidateAddr(addr), addr)
+		decoded, err := AddrPubKeyHash(addr)
+		assert.Nil(t, err)
+		assert.Equal(t, pubKeyHash, decoded, addr)
+	}
+
+	// a typo breaks the bech32 checksum
+	tampered := []byte(bech32Addr)
+	tampered[len(tampered)-1] = map[bool]byte{true: 'q', false: 'p'}[tampered[len(tampered)-1] != 'q']
+	assert.False(t, ValidateAddr(string(tampered)))
+	_, err := AddrPubKeyHash(string(tampered))
+	assert.NotNil(t, err)
+
+	// a valid bech32 string of BIP-173 with another hrp is not an address
+	hrp, _, err := utils.Bech32Decoding([]byte("a12uel5l"))
+	assert.Nil(t, err)
+	assert.Equal(t, "a", hrp)
+	assert.False(t, ValidateAddr("a12uel5l"))
+}
+
+func TestSpendFromBech32Addr(t *testing.T) {
+	useTempDataDir(t)
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	bech32Wallet := NewWallet()
+	tx, err := NewUTXOTx(wallet, string(bech32Wallet.GetAddrInFormat(Bech32)), 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+	assert.Equal(t, 10.0, utxoSet.Balance(HashingPubKey(bech32Wallet.PubKey)))
+
+	// the coins sent to the bech32 address are spendable, even to a base58check address
+	tx, err = NewUTXOTx(bech32Wallet, string(NewWallet().GetAddr()), 4, &utxoSet)
+	assert.Nil(t, err)
+	assert.True(t, chain.VerifyTx(tx))
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+	assert.Equal(t, 6.0, utxoSet.Balance(HashingPubKey(bech32Wallet.PubKey)))
+}
+
+func TestNewWalletFromReader(t *testing.T) {
+	seeded := func(seed byte) *Wallet {
+		return NewWalletFromReader(bytes.NewReader(bytes.Repeat([]byte{seed}, 40)))
+	}
+
+	wallet1, wallet2 := seeded(42), seeded(42)
+	assert.Equal(t, wallet1.PrivateKey.D, wallet2.PrivateKey.D, "The same seed leads to the same wallet")
+	assert.Equal(t, wallet1.PubKey, wallet2.PubKey)
+	assert.Equal(t, wallet1.GetAddr(), wallet2.GetAddr())
+	// the derivation is stable across runs
+	assert.Equal(t, "1H41rAdNELfk8QK6TXivCrMTeTq1s6kU81", string(wallet1.GetAddr()))
+	assert.NotEqual(t, wallet1.GetAddr(), seeded(43).GetAddr(), "Another seed leads to another wallet")
+
+	// the public key matches the private key, and the derived wallet signs valid transactions
+	x, y := elliptic.P256().ScalarBaseMult(wallet1.PrivateKey.D.Bytes())
+	assert.Equal(t, x, wallet1.PrivateKey.X)
+	assert.Equal(t, y, wallet1.PrivateKey.Y)
+	assert.Len(t, wallet1.PubKey, 64, "X and Y are padded")
+	addr := string(wallet1.GetAddr())
+	prevTx := NewCoinbaseTx(addr, "", 10, 1)
+	prevTxs := map[string]Transaction{hex.EncodeToString(prevTx.Id): *prevTx}
+	tx := &Transaction{
+		Version: TxVersion,
+		Vin:     []TxInput{{TxId: prevTx.Id, VoutIdx: 0, PubKey: wallet1.PubKey}},
+		Vout:    []TxOutput{*NewTxOutput(10, addr)},
+	}
+	tx.Id = tx.Hashing()
+	tx.Sign(wallet1.PrivateKey, prevTxs)
+	assert.True(t, tx.Verify(prevTxs))
+}
+
+func TestCompressedWallet(t *testing.T) {
+	useTempDataDir(t)
+
+	compressed := NewCompressedWallet()
+	assert.Len(t, compressed.PubKey, 33)
+	assert.Contains(t, []byte{2, 3}, compressed.PubKey[0])
+	assert.Equal(t, CompressedKey, compressed.KeyFormat())
+	assert.Equal(t, UncompressedKey, NewWallet().KeyFormat())
+	// the same key pair in the uncompressed format has another address
+	uncompressed := Wallet{compressed.PrivateKey, pubKeyOf(compressed.PrivateKey.PublicKey)}
+	assert.NotEqual(t, compressed.GetAddr(), uncompressed.GetAddr())
+	assert.True(t, ValidateAddr(string(compressed.GetAddr())))
+
+	chain, wallet := newTestChain(t, "1", "")
+	utxoSet := UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	tx, err := NewUTXOTx(wallet, string(compressed.GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+
+	// the compressed wallet signs transactions which are verified by decompressing its public key
+	tx, err = NewUTXOTx(compressed, string(NewWallet().GetAddr()), 4, &utxoSet)
+	assert.Nil(t, err)
+	assert.Equal(t, CompressedKey, tx.Vin[0].KeyFormat)
+	assert.True(t, chain.VerifyTx(tx))
+	tampered := tx.Copy()
+	tampered.Vin[0].Signature = tx.Vin[0].Signature
+	tampered.Vin[0].PubKey = tx.Vin[0].PubKey
+	tampered.Vin[0].KeyFormat = UncompressedKey
+	assert.False(t, chain.VerifyTx(&tampered), "The public key is parsed in the wrong format")
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+	assert.Equal(t, 6.0, utxoSet.Balance(HashingPubKey(compressed.PubKey)))
+
+	// the format survives saving and loading the wallets
+	useTempWalletDir(t)
+	wallets, _ := NewWallets("1")
+	addr := wallets.AddWallet(compressed, Base58Check)
+	wallets.Save2File("1")
+	loaded, _ := NewWallets("1")
+	loadedWallet, err := loaded.GetWallet(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, compressed.PubKey, loadedWallet.PubKey)
+}
+
+func TestBase58LeadingZeros(t *testing.T) {
+	for _, input := range [][]byte{{}, {0}, {0, 0}, {0, 0, 1, 2}, {0, 1, 0}, {1, 0, 0}} {
+		decoded, err := utils.Base58Decoding(utils.Base58Encoding(input))
+		assert.Nil(t, err)
+		assert.Equal(t, input, decoded, "Every leading zero byte survives the round trip")
+	}
+	_, err := utils.Base58Decoding([]byte("1O"))
+	assert.NotNil(t, err, "O is not in the alphabet")
+
+	// an address whose pubKeyHash starts with zero bytes
+	fullPayload := append([]byte{version, 0, 0}, bytes.Repeat([]byte{7}, 18)...)
+	fullPayload = append(fullPayload, getChecksum(fullPayload, addrCheckSumLen)...)
+	addr := string(utils.Base58Encoding(fullPayload))
+	assert.True(t, strings.HasPrefix(addr, "111"))
+	assert.True(t, ValidateAddr(addr))
+	addrVersion, pubKeyHash, err := DecodeAddr(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, version, addrVersion)
+	assert.Equal(t, fullPayload[1:21], pubKeyHash)
+
+	// the same pubKeyHash encoded before the leading zeros were kept, with a single '1'
+	legacyAddr := "15eiRqs3RvbY3ovAKdGge1C8Y6ngZfp"
+	legacyPubKeyHash, _ := hex.DecodeString("0000112233445566778899aabbccddeeff112233")
+	assert.True(t, ValidateAddr(legacyAddr), "The address encoded before the fix is still valid")
+	assert.True(t, ValidateAddrWithChecksumLen(legacyAddr, addrCheckSumLen))
+	addrVersion, pubKeyHash, err = DecodeAddr(legacyAddr)
+	assert.Nil(t, err)
+	assert.Equal(t, version, addrVersion)
+	assert.Equal(t, legacyPubKeyHash, pubKeyHash)
+	pubKeyHash, err = AddrPubKeyHash(legacyAddr)
+	assert.Nil(t, err)
+	assert.Equal(t, legacyPubKeyHash, pubKeyHash)
+	assert.Equal(t, "111"+legacyAddr[1:], string(PubKeyHashAddr(legacyPubKeyHash)), "It is re-encoded with the zeros")
+	assert.False(t, ValidateAddr(legacyAddr[:len(legacyAddr)-1]+"q"), "The checksum is still checked")
+}
+
+func TestValidateShortAddr(t *testing.T) {
+	addr := string(NewWallet().GetAddr())
+	bech32Addr := string(NewWallet().GetAddrInFormat(Bech32))
+	for _, short := range []string{"", "1", "z", "11", "11111", addr[:2], "lc1"} {
+		assert.NotPanics(t, func() {
+			assert.False(t, ValidateAddr(short), short)
+			assert.False(t, ValidateAddrWithChecksumLen(short, 8), short)
+		}, short)
+		_, err := AddrPubKeyHash(short)
+		assert.NotNil(t, err, short)
+	}
+	// long enough to be sliced, but truncated ones do not match the checksum
+	for _, truncated := range []string{"111111", addr[:8], addr[:len(addr)-1], bech32Addr[:len(bech32Addr)-1]} {
+		assert.NotPanics(t, func() {
+			assert.False(t, ValidateAddr(truncated), truncated)
+		}, truncated)
+	}
+
+	// a well-formed address must carry a pubKeyHash of 20 bytes
+	for _, pubKeyHash := range [][]byte{{7}, bytes.Repeat([]byte{7}, 19), bytes.Repeat([]byte{7}, 21)} {
+		fullPayload := append([]byte{version}, pubKeyHash...)
+		bech32Addr := string(utils.Bech32Encoding(bech32HRP, fullPayload))
+		fullPayload = append(fullPayload, getChecksum(fullPayload, addrCheckSumLen)...)
+		base58Addr := string(utils.Base58Encoding(fullPayload))
+		for _, addr := range []string{base58Addr, bech32Addr} {
+			assert.False(t, ValidateAddr(addr), "pubKeyHash of %d bytes is invalid", len(pubKeyHash))
+			_, err := AddrPubKeyHash(addr)
+			assert.True(t, errors.Is(err, ErrInvalidAddress), "pubKeyHash of %d bytes is invalid", len(pubKeyHash))
+		}
+		assert.False(t, ValidateAddrWithChecksumLen(base58Addr, addrCheckSumLen))
+	}
+}
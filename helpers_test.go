@@ -0,0 +1,49 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the helpers shared by the tests of the command line interface, e.g., the chains to test against.
+
+package main
+
+import (
+	`lightChain/core`
+	`testing`
+)
+
+// newMemChain creates an in-memory chain whose genesis coinbase reward goes to addr, and builds its UTXO set.
+func newMemChain(addr string) *core.BlockChain {
+	chain, _ := core.CreateBlockChainWithStore(core.NewMemStore(), addr, core.DefaultGenesisConfig())
+	core.UTXOSet{BlockChain: chain}.Rebuild()
+	return chain
+}
+
+// newTestChain creates an in-memory chain with two blocks.
+func newTestChain(t *testing.T) *core.BlockChain {
+	addr := string(core.NewWallet().GetAddr())
+	chain := newMemChain(addr)
+	mineTxs(chain, addr)
+	return chain
+}
+
+// mineTxs mines a block packing a coinbase transaction paying addr followed by txs on the tip of chain, and updates
+// the UTXO set of chain with it.
+func mineTxs(chain *core.BlockChain, addr string, txs ...*core.Transaction) *core.Block {
+	height := chain.GetChainHeight() + 1
+	coinbaseTx := core.NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)
+	block := chain.MineBlock(append([]*core.Transaction{coinbaseTx}, txs...))
+	core.UTXOSet{BlockChain: chain}.Update(block)
+	return block
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the announcement of a transaction from a wallet: the transaction id is announced with an
+// inventory first, and the transaction is transmitted only if the receiver pulls it with getdata.
+
+package network
+
+import (
+	`bytes`
+	`context`
+	`encoding/gob`
+	`io/ioutil`
+	`lightChain/core`
+	`lightChain/utils`
+	`net`
+	`time`
+)
+
+// announceTimeout is how long to wait for the receiver pulling the announced transaction. The receiver does not answer
+// if it already has the transaction.
+var announceTimeout = 5 * time.Second
+
+// AnnounceTx announces transaction to dstAddr with an inventory, and sends transaction if dstAddr pulls it with
+// getdata, thus a receiver already holding it does not get it again. The inventory carries a temporary address to be
+// pulled from, thus it works without a running node. It reports whether transaction is pulled. If it is pulled, the
+// error of sending it is returned, which wraps ErrTxRejected if the receiver rejects it (see SendTx).
+func AnnounceTx(ctx context.Context, dstAddr string, transaction *core.Transaction) (bool, error) {
+	// listen on a temporary port for the getdata
+	listener, err := net.Listen(protocol, "localhost:0")
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	senderAddr := listener.Addr().String()
+
+	inv := sInventory{SenderAddr: senderAddr, Kind: "tx", Items: [][]byte{transaction.Id}}
+	if err := send(ctx, dstAddr, append(cmd2Bytes("inv"), utils.GobEncode(inv)...)); err != nil {
+		return false, err
+	}
+
+	// wait for the getdata, or regard the transaction as already known by dstAddr at the deadline
+	deadline := time.Now().Add(announceTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := listener.(*net.TCPListener).SetDeadline(deadline); err != nil {
+		return false, err
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+		_ = conn.SetReadDeadline(ioDeadline(ctx))
+		request, err := ioutil.ReadAll(conn)
+		_ = conn.Close()
+		if err != nil || len(request) < cmdLen || bytes2Cmd(request[:cmdLen]) != "getdata" {
+			continue
+		}
+
+		var getData sGetData
+		if err := gob.NewDecoder(bytes.NewReader(request[cmdLen:])).Decode(&getData); err != nil {
+			continue
+		}
+		if getData.Kind != "tx" || !bytes.Equal(getData.Id, transaction.Id) {
+			continue
+		}
+		return true, sendTxFrom(ctx, senderAddr, getData.SenderAddr, transaction)
+	}
+}
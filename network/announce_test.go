@@ -0,0 +1,62 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`context`
+	`github.com/stretchr/testify/assert`
+	`lightChain/core`
+	`testing`
+	`time`
+)
+
+func TestAnnounceTx(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	var stop func()
+	nodeIPAddress, stop = runMockNode(t, chain)
+	t.Cleanup(stop)
+	oldTimeout := announceTimeout
+	announceTimeout = 200 * time.Millisecond
+	t.Cleanup(func() {
+		announceTimeout = oldTimeout
+	})
+
+	tx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	pulled, err := AnnounceTx(context.Background(), nodeIPAddress, tx)
+	assert.Nil(t, err)
+	assert.True(t, pulled, "The receiver pulls the announced transaction it does not have")
+	assert.Eventually(t, func() bool {
+		_, ok := PooledTx(tx.Id)
+		return ok
+	}, time.Second, 10*time.Millisecond, "The pulled transaction is pooled")
+
+	pulled, err = AnnounceTx(context.Background(), nodeIPAddress, tx)
+	assert.Nil(t, err)
+	assert.False(t, pulled, "The receiver does not refetch the pooled transaction")
+
+	// the transaction is packed into chain and removed from the pool while the node is stopped, thus the node is not
+	// serving or mining meanwhile
+	stop()
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+	txPool = make(TxPool)
+	nodeIPAddress = startMockNode(t, chain)
+	pulled, err = AnnounceTx(context.Background(), nodeIPAddress, tx)
+	assert.Nil(t, err)
+	assert.False(t, pulled, "The receiver does not refetch the packed transaction")
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the comparison of two nodes' lightChain copies through the "version" exchange.
+
+package network
+
+import (
+	`bytes`
+	`encoding/gob`
+	`fmt`
+	`io`
+	`io/ioutil`
+	`lightChain/utils`
+	`net`
+	`time`
+)
+
+// queryTimeout is how long to wait for a node answering a version query.
+const queryTimeout = 5 * time.Second
+
+// CompareChains queries the nodes peerA and peerB for their versions, and reports whether their lightChain copies are
+// in sync (the same tip height and tip hash), and the height of peerA minus the height of peerB.
+func CompareChains(peerA, peerB string) (bool, int, error) {
+	versionA, err := queryVersion(peerA)
+	if err != nil {
+		return false, 0, err
+	}
+	versionB, err := queryVersion(peerB)
+	if err != nil {
+		return false, 0, err
+	}
+
+	heightDiff := versionA.Height - versionB.Height
+	return heightDiff == 0 && bytes.Equal(versionA.TipHash, versionB.TipHash), heightDiff, nil
+}
+
+// queryVersion sends a version query to the node peerAddr, and waits for the sVersion it answers with. The query
+// carries a negative height, thus the node always answers with its own version (see handleVersion).
+func queryVersion(peerAddr string) (*sVersion, error) {
+	// listen on a temporary port for the answer
+	listener, err := net.Listen(protocol, "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	query := sVersion{
+		Version:    nodeVersion,
+		Height:     -1,
+		SenderAddr: listener.Addr().String(),
+	}
+	conn, err := net.DialTimeout(protocol, peerAddr, queryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not available: %v", peerAddr, err)
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(queryTimeout))
+	request := append(cmd2Bytes("version"), utils.GobEncode(query)...)
+	_, err = io.Copy(conn, bytes.NewReader(request))
+	_ = conn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// wait for the answer
+	err = listener.(*net.TCPListener).SetDeadline(time.Now().Add(queryTimeout))
+	if err != nil {
+		return nil, err
+	}
+	answerConn, err := listener.Accept()
+	if err != nil {
+		return nil, fmt.Errorf("%s does not answer: %v", peerAddr, err)
+	}
+	_ = answerConn.SetReadDeadline(time.Now().Add(queryTimeout))
+	answer, err := ioutil.ReadAll(answerConn)
+	_ = answerConn.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(answer) < cmdLen || bytes2Cmd(answer[:cmdLen]) != "version" {
+		return nil, fmt.Errorf("%s answers with an unexpected message", peerAddr)
+	}
+
+	var version sVersion
+	err = gob.NewDecoder(bytes.NewReader(answer[cmdLen:])).Decode(&version)
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`github.com/stretchr/testify/assert`
+	`testing`
+)
+
+func TestCompareChains(t *testing.T) {
+	KnownNodes = []string{CentralNode}
+	chain, longerChain := newMemChain(1), newMemChain(3)
+	nodeA, nodeB, nodeC := startMockNode(t, chain), startMockNode(t, chain), startMockNode(t, longerChain)
+
+	inSync, heightDiff, err := CompareChains(nodeA, nodeB)
+	assert.Nil(t, err)
+	assert.True(t, inSync, "Nodes with the same chain are in sync")
+	assert.Equal(t, 0, heightDiff)
+
+	inSync, heightDiff, err = CompareChains(nodeA, nodeC)
+	assert.Nil(t, err)
+	assert.False(t, inSync, "Nodes at different heights are not in sync")
+	assert.Equal(t, -2, heightDiff)
+
+	inSync, heightDiff, err = CompareChains(nodeC, nodeA)
+	assert.Nil(t, err)
+	assert.False(t, inSync)
+	assert.Equal(t, 2, heightDiff)
+
+	assert.Equal(t, []string{CentralNode}, KnownNodes, "Version queries do not register nodes")
+}
+
+func TestCompareChainsSameHeight(t *testing.T) {
+	// same height, but different tips
+	nodeA, nodeB := startMockNode(t, newMemChain(2)), startMockNode(t, newMemChain(2))
+
+	inSync, heightDiff, err := CompareChains(nodeA, nodeB)
+	assert.Nil(t, err)
+	assert.False(t, inSync, "Nodes with different tips are not in sync")
+	assert.Equal(t, 0, heightDiff)
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the helpers shared by the tests of the network package, e.g., the chains and nodes to test against.
+
+package network
+
+import (
+	`bytes`
+	`context`
+	`github.com/stretchr/testify/assert`
+	`lightChain/core`
+	`net`
+	`testing`
+)
+
+// newTestChain creates a chain inside a temporary data directory and resets the global state of this node as
+// the central node (which never mines).
+func newTestChain(t *testing.T) (*core.BlockChain, *core.Wallet) {
+	oldDataDir := core.DataDir
+	core.DataDir = t.TempDir()
+
+	wallet := core.NewWallet()
+	chain, _ := core.CreateBlockChain(string(wallet.GetAddr()), "test", core.DefaultGenesisConfig())
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	nodeIPAddress = CentralNode
+	KnownNodes = []string{CentralNode}
+	peerVersions = make(map[string]int)
+	txPool = make(map[string]core.Transaction)
+	orphanBlocks = newOrphanPool()
+
+	t.Cleanup(func() {
+		_ = chain.Db.Close()
+		core.DataDir = oldDataDir
+	})
+	return chain, wallet
+}
+
+// newMinerChain works like newTestChain, but this node is a miner whose rewards go to the returned wallet, and the
+// mining is not paused.
+func newMinerChain(t *testing.T) (*core.BlockChain, *core.Wallet) {
+	chain, wallet := newTestChain(t)
+	nodeIPAddress, miningWalletAddress, KnownNodes = "localhost:0", string(wallet.GetAddr()), nil
+	t.Cleanup(func() {
+		miningWalletAddress = ""
+		ResumeMining(context.Background(), chain)
+	})
+	return chain, wallet
+}
+
+// newMemChain creates an in-memory chain with numBlocks blocks mined after the genesis block.
+func newMemChain(numBlocks int) *core.BlockChain {
+	addr := string(core.NewWallet().GetAddr())
+	chain, _ := core.CreateBlockChainWithStore(core.NewMemStore(), addr, core.DefaultGenesisConfig())
+	core.UTXOSet{BlockChain: chain}.Rebuild()
+	for i := 0; i < numBlocks; i++ {
+		mineTxs(chain, addr)
+	}
+	return chain
+}
+
+// extendChain copies chain into an in-memory store, and mines numBlocks blocks on the copy after the tip of chain.
+func extendChain(t *testing.T, chain *core.BlockChain, numBlocks int) *core.BlockChain {
+	var buf bytes.Buffer
+	_, err := chain.ExportTo(&buf)
+	assert.Nil(t, err)
+	extended, err := core.ImportChainWithStore(&buf, core.NewMemStore())
+	assert.Nil(t, err)
+	addr := string(core.NewWallet().GetAddr())
+	for i := 0; i < numBlocks; i++ {
+		mineTxs(extended, addr)
+	}
+	return extended
+}
+
+// mineTxs mines a block packing a coinbase transaction paying addr followed by txs on the tip of chain, and updates
+// the UTXO set of chain with it.
+func mineTxs(chain *core.BlockChain, addr string, txs ...*core.Transaction) *core.Block {
+	height := chain.GetChainHeight() + 1
+	coinbaseTx := core.NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)
+	block := chain.MineBlock(append([]*core.Transaction{coinbaseTx}, txs...))
+	core.UTXOSet{BlockChain: chain}.Update(block)
+	return block
+}
+
+// startMockNode serves chain on a temporary port as a node does, and returns the address of the node.
+func startMockNode(t *testing.T, chain *core.BlockChain) string {
+	addr, stop := runMockNode(t, chain)
+	t.Cleanup(stop)
+	return addr
+}
+
+// runMockNode serves chain at a local address like startMockNode, but the node is stopped by the returned function,
+// which returns once the request being handled (if any) is done, i.e., the node is idle.
+func runMockNode(t *testing.T, chain *core.BlockChain) (string, func()) {
+	listener, err := net.Listen(protocol, "localhost:0")
+	assert.Nil(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			handleConn(context.Background(), conn, chain)
+		}
+	}()
+	return listener.Addr().String(), func() {
+		_ = listener.Close()
+		<-done
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the mining of the miner node: the pooled transactions are packed into a new block once the pool
+// is full. The mining can be paused and resumed at runtime, e.g., during maintenance.
+
+package network
+
+import (
+	`context`
+	`encoding/hex`
+	`fmt`
+	`lightChain/core`
+	`sync/atomic`
+)
+
+// miningPaused is 1 if the mining is paused. It is accessed atomically since it is set by the query interface.
+var miningPaused int32
+
+// PauseMining pauses the mining of this node. The received transactions are still pooled, but no block is produced
+// until ResumeMining.
+func PauseMining() {
+	atomic.StoreInt32(&miningPaused, 1)
+}
+
+// ResumeMining resumes the mining of this node, and packs the pooled transactions at once if the pool is full. The
+// mined blocks are broadcast with ctx.
+func ResumeMining(ctx context.Context, chain *core.BlockChain) {
+	atomic.StoreInt32(&miningPaused, 0)
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	mineTxPool(ctx, chain)
+}
+
+// MiningPaused reports whether the mining of this node is paused.
+func MiningPaused() bool {
+	return atomic.LoadInt32(&miningPaused) == 1
+}
+
+// mineTxPool packs the pooled transactions into new blocks and broadcasts them, if this node is a miner, the pool has
+// at least txNum4Mining transactions, and the mining is not paused. poolMu is held by the caller.
+func mineTxPool(ctx context.Context, chain *core.BlockChain) {
+	if len(txPool) < txNum4Mining || len(miningWalletAddress) == 0 {
+		return
+	}
+
+MineTxs:
+	if MiningPaused() {
+		fmt.Printf("Mining is paused. %d transactions are kept in pool.\n", len(txPool))
+		return
+	}
+	height := chain.GetChainHeight() + 1
+	verifiedTxs := packTxPool(chain, miningWalletAddress, height)
+	if len(verifiedTxs) == 1 { // the coinbase transaction only
+		fmt.Printf("No transaction is valid. Waiting for new transactions...\n")
+		return
+	}
+
+	// pack into a new block, which is mined at the retargeted difficulty
+	newBlock := chain.MineBlock(verifiedTxs)
+	if newBlock == nil {
+		fmt.Printf("Another block is added during mining. Packing the transactions again...\n")
+		goto MineTxs
+	}
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+	fmt.Printf("New block is successfully mined!\n")
+
+	// remove the already packed transactions from pool
+	for _, tx := range verifiedTxs {
+		delete(txPool, hex.EncodeToString(tx.Id))
+	}
+
+	// broadcast this newly mined block to all known nodes
+	for _, node := range knownNodes() {
+		if node != nodeIPAddress {
+			sendInv(ctx, node, "block", [][]byte{newBlock.Hash})
+		}
+	}
+
+	if len(txPool) > 0 {
+		goto MineTxs
+	}
+}
+
+// packTxPool returns the transactions of the block at height mined by minerAddr: the valid pooled transactions which
+// are final at the median time past of chain, and the coinbase transaction at last. The pooled transactions are packed
+// from the highest fee rate, but they may spend the outputs of each other, thus each parent is still packed before its
+// children, and the ones of a sender carrying nonces are packed in nonce order (see core.BlockChain.OrderVerifiedTxs).
+// The ones locked until the future are left in pool.
+func packTxPool(chain *core.BlockChain, minerAddr string, height int) []*core.Transaction {
+	var pooledTxs []*core.Transaction
+	medianTime := chain.MedianTimePast()
+	for _, txInPool := range txPool.SortedByFeeRate(chain) {
+		txInPool := txInPool
+		if txInPool.IsFinal(medianTime) {
+			pooledTxs = append(pooledTxs, &txInPool)
+		}
+	}
+	verifiedTxs := chain.OrderVerifiedTxs(pooledTxs)
+
+	coinbaseTx := core.NewCoinbaseTx(minerAddr, "", chain.CurrentReward(height), height)
+	return append(verifiedTxs, coinbaseTx)
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`context`
+	`encoding/hex`
+	`github.com/stretchr/testify/assert`
+	`lightChain/core`
+	`path/filepath`
+	`testing`
+	`time`
+)
+
+// fillPool sends txNum4Mining transactions of wallet to this node, each of which spends the change of the previous one.
+func fillPool(t *testing.T, chain *core.BlockChain, wallet *core.Wallet) {
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	tx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	handleTx(context.Background(), txRequest(tx), chain)
+	for i := 1; i < txNum4Mining; i++ {
+		changeIdx := len(tx.Vout) - 1
+		child := &core.Transaction{
+			Version: core.TxVersion,
+			Vin:     []core.TxInput{{TxId: tx.Id, VoutIdx: changeIdx, PubKey: wallet.PubKey}},
+			Vout:    []core.TxOutput{*core.NewTxOutput(tx.Vout[changeIdx].Value, string(wallet.GetAddr()))},
+		}
+		child.Id = child.Hashing()
+		child.Sign(wallet.PrivateKey, map[string]core.Transaction{hex.EncodeToString(tx.Id): *tx})
+		handleTx(context.Background(), txRequest(child), chain)
+		tx = child
+	}
+}
+
+func TestPauseMining(t *testing.T) {
+	chain, wallet := newMinerChain(t)
+
+	PauseMining()
+	assert.True(t, MiningPaused())
+	fillPool(t, chain, wallet)
+	assert.Equal(t, 0, chain.GetChainHeight(), "No block is produced while paused")
+	assert.Equal(t, txNum4Mining, len(txPool), "The received transactions are still pooled")
+
+	ResumeMining(context.Background(), chain)
+	assert.False(t, MiningPaused())
+	assert.Equal(t, 1, chain.GetChainHeight(), "Resuming mines the full pool")
+	assert.Empty(t, txPool)
+}
+
+func TestControlMining(t *testing.T) {
+	chain, wallet := newMinerChain(t)
+	listener, err := ListenQueries("unix", filepath.Join(t.TempDir(), "rpc.sock"))
+	assert.Nil(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	go ServeQueries(context.Background(), listener, chain)
+
+	paused, err := ControlMining("unix", listener.Addr().String(), true)
+	assert.Nil(t, err)
+	assert.True(t, paused)
+	fillPool(t, chain, wallet)
+	assert.Equal(t, 0, chain.GetChainHeight())
+
+	paused, err = ControlMining("unix", listener.Addr().String(), false)
+	assert.Nil(t, err)
+	assert.False(t, paused)
+	// the answer is written before mining, thus wait for the block (and the pool to be cleaned after it)
+	assert.Eventually(t, func() bool {
+		height, _, err := QueryHeight("unix", listener.Addr().String())
+		poolMu.Lock()
+		defer poolMu.Unlock()
+		return err == nil && height == 1 && len(txPool) == 0
+	}, 5*time.Second, 50*time.Millisecond, "Resuming through the query interface mines the full pool")
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the pool of orphan blocks, i.e., the received blocks whose parents are unknown yet. They are
+// kept until their parents arrive, and the pool is bounded by MaxOrphanBlocks against the peers flooding unconnectable
+// blocks.
+
+package network
+
+import (
+	`bytes`
+	`encoding/hex`
+	`fmt`
+	`lightChain/core`
+	`sort`
+	`sync`
+)
+
+// MaxOrphanBlocks is the most orphan blocks kept in orphanBlocks. The one arriving first is evicted when a new orphan
+// arrives at the limit. Set it before StartNode.
+var MaxOrphanBlocks = 100
+
+// orphanBlock is a block in OrphanPool with its arrival order.
+type orphanBlock struct {
+	block   *core.Block
+	arrival uint64
+}
+
+// OrphanPool keeps the orphan blocks, where the key is string of block's hash. It is safe for the concurrent handlers
+// of the blocks arriving on different connections.
+type OrphanPool struct {
+	mu          sync.Mutex
+	blocks      map[string]orphanBlock
+	nextArrival uint64
+}
+
+// newOrphanPool returns an empty OrphanPool.
+func newOrphanPool() *OrphanPool {
+	return &OrphanPool{blocks: make(map[string]orphanBlock)}
+}
+
+// The orphan blocks received by this node.
+var orphanBlocks = newOrphanPool()
+
+// Len returns the number of the orphan blocks in pool.
+func (pool *OrphanPool) Len() int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return len(pool.blocks)
+}
+
+// Has detects whether the block of hash is in pool.
+func (pool *OrphanPool) Has(hash []byte) bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	_, ok := pool.blocks[hex.EncodeToString(hash)]
+	return ok
+}
+
+// addIfOrphan puts block into pool if its parent is unknown to chain (see isOrphan), and reports whether it is an
+// orphan. The parent is checked under the lock of pool, and connectOrphans takes the children of a block under the
+// same lock after adding it, thus an orphan whose parent is added meanwhile is never left in pool.
+func (pool *OrphanPool) addIfOrphan(chain *core.BlockChain, block *core.Block) bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if !isOrphan(chain, block) {
+		return false
+	}
+	pool.add(block)
+	return true
+}
+
+// add puts block into pool, evicting the orphans arriving first if pool is full (see MaxOrphanBlocks). Nothing is done
+// if block is already in pool. pool.mu is held by the caller.
+func (pool *OrphanPool) add(block *core.Block) {
+	hash := hex.EncodeToString(block.Hash)
+	if _, ok := pool.blocks[hash]; ok {
+		return
+	}
+	for len(pool.blocks) > 0 && len(pool.blocks) >= MaxOrphanBlocks {
+		pool.evictOldest()
+	}
+	pool.blocks[hash] = orphanBlock{block: block, arrival: pool.nextArrival}
+	pool.nextArrival++
+}
+
+// evictOldest removes the orphan arriving first from pool. pool.mu is held by the caller.
+func (pool *OrphanPool) evictOldest() {
+	var oldest string
+	for hash, orphan := range pool.blocks {
+		if oldest == "" || orphan.arrival < pool.blocks[oldest].arrival {
+			oldest = hash
+		}
+	}
+	fmt.Printf("The orphan pool is full. Evict the orphan block %s\n", oldest)
+	delete(pool.blocks, oldest)
+}
+
+// takeChildren removes the orphans whose parent is the block of parentHash from pool, and returns them in the order
+// they arrive.
+func (pool *OrphanPool) takeChildren(parentHash []byte) []*core.Block {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	var children []orphanBlock
+	for hash, orphan := range pool.blocks {
+		if bytes.Equal(orphan.block.PrevBlockHash, parentHash) {
+			children = append(children, orphan)
+			delete(pool.blocks, hash)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].arrival < children[j].arrival
+	})
+
+	blocks := make([]*core.Block, 0, len(children))
+	for _, child := range children {
+		blocks = append(blocks, child.block)
+	}
+	return blocks
+}
+
+// isOrphan detects whether the parent of block is unknown to chain. The genesis block is never an orphan.
+func isOrphan(chain *core.BlockChain, block *core.Block) bool {
+	if block.Height == 0 {
+		return false
+	}
+	_, err := chain.GetBlock(block.PrevBlockHash)
+	return err != nil
+}
+
+// connectOrphans validates and adds to chain the orphans descending from the block of parentHash, which is just added.
+// The invalid ones are dropped, and so are their descendants, which stay orphans until evicted.
+func connectOrphans(chain *core.BlockChain, parentHash []byte) {
+	parents := [][]byte{parentHash}
+	for len(parents) > 0 {
+		children := orphanBlocks.takeChildren(parents[0])
+		parents = parents[1:]
+		for _, child := range children {
+			if err := chain.ValidateBlock(child); err != nil {
+				fmt.Printf("Reject the orphan block %x: %v\n", child.Hash, err)
+				continue
+			}
+			chain.AddBlock(child)
+			fmt.Printf("Connected the orphan block %x\n", child.Hash)
+			parents = append(parents, child.Hash)
+		}
+	}
+}
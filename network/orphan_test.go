@@ -0,0 +1,124 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`context`
+	`github.com/stretchr/testify/assert`
+	`lightChain/core`
+	`sync`
+	`testing`
+)
+
+// childBlock returns a block mined on the block of prevHash at height, whose reward goes to addr.
+func childBlock(prevHash []byte, height int, addr string, chain *core.BlockChain) *core.Block {
+	coinbaseTx := core.NewCoinbaseTx(addr, "", chain.CurrentReward(height), height)
+	return core.NewBlock([]*core.Transaction{coinbaseTx}, prevHash, height)
+}
+
+func TestOrphanPoolBounded(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	addr := string(wallet.GetAddr())
+	oldMax := MaxOrphanBlocks
+	MaxOrphanBlocks = 2
+	t.Cleanup(func() { MaxOrphanBlocks = oldMax })
+
+	// the parents of the flooded blocks never arrive
+	var flooded []*core.Block
+	for i := 0; i < 3; i++ {
+		unknownParent := []byte{byte(i), 1, 2, 3}
+		flooded = append(flooded, childBlock(unknownParent, chain.GetChainHeight()+2, addr, chain))
+		handleBlock(context.Background(), blockRequest(flooded[i]), chain)
+		assert.LessOrEqual(t, orphanBlocks.Len(), MaxOrphanBlocks, "The orphan pool is bounded")
+	}
+	assert.False(t, orphanBlocks.Has(flooded[0].Hash), "The orphan arriving first is evicted")
+	assert.True(t, orphanBlocks.Has(flooded[1].Hash))
+	assert.True(t, orphanBlocks.Has(flooded[2].Hash))
+	for _, block := range flooded {
+		_, err := chain.GetBlock(block.Hash)
+		assert.NotNil(t, err, "The orphan is not added to chain")
+	}
+}
+
+func TestOrphanConnected(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	addr := string(wallet.GetAddr())
+	oldMax := MaxOrphanBlocks
+	MaxOrphanBlocks = 2
+	t.Cleanup(func() { MaxOrphanBlocks = oldMax })
+
+	height := chain.GetChainHeight()
+	b1 := childBlock(chain.Tip, height+1, addr, chain)
+	b2 := childBlock(b1.Hash, height+2, addr, chain)
+	b3 := childBlock(b2.Hash, height+3, addr, chain)
+
+	// the descendants arrive before their parents
+	handleBlock(context.Background(), blockRequest(b3), chain)
+	handleBlock(context.Background(), blockRequest(b2), chain)
+	assert.Equal(t, 2, orphanBlocks.Len())
+	assert.Equal(t, height, chain.GetChainHeight(), "The orphans are not added yet")
+
+	handleBlock(context.Background(), blockRequest(b1), chain)
+	assert.Equal(t, 0, orphanBlocks.Len(), "The orphans are connected after their parent arrives")
+	assert.Equal(t, height+3, chain.GetChainHeight())
+	assert.Equal(t, b3.Hash, chain.Tip)
+}
+
+func TestOrphanWithoutWork(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	addr := string(wallet.GetAddr())
+	oldMax := MaxOrphanBlocks
+	MaxOrphanBlocks = 1
+	t.Cleanup(func() { MaxOrphanBlocks = oldMax })
+
+	height := chain.GetChainHeight()
+	b1 := childBlock(chain.Tip, height+1, addr, chain)
+	b2 := childBlock(b1.Hash, height+2, addr, chain)
+	handleBlock(context.Background(), blockRequest(b2), chain)
+
+	// the hash does not match the nonce any longer
+	flooded := childBlock([]byte{1, 2, 3}, height+2, addr, chain)
+	flooded.Nonce++
+	handleBlock(context.Background(), blockRequest(flooded), chain)
+	assert.False(t, orphanBlocks.Has(flooded.Hash), "The block without work is not kept")
+	assert.True(t, orphanBlocks.Has(b2.Hash), "The orphan is not evicted by the block without work")
+}
+
+func TestOrphansConcurrent(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	addr := string(wallet.GetAddr())
+
+	height := chain.GetChainHeight()
+	blocks := []*core.Block{childBlock(chain.Tip, height+1, addr, chain)}
+	for i := 1; i < 8; i++ {
+		blocks = append(blocks, childBlock(blocks[i-1].Hash, height+i+1, addr, chain))
+	}
+
+	// the blocks arrive on concurrent connections, thus a parent may be added while its child is checked
+	var wg sync.WaitGroup
+	for i := len(blocks) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(block *core.Block) {
+			defer wg.Done()
+			handleBlock(context.Background(), blockRequest(block), chain)
+		}(blocks[i])
+	}
+	wg.Wait()
+	assert.Equal(t, 0, orphanBlocks.Len(), "No orphan is left after its parent is added")
+	assert.Equal(t, height+len(blocks), chain.GetChainHeight())
+	assert.Equal(t, blocks[len(blocks)-1].Hash, chain.TipHash())
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file describes the wire format of the protocol: each command is followed by its gob-encoded payload struct.
+
+package network
+
+import (
+	`fmt`
+	`reflect`
+	`sort`
+)
+
+// CommandSpec describes a command of the protocol. Description tells what the request is for, and Payload lists the
+// fields ("name type") of the payload struct following the cmdLen bytes of the command.
+type CommandSpec struct {
+	Name        string
+	Description string
+	Payload     []string
+}
+
+// commandSpecs maps each command to its CommandSpec.
+var commandSpecs = make(map[string]CommandSpec)
+
+// DescribeCommand records the CommandSpec of cmd, whose payload is the gob-encoded struct like payload. The spec
+// described before for cmd is replaced.
+func DescribeCommand(cmd, description string, payload interface{}) {
+	var fields []string
+	payloadType := reflect.TypeOf(payload)
+	for idx := 0; idx < payloadType.NumField(); idx++ {
+		field := payloadType.Field(idx)
+		fields = append(fields, fmt.Sprintf("%s %s", field.Name, field.Type))
+	}
+	commandSpecs[cmd] = CommandSpec{Name: cmd, Description: description, Payload: fields}
+}
+
+// ProtocolInfo returns the CommandSpec of each command with a registered Handler, in the order of names. A command
+// which is not described (see DescribeCommand) is listed with its name only.
+func ProtocolInfo() []CommandSpec {
+	var specs []CommandSpec
+	for cmd := range handlers {
+		spec, ok := commandSpecs[cmd]
+		if !ok {
+			spec = CommandSpec{Name: cmd}
+		}
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+	return specs
+}
+
+func init() {
+	DescribeCommand("version", "Exchange the versions and the tips of two nodes. A negative Height marks a version query",
+		sVersion{})
+	DescribeCommand("addr", "Make the addresses in AddrList discoverable to the receiver", sAddr{})
+	DescribeCommand("inv", "Show the receiver the hashes of the blocks or the ids of the txs the sender has",
+		sInventory{})
+	DescribeCommand("getblocks", "Ask the receiver to show the hashes of all its blocks", sGetBlocks{})
+	DescribeCommand("getheaders", "Ask the receiver for the headers after From (excluded) till To (included)",
+		sGetHeaders{})
+	DescribeCommand("headers", "Send the block headers from the oldest to the newest", sHeaders{})
+	DescribeCommand("getdata", "Ask the receiver for the block or the tx whose identity is Id", sGetData{})
+	DescribeCommand("block", "Send a serialized block", sBlock{})
+	DescribeCommand("tx", "Send a serialized transaction, answered with a sTxAck on the same connection since version 2",
+		sTx{})
+}
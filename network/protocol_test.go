@@ -0,0 +1,50 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`context`
+	`github.com/stretchr/testify/assert`
+	`lightChain/core`
+	`sort`
+	`testing`
+)
+
+func TestProtocolInfo(t *testing.T) {
+	specs := ProtocolInfo()
+	assert.Equal(t, len(handlers), len(specs), "Every registered command is listed")
+	assert.True(t, sort.SliceIsSorted(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	}))
+	for _, spec := range specs {
+		_, ok := handlers[spec.Name]
+		assert.True(t, ok)
+		assert.NotEmpty(t, spec.Description, "Command %s is described", spec.Name)
+		assert.NotEmpty(t, spec.Payload, "The payload of command %s is described", spec.Name)
+	}
+	assert.Contains(t, commandSpecs["version"].Payload, "TipHash []uint8")
+	assert.Contains(t, commandSpecs["headers"].Payload, "Headers []core.Header")
+
+	// a command registered without a spec is listed with its name only
+	RegisterHandler("ping", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		return nil
+	})
+	t.Cleanup(func() {
+		delete(handlers, "ping")
+	})
+	assert.Contains(t, ProtocolInfo(), CommandSpec{Name: "ping"})
+}
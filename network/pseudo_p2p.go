@@ -36,8 +36,10 @@ package network
 
 import (
 	`bytes`
+	`context`
 	`encoding/gob`
 	`encoding/hex`
+	`errors`
 	`fmt`
 	`io`
 	`io/ioutil`
@@ -45,18 +47,40 @@ import (
 	`lightChain/utils`
 	`log`
 	`net`
+	`sync`
+	`time`
 )
 
 const (
 	protocol     = "tcp"             // we use tcp to establish connection between nodes
-	nodeVersion  = 1                 // lightChain version
+	nodeVersion  = 2                 // lightChain version
+	minVersion   = 1                 // the lowest version of peers this node is compatible with
+	txAckVersion = 2                 // the lowest negotiated version whose receivers acknowledge the txs (see sTxAck)
 	cmdLen       = 12                // the length of command transferred between nodes
 	CentralNode  = "localhost:23333" // the address of the central node
 	txNum4Mining = 2                 // if the txPool has more than txNum4Mining txs, the miner node starts packing and mining
 )
 
-// KnownNodes plays the role of connection to DNS server, which is responsible for node register and discovery.
-var KnownNodes = []string{CentralNode}
+// IOTimeout bounds each read of a request and each send, thus a slow peer can not tie up a goroutine forever. The
+// deadline of the context is applied instead if it is earlier.
+var IOTimeout = 30 * time.Second
+
+// SeedNodes are the nodes a newly added node tries in turn to bootstrap from (in bitcoin, seed nodes are chosen by
+// DNS server). Set it before StartNode.
+var SeedNodes = []string{CentralNode}
+
+// peerVersions records the negotiated version with each compatible peer, i.e., the lower one of the two nodes'
+// versions. Optional features (e.g., the acknowledgement of txs) are enabled for a peer only if the negotiated version
+// supports them (see peerVersion). It is guarded by versionsMu, since the versions are negotiated by the handlers of any
+// goroutine.
+var peerVersions = make(map[string]int)
+var versionsMu sync.Mutex
+
+// KnownNodes plays the role of connection to DNS server, which is responsible for node register and discovery. It is
+// seeded from SeedNodes. It is guarded by knownMu, since the peers are evicted and re-added by the sends of any
+// goroutine (see knownNodes).
+var KnownNodes = append([]string{}, SeedNodes...)
+var knownMu sync.Mutex
 
 // nodeIPAddress plays the role of "current node". It is set at StartNode function.
 var nodeIPAddress string
@@ -65,22 +89,26 @@ var nodeIPAddress string
 var miningWalletAddress string
 
 // A local pool for collecting known transactions, used for packing to a new block. Only the miner node can visit & modify this var.
-var txPool = make(map[string]core.Transaction)
+var txPool = make(TxPool)
 
-var blocksInTransit [][]byte
+// poolMu guards txPool, which is visited by the handlers of concurrent connections and the query interface. It is held
+// during mining as well, since the mined transactions are removed from txPool afterwards.
+var poolMu sync.Mutex
 
 /*
 The following defines the request communicated between nodes. In general, request consists of two parts:
 command (the first 12 bytes) and content (the left bytes).
-	- command: version, addr, inv, getblocks, getdata, block, tx
-	- content: sVersion, sAddr, sInventory, sGetBlocks, sGetData, sBlock, sTx
+	- command: version, addr, inv, getblocks, getheaders, headers, getdata, block, tx
+	- content: sVersion, sAddr, sInventory, sGetBlocks, sGetHeaders, sHeaders, sGetData, sBlock, sTx
 All the contents are defined as structs as follows.
 */
 
 // sVersion is used to find a newer blockchain copy from the server node for the client node whose address is SenderAddr.
+// A negative Height marks a version query (see CompareChains), which is answered without registering the sender.
 type sVersion struct {
 	Version    int    // current version of client's lightChain
 	Height     int    // current height (#blocks) of client's lightChain
+	TipHash    []byte // the hash of the tip block of client's lightChain
 	SenderAddr string // the address of client node who sends this
 }
 
@@ -102,6 +130,22 @@ type sGetBlocks struct {
 	SenderAddr string // the address of client node who sends this
 }
 
+// sGetHeaders is used to construct a request from the client node whose address is SenderAddr to the server node.
+// The request asks the server to show the blocks after From (excluded) till To (included), thus the client only
+// fetches the gap it's missing. An empty From means from the genesis block, and an empty To means till the tip.
+type sGetHeaders struct {
+	SenderAddr string // the address of client node who sends this
+	From       []byte
+	To         []byte
+}
+
+// sHeaders is used to send block headers (from the oldest to the newest) from the server node to the client node whose
+// address is SenderAddr. The client validates the header chain before downloading any block body.
+type sHeaders struct {
+	SenderAddr string // the address of client node who sends this
+	Headers    []core.Header
+}
+
 // sGetData is used to construct a request from the client node whose address is SenderAddr to the server node.
 // The request asks the server to show the block or transaction whose identity is Id.
 type sGetData struct {
@@ -122,6 +166,19 @@ type sTx struct {
 	Transaction []byte
 }
 
+// sTxAck is the answer of the receiver of a sTx, which is written back on the same connection: whether the transaction
+// is accepted into the pool of the receiver, or why it is rejected.
+type sTxAck struct {
+	Accepted bool
+	Reason   string // empty if the transaction is accepted
+}
+
+// ErrTxRejected is returned by SendTx if the receiver rejects the transaction.
+var ErrTxRejected = errors.New("transaction rejected")
+
+// replyKey is the key of the connection a request is received from in the context passed to the handlers, see reply.
+type replyKey struct{}
+
 /* The following code defines the server-side functions (starts with "handle") for each p2p node. */
 
 // StartNode starts a new node as a tcp server.
@@ -129,6 +186,12 @@ type sTx struct {
 // Then, the node will listen a port, waits for connection, and processes the connection. The new node' address is
 // generated with nodeId. minerAddr gives the address of wallet to receive the coinbase and mining reward.
 func StartNode(nodeId, minerAddr string) {
+	StartNodeContext(context.Background(), nodeId, minerAddr)
+}
+
+// StartNodeContext starts a new node like StartNode, and all the network I/O of the node is done with ctx. The node
+// stops once ctx is canceled, which also aborts the in-flight sends.
+func StartNodeContext(ctx context.Context, nodeId, minerAddr string) {
 	nodeIPAddress = fmt.Sprintf("localhost:%s", nodeId)
 	miningWalletAddress = minerAddr
 
@@ -138,55 +201,151 @@ func StartNode(nodeId, minerAddr string) {
 		log.Panic(err)
 	}
 	defer func() {
-		err := listener.Close()
+		_ = listener.Close()
+	}()
+	// unblock the Accept below once ctx is canceled
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	// request and make a local copy of current lightChain from the whole network (actually the seed nodes in our case)
+	chain, err := core.NewBlockChain(nodeId)
+	if errors.Is(err, core.ErrNoChain) {
+		fmt.Printf("Cannot start node %s: %v\n", nodeId, err)
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	setKnownNodes(SeedNodes)
+	resetTriedNodes()
+	if seed, ok := bootstrap(ctx, chain); ok {
+		fmt.Printf("Bootstrap from the seed node %s\n", seed)
+	}
+	go retryPeers(ctx, chain)
+
+	// serve the local queries on the unix domain socket
+	if RPCSocket != "" {
+		queryListener, err := ListenQueries("unix", RPCSocket)
 		if err != nil {
 			log.Panic(err)
 		}
-	}()
-
-	// request and make a local copy of current lightChain from the whole network (actually the central node in our case)
-	chain := core.NewBlockChain(nodeId)
-	if nodeIPAddress != CentralNode {
-		// if this node is not the central node, it should query the central node whether the blockchain it copied is outdated
-		sendVersion(CentralNode, chain)
+		defer func() {
+			_ = queryListener.Close()
+		}()
+		fmt.Printf("Serving queries on %s\n", RPCSocket)
+		go ServeQueries(ctx, queryListener, chain)
 	}
 
 	// as a server, wait, establish and handle each connection from clients
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			log.Panic(err)
 		}
-		go handleConn(conn, chain)
+		go handleConn(ctx, conn, chain)
+	}
+}
+
+// bootstrap tries each seed node (except this node itself) in turn, querying whether the blockchain this node copied
+// is outdated, until one responds. It returns the seed node responded. The unreachable seed nodes are evicted from
+// KnownNodes and retried later like the other peers (see evictPeer).
+func bootstrap(ctx context.Context, chain *core.BlockChain) (string, bool) {
+	for _, seed := range SeedNodes {
+		if seed == nodeIPAddress {
+			continue
+		}
+		if err := sendVersion(ctx, seed, chain); err == nil {
+			return seed, true
+		}
 	}
+	return "", false
 }
 
-// handleConn reads message from conn, extracts command from the message and call corresponding function
-// to process the command. Note that chain is from the server node.
-func handleConn(conn net.Conn, chain *core.BlockChain) {
+// Handler processes the request (whose first cmdLen bytes are the command) received by this node. The sends in response
+// should be done with ctx. Note that chain is from the server node.
+type Handler func(ctx context.Context, request []byte, chain *core.BlockChain) error
+
+// handlers maps each command to the Handler processing it.
+var handlers = make(map[string]Handler)
+
+// RegisterHandler registers handler for cmd, thus handleConn routes the requests of cmd to it. The handler registered
+// before for cmd is replaced.
+func RegisterHandler(cmd string, handler Handler) {
+	handlers[cmd] = handler
+}
+
+func init() {
+	RegisterHandler("version", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		handleVersion(ctx, request, chain)
+		return nil
+	})
+	RegisterHandler("addr", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		handleAddr(ctx, request)
+		return nil
+	})
+	RegisterHandler("block", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		handleBlock(ctx, request, chain)
+		return nil
+	})
+	RegisterHandler("inv", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		handleInv(ctx, request, chain)
+		return nil
+	})
+	RegisterHandler("getblocks", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		handleGetBlocks(ctx, request, chain)
+		return nil
+	})
+	RegisterHandler("getheaders", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		handleGetHeaders(ctx, request, chain)
+		return nil
+	})
+	RegisterHandler("headers", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		handleHeaders(ctx, request, chain)
+		return nil
+	})
+	RegisterHandler("getdata", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		handleGetData(ctx, request, chain)
+		return nil
+	})
+	RegisterHandler("tx", func(ctx context.Context, request []byte, chain *core.BlockChain) error {
+		return handleTx(ctx, request, chain)
+	})
+}
+
+// handleConn reads message from conn, extracts command from the message and call the Handler registered for the
+// command to process it. The reading is aborted at the deadline of ctx (see ioDeadline), or once ctx is canceled. The
+// Handler can answer on conn with reply. Note that chain is from the server node.
+func handleConn(ctx context.Context, conn net.Conn, chain *core.BlockChain) {
+	_ = conn.SetReadDeadline(ioDeadline(ctx))
+	stop := abortOnDone(ctx, conn)
 	request, err := ioutil.ReadAll(conn)
+	stop()
 	if err != nil {
-		log.Panic(err)
+		fmt.Printf("Failed to read the request from %s: %v\n", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	if len(request) < cmdLen {
+		fmt.Println("Invalid request: too short to carry a command!")
+		_ = conn.Close()
+		return
 	}
 	cmd := bytes2Cmd(request[:cmdLen])
 	fmt.Printf("Recevie command: %s\n", cmd)
+	if TraceMessages {
+		traceMessage("recv", cmd, conn.RemoteAddr().String(), len(request))
+	}
 
-	switch cmd {
-	case "version":
-		handleVersion(request, chain)
-	case "addr":
-		handleAddr(request)
-	case "block":
-		handleBlock(request, chain)
-	case "inv":
-		handleInv(request)
-	case "getblocks":
-		handleGetBlocks(request, chain)
-	case "getdata":
-		handleGetData(request, chain)
-	case "tx":
-		handleTx(request, chain)
-	default:
+	if handler, ok := handlers[cmd]; ok {
+		if err := handler(context.WithValue(ctx, replyKey{}, conn), request, chain); err != nil {
+			fmt.Printf("Failed to handle command %s: %v\n", cmd, err)
+		}
+	} else {
 		fmt.Println("Unknown command!")
 	}
 
@@ -198,8 +357,9 @@ func handleConn(conn net.Conn, chain *core.BlockChain) {
 
 // handleVersion handles the "version" request received from the client. If the server has a highest lightChain (which
 // means it has a newer lightChain copy), it will response to the client with sendVersion message. Otherwise, the server
-// will response to the client with sendGetBlocks message. Note that chain is from the server node.
-func handleVersion(request []byte, chain *core.BlockChain) {
+// will response to the client with sendGetHeaders message to fetch the blocks after its tip. Note that chain is from
+// the server node.
+func handleVersion(ctx context.Context, request []byte, chain *core.BlockChain) {
 	// extract the sVersion instance from the request
 	var buf bytes.Buffer
 	var payload sVersion
@@ -211,31 +371,34 @@ func handleVersion(request []byte, chain *core.BlockChain) {
 		log.Panic(err)
 	}
 
+	// drop the peer of an incompatible version
+	if !isCompatibleVersion(payload.Version) {
+		fmt.Printf("Drop the peer %s: version %d is lower than %d\n", payload.SenderAddr, payload.Version, minVersion)
+		return
+	}
+	setPeerVersion(payload.SenderAddr, payload.Version)
+
 	// according to the height of local (server) chain and client chain, response with different message
 	localHeight := chain.GetChainHeight()
 	externalHeight := payload.Height
 	if localHeight < externalHeight {
-		sendGetBlocks(payload.SenderAddr)
+		sendGetHeaders(ctx, payload.SenderAddr, chain.TipHash(), nil)
 	} else if localHeight > externalHeight {
-		sendVersion(payload.SenderAddr, chain)
+		sendVersion(ctx, payload.SenderAddr, chain)
+	}
+
+	// a version query does not come from a node
+	if externalHeight < 0 {
+		return
 	}
 
 	// if the client's address is not known beforehand, make it discoverable for all blockchain nodes
 	// this is actually a simulation of the DNS server's operation
-	senderAddrIsKnown := false
-	for _, node := range KnownNodes {
-		if node == payload.SenderAddr {
-			senderAddrIsKnown = true
-			break
-		}
-	}
-	if !senderAddrIsKnown {
-		KnownNodes = append(KnownNodes, payload.SenderAddr)
-	}
+	addKnownNode(payload.SenderAddr)
 }
 
 // TODO: this func may not used. The content of this func is included in handleVersion.
-func handleAddr(request []byte) {
+func handleAddr(ctx context.Context, request []byte) {
 	var buf bytes.Buffer
 	var payload sAddr
 
@@ -246,23 +409,26 @@ func handleAddr(request []byte) {
 		log.Panic(err)
 	}
 
+	knownMu.Lock()
 	KnownNodes = append(KnownNodes, payload.AddrList...)
 	fmt.Printf("#KnownNodes: %d\n", len(KnownNodes))
-	requestBlocks()
+	knownMu.Unlock()
+	requestBlocks(ctx)
 }
 
 // requestBlocks sends nodeIPAddress to all known nodes.
-func requestBlocks() {
-	for _, node := range KnownNodes {
-		sendGetBlocks(node)
+func requestBlocks(ctx context.Context) {
+	for _, node := range knownNodes() {
+		sendGetBlocks(ctx, node)
 	}
 }
 
 // handleInv handles the received sInventory instance from the client. If the inventory is block, this server will save
-// all received blocks' hash in blocksInTransit and call sendGetData to the client to get a block.
-// If the inventory is transaction and this server does not have this transaction, it will call sendGetData to the client
-// to get a tx.
-func handleInv(request []byte) {
+// all received blocks' hash in blocksInTransit (replacing the ones from the same client) and call sendGetData to the
+// client to get the first MaxBlocksInFlight blocks.
+// If the inventory is transaction and this server does not have this transaction (neither pooled nor packed into chain),
+// it will call sendGetData to the client to get a tx. Note that chain is from the server node.
+func handleInv(ctx context.Context, request []byte, chain *core.BlockChain) {
 	// extract the inventory instance from request
 	var buf bytes.Buffer
 	var payload sInventory
@@ -277,31 +443,26 @@ func handleInv(request []byte) {
 	fmt.Printf("Receive inventory with %d %ss\n", len(payload.Items), payload.Kind)
 
 	if payload.Kind == "block" {
-		blocksInTransit = payload.Items
-		blockHash := payload.Items[0]
-		sendGetData(payload.SenderAddr, "block", blockHash)
-
-		// reset blocksInTransit
-		var newInTransit [][]byte
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
-			}
+		// the hashes are listed from the newest to the oldest, but a block can only be validated after the blocks it
+		// depends on are added, thus download them from the oldest to the newest
+		var hashes [][]byte
+		for itemIdx := len(payload.Items) - 1; itemIdx >= 0; itemIdx-- {
+			hashes = append(hashes, payload.Items[itemIdx])
 		}
-		blocksInTransit = newInTransit
+		getBlocks(ctx, payload.SenderAddr, blocksInTransit.start(payload.SenderAddr, hashes))
 	}
 
 	if payload.Kind == "tx" {
 		txId := payload.Items[0]
-		if txPool[hex.EncodeToString(txId)].Id == nil {
-			sendGetData(payload.SenderAddr, "tx", txId)
+		if _, pooled := PooledTx(txId); !pooled && !chain.HasTx(txId) {
+			sendGetData(ctx, payload.SenderAddr, "tx", txId)
 		}
 	}
 }
 
 // handleGetBlocks handles the "getblocks" request received from the client. The server node sends all blocks' hash
 // it have to the client node. Note that chain is from the server node.
-func handleGetBlocks(request []byte, chain *core.BlockChain) {
+func handleGetBlocks(ctx context.Context, request []byte, chain *core.BlockChain) {
 	// extract sGetBlocks instance from the request
 	var buf bytes.Buffer
 	var payload sGetBlocks
@@ -315,14 +476,91 @@ func handleGetBlocks(request []byte, chain *core.BlockChain) {
 
 	// send all blocks' hash from the server node to the client node
 	blockHashes := chain.GetAllBlocksHashes()
-	sendInv(payload.SenderAddr, "block", blockHashes)
+	sendInv(ctx, payload.SenderAddr, "block", blockHashes)
+}
+
+// handleGetHeaders handles the "getheaders" request received from the client. The server node sends the headers of
+// the blocks in the requested range to the client node. If the range cannot be located (e.g., the client's tip is not
+// on the server's chain), the headers of all blocks are sent. Note that chain is from the server node.
+func handleGetHeaders(ctx context.Context, request []byte, chain *core.BlockChain) {
+	var buf bytes.Buffer
+	var payload sGetHeaders
+
+	buf.Write(request[cmdLen:])
+	decoder := gob.NewDecoder(&buf)
+	err := decoder.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	blockHashes, err := chain.GetBlocksHashesBetween(payload.From, payload.To)
+	if err != nil {
+		fmt.Printf("Cannot locate the requested blocks: %v. Send all blocks instead.\n", err)
+		blockHashes = chain.GetAllBlocksHashes()
+	}
+	if len(blockHashes) == 0 {
+		return
+	}
+
+	// the hashes are listed from the newest to the oldest, while the headers are sent from the oldest to the newest
+	var headers []core.Header
+	for idx := len(blockHashes) - 1; idx >= 0; idx-- {
+		block, err := chain.GetBlock(blockHashes[idx])
+		if err != nil {
+			log.Panic(err)
+		}
+		headers = append(headers, *block.Header())
+	}
+	sendHeaders(ctx, payload.SenderAddr, headers)
+}
+
+// handleHeaders handles the "headers" response received from the server. The client node validates the header chain
+// (the PoW of each header and the links between them), and checks that it is higher than local lightChain. Only then
+// the block bodies are downloaded from the oldest to the newest, otherwise the syncing is aborted before transferring
+// any block body. Note that chain is from the client node.
+func handleHeaders(ctx context.Context, request []byte, chain *core.BlockChain) {
+	var buf bytes.Buffer
+	var payload sHeaders
+
+	buf.Write(request[cmdLen:])
+	decoder := gob.NewDecoder(&buf)
+	err := decoder.Decode(&payload)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Receive %d headers\n", len(payload.Headers))
+	if len(payload.Headers) == 0 {
+		return
+	}
+	var headers []*core.Header
+	for idx := range payload.Headers {
+		headers = append(headers, &payload.Headers[idx])
+	}
+	if err := chain.ValidateHeaders(headers); err != nil {
+		fmt.Printf("Reject the headers: %v. Abort syncing.\n", err)
+		return
+	}
+	// the difficulty is fixed, thus a higher chain has more work
+	if headers[len(headers)-1].Height <= chain.GetChainHeight() {
+		fmt.Printf("The headers are not higher than local lightChain. Abort syncing.\n")
+		return
+	}
+
+	var hashes [][]byte
+	for _, header := range headers {
+		if _, err := chain.GetBlock(header.Hash); err != nil {
+			hashes = append(hashes, header.Hash)
+		}
+	}
+	getBlocks(ctx, payload.SenderAddr, blocksInTransit.start(payload.SenderAddr, hashes))
 }
 
 // handleGetData handles the "getdata" request received from the client. If the client requires block, this server sends
 // the specific block to the client by calling sendBlock. If the client requires tx, this server sends the specific tx
 // to the client by calling SendTx. Note that chain is from the server node.
 // TODO: we do not check whether the server node has the block or the tx. Fix this!
-func handleGetData(request []byte, chain *core.BlockChain) {
+func handleGetData(ctx context.Context, request []byte, chain *core.BlockChain) {
 	var buf bytes.Buffer
 	var payload sGetData
 
@@ -339,19 +577,24 @@ func handleGetData(request []byte, chain *core.BlockChain) {
 			log.Panic(err)
 		}
 
-		sendBlock(payload.SenderAddr, block)
+		sendBlock(ctx, payload.SenderAddr, block)
 	}
 
 	if payload.Kind == "tx" {
 		txId := hex.EncodeToString(payload.Id)
-		tx := txPool[txId]
+		tx, _ := PooledTx(payload.Id)
 
-		SendTx(payload.SenderAddr, &tx)
+		if err := SendTx(ctx, payload.SenderAddr, &tx); err != nil {
+			fmt.Printf("Transaction %s is not accepted by %s: %v\n", txId, payload.SenderAddr, err)
+		}
 	}
 }
 
-// handleBlock handles the received block from the client node. Note that chain is from the server node.
-func handleBlock(request []byte, chain *core.BlockChain) {
+// handleBlock handles the received block from the client node. The block whose parent is unknown is kept in
+// orphanBlocks, and connected after its parent is added. A new block following the block which the UTXO set is up to
+// date with is checked against the set as well (see core.ValidateBlockAgainstUTXO). Note that chain is from the
+// server node.
+func handleBlock(ctx context.Context, request []byte, chain *core.BlockChain) {
 	var buf bytes.Buffer
 	var payload sBlock
 
@@ -364,23 +607,58 @@ func handleBlock(request []byte, chain *core.BlockChain) {
 
 	block := core.DeserializeBlock(payload.Block)
 	fmt.Printf("Receive a new block!\n")
-	chain.AddBlock(block)
-	fmt.Printf("Added this block successfully! Its hash: %x\n", block.Hash)
-
-	// if this server finds that it has more blocks to download, just send request the same client for next block
-	// until all blocks are downloaded
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.SenderAddr, "block", blockHash)
-		blocksInTransit = blocksInTransit[1:]
+	_, err = chain.GetBlock(block.Hash)
+	known := err == nil
+	if !known && !chain.PoW.ValidateHeader(block.Header()) {
+		// otherwise the blocks without work would evict the orphans kept
+		fmt.Printf("Reject the block %x: invalid proof of work\n", block.Hash)
+		blocksInTransit.cancel(payload.SenderAddr)
+		return
+	}
+	if !known && orphanBlocks.addIfOrphan(chain, block) {
+		// the block cannot be validated without its parent, thus keep it until the parent arrives
+		fmt.Printf("Keep the orphan block %x until its parent %x arrives\n", block.Hash, block.PrevBlockHash)
 	} else {
+		if !known {
+			// validate the block which is not added before
+			if err := chain.ValidateBlock(block); err != nil {
+				fmt.Printf("Reject the block %x: %v\n", block.Hash, err)
+				// the blocks in transit from the sender are built on this block, thus are not downloaded
+				blocksInTransit.cancel(payload.SenderAddr)
+				return
+			}
+			// the UTXO set lags behind during a download, thus it is only checked against if it is up to date
+			utxoSet := core.UTXOSet{BlockChain: chain}
+			if bytes.Equal(utxoSet.TipHash(), block.PrevBlockHash) {
+				if err := core.ValidateBlockAgainstUTXO(block, &utxoSet); err != nil {
+					fmt.Printf("Reject the block %x: %v\n", block.Hash, err)
+					blocksInTransit.cancel(payload.SenderAddr)
+					return
+				}
+			}
+			chain.AddBlock(block)
+		}
+		fmt.Printf("Added this block successfully! Its hash: %x\n", block.Hash)
+		connectOrphans(chain, block.Hash)
+	}
+
+	// if this server finds that it has more blocks to download, request the same client for the next blocks to refill
+	// the window until all blocks are downloaded
+	requests, done := blocksInTransit.arrived(payload.SenderAddr, block.Hash)
+	getBlocks(ctx, payload.SenderAddr, requests)
+	if done {
 		utxoSet := core.UTXOSet{BlockChain: chain}
 		utxoSet.Rebuild()
 	}
 }
 
-// handleTx handles the received tx from the client node. Note that chain is from the server node.
-func handleTx(request []byte, chain *core.BlockChain) {
+// handleTx handles the received tx from the client node, and acknowledges it with a sTxAck (see reply) before relaying
+// or mining it, unless the client is known to predate the acknowledgement (see txAckVersion). The tx is rejected if it
+// is not verified (see core.BlockChain.CheckTxWithPool) or not final at the median time past, its fee rate is too
+// low (see MinRelayFeeRate), or it conflicts with the pooled transactions it cannot replace (see
+// TxPool.checkReplacement), and the reason is acknowledged to the client. A duplicate tx is acknowledged as accepted.
+// An error wrapping ErrTxRejected is returned if tx is rejected. Note that chain is from the server node.
+func handleTx(ctx context.Context, request []byte, chain *core.BlockChain) error {
 	// extract the tx from the client and put it into txPool
 	var buf bytes.Buffer
 	var payload sTx
@@ -393,64 +671,84 @@ func handleTx(request []byte, chain *core.BlockChain) {
 	}
 
 	tx := core.DeserializeTx(payload.Transaction)
-	txPool[hex.EncodeToString(tx.Id)] = tx
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	// ignore the duplicate transaction which is already pooled or already packed into chain
+	txId := hex.EncodeToString(tx.Id)
+	if _, ok := txPool[txId]; ok {
+		fmt.Printf("Transaction %s is already in the pool. Ignore it.\n", txId)
+		ackTx(ctx, payload.SenderAddr, nil)
+		return nil
+	}
+	if chain.HasTx(tx.Id) {
+		fmt.Printf("Transaction %s is already packed into lightChain. Ignore it.\n", txId)
+		ackTx(ctx, payload.SenderAddr, nil)
+		return nil
+	}
+	// the tx must be signed by the owners of its inputs before it can replace the pooled ones. The tx arriving before
+	// its parent is kept until the parent arrives (it is verified again when packed), but it replaces nothing
+	if err := chain.CheckTxWithPool(&tx, txPool); err != nil {
+		if !errors.Is(err, core.ErrTxNotFound) || len(txPool.conflictsOf(&tx)) > 0 {
+			ackTx(ctx, payload.SenderAddr, err)
+			return fmt.Errorf("%w: %s: %v", ErrTxRejected, txId, err)
+		}
+	}
+	if medianTime := chain.MedianTimePast(); !tx.IsFinal(medianTime) {
+		err := fmt.Errorf("locked until %d, the median time past is %d", tx.LockTime, medianTime)
+		ackTx(ctx, payload.SenderAddr, err)
+		return fmt.Errorf("%w: %s: %v", ErrTxRejected, txId, err)
+	}
+	if err := txPool.checkFeeRate(chain, &tx); err != nil {
+		ackTx(ctx, payload.SenderAddr, err)
+		return fmt.Errorf("%w: %s: %v", ErrTxRejected, txId, err)
+	}
+	replaced, err := txPool.checkReplacement(chain, &tx)
+	if err != nil {
+		ackTx(ctx, payload.SenderAddr, err)
+		return fmt.Errorf("%w: %s: %v", ErrTxRejected, txId, err)
+	}
+	for _, replacedId := range replaced {
+		fmt.Printf("Transaction %s is replaced by %s\n", replacedId, txId)
+		txPool.removeWithDescendants(replacedId)
+	}
+	// cache the serialized bytes before pooling, thus the copies of the pooled tx share them (see
+	// core.Transaction.Size)
+	tx.Size()
+	txPool[txId] = tx
+	ackTx(ctx, payload.SenderAddr, nil)
 
 	// CentralNode does not mining. Just broadcast this tx to every known nodes
 	if nodeIPAddress == CentralNode {
-		for _, node := range KnownNodes {
+		for _, node := range knownNodes() {
 			if node != nodeIPAddress && node != payload.SenderAddr {
-				sendInv(node, "tx", [][]byte{tx.Id})
+				sendInv(ctx, node, "tx", [][]byte{tx.Id})
 			}
 		}
 	} else {
-		if len(txPool) >= txNum4Mining && len(miningWalletAddress) > 0 {
-		MineTxs:
-			var verifiedTxs []*core.Transaction
-			for txIdInPool := range txPool {
-				txInPool := txPool[txIdInPool]
-				if chain.VerifyTx(&txInPool) {
-					verifiedTxs = append(verifiedTxs, &txInPool)
-				}
-			}
-
-			if len(verifiedTxs) == 0 {
-				fmt.Printf("No transaction is valid. Waiting for new transactions...\n")
-				return
-			}
-
-			coinbaseTx := core.NewCoinbaseTx(miningWalletAddress, "", chain.CoinbaseReward)
-			// verifiedTxs = append([]*core.Transaction{coinbaseTx}, verifiedTxs...)
-			verifiedTxs = append(verifiedTxs, coinbaseTx)
-
-			// pack into a new block
-			newBlock := chain.MineBlock(verifiedTxs)
-			utxoSet := core.UTXOSet{BlockChain: chain}
-			utxoSet.Rebuild()
-			fmt.Printf("New block is successfully mined!\n")
-
-			// remove the already packed transactions from pool
-			for _, tx := range verifiedTxs {
-				delete(txPool, hex.EncodeToString(tx.Id))
-			}
-
-			// broadcast this newly mined block to all known nodes
-			for _, node := range KnownNodes {
-				if node != nodeIPAddress {
-					sendInv(node, "block", [][]byte{newBlock.Hash})
-				}
-			}
+		mineTxPool(ctx, chain)
+	}
+	return nil
+}
 
-			if len(txPool) > 0 {
-				goto MineTxs
-			}
-		}
+// ackTx acknowledges the tx received from senderAddr with a sTxAck (see reply): the tx is accepted if rejection is
+// nil. A sender whose negotiated version predates txAckVersion does not read the acknowledgement, thus it is not
+// acknowledged.
+func ackTx(ctx context.Context, senderAddr string, rejection error) {
+	if version, ok := peerVersion(senderAddr); ok && version < txAckVersion {
+		return
 	}
+	ack := sTxAck{Accepted: rejection == nil}
+	if rejection != nil {
+		ack.Reason = rejection.Error()
+	}
+	reply(ctx, utils.GobEncode(ack))
 }
 
 /* The following code defines the client-side functions (starts with "send") for each p2p node. */
 
 // sendBlock sends block b to dstAddr.
-func sendBlock(dstAddr string, b *core.Block) {
+func sendBlock(ctx context.Context, dstAddr string, b *core.Block) {
 	block := sBlock{
 		SenderAddr: nodeIPAddress,
 		Block:      b.SerializeBlock(),
@@ -459,11 +757,11 @@ func sendBlock(dstAddr string, b *core.Block) {
 	payload := utils.GobEncode(block)
 	request := append(cmd2Bytes("block"), payload...)
 
-	send(dstAddr, request)
+	send(ctx, dstAddr, request)
 }
 
 // sendInv sends a sInventory instance constructed by nodeIPAddress, kind, and items to dstAddr.
-func sendInv(dstAddr, kind string, items [][]byte) {
+func sendInv(ctx context.Context, dstAddr, kind string, items [][]byte) {
 	inv := sInventory{
 		SenderAddr: nodeIPAddress,
 		Kind:       kind,
@@ -473,38 +771,65 @@ func sendInv(dstAddr, kind string, items [][]byte) {
 	payload := utils.GobEncode(inv)
 	request := append(cmd2Bytes("inv"), payload...)
 
-	send(dstAddr, request)
+	send(ctx, dstAddr, request)
 }
 
-// SendTx sends a sTx instance constructed by nodeIPAddress and transaction to dstAddr.
-func SendTx(dstAddr string, transaction *core.Transaction) {
+// SendTx sends a sTx instance constructed by nodeIPAddress and transaction to dstAddr, and waits for the sTxAck of
+// dstAddr. An error wrapping ErrTxRejected is returned with the reason if dstAddr rejects transaction, and an error is
+// returned as well if dstAddr does not acknowledge it. The version of dstAddr is queried first if it is not negotiated,
+// and transaction is sent without waiting if the negotiated version predates txAckVersion.
+func SendTx(ctx context.Context, dstAddr string, transaction *core.Transaction) error {
+	return sendTxFrom(ctx, nodeIPAddress, dstAddr, transaction)
+}
+
+// sendTxFrom is SendTx with the SenderAddr of sTx set to senderAddr.
+func sendTxFrom(ctx context.Context, senderAddr, dstAddr string, transaction *core.Transaction) error {
 	tx := sTx{
-		SenderAddr:  nodeIPAddress,
+		SenderAddr:  senderAddr,
 		Transaction: transaction.SerializeTx(),
 	}
 
 	payload := utils.GobEncode(tx)
 	request := append(cmd2Bytes("tx"), payload...)
 
-	send(dstAddr, request)
+	version, err := negotiatedVersion(dstAddr)
+	if err != nil {
+		return err
+	}
+	if version < txAckVersion {
+		return send(ctx, dstAddr, request)
+	}
+	answer, err := sendAndReceive(ctx, dstAddr, request)
+	if err != nil {
+		return err
+	}
+	var ack sTxAck
+	if err := gob.NewDecoder(bytes.NewReader(answer)).Decode(&ack); err != nil {
+		return fmt.Errorf("no acknowledgement of the transaction from %s: %v", dstAddr, err)
+	}
+	if !ack.Accepted {
+		return fmt.Errorf("%w by %s: %s", ErrTxRejected, dstAddr, ack.Reason)
+	}
+	return nil
 }
 
 // sendVersion sends a sVersion instance constructed by chain, nodeVersion, and nodeIPAddress to dstAddr.
-func sendVersion(dstAddr string, chain *core.BlockChain) {
+func sendVersion(ctx context.Context, dstAddr string, chain *core.BlockChain) error {
 	ver := sVersion{
 		Version:    nodeVersion,
 		Height:     chain.GetChainHeight(),
+		TipHash:    chain.TipHash(),
 		SenderAddr: nodeIPAddress,
 	}
 
 	payload := utils.GobEncode(ver)
 	request := append(cmd2Bytes("version"), payload...)
 
-	send(dstAddr, request)
+	return send(ctx, dstAddr, request)
 }
 
 // sendGetBlocks sends nodeIPAddress to dstAddr.
-func sendGetBlocks(dstAddr string) {
+func sendGetBlocks(ctx context.Context, dstAddr string) {
 	getBlocks := sGetBlocks{
 		SenderAddr: nodeIPAddress,
 	}
@@ -512,11 +837,36 @@ func sendGetBlocks(dstAddr string) {
 	payload := utils.GobEncode(getBlocks)
 	request := append(cmd2Bytes("getblocks"), payload...)
 
-	send(dstAddr, request)
+	send(ctx, dstAddr, request)
+}
+
+// sendGetHeaders sends a sGetHeaders instance constructed by nodeIPAddress, from, and to to dstAddr.
+func sendGetHeaders(ctx context.Context, dstAddr string, from, to []byte) {
+	getHeaders := sGetHeaders{
+		SenderAddr: nodeIPAddress,
+		From:       from,
+		To:         to,
+	}
+
+	payload := utils.GobEncode(getHeaders)
+	request := append(cmd2Bytes("getheaders"), payload...)
+
+	send(ctx, dstAddr, request)
+}
+
+// sendHeaders sends a sHeaders instance constructed by nodeIPAddress and headers to dstAddr.
+func sendHeaders(ctx context.Context, dstAddr string, headers []core.Header) {
+	payload := utils.GobEncode(sHeaders{
+		SenderAddr: nodeIPAddress,
+		Headers:    headers,
+	})
+	request := append(cmd2Bytes("headers"), payload...)
+
+	send(ctx, dstAddr, request)
 }
 
 // sendGetData sends a sGetData instance to dstAddr.
-func sendGetData(dstAddr, kind string, id []byte) {
+func sendGetData(ctx context.Context, dstAddr, kind string, id []byte) {
 	getData := sGetData{
 		SenderAddr: nodeIPAddress,
 		Kind:       kind,
@@ -526,41 +876,177 @@ func sendGetData(dstAddr, kind string, id []byte) {
 	payload := utils.GobEncode(getData)
 	request := append(cmd2Bytes("getdata"), payload...)
 
-	send(dstAddr, request)
+	send(ctx, dstAddr, request)
 }
 
-// send sends data to dstAddr through TCP.
-func send(dstAddr string, data []byte) {
-	// establish connection to dstAddr
-	conn, err := net.Dial(protocol, dstAddr)
+// send sends data to dstAddr through TCP. An error is returned if dstAddr is not reachable, or the sending is not done
+// before the deadline of ctx (see ioDeadline), or ctx is canceled.
+func send(ctx context.Context, dstAddr string, data []byte) error {
+	conn, err := dial(ctx, dstAddr)
 	if err != nil {
-		// if dstAddr is not reachable, remove it from KnownNodes
-		fmt.Printf("%s is not available\n", dstAddr)
-		var updatedNodes []string
-		for _, node := range KnownNodes {
-			if node != dstAddr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-		KnownNodes = updatedNodes
-		return
+		return err
 	}
 	defer func() {
-		err := conn.Close()
-		if err != nil {
-			log.Panic(err)
-		}
+		_ = conn.Close()
 	}()
+	return write(ctx, conn, dstAddr, data)
+}
 
-	// copy data to the connection
-	_, err = io.Copy(conn, bytes.NewReader(data))
+// sendAndReceive sends data to dstAddr like send, and returns the answer of dstAddr on the same connection (see reply).
+// The answer is empty if dstAddr closes the connection without answering.
+func sendAndReceive(ctx context.Context, dstAddr string, data []byte) ([]byte, error) {
+	conn, err := dial(ctx, dstAddr)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if err := write(ctx, conn, dstAddr, data); err != nil {
+		return nil, err
 	}
+
+	// close the sending side, thus dstAddr finishes reading the request and answers
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.CloseWrite(); err != nil {
+			return nil, err
+		}
+	}
+	_ = conn.SetReadDeadline(ioDeadline(ctx))
+	stop := abortOnDone(ctx, conn)
+	answer, err := ioutil.ReadAll(conn)
+	stop()
+	if err != nil {
+		fmt.Printf("Failed to receive the answer from %s: %v\n", dstAddr, err)
+		return nil, err
+	}
+	return answer, nil
+}
+
+// reply writes the answer data on the connection of the request being handled, and closes the sending side of it,
+// thus it answers once. It does nothing if ctx is not passed by handleConn, e.g., the handler is called directly.
+func reply(ctx context.Context, data []byte) {
+	conn, ok := ctx.Value(replyKey{}).(net.Conn)
+	if !ok {
+		return
+	}
+	_ = conn.SetWriteDeadline(ioDeadline(ctx))
+	if _, err := conn.Write(data); err != nil {
+		fmt.Printf("Failed to reply to %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	}
+}
+
+// dial establishes the connection to dstAddr. If dstAddr is not reachable, it is evicted from KnownNodes and retried
+// later (see evictPeer).
+func dial(ctx context.Context, dstAddr string) (net.Conn, error) {
+	dialer := net.Dialer{Deadline: ioDeadline(ctx)}
+	conn, err := dialer.DialContext(ctx, protocol, dstAddr)
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+	if err != nil {
+		// if dstAddr is not reachable, remove it from KnownNodes, and retry it later
+		fmt.Printf("%s is not available\n", dstAddr)
+		evictPeer(dstAddr)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// write copies data to conn established to dstAddr, a slow peer is given up at the deadline of ctx.
+func write(ctx context.Context, conn net.Conn, dstAddr string, data []byte) error {
+	_ = conn.SetWriteDeadline(ioDeadline(ctx))
+	stop := abortOnDone(ctx, conn)
+	_, err := io.Copy(conn, bytes.NewReader(data))
+	stop()
+	if err != nil {
+		fmt.Printf("Failed to send to %s: %v\n", dstAddr, err)
+		return err
+	}
+	if TraceMessages && len(data) >= cmdLen {
+		traceMessage("send", bytes2Cmd(data[:cmdLen]), dstAddr, len(data))
+	}
+	return nil
 }
 
 /* The following defines several auxiliary functions. */
 
+// ioDeadline returns the deadline of a read or a send starting now: IOTimeout later, or the deadline of ctx if it is
+// earlier.
+func ioDeadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(IOTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
+
+// abortOnDone aborts the in-flight I/O on conn once ctx is canceled, by moving its deadline to the past. The returned
+// function stops watching ctx, and should be called once the I/O is done.
+func abortOnDone(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// isCompatibleVersion checks whether a peer of version is compatible with this node. A peer newer than this node is
+// compatible, because it checks this node's version against its own compatibility range.
+func isCompatibleVersion(version int) bool {
+	return version >= minVersion
+}
+
+// negotiateVersion returns the version used to communicate with a compatible peer of version.
+func negotiateVersion(version int) int {
+	if version < nodeVersion {
+		return version
+	}
+	return nodeVersion
+}
+
+// setPeerVersion records the version negotiated with the compatible peer addr of version.
+func setPeerVersion(addr string, version int) {
+	versionsMu.Lock()
+	defer versionsMu.Unlock()
+	peerVersions[addr] = negotiateVersion(version)
+}
+
+// peerVersion returns the version negotiated with the peer addr, and whether it is negotiated.
+func peerVersion(addr string) (int, bool) {
+	versionsMu.Lock()
+	defer versionsMu.Unlock()
+	version, ok := peerVersions[addr]
+	return version, ok
+}
+
+// negotiatedVersion returns the version negotiated with the peer addr. If it is not negotiated yet, e.g., a wallet
+// sends a tx to a node, the version of addr is queried (see queryVersion) and negotiated.
+func negotiatedVersion(addr string) (int, error) {
+	if version, ok := peerVersion(addr); ok {
+		return version, nil
+	}
+	answer, err := queryVersion(addr)
+	if err != nil {
+		return 0, err
+	}
+	if !isCompatibleVersion(answer.Version) {
+		return 0, fmt.Errorf("%s is incompatible: version %d is lower than %d", addr, answer.Version, minVersion)
+	}
+	setPeerVersion(addr, answer.Version)
+	return negotiateVersion(answer.Version), nil
+}
+
 // cmd2Bytes converts the cmd string into a byte slice.
 func cmd2Bytes(cmd string) []byte {
 	var byteChars [cmdLen]byte
@@ -0,0 +1,526 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`bytes`
+	`context`
+	`encoding/gob`
+	`encoding/hex`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`io/ioutil`
+	`lightChain/core`
+	`lightChain/utils`
+	`net`
+	`sync`
+	`testing`
+	`time`
+)
+
+// txRequest constructs the "tx" request carrying tx.
+func txRequest(tx *core.Transaction) []byte {
+	payload := utils.GobEncode(sTx{SenderAddr: "localhost:3000", Transaction: tx.SerializeTx()})
+	return append(cmd2Bytes("tx"), payload...)
+}
+
+func TestHandleTxDuplicate(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	tx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+
+	handleTx(context.Background(), txRequest(tx), chain)
+	handleTx(context.Background(), txRequest(tx), chain)
+	assert.Equal(t, 1, len(txPool), "The duplicate transaction is pooled once")
+}
+
+func TestHandleTxAlreadyMined(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	tx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(wallet.GetAddr()), tx)
+
+	handleTx(context.Background(), txRequest(tx), chain)
+	assert.Equal(t, 0, len(txPool), "The already mined transaction is rejected")
+}
+
+func TestSendTxAck(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	dstAddr := startMockNode(t, chain)
+	oldRate := MinRelayFeeRate
+	MinRelayFeeRate = core.DefaultFeePerByte
+	t.Cleanup(func() {
+		MinRelayFeeRate = oldRate
+	})
+
+	noFeeTx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	err = SendTx(context.Background(), dstAddr, noFeeTx)
+	assert.True(t, errors.Is(err, ErrTxRejected), "The transaction without fee is rejected")
+	assert.Contains(t, err.Error(), "insufficient fee")
+	_, ok := PooledTx(noFeeTx.Id)
+	assert.False(t, ok)
+
+	feeTx, err := core.NewSplitTx(wallet, 2, 2*core.DefaultFeePerByte, &utxoSet)
+	assert.Nil(t, err)
+	badSigTx := *feeTx
+	badSigTx.Vin = append([]core.TxInput{}, feeTx.Vin...)
+	badSigTx.Vin[0].Signature = append([]byte{}, feeTx.Vin[0].Signature...)
+	badSigTx.Vin[0].Signature[0] ^= 0xff
+	err = SendTx(context.Background(), dstAddr, &badSigTx)
+	assert.True(t, errors.Is(err, ErrTxRejected), "The transaction with a bad signature is rejected")
+	assert.Contains(t, err.Error(), "invalid signature")
+	_, ok = PooledTx(badSigTx.Id)
+	assert.False(t, ok)
+
+	assert.Nil(t, SendTx(context.Background(), dstAddr, feeTx), "The transaction paying enough fee is accepted")
+	_, ok = PooledTx(feeTx.Id)
+	assert.True(t, ok)
+	assert.Nil(t, SendTx(context.Background(), dstAddr, feeTx), "The duplicate transaction is accepted")
+
+	// a receiver closing the connection without acknowledgement
+	silentAddr, _ := receiveRequest(t)
+	setPeerVersion(silentAddr, nodeVersion)
+	assert.NotNil(t, SendTx(context.Background(), silentAddr, feeTx))
+}
+
+func TestSendTxToLegacyPeer(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	tx, err := core.NewSplitTx(wallet, 2, 2*core.DefaultFeePerByte, &utxoSet)
+	assert.Nil(t, err)
+
+	// the receiver predating the acknowledgement is not waited for
+	legacyAddr, wait := receiveRequest(t)
+	setPeerVersion(legacyAddr, txAckVersion-1)
+	assert.Nil(t, SendTx(context.Background(), legacyAddr, tx))
+	request := wait()
+	assert.NotNil(t, request)
+	assert.Equal(t, "tx", bytes2Cmd(request[:cmdLen]))
+
+	// the sender predating the acknowledgement is not acknowledged
+	dstAddr := startMockNode(t, chain)
+	setPeerVersion("localhost:3000", txAckVersion-1)
+	answer, err := sendAndReceive(context.Background(), dstAddr, txRequest(tx))
+	assert.Nil(t, err)
+	assert.Empty(t, answer)
+	_, ok := PooledTx(tx.Id)
+	assert.True(t, ok)
+
+	// the version of an unknown receiver is queried
+	delete(peerVersions, dstAddr)
+	assert.Nil(t, SendTx(context.Background(), dstAddr, tx))
+	version, ok := peerVersion(dstAddr)
+	assert.True(t, ok, "The version of the receiver is negotiated")
+	assert.Equal(t, nodeVersion, version)
+}
+
+// receiveRequest starts a listener as a client node, and returns its address and a function waiting for the first
+// request it receives. The function returns nil if no request is received in a second.
+func receiveRequest(t *testing.T) (string, func() []byte) {
+	listener, err := net.Listen(protocol, "localhost:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		request, _ := ioutil.ReadAll(conn)
+		_ = conn.Close()
+		received <- request
+	}()
+	return listener.Addr().String(), func() []byte {
+		select {
+		case request := <-received:
+			return request
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+}
+
+func TestHandleGetHeaders(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	for i := 0; i < 4; i++ {
+		mineTxs(chain, string(wallet.GetAddr()))
+	}
+	allHashes := chain.GetAllBlocksHashes()
+
+	clientAddr, wait := receiveRequest(t)
+	payload := utils.GobEncode(sGetHeaders{SenderAddr: clientAddr, From: allHashes[3], To: allHashes[1]})
+	handleGetHeaders(context.Background(), append(cmd2Bytes("getheaders"), payload...), chain)
+
+	request := wait()
+	assert.Equal(t, "headers", bytes2Cmd(request[:cmdLen]))
+	var headers sHeaders
+	assert.Nil(t, gob.NewDecoder(bytes.NewReader(request[cmdLen:])).Decode(&headers))
+	var hashes [][]byte
+	for _, header := range headers.Headers {
+		hashes = append(hashes, header.Hash)
+	}
+	// from the oldest to the newest
+	assert.Equal(t, [][]byte{allHashes[2], allHashes[1]}, hashes, "Exactly the headers in the range are sent in order")
+}
+
+// headersRequest constructs the "headers" request carrying the headers of the blocks of chain from the oldest to
+// the newest.
+func headersRequest(senderAddr string, chain *core.BlockChain) []byte {
+	var headers []core.Header
+	blockHashes := chain.GetAllBlocksHashes()
+	for idx := len(blockHashes) - 1; idx >= 0; idx-- {
+		block, _ := chain.GetBlock(blockHashes[idx])
+		headers = append(headers, *block.Header())
+	}
+	payload := utils.GobEncode(sHeaders{SenderAddr: senderAddr, Headers: headers})
+	return append(cmd2Bytes("headers"), payload...)
+}
+
+func TestHandleHeaders(t *testing.T) {
+	chain, _ := newTestChain(t)
+	peerChain := extendChain(t, chain, 3)
+
+	peerAddr, wait := receiveRequest(t)
+	handleHeaders(context.Background(), headersRequest(peerAddr, peerChain), chain)
+
+	request := wait()
+	assert.NotNil(t, request, "Block bodies are requested after the headers are validated")
+	assert.Equal(t, "getdata", bytes2Cmd(request[:cmdLen]))
+	var getData sGetData
+	assert.Nil(t, gob.NewDecoder(bytes.NewReader(request[cmdLen:])).Decode(&getData))
+	firstHash, err := peerChain.BlockHashAtHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, firstHash, getData.Id, "The oldest block body missing locally is requested first")
+	assert.Equal(t, 3, blocksInTransit.inFlight(peerAddr), "All the missing block bodies are requested within the window")
+}
+
+func TestHandleHeadersInvalidPoW(t *testing.T) {
+	chain, _ := newTestChain(t)
+	peerChain := extendChain(t, chain, 3)
+	blocksInTransit = newTransitTracker()
+
+	peerAddr, wait := receiveRequest(t)
+	request := headersRequest(peerAddr, peerChain)
+	// tamper the nonce of a header
+	var payload sHeaders
+	assert.Nil(t, gob.NewDecoder(bytes.NewReader(request[cmdLen:])).Decode(&payload))
+	payload.Headers[2].Nonce++
+	handleHeaders(context.Background(), append(cmd2Bytes("headers"), utils.GobEncode(payload)...), chain)
+
+	assert.Nil(t, wait(), "No block body is requested")
+	assert.Equal(t, 0, blocksInTransit.pending(peerAddr))
+}
+
+// versionRequest constructs the "version" request sent by senderAddr at version and height.
+func versionRequest(senderAddr string, version, height int) []byte {
+	payload := utils.GobEncode(sVersion{Version: version, Height: height, SenderAddr: senderAddr})
+	return append(cmd2Bytes("version"), payload...)
+}
+
+func TestBootstrapFailover(t *testing.T) {
+	chain, _ := newTestChain(t)
+	resetTriedNodes()
+	oldSeedNodes := SeedNodes
+	defer func() {
+		SeedNodes = oldSeedNodes
+	}()
+
+	// the first seed node is down
+	listener, err := net.Listen(protocol, "localhost:0")
+	assert.Nil(t, err)
+	downAddr := listener.Addr().String()
+	_ = listener.Close()
+	upAddr, wait := receiveRequest(t)
+	SeedNodes = []string{nodeIPAddress, downAddr, upAddr}
+	setKnownNodes(SeedNodes)
+
+	seed, ok := bootstrap(context.Background(), chain)
+	assert.True(t, ok)
+	assert.Equal(t, upAddr, seed, "The second seed node is used")
+	request := wait()
+	assert.NotNil(t, request)
+	assert.Equal(t, "version", bytes2Cmd(request[:cmdLen]))
+	assert.NotContains(t, knownNodes(), downAddr, "The unreachable seed node is evicted")
+	assert.Contains(t, triedNodes, downAddr, "The unreachable seed node is retried later")
+
+	// no seed node is up
+	SeedNodes = []string{downAddr}
+	_, ok = bootstrap(context.Background(), chain)
+	assert.False(t, ok)
+}
+
+func TestHandleVersionIncompatible(t *testing.T) {
+	chain, _ := newTestChain(t)
+	peerAddr, wait := receiveRequest(t)
+
+	handleVersion(context.Background(), versionRequest(peerAddr, minVersion-1, chain.GetChainHeight()+1), chain)
+	assert.Nil(t, wait(), "The incompatible peer is not answered")
+	assert.Equal(t, []string{CentralNode}, KnownNodes, "The incompatible peer is not registered")
+	_, ok := peerVersion(peerAddr)
+	assert.False(t, ok)
+}
+
+func TestHandleVersionCompatible(t *testing.T) {
+	chain, _ := newTestChain(t)
+
+	for _, version := range []int{nodeVersion, nodeVersion + 1} {
+		peerAddr, wait := receiveRequest(t)
+		handleVersion(context.Background(), versionRequest(peerAddr, version, chain.GetChainHeight()+1), chain)
+
+		request := wait()
+		assert.NotNil(t, request, "The compatible peer is answered")
+		assert.Equal(t, "getheaders", bytes2Cmd(request[:cmdLen]), "The higher chain of the peer is requested")
+		assert.Contains(t, KnownNodes, peerAddr, "The compatible peer is registered")
+		version, ok := peerVersion(peerAddr)
+		assert.True(t, ok)
+		assert.Equal(t, nodeVersion, version, "The lower version is negotiated")
+	}
+}
+
+// serveRequest feeds request to handleConn through an in-memory connection, and waits until it is handled.
+func serveRequest(request []byte, chain *core.BlockChain) {
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handleConn(context.Background(), serverConn, chain)
+		close(done)
+	}()
+	_, _ = clientConn.Write(request)
+	_ = clientConn.Close()
+	<-done
+}
+
+func TestRegisterHandler(t *testing.T) {
+	chain := newMemChain(0)
+	var received []byte
+	RegisterHandler("ping", func(ctx context.Context, request []byte, c *core.BlockChain) error {
+		received = request
+		assert.Equal(t, chain, c)
+		return nil
+	})
+	t.Cleanup(func() {
+		delete(handlers, "ping")
+	})
+
+	request := append(cmd2Bytes("ping"), []byte("payload")...)
+	serveRequest(request, chain)
+	assert.Equal(t, request, received, "The request is routed to the registered handler")
+
+	// the error of the handler is reported
+	RegisterHandler("ping", func(ctx context.Context, request []byte, c *core.BlockChain) error {
+		return errors.New("bad ping")
+	})
+	assert.NotPanics(t, func() {
+		serveRequest(request, chain)
+	})
+}
+
+func TestHandleConnUnregistered(t *testing.T) {
+	chain := newMemChain(0)
+	_, ok := handlers["filterload"]
+	assert.False(t, ok)
+
+	assert.NotPanics(t, func() {
+		serveRequest(cmd2Bytes("filterload"), chain)
+		serveRequest([]byte("short"), chain)
+	}, "The unregistered command and the malformed request are handled gracefully")
+
+	for _, cmd := range []string{"version", "addr", "block", "inv", "getblocks", "getheaders", "headers", "getdata", "tx"} {
+		_, ok := handlers[cmd]
+		assert.True(t, ok, "The default handler of %s is registered", cmd)
+	}
+}
+
+func TestMinerPacksChainedTxs(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	nodeIPAddress, miningWalletAddress, KnownNodes = "localhost:0", string(wallet.GetAddr()), nil
+	t.Cleanup(func() {
+		miningWalletAddress = ""
+	})
+
+	// the child spends the change of its unconfirmed parent
+	parent, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 100, &utxoSet)
+	assert.Nil(t, err)
+	changeIdx := len(parent.Vout) - 1
+	child := core.Transaction{
+		Version: core.TxVersion,
+		Vin:     []core.TxInput{{TxId: parent.Id, VoutIdx: changeIdx, PubKey: wallet.PubKey}},
+		Vout:    []core.TxOutput{*core.NewTxOutput(parent.Vout[changeIdx].Value, string(core.NewWallet().GetAddr()))},
+	}
+	child.Id = child.Hashing()
+	child.Sign(wallet.PrivateKey, map[string]core.Transaction{hex.EncodeToString(parent.Id): *parent})
+
+	// the child arrives first
+	handleTx(context.Background(), txRequest(&child), chain)
+	assert.Equal(t, 0, chain.GetChainHeight(), "Nothing is mined before enough transactions arrive")
+	assert.Equal(t, 1, len(txPool))
+	handleTx(context.Background(), txRequest(parent), chain)
+
+	assert.Empty(t, txPool, "Both transactions are packed")
+	tip, err := chain.GetBlock(chain.Tip)
+	assert.Nil(t, err)
+	assert.Nil(t, chain.ValidateBlock(tip))
+	var packed [][]byte
+	for _, tx := range tip.Transactions {
+		if !tx.IsCoinbaseTx() {
+			packed = append(packed, tx.Id)
+		}
+	}
+	assert.Equal(t, [][]byte{parent.Id, child.Id}, packed, "The parent is packed before the child")
+}
+
+// blockRequest constructs the "block" request carrying block.
+func blockRequest(block *core.Block) []byte {
+	payload := utils.GobEncode(sBlock{SenderAddr: "localhost:3000", Block: block.SerializeBlock()})
+	return append(cmd2Bytes("block"), payload...)
+}
+
+func TestHandleBlockOverClaimedReward(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	addr := string(wallet.GetAddr())
+	height := chain.GetChainHeight() + 1
+
+	overClaimed := core.NewBlock([]*core.Transaction{
+		core.NewCoinbaseTx(addr, "", chain.CurrentReward(height)+1, height),
+	}, chain.Tip, height)
+	handleBlock(context.Background(), blockRequest(overClaimed), chain)
+	_, err := chain.GetBlock(overClaimed.Hash)
+	assert.True(t, errors.Is(err, core.ErrBlockNotFound), "The block inflating the reward is rejected")
+	assert.Equal(t, 0, chain.GetChainHeight())
+
+	valid := core.NewBlock([]*core.Transaction{
+		core.NewCoinbaseTx(addr, "", chain.CurrentReward(height), height),
+	}, chain.Tip, height)
+	handleBlock(context.Background(), blockRequest(valid), chain)
+	assert.Equal(t, valid.Hash, chain.Tip, "The block claiming the exact reward is added")
+}
+
+func TestHandleBlockAgainstUTXO(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	tx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	height := chain.GetChainHeight() + 1
+	block := core.NewBlock([]*core.Transaction{
+		core.NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height), tx,
+	}, chain.Tip, height)
+	assert.Nil(t, chain.ValidateBlock(block))
+
+	// the UTXO set (up to date with the tip) lost the outputs spent by tx
+	assert.Equal(t, chain.TipHash(), utxoSet.TipHash())
+	err = chain.Db.Update(func(dbTx core.StoreTx) error {
+		return dbTx.Bucket([]byte("ChainState")).Delete(tx.Vin[0].TxId)
+	})
+	assert.Nil(t, err)
+	handleBlock(context.Background(), blockRequest(block), chain)
+	_, err = chain.GetBlock(block.Hash)
+	assert.True(t, errors.Is(err, core.ErrBlockNotFound), "The block is checked against the UTXO set")
+}
+
+// slowPeer listens on a temporary port and accepts the connections, but never reads from them. It returns the
+// address of the peer.
+func slowPeer(t *testing.T) string {
+	listener, err := net.Listen(protocol, "localhost:0")
+	assert.Nil(t, err)
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	return listener.Addr().String()
+}
+
+// withIOTimeout sets IOTimeout to timeout during the test.
+func withIOTimeout(t *testing.T, timeout time.Duration) {
+	oldTimeout := IOTimeout
+	IOTimeout = timeout
+	t.Cleanup(func() {
+		IOTimeout = oldTimeout
+	})
+}
+
+func TestSendAbortsAtDeadline(t *testing.T) {
+	// large enough to fill the socket buffers, thus the send blocks on the slow peer
+	data := make([]byte, 64<<20)
+
+	withIOTimeout(t, 200*time.Millisecond)
+	start := time.Now()
+	err := send(context.Background(), slowPeer(t), data)
+	assert.NotNil(t, err, "The send to a slow peer aborts at IOTimeout")
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr) && netErr.Timeout())
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	withIOTimeout(t, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.NotNil(t, send(ctx, slowPeer(t), data), "The send to a slow peer aborts at the deadline of ctx")
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start = time.Now()
+	assert.NotNil(t, send(ctx, slowPeer(t), data), "The in-flight send aborts once ctx is canceled")
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
+
+func TestHandleConnAbortsAtDeadline(t *testing.T) {
+	chain := newMemChain(0)
+	withIOTimeout(t, 200*time.Millisecond)
+
+	// the client never finishes its request
+	serverConn, clientConn := net.Pipe()
+	defer func() {
+		_ = clientConn.Close()
+	}()
+	done := make(chan struct{})
+	go func() {
+		handleConn(context.Background(), serverConn, chain)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleConn blocks on the slow client")
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the query interface of a node for local tools. Different from the p2p requests, a query is
+// answered on the same connection, and the interface can listen on a unix domain socket without exposing a tcp port.
+
+package network
+
+import (
+	`bytes`
+	`context`
+	`encoding/gob`
+	`fmt`
+	`io`
+	`io/ioutil`
+	`lightChain/core`
+	`lightChain/utils`
+	`log`
+	`net`
+	`os`
+	`time`
+)
+
+// RPCSocket is the path of the unix domain socket the query interface listens on. The interface is disabled if it is
+// empty. Set it before StartNode.
+var RPCSocket string
+
+// sHeight is the answer to the "height" query.
+type sHeight struct {
+	Height  int
+	TipHash []byte
+}
+
+// sMiningState is the answer to the "pausemining" and "resumemining" queries.
+type sMiningState struct {
+	Paused bool
+}
+
+// ListenQueries listens for queries on network ("tcp" or "unix") at addr. The unix socket left by a node which did not
+// exit cleanly is removed first (see removeStaleSocket).
+func ListenQueries(network, addr string) (net.Listener, error) {
+	if network != "tcp" && network != "unix" {
+		return nil, fmt.Errorf("unsupported network %q for queries", network)
+	}
+	if network == "unix" {
+		if err := removeStaleSocket(addr); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen(network, addr)
+}
+
+// removeStaleSocket removes the unix socket file at path if nothing listens on it. The socket of a running node is
+// kept, thus listening on it fails rather than taking it over. A path which is not a socket is kept as well.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, queryTimeout)
+	if err == nil {
+		_ = conn.Close()
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// ServeQueries accepts and answers the queries on listener until it is closed. The sends caused by a query (e.g., the
+// broadcast of the blocks mined at "resumemining") are done with ctx.
+func ServeQueries(ctx context.Context, listener net.Listener, chain *core.BlockChain) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handleQuery(ctx, conn, chain)
+	}
+}
+
+// handleQuery reads the command of a query from conn and writes the answer back to conn.
+func handleQuery(ctx context.Context, conn net.Conn, chain *core.BlockChain) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(queryTimeout))
+
+	cmd := make([]byte, cmdLen)
+	if _, err := io.ReadFull(conn, cmd); err != nil {
+		return
+	}
+	switch bytes2Cmd(cmd) {
+	case "height":
+		answer := sHeight{Height: chain.GetChainHeight(), TipHash: chain.TipHash()}
+		if _, err := conn.Write(utils.GobEncode(answer)); err != nil {
+			log.Println(err)
+		}
+	case "pausemining":
+		PauseMining()
+		if _, err := conn.Write(utils.GobEncode(sMiningState{Paused: MiningPaused()})); err != nil {
+			log.Println(err)
+		}
+	case "resumemining":
+		if _, err := conn.Write(utils.GobEncode(sMiningState{Paused: false})); err != nil {
+			log.Println(err)
+		}
+		// answer before mining the pooled transactions, which may take longer than the query timeout
+		_ = conn.Close()
+		ResumeMining(ctx, chain)
+	default:
+		fmt.Printf("Unknown query: %s\n", bytes2Cmd(cmd))
+	}
+}
+
+// QueryHeight queries the node listening on network ("tcp" or "unix") at addr for the height and the tip hash of its
+// lightChain copy.
+func QueryHeight(network, addr string) (int, []byte, error) {
+	conn, err := net.DialTimeout(network, addr, queryTimeout)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s is not available: %v", addr, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(queryTimeout))
+
+	if _, err := conn.Write(cmd2Bytes("height")); err != nil {
+		return 0, nil, err
+	}
+	answer, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return 0, nil, err
+	}
+	var height sHeight
+	if err := gob.NewDecoder(bytes.NewReader(answer)).Decode(&height); err != nil {
+		return 0, nil, err
+	}
+	return height.Height, height.TipHash, nil
+}
+
+// ControlMining pauses (if pause is set) or resumes the mining of the node listening on network ("tcp" or "unix") at
+// addr, and returns whether its mining is paused afterwards.
+func ControlMining(network, addr string, pause bool) (bool, error) {
+	conn, err := net.DialTimeout(network, addr, queryTimeout)
+	if err != nil {
+		return false, fmt.Errorf("%s is not available: %v", addr, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(queryTimeout))
+
+	cmd := "resumemining"
+	if pause {
+		cmd = "pausemining"
+	}
+	if _, err := conn.Write(cmd2Bytes(cmd)); err != nil {
+		return false, err
+	}
+	answer, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return false, err
+	}
+	var state sMiningState
+	if err := gob.NewDecoder(bytes.NewReader(answer)).Decode(&state); err != nil {
+		return false, err
+	}
+	return state.Paused, nil
+}
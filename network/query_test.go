@@ -0,0 +1,76 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`context`
+	`github.com/stretchr/testify/assert`
+	`net`
+	`path/filepath`
+	`testing`
+)
+
+func TestQueryHeight(t *testing.T) {
+	chain := newMemChain(2)
+
+	for network, addr := range map[string]string{
+		"unix": filepath.Join(t.TempDir(), "rpc.sock"),
+		"tcp":  "localhost:0",
+	} {
+		listener, err := ListenQueries(network, addr)
+		assert.Nil(t, err, network)
+		go ServeQueries(context.Background(), listener, chain)
+
+		height, tipHash, err := QueryHeight(network, listener.Addr().String())
+		assert.Nil(t, err, network)
+		assert.Equal(t, 2, height, network)
+		assert.Equal(t, chain.Tip, tipHash, network)
+		assert.Nil(t, listener.Close())
+	}
+}
+
+func TestQueryHeightUnsupportedNetwork(t *testing.T) {
+	_, err := ListenQueries("udp", "localhost:0")
+	assert.NotNil(t, err)
+
+	_, _, err = QueryHeight("unix", filepath.Join(t.TempDir(), "missing.sock"))
+	assert.NotNil(t, err, "Nothing listens on the socket")
+}
+
+func TestListenQueriesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+
+	// the socket file is left by a node which did not exit cleanly
+	stale, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.Nil(t, stale.Close())
+
+	listener, err := ListenQueries("unix", path)
+	assert.Nil(t, err, "The stale socket is replaced")
+	go ServeQueries(context.Background(), listener, newMemChain(1))
+	height, _, err := QueryHeight("unix", path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, height)
+
+	// the socket of a running node is not taken over
+	_, err = ListenQueries("unix", path)
+	assert.NotNil(t, err)
+	_, _, err = QueryHeight("unix", path)
+	assert.Nil(t, err, "The running node still answers")
+	assert.Nil(t, listener.Close())
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the reconnection of the peers evicted from KnownNodes: an unreachable peer is moved to the
+// tried list, and retried with exponential backoff until it comes back or the node gives up on it.
+
+package network
+
+import (
+	`context`
+	`fmt`
+	`lightChain/core`
+	`sync`
+	`time`
+)
+
+// RetryPolicy decides when an evicted peer is retried: the first retry is InitialBackoff after the eviction, and the
+// backoff is multiplied by Multiplier after each failed retry, up to MaxBackoff. The peer is given up on after
+// MaxRetries failed retries.
+type RetryPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	MaxRetries     int
+}
+
+// PeerRetryPolicy is the RetryPolicy of the evicted peers. Set it before StartNode.
+var PeerRetryPolicy = RetryPolicy{InitialBackoff: 5 * time.Second, MaxBackoff: 10 * time.Minute, Multiplier: 2,
+	MaxRetries: 10}
+
+// maxTriedNodes is the most peers tried at the same time. A peer evicted when triedNodes is full is given up on.
+var maxTriedNodes = 1000
+
+// retryCheckInterval is how often the node checks whether any tried peer is due to be retried.
+var retryCheckInterval = time.Second
+
+// nowFunc returns the current time, which decides when the tried peers are due. Tests override it to retry without
+// waiting.
+var nowFunc = time.Now
+
+// triedPeer is a peer evicted from KnownNodes, which is retried at nextRetry. retries is the number of its failed
+// retries.
+type triedPeer struct {
+	backoff   time.Duration
+	nextRetry time.Time
+	retries   int
+}
+
+// triedNodes records the peers evicted from KnownNodes by their addresses. It is guarded by triedMu, since the peers
+// are evicted by the sends of any goroutine.
+var triedNodes = make(map[string]*triedPeer)
+var triedMu sync.Mutex
+
+// evictPeer removes the unreachable peer addr from KnownNodes, and moves it to triedNodes to be retried. A peer
+// already tried keeps its backoff, thus failing a retry does not restart it.
+func evictPeer(addr string) {
+	knownMu.Lock()
+	var updatedNodes []string
+	for _, node := range KnownNodes {
+		if node != addr {
+			updatedNodes = append(updatedNodes, node)
+		}
+	}
+	KnownNodes = updatedNodes
+	knownMu.Unlock()
+
+	triedMu.Lock()
+	defer triedMu.Unlock()
+	if _, ok := triedNodes[addr]; !ok && len(triedNodes) < maxTriedNodes {
+		backoff := PeerRetryPolicy.InitialBackoff
+		triedNodes[addr] = &triedPeer{backoff: backoff, nextRetry: nowFunc().Add(backoff)}
+	}
+}
+
+// addKnownNode adds addr to KnownNodes if it is not known, and removes it from triedNodes since it is reachable.
+func addKnownNode(addr string) {
+	triedMu.Lock()
+	delete(triedNodes, addr)
+	triedMu.Unlock()
+
+	knownMu.Lock()
+	defer knownMu.Unlock()
+	for _, node := range KnownNodes {
+		if node == addr {
+			return
+		}
+	}
+	KnownNodes = append(KnownNodes, addr)
+}
+
+// knownNodes returns a copy of KnownNodes. The peers are sent to through the copy, since a failed send evicts the peer
+// from KnownNodes.
+func knownNodes() []string {
+	knownMu.Lock()
+	defer knownMu.Unlock()
+	return append([]string{}, KnownNodes...)
+}
+
+// setKnownNodes replaces KnownNodes with a copy of nodes.
+func setKnownNodes(nodes []string) {
+	knownMu.Lock()
+	defer knownMu.Unlock()
+	KnownNodes = append([]string{}, nodes...)
+}
+
+// resetTriedNodes forgets all the tried peers.
+func resetTriedNodes() {
+	triedMu.Lock()
+	defer triedMu.Unlock()
+	triedNodes = make(map[string]*triedPeer)
+}
+
+// nextBackoff returns the backoff after a failed retry with backoff under policy.
+func (policy RetryPolicy) nextBackoff(backoff time.Duration) time.Duration {
+	next := time.Duration(float64(backoff) * policy.Multiplier)
+	if next > policy.MaxBackoff {
+		return policy.MaxBackoff
+	}
+	return next
+}
+
+// retryTriedNodes retries each tried peer which is due with a version message. The peers responding are re-added to
+// KnownNodes, and the others are retried later with a longer backoff, until they fail PeerRetryPolicy.MaxRetries
+// retries. It returns the peers re-added.
+func retryTriedNodes(ctx context.Context, chain *core.BlockChain) []string {
+	now := nowFunc()
+	var due []string
+	triedMu.Lock()
+	for addr, peer := range triedNodes {
+		if !now.Before(peer.nextRetry) {
+			due = append(due, addr)
+		}
+	}
+	triedMu.Unlock()
+
+	// the sends are done without holding triedMu, since a failed send evicts the peer again
+	var readded []string
+	for _, addr := range due {
+		err := sendVersion(ctx, addr, chain)
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err == nil {
+			fmt.Printf("%s is available again\n", addr)
+			addKnownNode(addr)
+			readded = append(readded, addr)
+			continue
+		}
+		retryFailed(addr)
+	}
+	return readded
+}
+
+// retryFailed backs off the tried peer addr after its failed retry, or gives up on it after
+// PeerRetryPolicy.MaxRetries failed retries.
+func retryFailed(addr string) {
+	triedMu.Lock()
+	defer triedMu.Unlock()
+	// the peer may have been re-added by its own version message meanwhile
+	peer, ok := triedNodes[addr]
+	if !ok {
+		return
+	}
+	peer.retries++
+	if peer.retries >= PeerRetryPolicy.MaxRetries {
+		fmt.Printf("Give up on %s after %d retries\n", addr, peer.retries)
+		delete(triedNodes, addr)
+		return
+	}
+	peer.backoff = PeerRetryPolicy.nextBackoff(peer.backoff)
+	peer.nextRetry = nowFunc().Add(peer.backoff)
+}
+
+// retryPeers retries the tried peers every retryCheckInterval until ctx is canceled.
+func retryPeers(ctx context.Context, chain *core.BlockChain) {
+	ticker := time.NewTicker(retryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			retryTriedNodes(ctx, chain)
+		}
+	}
+}
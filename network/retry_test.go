@@ -0,0 +1,154 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`context`
+	`fmt`
+	`github.com/stretchr/testify/assert`
+	`net`
+	`sync`
+	`testing`
+	`time`
+)
+
+// useClock makes nowFunc return the time set by the returned function, and restores nowFunc when the test finishes.
+func useClock(t *testing.T) func(now time.Time) {
+	oldNowFunc := nowFunc
+	now := time.Unix(1600000000, 0)
+	nowFunc = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() {
+		nowFunc = oldNowFunc
+	})
+	return func(newNow time.Time) {
+		now = newNow
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, Multiplier: 2}
+	assert.Equal(t, 2*time.Second, policy.nextBackoff(time.Second))
+	assert.Equal(t, 3*time.Second, policy.nextBackoff(2*time.Second), "The backoff is capped by MaxBackoff")
+	assert.Equal(t, 3*time.Second, policy.nextBackoff(3*time.Second))
+}
+
+func TestRetryEvictedPeer(t *testing.T) {
+	chain, _ := newTestChain(t)
+	resetTriedNodes()
+	setClock := useClock(t)
+	start := nowFunc()
+	initial := PeerRetryPolicy.InitialBackoff
+
+	// reserve an address for the peer, which is down
+	listener, err := net.Listen(protocol, "localhost:0")
+	assert.Nil(t, err)
+	peerAddr := listener.Addr().String()
+	_ = listener.Close()
+	KnownNodes = append(KnownNodes, peerAddr)
+
+	assert.NotNil(t, sendVersion(context.Background(), peerAddr, chain))
+	assert.NotContains(t, KnownNodes, peerAddr, "The unreachable peer is evicted")
+	assert.Contains(t, triedNodes, peerAddr, "The evicted peer is tried later")
+	assert.Empty(t, retryTriedNodes(context.Background(), chain), "The peer is not retried before the backoff")
+
+	// the retry fails, thus the backoff grows
+	setClock(start.Add(initial))
+	assert.Empty(t, retryTriedNodes(context.Background(), chain))
+	assert.Equal(t, PeerRetryPolicy.nextBackoff(initial), triedNodes[peerAddr].backoff)
+	assert.NotContains(t, KnownNodes, peerAddr)
+
+	// the peer recovers, and is re-added at the next retry
+	listener, err = net.Listen(protocol, peerAddr)
+	assert.Nil(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	setClock(start.Add(2 * initial))
+	assert.Empty(t, retryTriedNodes(context.Background(), chain), "The peer is retried after the longer backoff")
+	setClock(start.Add(initial + PeerRetryPolicy.nextBackoff(initial)))
+	assert.Equal(t, []string{peerAddr}, retryTriedNodes(context.Background(), chain))
+	assert.Contains(t, KnownNodes, peerAddr, "The recovered peer is re-added")
+	assert.NotContains(t, triedNodes, peerAddr)
+
+	// a peer registering itself with a version message is no longer tried
+	evictPeer(peerAddr)
+	addKnownNode(peerAddr)
+	addKnownNode(peerAddr)
+	assert.NotContains(t, triedNodes, peerAddr)
+	count := 0
+	for _, node := range KnownNodes {
+		if node == peerAddr {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count, "The peer is known once")
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	chain, _ := newTestChain(t)
+	resetTriedNodes()
+	setClock := useClock(t)
+	oldPolicy, oldMaxTriedNodes := PeerRetryPolicy, maxTriedNodes
+	PeerRetryPolicy = RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Second, Multiplier: 2, MaxRetries: 2}
+	defer func() {
+		PeerRetryPolicy, maxTriedNodes = oldPolicy, oldMaxTriedNodes
+	}()
+
+	listener, err := net.Listen(protocol, "localhost:0")
+	assert.Nil(t, err)
+	peerAddr := listener.Addr().String()
+	_ = listener.Close()
+
+	evictPeer(peerAddr)
+	setClock(nowFunc().Add(time.Second))
+	retryTriedNodes(context.Background(), chain)
+	assert.Equal(t, 1, triedNodes[peerAddr].retries)
+	setClock(nowFunc().Add(time.Second))
+	retryTriedNodes(context.Background(), chain)
+	assert.NotContains(t, triedNodes, peerAddr, "The peer is given up on after MaxRetries failed retries")
+
+	// the peers evicted when triedNodes is full are not tried
+	maxTriedNodes = 1
+	evictPeer("localhost:1")
+	evictPeer("localhost:2")
+	assert.Len(t, triedNodes, 1)
+	assert.Contains(t, triedNodes, "localhost:1")
+}
+
+func TestKnownNodesConcurrent(t *testing.T) {
+	newTestChain(t)
+	resetTriedNodes()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			addr := fmt.Sprintf("localhost:%d", 4000+i)
+			for j := 0; j < 100; j++ {
+				addKnownNode(addr)
+				_ = knownNodes()
+				evictPeer(addr)
+			}
+			addKnownNode(addr)
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, knownNodes(), 9, "Each peer is known once")
+}
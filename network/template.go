@@ -0,0 +1,130 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the mining by an external miner: the node assembles a block template from its pool, the miner
+// grinds the nonce of it, and the solved block is submitted back to the node.
+
+package network
+
+import (
+	`bytes`
+	`context`
+	`encoding/gob`
+	`encoding/hex`
+	`fmt`
+	`lightChain/core`
+	`log`
+)
+
+// BlockTemplate is a block following the tip of a chain whose nonce is not found yet. The external miner finds a nonce
+// such that Hash(nonce), i.e., the hash of Header.PoWData(nonce) by the PoW scheme of the chain, is below
+// Header.Target(), and submits Block(nonce) with SubmitBlock.
+type BlockTemplate struct {
+	Header       core.Header // the header without Hash and Nonce, whose MerkleRoot is computed from Transactions
+	Transactions []*core.Transaction
+	PoW          string // the name of the PoW scheme of the chain (see core.PoWByName)
+}
+
+// GetBlockTemplate assembles the BlockTemplate following the tip of chain, which packs the pooled transactions like
+// the mining of this node (see packTxPool). The coinbase reward goes to minerAddr, and the difficulty is retargeted
+// (see core.BlockChain.CalcNextDifficulty).
+func GetBlockTemplate(chain *core.BlockChain, minerAddr string) *BlockTemplate {
+	tip, err := chain.GetBlock(chain.TipHash())
+	if err != nil {
+		log.Panic(err)
+	}
+	height := tip.Height + 1
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	block := &core.Block{
+		TimeStamp:     nowFunc().Unix(),
+		PrevBlockHash: tip.Hash,
+		Height:        height,
+		Bits:          chain.CalcNextDifficulty(),
+		Transactions:  packTxPool(chain, minerAddr, height),
+	}
+	return &BlockTemplate{Header: *block.Header(), Transactions: block.Transactions, PoW: chain.Config.PoW}
+}
+
+// Hash returns the hash of the block of tmpl with nonce by the PoW scheme of the chain (see core.PoWStrategy.Hash).
+func (tmpl *BlockTemplate) Hash(nonce int) []byte {
+	pow, err := core.PoWByName(tmpl.PoW)
+	if err != nil {
+		log.Panic(err)
+	}
+	header := tmpl.Header
+	header.Nonce = nonce
+	return pow.Hash(&header)
+}
+
+// Block returns the block of tmpl solved with nonce.
+func (tmpl *BlockTemplate) Block(nonce int) *core.Block {
+	return &core.Block{
+		TimeStamp:     tmpl.Header.TimeStamp,
+		PrevBlockHash: tmpl.Header.PrevBlockHash,
+		Hash:          tmpl.Hash(nonce),
+		Nonce:         nonce,
+		Height:        tmpl.Header.Height,
+		Bits:          tmpl.Header.Bits,
+		Transactions:  tmpl.Transactions,
+	}
+}
+
+// SubmitBlock validates the block solved by an external miner (serialized by core.Block.SerializeBlock), and connects
+// it to chain: the packed transactions are removed from the pool, and the block is broadcast to the known nodes with
+// ctx. The block must follow the tip of chain at the retargeted difficulty, thus a template assembled before the tip
+// changes is stale. The returned error wraps core.ErrInvalidBlock if the block is rejected.
+func SubmitBlock(ctx context.Context, chain *core.BlockChain, serializedBlock []byte) error {
+	var block core.Block
+	if err := gob.NewDecoder(bytes.NewReader(serializedBlock)).Decode(&block); err != nil {
+		return fmt.Errorf("%w: %v", core.ErrInvalidBlock, err)
+	}
+
+	tip, err := chain.GetBlock(chain.TipHash())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(block.PrevBlockHash, tip.Hash) || block.Height != tip.Height+1 {
+		return fmt.Errorf("%w: block %x does not follow the tip %x", core.ErrInvalidBlock, block.Hash, tip.Hash)
+	}
+	if difficulty := chain.CalcNextDifficulty(); block.Difficulty() != difficulty {
+		return fmt.Errorf("%w: block %x is mined at difficulty %d, but %d is required", core.ErrInvalidBlock,
+			block.Hash, block.Difficulty(), difficulty)
+	}
+	if !chain.PoW.ValidateHeader(block.Header()) {
+		return fmt.Errorf("%w: block %x has invalid proof of work", core.ErrInvalidBlock, block.Hash)
+	}
+	if err := chain.ValidateBlock(&block); err != nil {
+		return err
+	}
+
+	chain.AddBlock(&block)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	utxoSet.Update(&block)
+	fmt.Printf("The submitted block %x is added!\n", block.Hash)
+
+	poolMu.Lock()
+	for _, tx := range block.Transactions {
+		delete(txPool, hex.EncodeToString(tx.Id))
+	}
+	poolMu.Unlock()
+	for _, node := range knownNodes() {
+		if node != nodeIPAddress {
+			sendInv(ctx, node, "block", [][]byte{block.Hash})
+		}
+	}
+	return nil
+}
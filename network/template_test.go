@@ -0,0 +1,91 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`context`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`lightChain/core`
+	`math/big`
+	`testing`
+)
+
+// solveTemplate grinds the nonce of tmpl from 0 as an external miner, and returns the first nonce which solves the
+// template if solved is set, otherwise the first one which does not.
+func solveTemplate(tmpl *BlockTemplate, solved bool) int {
+	var hashInt big.Int
+	for nonce := 0; ; nonce++ {
+		hashInt.SetBytes(tmpl.Hash(nonce))
+		if (hashInt.Cmp(tmpl.Header.Target()) < 0) == solved {
+			return nonce
+		}
+	}
+}
+
+func TestSubmitBlock(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	tx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	handleTx(context.Background(), txRequest(tx), chain)
+
+	minerAddr := string(core.NewWallet().GetAddr())
+	tmpl := GetBlockTemplate(chain, minerAddr)
+	assert.Equal(t, chain.GetChainHeight()+1, tmpl.Header.Height)
+	assert.Equal(t, chain.Tip, tmpl.Header.PrevBlockHash)
+	assert.Equal(t, 2, len(tmpl.Transactions), "The pooled transaction and the coinbase are packed")
+	assert.Equal(t, tx.Id, tmpl.Transactions[0].Id)
+	assert.True(t, tmpl.Transactions[1].IsCoinbaseTx())
+
+	// a template solved with a wrong nonce is rejected
+	wrong := tmpl.Block(solveTemplate(tmpl, false))
+	err = SubmitBlock(context.Background(), chain, wrong.SerializeBlock())
+	assert.True(t, errors.Is(err, core.ErrInvalidBlock), "The block with a wrong nonce is rejected")
+	assert.NotEqual(t, wrong.Hash, chain.Tip)
+
+	solved := tmpl.Block(solveTemplate(tmpl, true))
+	assert.Nil(t, SubmitBlock(context.Background(), chain, solved.SerializeBlock()))
+	assert.Equal(t, solved.Hash, chain.Tip, "The solved block is connected")
+	_, ok := PooledTx(tx.Id)
+	assert.False(t, ok, "The packed transaction is removed from the pool")
+	minerPubKeyHash, err := core.AddrPubKeyHash(minerAddr)
+	assert.Nil(t, err)
+	assert.Equal(t, chain.CurrentReward(solved.Height), utxoSet.Balance(minerPubKeyHash), "The UTXO set is updated")
+
+	// the template is stale once the tip changes
+	err = SubmitBlock(context.Background(), chain, solved.SerializeBlock())
+	assert.True(t, errors.Is(err, core.ErrInvalidBlock), "The stale block is rejected")
+}
+
+func TestSubmitBlockMemoryHard(t *testing.T) {
+	// only the global state of this node is reset, the chain is replaced by a memory-hard one
+	newTestChain(t)
+	config := core.DefaultGenesisConfig()
+	config.PoW = core.MemoryHardPoWName
+	chain, err := core.CreateBlockChainWithStore(core.NewMemStore(), string(core.NewWallet().GetAddr()), config)
+	assert.Nil(t, err)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	utxoSet.Rebuild()
+
+	tmpl := GetBlockTemplate(chain, string(core.NewWallet().GetAddr()))
+	assert.Equal(t, core.MemoryHardPoWName, tmpl.PoW)
+	solved := tmpl.Block(solveTemplate(tmpl, true))
+	assert.True(t, chain.PoW.ValidateHeader(solved.Header()), "The template is solved through the memory-hard PoW")
+	assert.Nil(t, SubmitBlock(context.Background(), chain, solved.SerializeBlock()))
+	assert.Equal(t, solved.Hash, chain.TipHash(), "The solved block is connected")
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file implements the trace of all the network messages for debugging.
+
+package network
+
+import (
+	`log`
+	`os`
+)
+
+// TraceMessages enables logging every message sent and received by this node, with the direction, command, peer, and
+// size. Set it before StartNode.
+var TraceMessages = false
+
+// tracer is the logger of the message trace.
+var tracer = log.New(os.Stdout, "[trace] ", log.LstdFlags|log.Lmicroseconds)
+
+// traceMessage logs a message if TraceMessages is set. direction is "send" or "recv", and peer is the address of the
+// other side of the message.
+func traceMessage(direction, cmd, peer string, size int) {
+	if !TraceMessages {
+		return
+	}
+	tracer.Printf("%s cmd=%s peer=%s size=%d\n", direction, cmd, peer, size)
+}
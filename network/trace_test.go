@@ -0,0 +1,87 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`bytes`
+	`context`
+	`fmt`
+	`github.com/stretchr/testify/assert`
+	`os`
+	`strings`
+	`sync`
+	`testing`
+	`time`
+)
+
+// lockedBuffer is a bytes.Buffer safe for the concurrent writes of the tracer and the reads of the test.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// traceTo enables the message trace into a new buffer until the test finishes.
+func traceTo(t *testing.T) *lockedBuffer {
+	trace := &lockedBuffer{}
+	TraceMessages = true
+	tracer.SetOutput(trace)
+	t.Cleanup(func() {
+		TraceMessages = false
+		tracer.SetOutput(os.Stdout)
+	})
+	return trace
+}
+
+func TestTraceVersionExchange(t *testing.T) {
+	KnownNodes = []string{CentralNode}
+	chain, higherChain := newMemChain(0), newMemChain(2)
+	nodeAddr, peerAddr := startMockNode(t, chain), startMockNode(t, higherChain)
+	nodeIPAddress = nodeAddr
+	trace := traceTo(t)
+
+	// the peer with the higher chain answers the version of this node with its own version
+	assert.Nil(t, sendVersion(context.Background(), peerAddr, chain))
+	outbound := fmt.Sprintf("send cmd=version peer=%s", peerAddr)
+	answer := fmt.Sprintf("send cmd=version peer=%s", nodeAddr)
+	assert.Eventually(t, func() bool {
+		return strings.Count(trace.String(), "recv cmd=version") >= 2 && strings.Contains(trace.String(), answer)
+	}, time.Second, 10*time.Millisecond, "The inbound version and the response are traced")
+	assert.Contains(t, trace.String(), outbound, "The outbound version is traced")
+	assert.Regexp(t, `recv cmd=version peer=\S+ size=\d+`, trace.String())
+}
+
+func TestTraceDisabled(t *testing.T) {
+	trace := traceTo(t)
+	TraceMessages = false
+
+	chain := newMemChain(0)
+	assert.Nil(t, sendVersion(context.Background(), startMockNode(t, chain), chain))
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, trace.String(), "Nothing is traced when disabled")
+}
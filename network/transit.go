@@ -0,0 +1,127 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file tracks the blocks in transit, i.e., the blocks announced by each peer and not downloaded yet. At most
+// MaxBlocksInFlight blocks are requested from a peer at once, and the window is refilled as the requested blocks
+// arrive. The blocks arriving before their parents are kept in the orphan pool (see orphan.go).
+
+package network
+
+import (
+	`context`
+	`encoding/hex`
+	`sync`
+)
+
+// MaxBlocksInFlight is the maximum number of the blocks requested from a peer and not arrived yet. It must be positive.
+var MaxBlocksInFlight = 16
+
+// peerTransit is the download of the blocks announced by a peer.
+type peerTransit struct {
+	queued   [][]byte        // the hashes not requested yet, from the oldest to the newest
+	inFlight map[string]bool // the hashes (in hex) requested and not arrived yet
+}
+
+// transitTracker keeps the blocks in transit from each peer, where the key is the address of the peer. It is shared
+// by the connections from all the peers, thus guarded by mu, and the download from a peer never touches the one from
+// another.
+type transitTracker struct {
+	mu    sync.Mutex
+	peers map[string]*peerTransit
+}
+
+// newTransitTracker returns a transitTracker without any block in transit.
+func newTransitTracker() *transitTracker {
+	return &transitTracker{peers: make(map[string]*peerTransit)}
+}
+
+// The blocks in transit from the peers of this node.
+var blocksInTransit = newTransitTracker()
+
+// start replaces the blocks in transit from peer with hashes (from the oldest to the newest), and returns the ones
+// to request right now, i.e., the oldest MaxBlocksInFlight ones.
+func (tracker *transitTracker) start(peer string, hashes [][]byte) [][]byte {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	transit := &peerTransit{queued: append([][]byte{}, hashes...), inFlight: make(map[string]bool)}
+	tracker.peers[peer] = transit
+	return tracker.fill(peer, transit)
+}
+
+// arrived marks the block of hash from peer as arrived, and returns the blocks to request to refill the window. done
+// is true if the download from peer is finished, i.e., nothing is queued or in flight.
+func (tracker *transitTracker) arrived(peer string, hash []byte) (requests [][]byte, done bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	transit, ok := tracker.peers[peer]
+	if !ok {
+		return nil, true
+	}
+	delete(transit.inFlight, hex.EncodeToString(hash))
+	return tracker.fill(peer, transit), tracker.peers[peer] == nil
+}
+
+// fill moves the queued blocks of peer in flight until the window is full, and returns them. The download from peer
+// is dropped if it is finished. The caller must hold mu.
+func (tracker *transitTracker) fill(peer string, transit *peerTransit) [][]byte {
+	var requests [][]byte
+	for len(transit.queued) > 0 && len(transit.inFlight) < MaxBlocksInFlight {
+		hash := transit.queued[0]
+		transit.queued = transit.queued[1:]
+		transit.inFlight[hex.EncodeToString(hash)] = true
+		requests = append(requests, hash)
+	}
+	if len(transit.queued) == 0 && len(transit.inFlight) == 0 {
+		delete(tracker.peers, peer)
+	}
+	return requests
+}
+
+// cancel drops all the blocks in transit from peer.
+func (tracker *transitTracker) cancel(peer string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	delete(tracker.peers, peer)
+}
+
+// pending returns the number of the blocks from peer which are not requested yet.
+func (tracker *transitTracker) pending(peer string) int {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if transit, ok := tracker.peers[peer]; ok {
+		return len(transit.queued)
+	}
+	return 0
+}
+
+// inFlight returns the number of the blocks requested from peer and not arrived yet.
+func (tracker *transitTracker) inFlight(peer string) int {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if transit, ok := tracker.peers[peer]; ok {
+		return len(transit.inFlight)
+	}
+	return 0
+}
+
+// getBlocks sends a getdata request to peer for each block of hashes.
+func getBlocks(ctx context.Context, peer string, hashes [][]byte) {
+	for _, hash := range hashes {
+		sendGetData(ctx, peer, "block", hash)
+	}
+}
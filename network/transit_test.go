@@ -0,0 +1,181 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`bytes`
+	`context`
+	`encoding/gob`
+	`github.com/stretchr/testify/assert`
+	`io/ioutil`
+	`lightChain/core`
+	`lightChain/utils`
+	`net`
+	`testing`
+	`time`
+)
+
+// receiveRequests listens on a random port, and returns its address and a function waiting (at most 1s) for the
+// next request sent to it.
+func receiveRequests(t *testing.T) (string, func() []byte) {
+	listener, err := net.Listen(protocol, "localhost:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	received := make(chan []byte, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			request, _ := ioutil.ReadAll(conn)
+			_ = conn.Close()
+			received <- request
+		}
+	}()
+	return listener.Addr().String(), func() []byte {
+		select {
+		case request := <-received:
+			return request
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+}
+
+// requestedBlock decodes the hash of the block requested by the getdata request.
+func requestedBlock(t *testing.T, request []byte) []byte {
+	assert.NotNil(t, request, "The next block is requested")
+	if request == nil {
+		return nil
+	}
+	assert.Equal(t, "getdata", bytes2Cmd(request[:cmdLen]))
+	var getData sGetData
+	assert.Nil(t, gob.NewDecoder(bytes.NewReader(request[cmdLen:])).Decode(&getData))
+	return getData.Id
+}
+
+func TestBlocksInTransitPerPeer(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	addr := string(wallet.GetAddr())
+	blocksInTransit = newTransitTracker()
+	// request the blocks one by one, thus the order of the requests is known
+	oldWindow := MaxBlocksInFlight
+	MaxBlocksInFlight = 1
+	t.Cleanup(func() { MaxBlocksInFlight = oldWindow })
+
+	tip, _ := chain.GetBlock(chain.GetAllBlocksHashes()[0])
+	var blocks []*core.Block
+	prevHash := tip.Hash
+	for i := 1; i <= 3; i++ {
+		block := childBlock(prevHash, tip.Height+i, addr, chain)
+		blocks = append(blocks, block)
+		prevHash = block.Hash
+	}
+	peerA, waitA := receiveRequests(t)
+	peerB, waitB := receiveRequests(t)
+	inv := func(peer string, blocks ...*core.Block) {
+		var items [][]byte
+		for idx := len(blocks) - 1; idx >= 0; idx-- {
+			items = append(items, blocks[idx].Hash)
+		}
+		payload := utils.GobEncode(sInventory{SenderAddr: peer, Kind: "block", Items: items})
+		handleInv(context.Background(), append(cmd2Bytes("inv"), payload...), chain)
+	}
+	deliver := func(peer string, block *core.Block) {
+		payload := utils.GobEncode(sBlock{SenderAddr: peer, Block: block.SerializeBlock()})
+		handleBlock(context.Background(), append(cmd2Bytes("block"), payload...), chain)
+	}
+
+	// peer B announces its blocks while the blocks of peer A are being downloaded
+	inv(peerA, blocks[:2]...)
+	assert.Equal(t, blocks[0].Hash, requestedBlock(t, waitA()))
+	inv(peerB, blocks...)
+	assert.Equal(t, blocks[0].Hash, requestedBlock(t, waitB()))
+	assert.Equal(t, 1, blocksInTransit.pending(peerA), "The download from A is kept")
+	assert.Equal(t, 2, blocksInTransit.pending(peerB))
+
+	// the blocks arrive interleaved
+	deliver(peerA, blocks[0])
+	assert.Equal(t, blocks[1].Hash, requestedBlock(t, waitA()))
+	deliver(peerB, blocks[0])
+	assert.Equal(t, blocks[1].Hash, requestedBlock(t, waitB()))
+	deliver(peerA, blocks[1])
+	assert.Equal(t, 0, blocksInTransit.pending(peerA))
+	assert.Equal(t, 1, blocksInTransit.pending(peerB), "The download from B is not affected by A")
+	deliver(peerB, blocks[1])
+	assert.Equal(t, blocks[2].Hash, requestedBlock(t, waitB()))
+	deliver(peerB, blocks[2])
+
+	assert.Nil(t, waitA(), "A is not asked for B's blocks")
+	assert.Equal(t, tip.Height+3, chain.GetChainHeight())
+	for _, block := range blocks {
+		_, err := chain.GetBlock(block.Hash)
+		assert.Nil(t, err)
+	}
+}
+
+func TestBlocksInTransitWindow(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	addr := string(wallet.GetAddr())
+	blocksInTransit = newTransitTracker()
+	oldWindow := MaxBlocksInFlight
+	MaxBlocksInFlight = 2
+	t.Cleanup(func() { MaxBlocksInFlight = oldWindow })
+
+	tip, _ := chain.GetBlock(chain.GetAllBlocksHashes()[0])
+	var items [][]byte
+	byHash := make(map[string]*core.Block)
+	prevHash := tip.Hash
+	for i := 1; i <= 5; i++ {
+		block := childBlock(prevHash, tip.Height+i, addr, chain)
+		items = append([][]byte{block.Hash}, items...)
+		byHash[string(block.Hash)] = block
+		prevHash = block.Hash
+	}
+	peer, wait := receiveRequests(t)
+	payload := utils.GobEncode(sInventory{SenderAddr: peer, Kind: "block", Items: items})
+	handleInv(context.Background(), append(cmd2Bytes("inv"), payload...), chain)
+
+	// the blocks requested at once are delivered from the newest to the oldest, thus the ones arriving first are
+	// orphans until their parents arrive
+	arrived := 0
+	for arrived < 5 {
+		var requested [][]byte
+		for len(requested) < blocksInTransit.inFlight(peer) {
+			requested = append(requested, requestedBlock(t, wait()))
+		}
+		assert.LessOrEqual(t, len(requested), MaxBlocksInFlight, "The requests are bounded by the window")
+		if arrived < 4 {
+			assert.Equal(t, MaxBlocksInFlight, len(requested), "The window is filled")
+		}
+		for idx := len(requested) - 1; idx >= 0; idx-- {
+			block := byHash[string(requested[idx])]
+			payload := utils.GobEncode(sBlock{SenderAddr: peer, Block: block.SerializeBlock()})
+			handleBlock(context.Background(), append(cmd2Bytes("block"), payload...), chain)
+			arrived++
+		}
+	}
+
+	assert.Equal(t, 0, blocksInTransit.inFlight(peer))
+	assert.Equal(t, 0, blocksInTransit.pending(peer))
+	assert.Equal(t, 0, orphanBlocks.Len(), "The orphans are connected once their parents arrive")
+	assert.Equal(t, tip.Height+5, chain.GetChainHeight(), "All the blocks arrive")
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the mempool (txPool) of this node, and orders the pooled transactions by fee rate.
+
+package network
+
+import (
+	`bytes`
+	`encoding/hex`
+	`fmt`
+	`lightChain/core`
+	`sort`
+)
+
+// MinRelayFeeRate is the lowest fee rate (coins per byte of the serialized transaction) of the transactions accepted
+// into txPool. The default 0 accepts the transactions without fee. Set it before StartNode.
+var MinRelayFeeRate = 0.0
+
+// TxPool is a pool of transactions not packed yet, where the key is string of transaction's Id.
+type TxPool map[string]core.Transaction
+
+// SortedByFeeRate returns the pooled transactions sorted by fee rate (fee per byte of the serialized transaction) in
+// descending order. The previous transactions are looked up in chain and pool, thus the ones spending the outputs of
+// their pooled parents are rated as well. The ones whose fees are unknown, i.e., whose parents are found in neither,
+// are put at last. The transactions at the same fee rate are sorted by Id.
+func (pool TxPool) SortedByFeeRate(chain *core.BlockChain) []core.Transaction {
+	type ratedTx struct {
+		tx    core.Transaction
+		rate  float64
+		known bool
+	}
+	var rated []ratedTx
+	for _, tx := range pool {
+		fee, err := chain.TxFee(&tx, pool)
+		rated = append(rated, ratedTx{tx, fee / float64(tx.Size()), err == nil})
+	}
+	sort.Slice(rated, func(i, j int) bool {
+		if rated[i].known != rated[j].known {
+			return rated[i].known
+		}
+		if rated[i].rate != rated[j].rate {
+			return rated[i].rate > rated[j].rate
+		}
+		return bytes.Compare(rated[i].tx.Id, rated[j].tx.Id) < 0
+	})
+
+	sorted := make([]core.Transaction, 0, len(rated))
+	for _, r := range rated {
+		sorted = append(sorted, r.tx)
+	}
+	return sorted
+}
+
+// checkFeeRate returns an error if the fee rate of tx is lower than MinRelayFeeRate. The previous transactions are
+// looked up in chain and pool like SortedByFeeRate, and tx is rejected if its fee is unknown while MinRelayFeeRate is
+// set.
+func (pool TxPool) checkFeeRate(chain *core.BlockChain, tx *core.Transaction) error {
+	if MinRelayFeeRate <= 0 {
+		return nil
+	}
+	fee, err := chain.TxFee(tx, pool)
+	if err != nil {
+		return fmt.Errorf("unknown fee: %v", err)
+	}
+	if rate := fee / float64(tx.Size()); rate < MinRelayFeeRate {
+		return fmt.Errorf("insufficient fee: %g coins per byte, the minimum is %g", rate, MinRelayFeeRate)
+	}
+	return nil
+}
+
+// conflictsOf returns the Ids of the pooled transactions spending any output tx spends, i.e., the ones conflicting
+// with tx.
+func (pool TxPool) conflictsOf(tx *core.Transaction) []string {
+	spent := make(map[string]bool)
+	for _, txInput := range tx.Vin {
+		spent[fmt.Sprintf("%x:%d", txInput.TxId, txInput.VoutIdx)] = true
+	}
+	var conflicts []string
+	for txId, pooled := range pool {
+		for _, txInput := range pooled.Vin {
+			if spent[fmt.Sprintf("%x:%d", txInput.TxId, txInput.VoutIdx)] {
+				conflicts = append(conflicts, txId)
+				break
+			}
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// checkReplacement returns the Ids of the pooled transactions replaced by tx, i.e., the ones conflicting with it. tx
+// can only replace them if all of them signal replaceability (see core.Transaction.Replaceable), and its fee is higher
+// than the sum of the fees of them and their descendants, which are evicted with them (see removeWithDescendants).
+// Otherwise, an error is returned. The previous transactions are looked up in chain and pool like SortedByFeeRate.
+func (pool TxPool) checkReplacement(chain *core.BlockChain, tx *core.Transaction) ([]string, error) {
+	conflicts := pool.conflictsOf(tx)
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+	for _, txId := range conflicts {
+		if conflict := pool[txId]; !conflict.Replaceable {
+			return nil, fmt.Errorf("conflicts with the non-replaceable transaction %s", txId)
+		}
+	}
+	conflictsFee := 0.0
+	for _, txId := range pool.withDescendants(conflicts) {
+		evicted := pool[txId]
+		fee, err := chain.TxFee(&evicted, pool)
+		if err != nil {
+			return nil, fmt.Errorf("unknown fee of the evicted transaction %s: %v", txId, err)
+		}
+		conflictsFee += fee
+	}
+	fee, err := chain.TxFee(tx, pool)
+	if err != nil {
+		return nil, fmt.Errorf("unknown fee: %v", err)
+	}
+	if fee <= conflictsFee {
+		return nil, fmt.Errorf("insufficient fee to replace: %g coins, the conflicting transactions and their "+
+			"descendants pay %g", fee, conflictsFee)
+	}
+	return conflicts, nil
+}
+
+// removeWithDescendants removes the transaction of txId from pool, and the pooled transactions spending its outputs
+// recursively, since they are invalid without it.
+func (pool TxPool) removeWithDescendants(txId string) {
+	for _, removedId := range pool.withDescendants([]string{txId}) {
+		delete(pool, removedId)
+	}
+}
+
+// withDescendants returns txIds and the Ids of the pooled transactions spending their outputs recursively, each once.
+func (pool TxPool) withDescendants(txIds []string) []string {
+	visited := make(map[string]bool)
+	var result []string
+	queue := append([]string{}, txIds...)
+	for len(queue) > 0 {
+		parentId := queue[0]
+		queue = queue[1:]
+		if visited[parentId] {
+			continue
+		}
+		visited[parentId] = true
+		result = append(result, parentId)
+		for childId, child := range pool {
+			for _, txInput := range child.Vin {
+				if hex.EncodeToString(txInput.TxId) == parentId {
+					queue = append(queue, childId)
+					break
+				}
+			}
+		}
+	}
+	return result
+}
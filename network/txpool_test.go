@@ -0,0 +1,184 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`context`
+	`encoding/hex`
+	`errors`
+	`github.com/stretchr/testify/assert`
+	`lightChain/core`
+	`sort`
+	`testing`
+)
+
+// spendAll returns a transaction of wallet spending the first output of prev, which pays fee and sends the rest to
+// a new wallet.
+func spendAll(wallet *core.Wallet, prev *core.Transaction, fee float64) *core.Transaction {
+	tx := &core.Transaction{
+		Version: core.TxVersion,
+		Vin:     []core.TxInput{{TxId: prev.Id, VoutIdx: 0, PubKey: wallet.PubKey}},
+		Vout:    []core.TxOutput{*core.NewTxOutput(prev.Vout[0].Value-fee, string(core.NewWallet().GetAddr()))},
+	}
+	tx.Id = tx.Hashing()
+	tx.Sign(wallet.PrivateKey, map[string]core.Transaction{hex.EncodeToString(prev.Id): *prev})
+	return tx
+}
+
+func TestSortedByFeeRate(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+
+	// low and high spend the same outputs at different fee rates
+	low, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	low.Vout[1].Value -= 0.1
+	high, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 20, &utxoSet)
+	assert.Nil(t, err)
+	high.Vout[1].Value -= 2
+	// the parent of child is only in the pool
+	child := &core.Transaction{
+		Version: core.TxVersion,
+		Vin:     []core.TxInput{{TxId: high.Id, VoutIdx: 1, PubKey: wallet.PubKey}},
+		Vout:    []core.TxOutput{*core.NewTxOutput(high.Vout[1].Value-0.5, string(wallet.GetAddr()))},
+	}
+	child.Id = child.Hashing()
+	orphan := &core.Transaction{
+		Version: core.TxVersion,
+		Vin:     []core.TxInput{{TxId: []byte("unknown"), VoutIdx: 0, PubKey: wallet.PubKey}},
+		Vout:    []core.TxOutput{*core.NewTxOutput(1, string(wallet.GetAddr()))},
+	}
+	orphan.Id = orphan.Hashing()
+
+	pool := make(TxPool)
+	for _, tx := range []*core.Transaction{orphan, low, child, high} {
+		pool[hex.EncodeToString(tx.Id)] = *tx
+	}
+	var sorted [][]byte
+	for _, tx := range pool.SortedByFeeRate(chain) {
+		sorted = append(sorted, tx.Id)
+	}
+	assert.Equal(t, [][]byte{high.Id, child.Id, low.Id, orphan.Id}, sorted,
+		"Sorted by fee rate, with the unknown fee at last")
+	assert.Empty(t, TxPool{}.SortedByFeeRate(chain))
+}
+
+func TestMinerPacksByFeeRate(t *testing.T) {
+	chain, wallet := newMinerChain(t)
+	genesisCoinbase := chain.Iterator().Next().Transactions[0]
+	// give the wallet a second output to spend
+	PauseMining()
+	coinbaseTx := mineTxs(chain, string(wallet.GetAddr())).Transactions[0]
+
+	low, high := spendAll(wallet, genesisCoinbase, 0.1), spendAll(wallet, coinbaseTx, 1)
+	handleTx(context.Background(), txRequest(low), chain)
+	handleTx(context.Background(), txRequest(high), chain)
+	ResumeMining(context.Background(), chain)
+
+	assert.Empty(t, txPool)
+	tip, err := chain.GetBlock(chain.Tip)
+	assert.Nil(t, err)
+	assert.Equal(t, high.Id, tip.Transactions[0].Id, "The higher fee rate is packed first")
+	assert.Equal(t, low.Id, tip.Transactions[1].Id)
+}
+
+func TestSortedByFeeRateCache(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	genesisCoinbase := chain.Iterator().Next().Transactions[0]
+	tx := spendAll(wallet, genesisCoinbase, 0.1)
+	assert.Nil(t, handleTx(context.Background(), txRequest(tx), chain))
+
+	// the sorted copy shares the bytes cached when pooling, i.e., it is not serialized again
+	sorted := txPool.SortedByFeeRate(chain)
+	assert.Equal(t, 1, len(sorted))
+	pooled := txPool[hex.EncodeToString(tx.Id)]
+	assert.True(t, &pooled.SerializeTx()[0] == &sorted[0].SerializeTx()[0], "The cached bytes are reused")
+	assert.Equal(t, tx.SerializeTx(), sorted[0].SerializeTx())
+}
+
+// replaceableSpend works like spendAll, but the rest goes to receiver, and tx signals replaceability if replaceable is
+// set.
+func replaceableSpend(wallet *core.Wallet, prev *core.Transaction, fee float64, receiver *core.Wallet,
+	replaceable bool) *core.Transaction {
+	tx := &core.Transaction{
+		Version:     core.TxVersion,
+		Vin:         []core.TxInput{{TxId: prev.Id, VoutIdx: 0, PubKey: wallet.PubKey}},
+		Vout:        []core.TxOutput{*core.NewTxOutput(prev.Vout[0].Value-fee, string(receiver.GetAddr()))},
+		Replaceable: replaceable,
+	}
+	tx.Id = tx.Hashing()
+	tx.Sign(wallet.PrivateKey, map[string]core.Transaction{hex.EncodeToString(prev.Id): *prev})
+	return tx
+}
+
+// pooledIds returns the sorted Ids of the transactions in txPool.
+func pooledIds() []string {
+	var ids []string
+	for txId := range txPool {
+		ids = append(ids, txId)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestReplacement(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	genesisCoinbase := chain.Iterator().Next().Transactions[0]
+	receiver := core.NewWallet()
+	conflict := replaceableSpend(wallet, genesisCoinbase, 1, core.NewWallet(), false)
+
+	// the original does not signal replaceability
+	original := replaceableSpend(wallet, genesisCoinbase, 0.1, receiver, false)
+	assert.Nil(t, handleTx(context.Background(), txRequest(original), chain))
+	err := handleTx(context.Background(), txRequest(conflict), chain)
+	assert.True(t, errors.Is(err, ErrTxRejected), "The non-replaceable transaction is not replaced")
+	assert.Equal(t, []string{hex.EncodeToString(original.Id)}, pooledIds())
+
+	// the original signals replaceability, and its child is evicted together
+	txPool = make(TxPool)
+	original = replaceableSpend(wallet, genesisCoinbase, 0.1, receiver, true)
+	assert.Nil(t, handleTx(context.Background(), txRequest(original), chain))
+	child := spendAll(receiver, original, 0.1)
+	assert.Nil(t, handleTx(context.Background(), txRequest(child), chain))
+	assert.Equal(t, 2, len(txPool))
+
+	sameFee := replaceableSpend(wallet, genesisCoinbase, 0.1, core.NewWallet(), true)
+	err = handleTx(context.Background(), txRequest(sameFee), chain)
+	assert.True(t, errors.Is(err, ErrTxRejected), "The replacement must pay a higher fee")
+	assert.Equal(t, 2, len(txPool))
+	belowDescendants := replaceableSpend(wallet, genesisCoinbase, 0.15, core.NewWallet(), true)
+	err = handleTx(context.Background(), txRequest(belowDescendants), chain)
+	assert.True(t, errors.Is(err, ErrTxRejected), "The replacement must pay for the evicted descendants as well")
+	assert.Equal(t, 2, len(txPool))
+
+	assert.Nil(t, handleTx(context.Background(), txRequest(conflict), chain), "The replaceable one is replaced")
+	assert.Equal(t, []string{hex.EncodeToString(conflict.Id)}, pooledIds())
+}
+
+func TestUnsignedReplacement(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	genesisCoinbase := chain.Iterator().Next().Transactions[0]
+	original := replaceableSpend(wallet, genesisCoinbase, 0.1, core.NewWallet(), true)
+	assert.Nil(t, handleTx(context.Background(), txRequest(original), chain))
+
+	// the conflict pays a higher fee, but it is signed by someone not owning the spent output
+	unsigned := replaceableSpend(core.NewWallet(), genesisCoinbase, 1, core.NewWallet(), true)
+	unsigned.Vin[0].PubKey = wallet.PubKey
+	err := handleTx(context.Background(), txRequest(unsigned), chain)
+	assert.True(t, errors.Is(err, ErrTxRejected), "The unsigned conflict can not replace the original")
+	assert.Equal(t, []string{hex.EncodeToString(original.Id)}, pooledIds())
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file classifies a transaction by where this node knows it from: the chain or the mempool (txPool).
+
+package network
+
+import (
+	`encoding/hex`
+	`lightChain/core`
+)
+
+// TxStateKind is the state of a transaction known by this node.
+type TxStateKind int
+
+const (
+	TxUnknown   TxStateKind = iota // neither packed in the chain nor pooled
+	TxPending                      // pooled in txPool, waiting to be mined
+	TxConfirmed                    // packed in the chain
+)
+
+func (kind TxStateKind) String() string {
+	switch kind {
+	case TxPending:
+		return "pending"
+	case TxConfirmed:
+		return "confirmed"
+	default:
+		return "unknown"
+	}
+}
+
+// TxStatus is the state of a transaction. Depth is the depth of the block packing it (see BlockChain.BlockDepth),
+// which is only set for the confirmed transaction.
+type TxStatus struct {
+	State TxStateKind
+	Depth int
+}
+
+// PooledTx returns the transaction whose Id is txId from txPool, and reports whether it is pooled.
+func PooledTx(txId []byte) (core.Transaction, bool) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	tx, ok := txPool[hex.EncodeToString(txId)]
+	return tx, ok
+}
+
+// TxState classifies the transaction whose Id is txId: confirmed (with depth) if chain packs it, pending if it is in
+// txPool, or unknown otherwise. An error is returned if the txid index refers to a block missing in chain.
+func TxState(txId []byte, chain *core.BlockChain) (TxStatus, error) {
+	if blockHash, err := chain.LookupTx(txId); err == nil {
+		depth, err := chain.BlockDepth(blockHash)
+		if err != nil {
+			return TxStatus{}, err
+		}
+		return TxStatus{State: TxConfirmed, Depth: depth}, nil
+	}
+	if _, ok := PooledTx(txId); ok {
+		return TxStatus{State: TxPending}, nil
+	}
+	return TxStatus{State: TxUnknown}, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	`context`
+	`crypto/rand`
+	`github.com/stretchr/testify/assert`
+	`lightChain/core`
+	`testing`
+)
+
+func TestTxState(t *testing.T) {
+	chain, wallet := newTestChain(t)
+	utxoSet := core.UTXOSet{BlockChain: chain}
+
+	// a mined transaction is confirmed
+	minedTx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	mineTxs(chain, string(wallet.GetAddr()), minedTx)
+	status, err := TxState(minedTx.Id, chain)
+	assert.Nil(t, err)
+	assert.Equal(t, TxStatus{State: TxConfirmed, Depth: 1}, status)
+
+	mineTxs(chain, string(wallet.GetAddr()))
+	status, err = TxState(minedTx.Id, chain)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, status.Depth, "The depth grows with the chain")
+
+	// a pooled transaction is pending
+	pooledTx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	handleTx(context.Background(), txRequest(pooledTx), chain)
+	status, err = TxState(pooledTx.Id, chain)
+	assert.Nil(t, err)
+	assert.Equal(t, TxStatus{State: TxPending}, status)
+
+	// a transaction mined only on a losing fork is not confirmed, and it is accepted into the pool
+	txPool = make(TxPool) // the pending transaction spends the same outputs
+	forkTx, err := core.NewUTXOTx(wallet, string(core.NewWallet().GetAddr()), 10, &utxoSet)
+	assert.Nil(t, err)
+	height := chain.GetChainHeight()
+	parentHash, err := chain.BlockHashAtHeight(height - 1)
+	assert.Nil(t, err)
+	coinbaseTx := core.NewCoinbaseTx(string(wallet.GetAddr()), "", chain.CurrentReward(height), height)
+	sideBlock := core.NewBlockWithPoW([]*core.Transaction{coinbaseTx, forkTx}, parentHash, height, core.NoopPoW{})
+	assert.True(t, chain.AddBlock(sideBlock))
+	status, err = TxState(forkTx.Id, chain)
+	assert.Nil(t, err)
+	assert.Equal(t, TxStatus{State: TxUnknown}, status)
+	assert.Nil(t, handleTx(context.Background(), txRequest(forkTx), chain))
+	status, err = TxState(forkTx.Id, chain)
+	assert.Nil(t, err)
+	assert.Equal(t, TxStatus{State: TxPending}, status)
+
+	// a random id is unknown
+	randomId := make([]byte, 32)
+	_, _ = rand.Read(randomId)
+	status, err = TxState(randomId, chain)
+	assert.Nil(t, err)
+	assert.Equal(t, TxUnknown, status.State)
+	assert.Equal(t, "unknown", status.State.String())
+}
@@ -0,0 +1,338 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file defines the results of the query commands. Each result is printed as the human-readable text, or in JSON
+// if the global flag -json is set, thus the scripts can parse the output.
+
+package main
+
+import (
+	`encoding/hex`
+	`errors`
+	`fmt`
+	`io`
+	`lightChain/core`
+	`log`
+	`math`
+)
+
+// result is the result of a query command, which can be printed as text. It is marshaled as JSON otherwise.
+type result interface {
+	printText(w io.Writer)
+}
+
+// output prints res to w, in JSON if cli.JSON is set, otherwise as text.
+func (cli *CLI) output(w io.Writer, res result) {
+	if cli.JSON {
+		writeJSON(w, res)
+		return
+	}
+	res.printText(w)
+}
+
+// balanceResult is the result of getbalance. Balance is the total value of the unspent outputs, and Spendable excludes
+// the immature coinbase outputs from it (see core.UTXOSet.SpendableBalance). Spendable is advisory, since
+// core.CoinbaseMaturity is not enforced by send yet, and it is nil if unknown, i.e., the txid index is missing.
+type balanceResult struct {
+	Address   string   `json:"address"`
+	Balance   float64  `json:"balance"`
+	Spendable *float64 `json:"spendable"`
+}
+
+// balanceOf returns the balance of addr on chain, which is read from the UTXO set. The spendable balance is left
+// unknown if the txid index is missing, which can not be rebuilt when chain is opened read-only.
+func balanceOf(chain *core.BlockChain, addr string) (balanceResult, error) {
+	pubKeyHash, err := core.AddrPubKeyHash(addr)
+	if err != nil {
+		return balanceResult{}, err
+	}
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	res := balanceResult{Address: addr, Balance: utxoSet.Balance(pubKeyHash)}
+	spendable, err := utxoSet.SpendableBalance(pubKeyHash)
+	if errors.Is(err, core.ErrTxNotFound) {
+		return res, nil
+	}
+	if err != nil {
+		return balanceResult{}, err
+	}
+	res.Spendable = &spendable
+	return res, nil
+}
+
+func (res balanceResult) printText(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "The balance of '%s': %f\n", res.Address, res.Balance)
+	if res.Spendable == nil {
+		_, _ = fmt.Fprintf(w, "The spendable balance of '%s' is unknown: the txid index is missing (see "+
+			"rebuildtxindex)\n\n", res.Address)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "The spendable balance of '%s': %f (advisory, the immature coinbases are not locked yet)\n\n",
+		res.Address, *res.Spendable)
+}
+
+// chainInfoResult is the result of getchaininfo. Legal is false if the number of blocks is not the tip height + 1.
+// UTXOCommitment is the hashing of the UTXO set (see core.UTXOSet.Commitment), which is empty if the UTXO set is not
+// built yet. The nodes at the same tip have the same commitment.
+type chainInfoResult struct {
+	Blocks             int    `json:"blocks"`
+	Transactions       int    `json:"transactions"`
+	TipHeight          int    `json:"tipHeight"`
+	TipHash            string `json:"tipHash"`
+	TargetBlockSeconds int64  `json:"targetBlockSeconds"`
+	UTXOCommitment     string `json:"utxoCommitment,omitempty"`
+	Legal              bool   `json:"legal"`
+}
+
+// chainInfoOf returns the summary of chain.
+func chainInfoOf(chain *core.BlockChain) chainInfoResult {
+	numBlocks, numTxs, tipHeight := chain.Summary()
+	commitment, err := core.UTXOSet{BlockChain: chain}.Commitment()
+	if err != nil && !errors.Is(err, core.ErrBucketNotFound) {
+		log.Panic(err)
+	}
+	return chainInfoResult{
+		Blocks:             numBlocks,
+		Transactions:       numTxs,
+		TipHeight:          tipHeight,
+		TipHash:            hex.EncodeToString(chain.TipHash()),
+		TargetBlockSeconds: chain.Config.TargetBlockSeconds,
+		UTXOCommitment:     hex.EncodeToString(commitment),
+		Legal:              numBlocks == tipHeight+1,
+	}
+}
+
+func (res chainInfoResult) printText(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Blocks: %d\n", res.Blocks)
+	_, _ = fmt.Fprintf(w, "Transactions: %d\n", res.Transactions)
+	_, _ = fmt.Fprintf(w, "Tip height: %d\n", res.TipHeight)
+	_, _ = fmt.Fprintf(w, "Tip hash: %s\n", res.TipHash)
+	_, _ = fmt.Fprintf(w, "Target block interval: %ds\n", res.TargetBlockSeconds)
+	if res.UTXOCommitment != "" {
+		_, _ = fmt.Fprintf(w, "UTXO commitment: %s\n", res.UTXOCommitment)
+	}
+	if !res.Legal {
+		_, _ = fmt.Fprintln(w, "Warning: local lightChain is illegal (height + 1 ≠ blocks num)!")
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+// tipInfoResult is the result of tipinfo: the tip of chain, and the tips of all the stored branches (see
+// core.BlockChain.BranchTips). The works are in decimal, since they may exceed the precision of float64 in JSON.
+type tipInfoResult struct {
+	TipHash   string         `json:"tipHash"`
+	TipHeight int            `json:"tipHeight"`
+	TipWork   string         `json:"tipWork"`
+	Branches  []branchResult `json:"branches"`
+}
+
+// branchResult is the tip of a branch in tipInfoResult.
+type branchResult struct {
+	Hash     string `json:"hash"`
+	Height   int    `json:"height"`
+	Work     string `json:"work"`
+	Selected bool   `json:"selected"`
+}
+
+// tipInfoOf returns the tip of chain and the tips of the branches competing with it.
+func tipInfoOf(chain *core.BlockChain) tipInfoResult {
+	var res tipInfoResult
+	for _, tip := range chain.BranchTips() {
+		branch := branchResult{hex.EncodeToString(tip.Hash), tip.Height, tip.Work.String(), tip.Selected}
+		if tip.Selected {
+			res.TipHash, res.TipHeight, res.TipWork = branch.Hash, branch.Height, branch.Work
+		}
+		res.Branches = append(res.Branches, branch)
+	}
+	return res
+}
+
+func (res tipInfoResult) printText(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Tip hash: %s\n", res.TipHash)
+	_, _ = fmt.Fprintf(w, "Tip height: %d\n", res.TipHeight)
+	_, _ = fmt.Fprintf(w, "Tip work: %s\n", res.TipWork)
+	_, _ = fmt.Fprintf(w, "Branches: %d\n", len(res.Branches))
+	for _, branch := range res.Branches {
+		mark := " "
+		if branch.Selected {
+			mark = "*"
+		}
+		_, _ = fmt.Fprintf(w, "%s %-8d %-12s %s\n", mark, branch.Height, branch.Work, branch.Hash)
+	}
+	_, _ = fmt.Fprintln(w, "The highest branch is selected (*), and the one stored first wins at the same height.")
+	_, _ = fmt.Fprintln(w)
+}
+
+// blockNumResult is the result of getblocknum.
+type blockNumResult struct {
+	Blocks int `json:"blocks"`
+}
+
+func (res blockNumResult) printText(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "%d\n\n", res.Blocks)
+}
+
+// addrListResult is the result of listaddr.
+type addrListResult struct {
+	Addresses []string `json:"addresses"`
+}
+
+func (res addrListResult) printText(w io.Writer) {
+	for addrIdx, addr := range res.Addresses {
+		_, _ = fmt.Fprintf(w, "#%d: %s\n", addrIdx, addr)
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+// addrInfoResult is the result of validateaddr. Error is the reason if addr cannot be decoded.
+type addrInfoResult struct {
+	Valid      bool   `json:"valid"`
+	Version    byte   `json:"version"`
+	PubKeyHash string `json:"pubKeyHash,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// addrInfoOf returns whether addr is a valid address, and its version byte and pubKeyHash if so.
+func addrInfoOf(addr string) addrInfoResult {
+	addrVersion, pubKeyHash, err := core.DecodeAddr(addr)
+	if err != nil {
+		return addrInfoResult{Valid: false, Error: err.Error()}
+	}
+	return addrInfoResult{Valid: core.ValidateAddr(addr), Version: addrVersion, PubKeyHash: hex.EncodeToString(pubKeyHash)}
+}
+
+func (res addrInfoResult) printText(w io.Writer) {
+	if res.Error != "" {
+		_, _ = fmt.Fprintf(w, "Valid: false (%s)\n", res.Error)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "Valid: %t\n", res.Valid)
+	_, _ = fmt.Fprintf(w, "Version: 0x%02x\n", res.Version)
+	_, _ = fmt.Fprintf(w, "PubKeyHash: %s\n", res.PubKeyHash)
+}
+
+// txResult is the result of printtx and getrawtx, which is marshaled by core.Transaction.
+type txResult struct {
+	*core.Transaction
+}
+
+func (res txResult) printText(w io.Writer) {
+	_, _ = fmt.Fprintln(w, res.Transaction)
+}
+
+// supplyResult is the result of supply.
+type supplyResult struct {
+	TotalSupply  float64 `json:"totalSupply"`
+	IssuedSupply float64 `json:"issuedSupply"`
+}
+
+func (res supplyResult) printText(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Total supply (sum of UTXO): %f\n", res.TotalSupply)
+	_, _ = fmt.Fprintf(w, "Issued supply (sum of coinbase rewards): %f\n", res.IssuedSupply)
+	if math.Abs(res.TotalSupply-res.IssuedSupply) > 1e-6 {
+		_, _ = fmt.Fprintln(w, "Warning: total supply and issued supply disagree! Try rebuildutxo, or there is an "+
+			"inflation bug.")
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+// utxoListResult is the result of listutxo. CoinAge is the sum of the coin ages of the outputs.
+type utxoListResult struct {
+	Address   string       `json:"address"`
+	TipHeight int          `json:"tipHeight"`
+	UTXOs     []utxoResult `json:"utxos"`
+	CoinAge   float64      `json:"coinAge"`
+}
+
+// utxoResult is an unspent output in utxoListResult.
+type utxoResult struct {
+	TxId          string  `json:"txId"`
+	Index         int     `json:"index"`
+	Value         float64 `json:"value"`
+	Height        int     `json:"height"`
+	Confirmations int     `json:"confirmations"`
+	CoinAge       float64 `json:"coinAge"`
+}
+
+// utxoListOf returns the unspent outputs of addr on chain with their coin ages (see core.UTXOSet.ListUTXO).
+func utxoListOf(chain *core.BlockChain, addr string) (utxoListResult, error) {
+	pubKeyHash, err := core.AddrPubKeyHash(addr)
+	if err != nil {
+		return utxoListResult{}, err
+	}
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	utxos, err := utxoSet.ListUTXO(pubKeyHash)
+	if err != nil {
+		return utxoListResult{}, err
+	}
+
+	res := utxoListResult{Address: addr, TipHeight: chain.GetChainHeight(), UTXOs: []utxoResult{}}
+	for _, utxo := range utxos {
+		coinAge := utxo.CoinAge(res.TipHeight)
+		res.UTXOs = append(res.UTXOs, utxoResult{hex.EncodeToString(utxo.TxId), utxo.Index, utxo.Output.Value,
+			utxo.Height, utxo.Confirmations(res.TipHeight), coinAge})
+		res.CoinAge += coinAge
+	}
+	return res, nil
+}
+
+func (res utxoListResult) printText(w io.Writer) {
+	for _, utxo := range res.UTXOs {
+		_, _ = fmt.Fprintf(w, "%s:%d %f (height %d, %d confirmations, coin age %f)\n", utxo.TxId, utxo.Index,
+			utxo.Value, utxo.Height, utxo.Confirmations, utxo.CoinAge)
+	}
+	_, _ = fmt.Fprintf(w, "The coin age of '%s': %f\n\n", res.Address, res.CoinAge)
+}
+
+// richListResult is the result of richlist.
+type richListResult struct {
+	Balances []addrBalanceResult `json:"balances"`
+}
+
+// addrBalanceResult is an entry of richListResult.
+type addrBalanceResult struct {
+	Address    string  `json:"address"`
+	PubKeyHash string  `json:"pubKeyHash"`
+	Balance    float64 `json:"balance"`
+}
+
+// richListOf returns the top n owners by balance in the UTXO set of chain (see core.UTXOSet.RichList).
+func richListOf(chain *core.BlockChain, n int) richListResult {
+	utxoSet := core.UTXOSet{BlockChain: chain}
+	res := richListResult{Balances: []addrBalanceResult{}}
+	for _, entry := range utxoSet.RichList(n) {
+		res.Balances = append(res.Balances, addrBalanceResult{entry.Address, hex.EncodeToString(entry.PubKeyHash),
+			entry.Balance})
+	}
+	return res
+}
+
+func (res richListResult) printText(w io.Writer) {
+	for rank, entry := range res.Balances {
+		_, _ = fmt.Fprintf(w, "#%d: %s %f\n", rank+1, entry.Address, entry.Balance)
+	}
+	_, _ = fmt.Fprintln(w)
+}
+
+// feeResult is the result of estimatefee.
+type feeResult struct {
+	Size int     `json:"size"`
+	Fee  float64 `json:"fee"`
+}
+
+func (res feeResult) printText(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Estimated size: %d bytes\n", res.Size)
+	_, _ = fmt.Fprintf(w, "Estimated fee: %f\n\n", res.Fee)
+}
@@ -20,6 +20,7 @@ package utils
 
 import (
 	`bytes`
+	`fmt`
 	`math/big`
 )
 
@@ -40,35 +41,42 @@ func Base58Encoding(input []byte) []byte {
 		encoded = append(encoded, alphabet[mod.Int64()])
 	}
 	ReverseBytes(encoded)
-	// all the zero bytes of input are encoded as alphabet[0] and put at the beginning
-	// NOTE: 0x00 should only appear as leading bytes of input
-	for b := range input {
-		if b == 0x00 {
-			encoded = append([]byte{alphabet[0]}, encoded...)
+	// all the leading zero bytes of input are encoded as alphabet[0] and put at the beginning
+	for _, b := range input {
+		if b != 0x00 {
+			break
 		}
+		encoded = append([]byte{alphabet[0]}, encoded...)
 	}
 
 	return encoded
 }
 
-// Base58Decoding returns the decoded byte slice from the base58 encoded input.
-func Base58Decoding(input []byte) []byte {
+// Base58Decoding returns the decoded byte slice from the base58 encoded input. An error is returned if input has
+// a character out of the base58 alphabet. Note that Base58Encoding once put a single alphabet[0] at the beginning for
+// any number of leading zero bytes, the decoding of such input lacks the zero bytes, which can only be restored by a
+// caller knowing the length of the input.
+func Base58Decoding(input []byte) ([]byte, error) {
 	tmp := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
-			zeroBytes++
+	for _, b := range input {
+		if b != alphabet[0] {
+			break
 		}
+		zeroBytes++
 	}
 	payload := input[zeroBytes:]
 	for _, b := range payload {
 		byteIdx := bytes.IndexByte(alphabet, b)
+		if byteIdx < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", b)
+		}
 		tmp.Mul(tmp, big.NewInt(length))
 		tmp.Add(tmp, big.NewInt(int64(byteIdx)))
 	}
 	// decode all the alphabet[0] as zero bytes at the beginning of input
 	decoded := tmp.Bytes()
 	decoded = append(bytes.Repeat([]byte{byte(0x00)}, zeroBytes), decoded...)
-	return decoded
+	return decoded, nil
 }
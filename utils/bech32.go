@@ -0,0 +1,136 @@
+// Copyright 2021 Hailiang Zhao <hliangzhao@zju.edu.cn>
+// This file is part of the lightChain.
+//
+// The lightChain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lightChain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lightChain. If not, see <http://www.gnu.org/licenses/>.
+
+// This file gives the encoding and decoding methods of bech32 (BIP-173), which are used to generate the wallet
+// addresses in the bech32 format.
+
+package utils
+
+import (
+	`bytes`
+	`errors`
+	`fmt`
+	`strings`
+)
+
+var bech32Alphabet = []byte("qpzry9x8gf2tvdw0s3jn54khce6mua7l")
+
+// bech32Polymod computes the BCH checksum of the 5-bit values.
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32HrpExpand expands the human-readable part for the checksum computation.
+func bech32HrpExpand(hrp string) []byte {
+	var expanded []byte
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c>>5)
+	}
+	expanded = append(expanded, 0)
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c&31)
+	}
+	return expanded
+}
+
+// convertBits regroups the fromBits-bit values of data into toBits-bit values. The trailing bits are padded with 0
+// if pad is true, otherwise they should be zero.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var converted []byte
+	acc, bits := uint32(0), uint(0)
+	maxValue := uint32(1)<<toBits - 1
+	for _, b := range data {
+		if uint32(b)>>fromBits != 0 {
+			return nil, fmt.Errorf("invalid data byte %d", b)
+		}
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			converted = append(converted, byte(acc>>bits&maxValue))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			converted = append(converted, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return converted, nil
+}
+
+// Bech32Encoding returns the bech32 encoding result for input byte slice with the human-readable part hrp.
+func Bech32Encoding(hrp string, input []byte) []byte {
+	values, _ := convertBits(input, 8, 5, true)
+
+	// the checksum is the polymod of hrp, values, and six zeros
+	checksumInput := append(bech32HrpExpand(hrp), values...)
+	polymod := bech32Polymod(append(checksumInput, 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>uint(5*(5-i))&31))
+	}
+
+	encoded := append([]byte(hrp), '1')
+	for _, v := range values {
+		encoded = append(encoded, bech32Alphabet[v])
+	}
+	return encoded
+}
+
+// Bech32Decoding returns the human-readable part and the decoded byte slice from the bech32 encoded input. An error is
+// returned if input is malformed or its checksum mismatches.
+func Bech32Decoding(input []byte) (string, []byte, error) {
+	str := string(input)
+	if strings.ToLower(str) != str && strings.ToUpper(str) != str {
+		return "", nil, errors.New("mixed case in bech32 string")
+	}
+	str = strings.ToLower(str)
+
+	sepIdx := strings.LastIndexByte(str, '1')
+	if sepIdx < 1 || sepIdx+7 > len(str) {
+		return "", nil, errors.New("invalid bech32 separator position")
+	}
+	hrp := str[:sepIdx]
+	var values []byte
+	for _, c := range []byte(str[sepIdx+1:]) {
+		v := bytes.IndexByte(bech32Alphabet, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HrpExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+
+	decoded, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, decoded, nil
+}